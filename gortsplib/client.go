@@ -63,9 +63,13 @@ type Client struct {
 	//
 	// initialization
 	//
-	// disable being redirected to other servers, that can happen during Describe().
+	// disable being redirected to other servers, that can happen during Describe() or Announce().
+	// Credentials are kept only when redirected to the same host and port.
 	// It defaults to false.
 	RedirectDisable bool
+	// maximum number of consecutive redirects that are followed.
+	// It defaults to 5.
+	RedirectMaxCount int
 	// enable communication with servers which don't provide server ports.
 	// this can be a security issue.
 	// It defaults to false.
@@ -228,8 +232,11 @@ func (c *Client) DialPublishContext(ctx context.Context, address string, tracks
 		return nil, err
 	}
 
+	// in case of redirects, the stream URL is different from the address
+	baseURL := conn.streamBaseURL
+
 	for _, track := range tracks {
-		_, err := conn.Setup(headers.TransportModeRecord, u, track, 0, 0)
+		_, err := conn.Setup(headers.TransportModeRecord, baseURL, track, 0, 0)
 		if err != nil {
 			conn.Close()
 			return nil, err
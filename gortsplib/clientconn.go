@@ -178,6 +178,11 @@ func newClientConn(c *Client, scheme string, host string) (*ClientConn, error) {
 		c.TLSConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 
+	// initialization
+	if c.RedirectMaxCount == 0 {
+		c.RedirectMaxCount = 5
+	}
+
 	// reading / writing
 	if c.InitialUDPReadTimeout == 0 {
 		c.InitialUDPReadTimeout = 3 * time.Second
@@ -266,11 +271,11 @@ outer:
 			req.res <- clientRes{res: res, err: err}
 
 		case req := <-cc.describe:
-			tracks, baseURL, res, err := cc.doDescribe(req.url)
+			tracks, baseURL, res, err := cc.doDescribe(req.url, 0)
 			req.res <- clientRes{tracks: tracks, baseURL: baseURL, res: res, err: err}
 
 		case req := <-cc.announce:
-			res, err := cc.doAnnounce(req.url, req.tracks)
+			res, err := cc.doAnnounce(req.url, req.tracks, 0)
 			req.res <- clientRes{res: res, err: err}
 
 		case req := <-cc.setup:
@@ -821,7 +826,7 @@ func (cc *ClientConn) connOpen() error {
 	return nil
 }
 
-func (cc *ClientConn) do(req *base.Request, skipResponse bool) (*base.Response, error) {
+func (cc *ClientConn) doOnce(req *base.Request, skipResponse bool) (*base.Response, error) {
 	if cc.nconn == nil {
 		err := cc.connOpen()
 		if err != nil {
@@ -922,8 +927,20 @@ func (cc *ClientConn) do(req *base.Request, skipResponse bool) (*base.Response,
 		cc.session = sx.Session
 	}
 
-	// if required, send request again with authentication
-	if res.StatusCode == base.StatusUnauthorized && req.URL.User != nil && cc.sender == nil {
+	return &res, nil
+}
+
+func (cc *ClientConn) do(req *base.Request, skipResponse bool) (*base.Response, error) {
+	res, err := cc.doOnce(req, skipResponse)
+	if err != nil || skipResponse {
+		return res, err
+	}
+
+	// if required, send request again with authentication.
+	// this is done even if credentials have already been sent, since
+	// servers can require authentication only for specific methods,
+	// or can invalidate the previous nonce.
+	if res.StatusCode == base.StatusUnauthorized && req.URL.User != nil {
 		pass, _ := req.URL.User.Password()
 		user := req.URL.User.Username()
 
@@ -933,10 +950,56 @@ func (cc *ClientConn) do(req *base.Request, skipResponse bool) (*base.Response,
 		}
 		cc.sender = sender
 
-		return cc.do(req, false)
+		return cc.doOnce(req, false)
 	}
 
-	return &res, nil
+	return res, nil
+}
+
+func isRedirect(res *base.Response) bool {
+	return res.StatusCode >= base.StatusMovedPermanently &&
+		res.StatusCode <= base.StatusUseProxy &&
+		len(res.Header["Location"]) == 1
+}
+
+// redirect closes the connection and connects to the server pointed by the Location header.
+func (cc *ClientConn) redirect(u *base.URL, res *base.Response, redirectCount int) (*base.URL, error) {
+	if redirectCount >= cc.c.RedirectMaxCount {
+		return nil, liberrors.ErrClientTooManyRedirects{}
+	}
+
+	prevHost := cc.host
+
+	cc.reset(false)
+
+	nu, err := base.ParseURL(res.Header["Location"][0])
+	if err != nil {
+		return nil, err
+	}
+
+	cc.scheme = nu.Scheme
+	cc.host = nu.Host
+	if !strings.Contains(cc.host, ":") {
+		cc.host += ":554"
+	}
+
+	// keep credentials only when the server is the same,
+	// in order not to send them to third parties
+	if nu.User == nil && cc.host == prevHost {
+		nu.User = u.User
+	}
+
+	err = cc.connOpen()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cc.doOptions(nu)
+	if err != nil {
+		return nil, err
+	}
+
+	return nu, nil
 }
 
 func (cc *ClientConn) doOptions(u *base.URL) (*base.Response, error) {
@@ -996,7 +1059,7 @@ func (cc *ClientConn) Options(u *base.URL) (*base.Response, error) {
 	}
 }
 
-func (cc *ClientConn) doDescribe(u *base.URL) (Tracks, *base.URL, *base.Response, error) {
+func (cc *ClientConn) doDescribe(u *base.URL, redirectCount int) (Tracks, *base.URL, *base.Response, error) {
 	err := cc.checkState(map[clientConnState]struct{}{
 		clientConnStateInitial:   {},
 		clientConnStatePrePlay:   {},
@@ -1019,32 +1082,13 @@ func (cc *ClientConn) doDescribe(u *base.URL) (Tracks, *base.URL, *base.Response
 
 	if res.StatusCode != base.StatusOK {
 		// redirect
-		if !cc.c.RedirectDisable &&
-			res.StatusCode >= base.StatusMovedPermanently &&
-			res.StatusCode <= base.StatusUseProxy &&
-			len(res.Header["Location"]) == 1 {
-
-			cc.reset(false)
-
-			u, err := base.ParseURL(res.Header["Location"][0])
-			if err != nil {
-				return nil, nil, nil, err
-			}
-
-			cc.scheme = u.Scheme
-			cc.host = u.Host
-
-			err = cc.connOpen()
-			if err != nil {
-				return nil, nil, nil, err
-			}
-
-			_, err = cc.doOptions(u)
+		if !cc.c.RedirectDisable && isRedirect(res) {
+			u, err := cc.redirect(u, res, redirectCount)
 			if err != nil {
 				return nil, nil, nil, err
 			}
 
-			return cc.doDescribe(u)
+			return cc.doDescribe(u, redirectCount+1)
 		}
 
 		return nil, nil, res, liberrors.ErrClientInvalidStatusCode{Code: res.StatusCode, Message: res.StatusMessage}
@@ -1108,7 +1152,7 @@ func (cc *ClientConn) Describe(u *base.URL) (Tracks, *base.URL, *base.Response,
 	}
 }
 
-func (cc *ClientConn) doAnnounce(u *base.URL, tracks Tracks) (*base.Response, error) {
+func (cc *ClientConn) doAnnounce(u *base.URL, tracks Tracks, redirectCount int) (*base.Response, error) {
 	err := cc.checkState(map[clientConnState]struct{}{
 		clientConnStateInitial: {},
 	})
@@ -1142,6 +1186,16 @@ func (cc *ClientConn) doAnnounce(u *base.URL, tracks Tracks) (*base.Response, er
 	}
 
 	if res.StatusCode != base.StatusOK {
+		// redirect
+		if !cc.c.RedirectDisable && isRedirect(res) {
+			u, err := cc.redirect(u, res, redirectCount)
+			if err != nil {
+				return nil, err
+			}
+
+			return cc.doAnnounce(u, tracks, redirectCount+1)
+		}
+
 		return nil, liberrors.ErrClientInvalidStatusCode{
 			Code: res.StatusCode, Message: res.StatusMessage,
 		}
@@ -0,0 +1,120 @@
+// Package framedropper contains an utility to drop the frames sent to readers
+// that are slower than the stream, without corrupting the stream.
+package framedropper
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+)
+
+type state int
+
+const (
+	stateNormal state = iota
+	stateDropNonReference
+	stateWaitKeyFrame
+)
+
+// FrameDropper decides which frames are written into the buffer of a reader.
+// When the buffer is filled over 3/4 of its size, H264 non-reference frames are dropped.
+// When the buffer is full, all frames are dropped until the next H264 keyframe.
+// Frames are written normally again when the buffer is filled under half of its size.
+// It must be used by a single goroutine.
+type FrameDropper struct {
+	videoTrackID  int
+	highWatermark uint64
+	lowWatermark  uint64
+	full          uint64
+
+	state       state
+	behindSince time.Time
+}
+
+// New allocates a FrameDropper.
+// videoTrackID is the ID of the H264 track, or -1 if there's none.
+func New(videoTrackID int, bufferSize uint64) *FrameDropper {
+	full := bufferSize
+	if full > 1 {
+		full--
+	}
+
+	return &FrameDropper{
+		videoTrackID:  videoTrackID,
+		highWatermark: bufferSize * 3 / 4,
+		lowWatermark:  bufferSize / 2,
+		full:          full,
+	}
+}
+
+// Filter returns whether a frame must be written into the buffer,
+// given the number of entries that are currently in the buffer.
+func (d *FrameDropper) Filter(trackID int, streamType base.StreamType, payload []byte, bufferLen uint64) bool {
+	switch d.state {
+	case stateNormal:
+		if bufferLen >= d.highWatermark {
+			d.state = stateDropNonReference
+			d.behindSince = time.Now()
+		}
+
+	case stateDropNonReference:
+		if bufferLen <= d.lowWatermark {
+			d.state = stateNormal
+			d.behindSince = time.Time{}
+		}
+	}
+
+	if d.state != stateWaitKeyFrame && bufferLen >= d.full {
+		// without a H264 track there are no keyframes to wait for:
+		// drop the frames that don't fit into the buffer.
+		if d.videoTrackID < 0 {
+			return false
+		}
+
+		d.state = stateWaitKeyFrame
+	}
+
+	switch d.state {
+	case stateDropNonReference:
+		if trackID == d.videoTrackID && streamType == base.StreamTypeRTP {
+			var pkt rtp.Packet
+			err := pkt.Unmarshal(payload)
+			if err == nil && rtph264.IsNonReference(pkt.Payload) {
+				return false
+			}
+		}
+
+	case stateWaitKeyFrame:
+		if streamType == base.StreamTypeRTCP {
+			return bufferLen < d.full
+		}
+
+		if trackID != d.videoTrackID || bufferLen >= d.highWatermark {
+			return false
+		}
+
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(payload)
+		if err != nil || !rtph264.IsRandomAccess(pkt.Payload) {
+			return false
+		}
+
+		// resume from the keyframe, and keep dropping non-reference frames
+		// until the buffer is emptied.
+		d.state = stateDropNonReference
+	}
+
+	return true
+}
+
+// Behind returns the time elapsed since the reader started lagging behind the stream,
+// or zero if the reader is keeping up with the stream.
+func (d *FrameDropper) Behind() time.Duration {
+	if d.behindSince.IsZero() {
+		return 0
+	}
+	return time.Since(d.behindSince)
+}
@@ -0,0 +1,73 @@
+package framedropper
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gortsplib/pkg/base"
+)
+
+func mustMarshal(payload []byte) []byte {
+	byts, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+		},
+		Payload: payload,
+	}).Marshal()
+	if err != nil {
+		panic(err)
+	}
+	return byts
+}
+
+var (
+	idr          = mustMarshal([]byte{0x65, 0x88, 0x84})
+	reference    = mustMarshal([]byte{0x41, 0x9a, 0x24})
+	nonReference = mustMarshal([]byte{0x01, 0x9e, 0x42})
+	audio        = mustMarshal([]byte{0x01, 0x02})
+)
+
+func TestFrameDropper(t *testing.T) {
+	d := New(0, 16)
+
+	// normal
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, nonReference, 0))
+	require.Equal(t, true, d.Filter(1, base.StreamTypeRTP, audio, 0))
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, nonReference, 11))
+	require.Equal(t, 0, int(d.Behind()))
+
+	// buffer over 3/4
+	require.Equal(t, false, d.Filter(0, base.StreamTypeRTP, nonReference, 12))
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, reference, 12))
+	require.Equal(t, true, d.Filter(1, base.StreamTypeRTP, audio, 12))
+	require.NotEqual(t, 0, int(d.Behind()))
+
+	// buffer full
+	require.Equal(t, false, d.Filter(0, base.StreamTypeRTP, reference, 15))
+	require.Equal(t, false, d.Filter(0, base.StreamTypeRTP, reference, 10))
+	require.Equal(t, false, d.Filter(1, base.StreamTypeRTP, audio, 10))
+	require.Equal(t, true, d.Filter(1, base.StreamTypeRTCP, audio, 10))
+
+	// keyframe
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, idr, 10))
+	require.Equal(t, false, d.Filter(0, base.StreamTypeRTP, nonReference, 10))
+	require.NotEqual(t, 0, int(d.Behind()))
+
+	// buffer under half
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, nonReference, 8))
+	require.Equal(t, 0, int(d.Behind()))
+}
+
+func TestFrameDropperNoVideo(t *testing.T) {
+	d := New(-1, 16)
+
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, audio, 12))
+	require.NotEqual(t, 0, int(d.Behind()))
+	require.Equal(t, false, d.Filter(0, base.StreamTypeRTP, audio, 15))
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, audio, 14))
+	require.Equal(t, true, d.Filter(0, base.StreamTypeRTP, audio, 8))
+	require.Equal(t, 0, int(d.Behind()))
+}
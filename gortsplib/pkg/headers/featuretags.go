@@ -0,0 +1,51 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aler9/gortsplib/pkg/base"
+)
+
+// FeatureTagONVIFReplay is the option tag of the ONVIF replay extension.
+const FeatureTagONVIFReplay = "onvif-replay"
+
+// FeatureTags is a list of option tags, used by the Require, Proxy-Require,
+// Supported and Unsupported headers.
+type FeatureTags []string
+
+// Read decodes a Require, Proxy-Require, Supported or Unsupported header.
+func (h *FeatureTags) Read(v base.HeaderValue) error {
+	if len(v) == 0 {
+		return fmt.Errorf("value not provided")
+	}
+
+	// the header can be split into multiple lines
+	for _, line := range v {
+		for _, tag := range strings.Split(line, ",") {
+			tag = strings.Trim(tag, " ")
+			if tag == "" {
+				return fmt.Errorf("empty option tag (%v)", line)
+			}
+
+			*h = append(*h, tag)
+		}
+	}
+
+	return nil
+}
+
+// Write encodes a Require, Proxy-Require, Supported or Unsupported header.
+func (h FeatureTags) Write() base.HeaderValue {
+	return base.HeaderValue{strings.Join(h, ", ")}
+}
+
+// Contains checks whether the list contains the given option tag.
+func (h FeatureTags) Contains(tag string) bool {
+	for _, t := range h {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
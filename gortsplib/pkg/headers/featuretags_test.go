@@ -0,0 +1,86 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/gortsplib/pkg/base"
+)
+
+var casesFeatureTags = []struct {
+	name string
+	vin  base.HeaderValue
+	vout base.HeaderValue
+	h    FeatureTags
+}{
+	{
+		"single value",
+		base.HeaderValue{`onvif-replay`},
+		base.HeaderValue{`onvif-replay`},
+		FeatureTags{"onvif-replay"},
+	},
+	{
+		"multiple values",
+		base.HeaderValue{`onvif-replay,play.basic`},
+		base.HeaderValue{`onvif-replay, play.basic`},
+		FeatureTags{"onvif-replay", "play.basic"},
+	},
+	{
+		"multiple lines",
+		base.HeaderValue{`onvif-replay`, ` play.basic, setup.rtp.rtcp.mux`},
+		base.HeaderValue{`onvif-replay, play.basic, setup.rtp.rtcp.mux`},
+		FeatureTags{"onvif-replay", "play.basic", "setup.rtp.rtcp.mux"},
+	},
+}
+
+func TestFeatureTagsRead(t *testing.T) {
+	for _, ca := range casesFeatureTags {
+		t.Run(ca.name, func(t *testing.T) {
+			var h FeatureTags
+			err := h.Read(ca.vin)
+			require.NoError(t, err)
+			require.Equal(t, ca.h, h)
+		})
+	}
+}
+
+func TestFeatureTagsReadErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		hv   base.HeaderValue
+		err  string
+	}{
+		{
+			"empty",
+			base.HeaderValue{},
+			"value not provided",
+		},
+		{
+			"empty tag",
+			base.HeaderValue{"onvif-replay,,play.basic"},
+			"empty option tag (onvif-replay,,play.basic)",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var h FeatureTags
+			err := h.Read(ca.hv)
+			require.Equal(t, ca.err, err.Error())
+		})
+	}
+}
+
+func TestFeatureTagsWrite(t *testing.T) {
+	for _, ca := range casesFeatureTags {
+		t.Run(ca.name, func(t *testing.T) {
+			req := ca.h.Write()
+			require.Equal(t, ca.vout, req)
+		})
+	}
+}
+
+func TestFeatureTagsContains(t *testing.T) {
+	h := FeatureTags{"onvif-replay", "play.basic"}
+	require.Equal(t, true, h.Contains(FeatureTagONVIFReplay))
+	require.Equal(t, false, h.Contains("setup.rtp.rtcp.mux"))
+}
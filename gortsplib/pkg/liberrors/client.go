@@ -196,3 +196,11 @@ type ErrClientRTPInfoInvalid struct {
 func (e ErrClientRTPInfoInvalid) Error() string {
 	return fmt.Sprintf("invalid RTP-Info: %v", e.Err)
 }
+
+// ErrClientTooManyRedirects is an error that can be returned by a client.
+type ErrClientTooManyRedirects struct{}
+
+// Error implements the error interface.
+func (e ErrClientTooManyRedirects) Error() string {
+	return "too many redirects"
+}
@@ -31,6 +31,14 @@ func (e ErrServerSessionTimedOut) Error() string {
 	return "timed out"
 }
 
+// ErrServerSessionSlowReader is an error that can be returned by a server.
+type ErrServerSessionSlowReader struct{}
+
+// Error implements the error interface.
+func (e ErrServerSessionSlowReader) Error() string {
+	return "reader is too slow"
+}
+
 // ErrServerTCPFramesEnable is an error that can be returned by a server.
 type ErrServerTCPFramesEnable struct{}
 
@@ -257,3 +265,13 @@ type ErrServerPathHasChanged struct {
 func (e ErrServerPathHasChanged) Error() string {
 	return fmt.Sprintf("path has changed, was '%s', now is '%s'", e.Prev, e.Cur)
 }
+
+// ErrServerRequireHeaderInvalid is an error that can be returned by a server.
+type ErrServerRequireHeaderInvalid struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e ErrServerRequireHeaderInvalid) Error() string {
+	return fmt.Sprintf("invalid Require header: %v", e.Err)
+}
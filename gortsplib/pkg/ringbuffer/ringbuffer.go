@@ -39,7 +39,7 @@ func (r *RingBuffer) Reset() {
 		atomic.SwapPointer(&r.buffer[i], nil)
 	}
 	atomic.SwapUint64(&r.writeIndex, 0)
-	r.readIndex = 1
+	atomic.StoreUint64(&r.readIndex, 1)
 	atomic.StoreInt64(&r.closed, 0)
 }
 
@@ -54,7 +54,7 @@ func (r *RingBuffer) Push(data interface{}) {
 // Pull pulls some data from the beginning of the buffer.
 func (r *RingBuffer) Pull() (interface{}, bool) {
 	for {
-		i := r.readIndex % r.bufferSize
+		i := atomic.LoadUint64(&r.readIndex) % r.bufferSize
 		res := (*interface{})(atomic.SwapPointer(&r.buffer[i], nil))
 		if res == nil {
 			if atomic.SwapInt64(&r.closed, 0) == 1 {
@@ -64,7 +64,28 @@ func (r *RingBuffer) Pull() (interface{}, bool) {
 			continue
 		}
 
-		r.readIndex++
+		atomic.AddUint64(&r.readIndex, 1)
 		return *res, true
 	}
 }
+
+// Size returns the size of the buffer.
+func (r *RingBuffer) Size() uint64 {
+	return r.bufferSize
+}
+
+// Len returns the number of entries that are waiting to be pulled.
+// When the buffer is full, Push() overwrites the oldest entries.
+func (r *RingBuffer) Len() uint64 {
+	writeIndex := atomic.LoadUint64(&r.writeIndex)
+	readIndex := atomic.LoadUint64(&r.readIndex)
+	if readIndex > writeIndex {
+		return 0
+	}
+
+	n := writeIndex + 1 - readIndex
+	if n > r.bufferSize {
+		return r.bufferSize
+	}
+	return n
+}
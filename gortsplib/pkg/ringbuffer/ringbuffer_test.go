@@ -137,3 +137,24 @@ func BenchmarkPushPullPaused10(b *testing.B) {
 		<-done
 	}
 }
+
+func TestLen(t *testing.T) {
+	r := New(4)
+	defer r.Close()
+
+	require.Equal(t, uint64(4), r.Size())
+	require.Equal(t, uint64(0), r.Len())
+
+	r.Push([]byte{0x01})
+	r.Push([]byte{0x02})
+	require.Equal(t, uint64(2), r.Len())
+
+	_, ok := r.Pull()
+	require.Equal(t, true, ok)
+	require.Equal(t, uint64(1), r.Len())
+
+	for i := 0; i < 6; i++ {
+		r.Push([]byte{0x03})
+	}
+	require.Equal(t, uint64(4), r.Len())
+}
@@ -53,7 +53,7 @@ func NewEncoder(payloadType uint8,
 }
 
 func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
-	return e.initialTs + uint32(ts.Seconds()*e.clockRate)
+	return e.initialTs + uint32(int64(ts.Seconds()*e.clockRate))
 }
 
 // Encode encodes AUs into RTP/AAC packets.
@@ -51,7 +51,7 @@ func NewEncoder(payloadType uint8,
 }
 
 func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
-	return e.initialTs + uint32(ts.Seconds()*rtpClockRate)
+	return e.initialTs + uint32(int64(ts.Seconds()*rtpClockRate))
 }
 
 // Encode encodes NALUs into RTP/H264 packets.
@@ -0,0 +1,15 @@
+package rtph264
+
+// IsNonReference checks whether a RTP/H264 payload contains only NALUs that
+// are not used as reference by other NALUs, and can be discarded without
+// preventing the decoding of the next ones.
+func IsNonReference(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	// in single NALUs, fragments and aggregations, the NRI field
+	// is the highest value of the NRI fields of the contained NALUs.
+	nri := (payload[0] >> 5) & 0x03
+	return nri == 0
+}
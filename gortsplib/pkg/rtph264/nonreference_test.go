@@ -0,0 +1,45 @@
+package rtph264
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNonReference(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		ok      bool
+	}{
+		{
+			"idr",
+			[]byte{0x65, 0x88, 0x84},
+			false,
+		},
+		{
+			"reference",
+			[]byte{0x41, 0x9a, 0x24},
+			false,
+		},
+		{
+			"non-reference",
+			[]byte{0x01, 0x9e, 0x42},
+			true,
+		},
+		{
+			"fu-a non-reference",
+			[]byte{0x1c, 0x81, 0x9e},
+			true,
+		},
+		{
+			"empty",
+			[]byte{},
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.ok, IsNonReference(ca.payload))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package rtph264
+
+import (
+	"encoding/binary"
+)
+
+// IsRandomAccess checks whether a RTP/H264 payload contains an IDR NALU or
+// the fragment that starts one, that allows to start decoding the stream.
+func IsRandomAccess(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	typ := naluType(payload[0] & 0x1F)
+
+	switch typ {
+	case naluTypeIDR, naluTypeSPS, naluTypePPS:
+		return true
+
+	case naluTypeSTAPA:
+		payload = payload[1:]
+
+		for len(payload) >= 2 {
+			size := int(binary.BigEndian.Uint16(payload))
+			payload = payload[2:]
+
+			if size == 0 || size > len(payload) {
+				return false
+			}
+
+			switch naluType(payload[0] & 0x1F) {
+			case naluTypeIDR, naluTypeSPS, naluTypePPS:
+				return true
+			}
+
+			payload = payload[size:]
+		}
+
+	case naluTypeFUA:
+		if len(payload) < 2 {
+			return false
+		}
+
+		start := payload[1] >> 7
+		return start == 1 && naluType(payload[1]&0x1F) == naluTypeIDR
+	}
+
+	return false
+}
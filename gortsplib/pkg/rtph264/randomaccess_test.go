@@ -0,0 +1,55 @@
+package rtph264
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRandomAccess(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		ok      bool
+	}{
+		{
+			"idr",
+			[]byte{0x65, 0x88, 0x84},
+			true,
+		},
+		{
+			"non-idr",
+			[]byte{0x41, 0x9a, 0x24},
+			false,
+		},
+		{
+			"stap-a with sps",
+			[]byte{0x18, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce},
+			true,
+		},
+		{
+			"stap-a without idr",
+			[]byte{0x18, 0x00, 0x02, 0x06, 0x05, 0x00, 0x02, 0x41, 0x9a},
+			false,
+		},
+		{
+			"fu-a start of idr",
+			[]byte{0x7c, 0x85, 0x88},
+			true,
+		},
+		{
+			"fu-a continuation of idr",
+			[]byte{0x7c, 0x05, 0x88},
+			false,
+		},
+		{
+			"empty",
+			[]byte{},
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.ok, IsRandomAccess(ca.payload))
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -463,6 +464,18 @@ func TestEncodeRandomInitialState(t *testing.T) {
 	NewEncoder(96, nil, nil, nil)
 }
 
+func TestEncodeNegativeTimestamp(t *testing.T) {
+	initialTs := uint32(0x88776655)
+	e := NewEncoder(96, nil, nil, &initialTs)
+	enc, err := e.Encode([][]byte{{0x05}}, -1*time.Second)
+	require.NoError(t, err)
+
+	var pkt rtp.Packet
+	err = pkt.Unmarshal(enc[0])
+	require.NoError(t, err)
+	require.Equal(t, initialTs-90000, pkt.Timestamp)
+}
+
 type dummyReader struct {
 	byts [][]byte
 	i    int
@@ -0,0 +1,148 @@
+// Package rtponvif contains utilities to read and write the ONVIF replay RTP header extension.
+package rtponvif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	// ExtensionProfile is the profile of the ONVIF replay header extension.
+	ExtensionProfile = 0xABAC
+
+	rtpHeaderSize = 12
+
+	// length of the extension body, in 32-bit words
+	extensionLength = 3
+
+	// seconds between 1st January 1900 and 1st January 1970
+	ntpEpochOffset = 2208988800
+)
+
+// ReplayExtension is the ONVIF replay RTP header extension, that allows to send
+// the absolute time of recorded content.
+// Specification: ONVIF Streaming Specification, 6.3
+type ReplayExtension struct {
+	// absolute time of the first byte of the access unit.
+	NTPTime time.Time
+
+	// whether the access unit can be decoded independently from the previous ones.
+	CleanPoint bool
+
+	// whether this is the last packet of the recording.
+	End bool
+
+	// whether there's a gap in the recording before this packet.
+	Discontinuity bool
+
+	// lower 8 bits of the CSeq of the request that started the playback.
+	CSeq uint8
+}
+
+func headerSize(pkt []byte) (int, error) {
+	if len(pkt) < rtpHeaderSize {
+		return 0, fmt.Errorf("packet is too short")
+	}
+
+	if (pkt[0] >> 6) != 2 {
+		return 0, fmt.Errorf("unsupported RTP version (%d)", pkt[0]>>6)
+	}
+
+	n := rtpHeaderSize + int(pkt[0]&0x0F)*4
+	if len(pkt) < n {
+		return 0, fmt.Errorf("packet is too short")
+	}
+
+	return n, nil
+}
+
+func encodeNTP(t time.Time) uint64 {
+	ns := t.UnixNano()
+	secs := uint64(ns/1000000000) + ntpEpochOffset
+	frac := (uint64(ns%1000000000) << 32) / 1000000000
+	return secs<<32 | frac
+}
+
+func decodeNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	ns := int64(((v & 0xFFFFFFFF) * 1000000000) >> 32)
+	return time.Unix(secs, ns)
+}
+
+// Read decodes the extension from a RTP packet.
+func (e *ReplayExtension) Read(pkt []byte) error {
+	n, err := headerSize(pkt)
+	if err != nil {
+		return err
+	}
+
+	if (pkt[0] & 0x10) == 0 {
+		return fmt.Errorf("packet doesn't contain a header extension")
+	}
+
+	if len(pkt) < (n + 4) {
+		return fmt.Errorf("packet is too short")
+	}
+
+	profile := binary.BigEndian.Uint16(pkt[n:])
+	if profile != ExtensionProfile {
+		return fmt.Errorf("unsupported extension profile (0x%04X)", profile)
+	}
+
+	length := int(binary.BigEndian.Uint16(pkt[n+2:]))
+	if length < extensionLength {
+		return fmt.Errorf("invalid extension length (%d)", length)
+	}
+
+	if len(pkt) < (n + 4 + length*4) {
+		return fmt.Errorf("packet is too short")
+	}
+
+	body := pkt[n+4:]
+	e.NTPTime = decodeNTP(binary.BigEndian.Uint64(body))
+	e.CleanPoint = (body[8] & 0x80) != 0
+	e.End = (body[8] & 0x40) != 0
+	e.Discontinuity = (body[8] & 0x20) != 0
+	e.CSeq = body[9]
+
+	return nil
+}
+
+// Write inserts the extension into a RTP packet.
+// It returns a new packet; the input packet is not modified.
+func (e ReplayExtension) Write(pkt []byte) ([]byte, error) {
+	n, err := headerSize(pkt)
+	if err != nil {
+		return nil, err
+	}
+
+	if (pkt[0] & 0x10) != 0 {
+		return nil, fmt.Errorf("packet already contains a header extension")
+	}
+
+	out := make([]byte, len(pkt)+4+extensionLength*4)
+	copy(out, pkt[:n])
+	out[0] |= 0x10
+
+	binary.BigEndian.PutUint16(out[n:], ExtensionProfile)
+	binary.BigEndian.PutUint16(out[n+2:], extensionLength)
+
+	body := out[n+4:]
+	binary.BigEndian.PutUint64(body, encodeNTP(e.NTPTime))
+
+	if e.CleanPoint {
+		body[8] |= 0x80
+	}
+	if e.End {
+		body[8] |= 0x40
+	}
+	if e.Discontinuity {
+		body[8] |= 0x20
+	}
+	body[9] = e.CSeq
+
+	copy(body[extensionLength*4:], pkt[n:])
+
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package rtponvif
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayExtension(t *testing.T) {
+	pkt := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 946,
+			Timestamp:      1287987768,
+			SSRC:           0xba9da416,
+		},
+		Payload: []byte{0x01, 0x02, 0x03, 0x04},
+	}
+	byts, _ := pkt.Marshal()
+
+	ext := ReplayExtension{
+		NTPTime:    time.Date(2008, 0o5, 20, 22, 15, 20, 0, time.UTC),
+		CleanPoint: true,
+		End:        false,
+		CSeq:       5,
+	}
+
+	enc, err := ext.Write(byts)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x90, 0xe0, 0x03, 0xb2, 0x4c, 0xc5, 0x22, 0x38,
+		0xba, 0x9d, 0xa4, 0x16, 0xab, 0xac, 0x00, 0x03,
+		0xcb, 0xdd, 0xcb, 0xf8, 0x00, 0x00, 0x00, 0x00,
+		0x80, 0x05, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04,
+	}, enc)
+
+	// the packet must still be readable by a standard decoder
+	var dec rtp.Packet
+	err = dec.Unmarshal(enc)
+	require.NoError(t, err)
+	require.Equal(t, pkt.Payload, dec.Payload)
+	require.Equal(t, pkt.SequenceNumber, dec.SequenceNumber)
+
+	var ext2 ReplayExtension
+	err = ext2.Read(enc)
+	require.NoError(t, err)
+	require.Equal(t, ext.NTPTime.UnixNano(), ext2.NTPTime.UnixNano())
+	ext2.NTPTime = ext.NTPTime
+	require.Equal(t, ext, ext2)
+}
+
+func TestReplayExtensionErrors(t *testing.T) {
+	ext := ReplayExtension{}
+
+	_, err := ext.Write([]byte{0x80, 0x60})
+	require.Equal(t, "packet is too short", err.Error())
+
+	_, err = ext.Write([]byte{
+		0x90, 0xe0, 0x03, 0xb2, 0x4c, 0xc5, 0x22, 0x38,
+		0xba, 0x9d, 0xa4, 0x16,
+	})
+	require.Equal(t, "packet already contains a header extension", err.Error())
+
+	err = ext.Read([]byte{
+		0x80, 0xe0, 0x03, 0xb2, 0x4c, 0xc5, 0x22, 0x38,
+		0xba, 0x9d, 0xa4, 0x16,
+	})
+	require.Equal(t, "packet doesn't contain a header extension", err.Error())
+
+	err = ext.Read([]byte{
+		0x90, 0xe0, 0x03, 0xb2, 0x4c, 0xc5, 0x22, 0x38,
+		0xba, 0x9d, 0xa4, 0x16, 0xbe, 0xde, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+	})
+	require.Equal(t, "unsupported extension profile (0xBEDE)", err.Error())
+}
@@ -82,6 +82,11 @@ type Server struct {
 	WriteTimeout time.Duration
 	// a TLS configuration to accept TLS (RTSPS) connections.
 	TLSConfig *tls.Config
+	// option tags of the features supported by the server.
+	// They are advertised through the Supported header, and requests
+	// that require other features are rejected.
+	// The only option tag with a built-in implementation is headers.FeatureTagONVIFReplay.
+	SupportedFeatures []string
 	// a port to send and receive RTP packets with UDP.
 	// If UDPRTPAddress and UDPRTCPAddress are filled, the server can read and write UDP streams.
 	UDPRTPAddress string
@@ -108,6 +113,12 @@ type Server struct {
 	// This must be touched only when the server reports problems about buffer sizes.
 	// It defaults to 2048.
 	ReadBufferSize int
+	// readers that read with TCP, can't keep up with the stream and keep
+	// dropping frames for longer than this amount of time, are disconnected.
+	// When a reader can't keep up, H264 non-reference frames are dropped first,
+	// then entire GOPs until the buffer is emptied.
+	// It defaults to zero, that means that readers are never disconnected.
+	SlowReaderTimeout time.Duration
 
 	//
 	// system functions
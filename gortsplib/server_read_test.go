@@ -15,6 +15,7 @@ import (
 
 	"github.com/aler9/gortsplib/pkg/base"
 	"github.com/aler9/gortsplib/pkg/headers"
+	"github.com/aler9/gortsplib/pkg/rtponvif"
 )
 
 func multicastCapableIP(t *testing.T) string {
@@ -685,6 +686,333 @@ func TestServerReadTCPResponseBeforeFrames(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestServerReadOnReaderActive(t *testing.T) {
+	track, err := NewTrackH264(96, &TrackConfigH264{[]byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	packet := func(seq uint16, ts uint32) []byte {
+		byts, _ := (&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: seq,
+				Timestamp:      ts,
+				SSRC:           0x38F27A2F,
+			},
+			Payload: []byte{0x01, 0x02},
+		}).Marshal()
+		return byts
+	}
+
+	// the last packet of the stream
+	stream.WriteFrame(0, StreamTypeRTP, packet(12, 3000))
+
+	stream.SetOnReaderActive(func(ss *ServerSession, replay func(int, []byte), activate func()) {
+		replay(0, packet(10, 1000))
+		replay(0, packet(11, 2000))
+		activate()
+		stream.WriteFrame(0, StreamTypeRTP, packet(13, 4000))
+	})
+
+	s := &Server{
+		Handler: &testServerHandler{
+			onSetup: func(ctx *ServerHandlerOnSetupCtx) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(ctx *ServerHandlerOnPlayCtx) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+	}
+
+	err = s.Start("localhost:8554")
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	res, err := writeReqReadRes(bconn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": res.Header["Session"],
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	// RTP-Info refers to the first replayed packet
+	var ri headers.RTPInfo
+	err = ri.Read(res.Header["RTP-Info"])
+	require.NoError(t, err)
+	require.Equal(t, uint16(10), *ri[0].SequenceNumber)
+	require.Equal(t, uint32(1000), *ri[0].Timestamp)
+
+	for _, expected := range [][]byte{packet(10, 1000), packet(11, 2000), packet(13, 4000)} {
+		var fr base.InterleavedFrame
+		fr.Payload = make([]byte, 2048)
+		err = fr.Read(bconn.Reader)
+		require.NoError(t, err)
+		require.Equal(t, 0, fr.Channel)
+		require.Equal(t, expected, fr.Payload)
+	}
+}
+
+func TestServerReadONVIFReplay(t *testing.T) {
+	track, err := NewTrackH264(96, &TrackConfigH264{[]byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	stream := NewServerStream(Tracks{track})
+
+	s := &Server{
+		Handler: &testServerHandler{
+			onSetup: func(ctx *ServerHandlerOnSetupCtx) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(ctx *ServerHandlerOnPlayCtx) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+		SupportedFeatures: []string{headers.FeatureTagONVIFReplay},
+	}
+
+	err = s.Start("localhost:8554")
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	res, err := writeReqReadRes(bconn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"1"},
+			"Require": base.HeaderValue{"onvif-replay"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":         base.HeaderValue{"2"},
+			"Session":      res.Header["Session"],
+			"Require":      base.HeaderValue{"onvif-replay"},
+			"Rate-Control": base.HeaderValue{"no"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"no"}, res.Header["Rate-Control"])
+
+	pkt := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 946,
+			Timestamp:      1287987768,
+			SSRC:           0xba9da416,
+		},
+		Payload: []byte{0x65, 0x88, 0x84},
+	}
+	byts, _ := pkt.Marshal()
+	ntp := time.Date(2008, 0o5, 20, 22, 15, 20, 0, time.UTC)
+	stream.WriteFrameNTP(0, StreamTypeRTP, byts, ntp)
+
+	var fr base.InterleavedFrame
+	fr.Payload = make([]byte, 2048)
+	err = fr.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, 0, fr.Channel)
+
+	var ext rtponvif.ReplayExtension
+	err = ext.Read(fr.Payload)
+	require.NoError(t, err)
+	require.Equal(t, ntp.UnixNano(), ext.NTPTime.UnixNano())
+	require.Equal(t, true, ext.CleanPoint)
+	require.Equal(t, uint8(2), ext.CSeq)
+
+	var pkt2 rtp.Packet
+	err = pkt2.Unmarshal(fr.Payload)
+	require.NoError(t, err)
+	require.Equal(t, pkt.Payload, pkt2.Payload)
+}
+
+func TestServerReadONVIFReplayConcurrentWrite(t *testing.T) {
+	track, err := NewTrackH264(96, &TrackConfigH264{[]byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	packet := func(seq uint16, ts uint32) []byte {
+		byts, _ := (&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: seq,
+				Timestamp:      ts,
+				SSRC:           0x38F27A2F,
+			},
+			Payload: []byte{0x65, 0x01},
+		}).Marshal()
+		return byts
+	}
+
+	// frames are written by the source while the reader is replayed
+	writerTerminate := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for i := 0; ; i++ {
+			select {
+			case <-writerTerminate:
+				return
+			default:
+			}
+
+			stream.WriteFrameNTP(0, StreamTypeRTP, packet(uint16(i), uint32(i)*3000), time.Now())
+		}
+	}()
+
+	stream.SetOnReaderActive(func(ss *ServerSession, replay func(int, []byte), activate func()) {
+		for i := 0; i < 10; i++ {
+			replay(0, packet(uint16(i), uint32(i)*3000))
+		}
+
+		close(writerTerminate)
+		<-writerDone
+		activate()
+	})
+
+	s := &Server{
+		Handler: &testServerHandler{
+			onSetup: func(ctx *ServerHandlerOnSetupCtx) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(ctx *ServerHandlerOnPlayCtx) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+		SupportedFeatures: []string{headers.FeatureTagONVIFReplay},
+	}
+
+	err = s.Start("localhost:8554")
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	res, err := writeReqReadRes(bconn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"1"},
+			"Require": base.HeaderValue{"onvif-replay"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": res.Header["Session"],
+			"Require": base.HeaderValue{"onvif-replay"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	for i := 0; i < 10; i++ {
+		var fr base.InterleavedFrame
+		fr.Payload = make([]byte, 2048)
+		err = fr.Read(bconn.Reader)
+		require.NoError(t, err)
+
+		var ext rtponvif.ReplayExtension
+		err = ext.Read(fr.Payload)
+		require.NoError(t, err)
+		require.Equal(t, true, ext.CleanPoint)
+	}
+}
+
 func TestServerReadPlayPlay(t *testing.T) {
 	track, err := NewTrackH264(96, &TrackConfigH264{[]byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04}})
 	require.NoError(t, err)
@@ -883,6 +883,198 @@ func TestServerGetSetParameter(t *testing.T) {
 	require.Equal(t, []byte("param1: 123456\r\n"), res.Body)
 }
 
+// handler that doesn't implement any callback
+type testServerHandlerEmpty struct{}
+
+func TestServerGetSetParameterDefault(t *testing.T) {
+	s := &Server{
+		Handler: &testServerHandlerEmpty{},
+	}
+
+	err := s.Start("localhost:8554")
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	res, err := writeReqReadRes(bconn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"GET_PARAMETER, SET_PARAMETER, TEARDOWN"}, res.Header["Public"])
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.GetParameter,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.SetParameter,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"3"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.SetParameter,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"4"},
+		},
+		Body: []byte("param1: 123456\r\n"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusParameterNotUnderstood, res.StatusCode)
+
+	// the connection is still open
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"5"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
+func TestServerSetParameterInSession(t *testing.T) {
+	track, err := NewTrackH264(96, &TrackConfigH264{[]byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	stream := NewServerStream(Tracks{track})
+
+	var session *ServerSession
+
+	s := &Server{
+		Handler: &testServerHandler{
+			onSetup: func(ctx *ServerHandlerOnSetupCtx) (*base.Response, *ServerStream, error) {
+				session = ctx.Session
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onSetParameter: func(ctx *ServerHandlerOnSetParameterCtx) (*base.Response, error) {
+				require.Equal(t, session, ctx.Session)
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+	}
+
+	err = s.Start("localhost:8554")
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	res, err := writeReqReadRes(bconn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.SetParameter,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": res.Header["Session"],
+		},
+		Body: []byte("param1: 123456\r\n"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
+func TestServerRequire(t *testing.T) {
+	s := &Server{
+		Handler:           &testServerHandlerEmpty{},
+		SupportedFeatures: []string{headers.FeatureTagONVIFReplay},
+	}
+
+	err := s.Start("localhost:8554")
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	res, err := writeReqReadRes(bconn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"onvif-replay"}, res.Header["Supported"])
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Require": base.HeaderValue{"onvif-replay, play.basic"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOptionNotSupported, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"play.basic"}, res.Header["Unsupported"])
+
+	res, err = writeReqReadRes(bconn, base.Request{
+		Method: base.GetParameter,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":      base.HeaderValue{"3"},
+			"Require":   base.HeaderValue{"onvif-replay"},
+			"Supported": base.HeaderValue{"onvif-replay"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"onvif-replay"}, res.Header["Supported"])
+}
+
 func TestServerErrorInvalidSession(t *testing.T) {
 	for _, method := range []base.Method{
 		base.Play,
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/headers"
 	"github.com/aler9/gortsplib/pkg/liberrors"
 	"github.com/aler9/gortsplib/pkg/multibuffer"
 	"github.com/aler9/gortsplib/pkg/ringbuffer"
@@ -28,6 +29,20 @@ func stringsReverseIndex(s, substr string) int {
 	return -1
 }
 
+// response to a SET_PARAMETER request that is not processed by a handler.
+func setParameterDefaultResponse(req *base.Request) *base.Response {
+	// SET_PARAMETER without body is used like a ping; reply with 200
+	if len(req.Body) == 0 {
+		return &base.Response{
+			StatusCode: base.StatusOK,
+		}
+	}
+
+	return &base.Response{
+		StatusCode: base.StatusParameterNotUnderstood,
+	}
+}
+
 func getSessionID(header base.Header) string {
 	if h, ok := header["Session"]; ok && len(h) == 1 {
 		return h[0]
@@ -280,6 +295,33 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 		}, liberrors.ErrServerCSeqMissing{}
 	}
 
+	// check whether the features required by the client are supported
+	if v, ok := req.Header["Require"]; ok {
+		var required headers.FeatureTags
+		err := required.Read(v)
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, liberrors.ErrServerRequireHeaderInvalid{Err: err}
+		}
+
+		var unsupported headers.FeatureTags
+		for _, tag := range required {
+			if !headers.FeatureTags(sc.s.SupportedFeatures).Contains(tag) {
+				unsupported = append(unsupported, tag)
+			}
+		}
+
+		if len(unsupported) > 0 {
+			return &base.Response{
+				StatusCode: base.StatusOptionNotSupported,
+				Header: base.Header{
+					"Unsupported": unsupported.Write(),
+				},
+			}, nil
+		}
+	}
+
 	sxID := getSessionID(req.Header)
 
 	// the connection can't communicate with another session
@@ -320,9 +362,7 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 			methods = append(methods, string(base.Pause))
 		}
 		methods = append(methods, string(base.GetParameter))
-		if _, ok := sc.s.Handler.(ServerHandlerOnSetParameter); ok {
-			methods = append(methods, string(base.SetParameter))
-		}
+		methods = append(methods, string(base.SetParameter))
 		methods = append(methods, string(base.Teardown))
 
 		return &base.Response{
@@ -448,7 +488,23 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 			})
 		}
 
+		// GET_PARAMETER is used like a ping before a session is created too
+		return &base.Response{
+			StatusCode: base.StatusOK,
+			Header: base.Header{
+				"Content-Type": base.HeaderValue{"text/parameters"},
+			},
+			Body: []byte("\n"),
+		}, nil
+
 	case base.SetParameter:
+		// handle request in session
+		if sxID != "" {
+			_, res, err := sc.handleRequestInSession(sxID, req, false)
+			return res, err
+		}
+
+		// handle request here
 		if h, ok := sc.s.Handler.(ServerHandlerOnSetParameter); ok {
 			pathAndQuery, ok := req.URL.RTSPPath()
 			if !ok {
@@ -466,6 +522,8 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 				Query: query,
 			})
 		}
+
+		return setParameterDefaultResponse(req), nil
 	}
 
 	return &base.Response{
@@ -492,6 +550,13 @@ func (sc *ServerConn) handleRequestOuter(req *base.Request) error {
 	// add server
 	res.Header["Server"] = base.HeaderValue{"gortsplib"}
 
+	// add supported features
+	if len(sc.s.SupportedFeatures) > 0 {
+		if _, ok := req.Header["Supported"]; ok || req.Method == base.Options {
+			res.Header["Supported"] = headers.FeatureTags(sc.s.SupportedFeatures).Write()
+		}
+	}
+
 	if h, ok := sc.s.Handler.(ServerHandlerOnResponse); ok {
 		h.OnResponse(sc, res)
 	}
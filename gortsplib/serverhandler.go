@@ -169,10 +169,11 @@ type ServerHandlerOnGetParameter interface {
 
 // ServerHandlerOnSetParameterCtx is the context of a SET_PARAMETER request.
 type ServerHandlerOnSetParameterCtx struct {
-	Conn  *ServerConn
-	Req   *base.Request
-	Path  string
-	Query string
+	Session *ServerSession
+	Conn    *ServerConn
+	Req     *base.Request
+	Path    string
+	Query   string
 }
 
 // ServerHandlerOnSetParameter can be implemented by a ServerHandler.
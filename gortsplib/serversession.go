@@ -2,15 +2,18 @@ package gortsplib
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/framedropper"
 	"github.com/aler9/gortsplib/pkg/headers"
 	"github.com/aler9/gortsplib/pkg/liberrors"
 	"github.com/aler9/gortsplib/pkg/rtcpreceiver"
@@ -141,10 +144,16 @@ type ServerSession struct {
 	udpZone                 string                        // udp
 	announcedTracks         []ServerSessionAnnouncedTrack // publish
 	udpLastFrameTime        *int64                        // publish, udp
+	onvifReplay             bool                          // read
+	onvifReplayCSeq         uint8                         // read
+	frameDropperMutex       sync.Mutex                    // read, tcp
+	frameDropper            *framedropper.FrameDropper    // read, tcp
+	framesDropped           *uint64                       // read, tcp
 
 	// in
 	request    chan sessionRequestReq
 	connRemove chan *ServerConn
+	slowReader chan struct{}
 }
 
 func newServerSession(
@@ -164,6 +173,11 @@ func newServerSession(
 		lastRequestTime: time.Now(),
 		request:         make(chan sessionRequestReq),
 		connRemove:      make(chan *ServerConn),
+		slowReader:      make(chan struct{}, 1),
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
 	}
 
 	s.wg.Add(1)
@@ -203,6 +217,12 @@ func (ss *ServerSession) AnnouncedTracks() []ServerSessionAnnouncedTrack {
 	return ss.announcedTracks
 }
 
+// FramesDropped returns the number of frames that were not sent to the session,
+// since it was reading with TCP and couldn't keep up with the stream.
+func (ss *ServerSession) FramesDropped() uint64 {
+	return atomic.LoadUint64(ss.framesDropped)
+}
+
 func (ss *ServerSession) checkState(allowed map[ServerSessionState]struct{}) error {
 	if _, ok := allowed[ss.state]; ok {
 		return nil
@@ -316,6 +336,9 @@ func (ss *ServerSession) run() {
 					ss.WriteFrame(trackID, StreamTypeRTCP, r)
 				}
 
+			case <-ss.slowReader:
+				return liberrors.ErrServerSessionSlowReader{}
+
 			case <-ss.ctx.Done():
 				return liberrors.ErrServerTerminated{}
 			}
@@ -400,9 +423,7 @@ func (ss *ServerSession) handleRequest(sc *ServerConn, req *base.Request) (*base
 			methods = append(methods, string(base.Pause))
 		}
 		methods = append(methods, string(base.GetParameter))
-		if _, ok := sc.s.Handler.(ServerHandlerOnSetParameter); ok {
-			methods = append(methods, string(base.SetParameter))
-		}
+		methods = append(methods, string(base.SetParameter))
 		methods = append(methods, string(base.Teardown))
 
 		return &base.Response{
@@ -786,8 +807,29 @@ func (ss *ServerSession) handleRequest(sc *ServerConn, req *base.Request) (*base
 					ss.udpZone = sc.zone()
 				} else {
 					ss.tcpConn = sc
+					ss.frameDropper = framedropper.New(ss.setuppedStream.videoTrackID(ss.setuppedTracks),
+						sc.tcpFrameWriteBuffer.Size())
 				}
 
+				// the Require header has already been validated by the connection
+				var required headers.FeatureTags
+				required.Read(req.Header["Require"])
+				ss.onvifReplay = required.Contains(headers.FeatureTagONVIFReplay)
+
+				if ss.onvifReplay {
+					cseq, _ := strconv.ParseUint(req.Header["CSeq"][0], 10, 64)
+					ss.onvifReplayCSeq = uint8(cseq)
+
+					if v, ok := req.Header["Rate-Control"]; ok {
+						if res.Header == nil {
+							res.Header = make(base.Header)
+						}
+						res.Header["Rate-Control"] = v
+					}
+				}
+
+				replayed := ss.setuppedStream.readerActivate(ss)
+
 				// add RTP-Info
 				var trackIDs []int
 				for trackID := range ss.setuppedTracks {
@@ -798,9 +840,19 @@ func (ss *ServerSession) handleRequest(sc *ServerConn, req *base.Request) (*base
 				})
 				var ri headers.RTPInfo
 				for _, trackID := range trackIDs {
-					ts := ss.setuppedStream.timestamp(trackID)
-					if ts == 0 {
-						continue
+					var lsn uint16
+					var ts uint32
+
+					// past packets have been replayed: RTP-Info refers to the first of them
+					if pkt, ok := replayed[trackID]; ok {
+						lsn = binary.BigEndian.Uint16(pkt[2:4])
+						ts = binary.BigEndian.Uint32(pkt[4:8])
+					} else {
+						ts = ss.setuppedStream.timestamp(trackID)
+						if ts == 0 {
+							continue
+						}
+						lsn = ss.setuppedStream.lastSequenceNumber(trackID)
 					}
 
 					u := &base.URL{
@@ -810,8 +862,6 @@ func (ss *ServerSession) handleRequest(sc *ServerConn, req *base.Request) (*base
 						Path:   "/" + *ss.setuppedPath + "/trackID=" + strconv.FormatInt(int64(trackID), 10),
 					}
 
-					lsn := ss.setuppedStream.lastSequenceNumber(trackID)
-
 					ri = append(ri, &headers.RTPInfoEntry{
 						URL:            u.String(),
 						SequenceNumber: &lsn,
@@ -825,8 +875,6 @@ func (ss *ServerSession) handleRequest(sc *ServerConn, req *base.Request) (*base
 					res.Header["RTP-Info"] = ri.Write()
 				}
 
-				ss.setuppedStream.readerSetActive(ss)
-
 				if *ss.setuppedProtocol == base.StreamProtocolUDP {
 					if *ss.setuppedDelivery == base.StreamDeliveryUnicast {
 						for trackID, track := range ss.setuppedTracks {
@@ -1024,6 +1072,28 @@ func (ss *ServerSession) handleRequest(sc *ServerConn, req *base.Request) (*base
 			},
 			Body: []byte("\n"),
 		}, nil
+
+	case base.SetParameter:
+		if h, ok := sc.s.Handler.(ServerHandlerOnSetParameter); ok {
+			pathAndQuery, ok := req.URL.RTSPPath()
+			if !ok {
+				return &base.Response{
+					StatusCode: base.StatusBadRequest,
+				}, liberrors.ErrServerInvalidPath{}
+			}
+
+			path, query := base.PathSplitQuery(pathAndQuery)
+
+			return h.OnSetParameter(&ServerHandlerOnSetParameterCtx{
+				Session: ss,
+				Conn:    sc,
+				Req:     req,
+				Path:    path,
+				Query:   query,
+			})
+		}
+
+		return setParameterDefaultResponse(req), nil
 	}
 
 	return &base.Response{
@@ -1056,6 +1126,31 @@ func (ss *ServerSession) WriteFrame(trackID int, streamType StreamType, payload
 			}
 		}
 	} else {
+		if ss.frameDropper != nil {
+			// frames are written by the stream writer and by the RTCP sender,
+			// at the same time.
+			ss.frameDropperMutex.Lock()
+			ok := ss.frameDropper.Filter(trackID, streamType, payload, ss.tcpConn.tcpFrameWriteBuffer.Len())
+			behind := ss.frameDropper.Behind()
+			ss.frameDropperMutex.Unlock()
+
+			if !ok {
+				atomic.AddUint64(ss.framesDropped, 1)
+			}
+
+			if ss.s.SlowReaderTimeout != 0 && behind >= ss.s.SlowReaderTimeout {
+				select {
+				case ss.slowReader <- struct{}{}:
+				default:
+				}
+				return
+			}
+
+			if !ok {
+				return
+			}
+		}
+
 		channel := ss.setuppedTracks[trackID].tcpChannel
 		if streamType == base.StreamTypeRTCP {
 			channel++
@@ -7,7 +7,11 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/pion/rtp"
+
 	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/aler9/gortsplib/pkg/rtponvif"
 )
 
 type listenerPair struct {
@@ -20,6 +24,39 @@ type trackInfo struct {
 	lastTimeRTP        uint32
 	lastTimeNTP        int64
 	lastSSRC           uint32
+
+	// set when the stream is created
+	isH264    bool
+	clockRate int
+
+	// written by WriteFrame and read by WriteFrame and by readerActivate,
+	// that replays frames from another goroutine
+	refTimeMutex sync.Mutex
+	refTimeSet   bool
+	refTimeRTP   uint32
+	refTimeNTP   time.Time
+}
+
+func (t *trackInfo) setRefTime(timestamp uint32, ntp time.Time) {
+	t.refTimeMutex.Lock()
+	defer t.refTimeMutex.Unlock()
+
+	if !ntp.IsZero() || !t.refTimeSet {
+		t.refTimeSet = true
+		t.refTimeRTP = timestamp
+		if !ntp.IsZero() {
+			t.refTimeNTP = ntp
+		} else {
+			t.refTimeNTP = time.Now()
+		}
+	}
+}
+
+func (t *trackInfo) refTime() (uint32, time.Time) {
+	t.refTimeMutex.Lock()
+	defer t.refTimeMutex.Unlock()
+
+	return t.refTimeRTP, t.refTimeNTP
 }
 
 // ServerStream represents a single stream.
@@ -36,6 +73,7 @@ type ServerStream struct {
 	readers            map[*ServerSession]struct{}
 	multicastListeners []*listenerPair
 	trackInfos         []*trackInfo
+	onReaderActive     func(ss *ServerSession, replay func(int, []byte), activate func())
 }
 
 // NewServerStream allocates a ServerStream.
@@ -48,8 +86,12 @@ func NewServerStream(tracks Tracks) *ServerStream {
 	st.tracks = cloneAndClearTracks(tracks)
 
 	st.trackInfos = make([]*trackInfo, len(tracks))
-	for i := range st.trackInfos {
-		st.trackInfos[i] = &trackInfo{}
+	for i, track := range st.tracks {
+		clockRate, _ := track.ClockRate()
+		st.trackInfos[i] = &trackInfo{
+			isH264:    track.IsH264(),
+			clockRate: clockRate,
+		}
 	}
 
 	return st
@@ -85,6 +127,16 @@ func (st *ServerStream) Close() error {
 	return nil
 }
 
+// SetOnReaderActive sets a callback that is called when a reader starts
+// reading the stream, after a PLAY request. The callback can send past RTP packets
+// to the reader with replay(trackID, payload); RTP-Info refers to the first
+// replayed packet of each track. Then the callback must call activate(),
+// that starts sending the frames of the stream to the reader.
+// It must be called before the stream is used.
+func (st *ServerStream) SetOnReaderActive(cb func(ss *ServerSession, replay func(int, []byte), activate func())) {
+	st.onReaderActive = cb
+}
+
 // Tracks returns the tracks of the stream.
 func (st *ServerStream) Tracks() Tracks {
 	return st.tracks
@@ -166,6 +218,51 @@ func (st *ServerStream) readerRemove(ss *ServerSession) {
 	}
 }
 
+// videoTrackID returns the ID of the first H264 track among the given ones, or -1.
+func (st *ServerStream) videoTrackID(tracks map[int]ServerSessionSetuppedTrack) int {
+	ret := -1
+	for trackID := range tracks {
+		if st.trackInfos[trackID].isH264 && (ret < 0 || trackID < ret) {
+			ret = trackID
+		}
+	}
+	return ret
+}
+
+// readerActivate starts sending frames to a reader.
+// It returns the first RTP packet that has been replayed to the reader, for each track.
+func (st *ServerStream) readerActivate(ss *ServerSession) map[int][]byte {
+	if st.onReaderActive == nil {
+		st.readerSetActive(ss)
+		return nil
+	}
+
+	replayed := make(map[int][]byte)
+
+	st.onReaderActive(ss,
+		func(trackID int, payload []byte) {
+			if len(payload) < 12 {
+				return
+			}
+
+			if _, ok := replayed[trackID]; !ok {
+				replayed[trackID] = payload
+			}
+
+			if ss.onvifReplay {
+				writeFrameONVIF(ss, trackID, payload, *st.onvifExtension(st.trackInfos[trackID], payload))
+				return
+			}
+
+			ss.WriteFrame(trackID, StreamTypeRTP, payload)
+		},
+		func() {
+			st.readerSetActive(ss)
+		})
+
+	return replayed
+}
+
 func (st *ServerStream) readerSetActive(ss *ServerSession) {
 	st.mutex.Lock()
 	defer st.mutex.Unlock()
@@ -195,8 +292,19 @@ func (st *ServerStream) readerSetInactive(ss *ServerSession) {
 
 // WriteFrame writes a frame to all the readers of the stream.
 func (st *ServerStream) WriteFrame(trackID int, streamType StreamType, payload []byte) {
-	if streamType == StreamTypeRTP && len(payload) >= 8 {
-		track := st.trackInfos[trackID]
+	st.WriteFrameNTP(trackID, streamType, payload, time.Time{})
+}
+
+// WriteFrameNTP writes a frame to all the readers of the stream.
+// ntp is the absolute time of the frame, that is sent to the readers that
+// requested the ONVIF replay extension, and is useful with recorded content.
+// If it is zero, it is estimated from the RTP timestamp.
+func (st *ServerStream) WriteFrameNTP(trackID int, streamType StreamType, payload []byte, ntp time.Time) {
+	// info of the track, if the frame is a valid RTP packet
+	var track *trackInfo
+
+	if streamType == StreamTypeRTP && len(payload) >= 12 {
+		track = st.trackInfos[trackID]
 
 		sequenceNumber := binary.BigEndian.Uint16(payload[2:4])
 		atomic.StoreUint32(&track.lastSequenceNumber, uint32(sequenceNumber))
@@ -207,13 +315,25 @@ func (st *ServerStream) WriteFrame(trackID int, streamType StreamType, payload [
 
 		ssrc := binary.BigEndian.Uint32(payload[8:12])
 		atomic.StoreUint32(&track.lastSSRC, ssrc)
+
+		track.setRefTime(timestamp, ntp)
 	}
 
 	st.mutex.RLock()
 	defer st.mutex.RUnlock()
 
 	// send unicast
+	var onvifExt *rtponvif.ReplayExtension
 	for r := range st.readersUnicast {
+		if r.onvifReplay && track != nil {
+			if onvifExt == nil {
+				onvifExt = st.onvifExtension(track, payload)
+			}
+
+			writeFrameONVIF(r, trackID, payload, *onvifExt)
+			continue
+		}
+
 		r.WriteFrame(trackID, streamType, payload)
 	}
 
@@ -234,3 +354,41 @@ func (st *ServerStream) WriteFrame(trackID int, streamType StreamType, payload [
 		}
 	}
 }
+
+// writeFrameONVIF writes a RTP packet to a reader that requested the
+// ONVIF replay extension.
+func writeFrameONVIF(r *ServerSession, trackID int, payload []byte, ext rtponvif.ReplayExtension) {
+	ext.CSeq = r.onvifReplayCSeq
+
+	// packets that already contain a header extension are sent as they are
+	byts, err := ext.Write(payload)
+	if err == nil {
+		r.WriteFrame(trackID, StreamTypeRTP, byts)
+		return
+	}
+
+	r.WriteFrame(trackID, StreamTypeRTP, payload)
+}
+
+func (st *ServerStream) onvifExtension(track *trackInfo, payload []byte) *rtponvif.ReplayExtension {
+	timestamp := binary.BigEndian.Uint32(payload[4:8])
+	refTimeRTP, refTimeNTP := track.refTime()
+
+	ext := &rtponvif.ReplayExtension{
+		NTPTime:    refTimeNTP,
+		CleanPoint: true,
+	}
+
+	if track.clockRate != 0 {
+		ext.NTPTime = ext.NTPTime.Add(time.Duration(
+			int64(int32(timestamp-refTimeRTP)) * int64(time.Second) / int64(track.clockRate)))
+	}
+
+	if track.isH264 {
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(payload)
+		ext.CleanPoint = err == nil && rtph264.IsRandomAccess(pkt.Payload)
+	}
+
+	return ext
+}
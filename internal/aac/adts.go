@@ -18,6 +18,10 @@ func DecodeADTS(byts []byte) ([]*ADTSPacket, error) {
 	var ret []*ADTSPacket
 
 	for len(byts) > 0 {
+		if len(byts) < 7 {
+			return nil, fmt.Errorf("invalid length")
+		}
+
 		syncWord := (uint16(byts[0]) << 4) | (uint16(byts[1]) >> 4)
 		if syncWord != 0xfff {
 			return nil, fmt.Errorf("invalid syncword")
@@ -91,11 +95,6 @@ func DecodeADTS(byts []byte) ([]*ADTSPacket, error) {
 			(uint16(byts[4])<<3)|
 			((uint16(byts[5])>>5)&0x07)) - 7
 
-		fullness := ((uint16(byts[5]) & 0x1F) << 6) | ((uint16(byts[6]) >> 2) & 0x3F)
-		if fullness != 1800 {
-			return nil, fmt.Errorf("fullness not supported")
-		}
-
 		frameCount := byts[6] & 0x03
 		if frameCount != 0 {
 			return nil, fmt.Errorf("multiple frame count not supported")
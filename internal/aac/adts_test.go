@@ -40,6 +40,19 @@ var casesADTS = []struct {
 	},
 }
 
+func TestDecodeADTSVBR(t *testing.T) {
+	// variable bitrate, as produced by most encoders
+	pkts, err := DecodeADTS([]byte{0xff, 0xf1, 0x10, 0x80, 0x1, 0x3f, 0xfc, 0xaa, 0xbb})
+	require.NoError(t, err)
+	require.Equal(t, []*ADTSPacket{
+		{
+			SampleRate:   44100,
+			ChannelCount: 2,
+			Frame:        []byte{0xaa, 0xbb},
+		},
+	}, pkts)
+}
+
 func TestDecodeADTS(t *testing.T) {
 	for _, ca := range casesADTS {
 		t.Run(ca.name, func(t *testing.T) {
@@ -0,0 +1,31 @@
+// Package cluster contains a directory that allows the nodes of a cluster
+// to find out which node is publishing a path.
+package cluster
+
+// Node is a server that is part of a cluster.
+type Node struct {
+	// unique name of the node.
+	Name string `json:"name"`
+
+	// URL of the RTSP server of the node, in format rtsp://host:port.
+	RTSPAddress string `json:"rtspAddress"`
+
+	// URL of the HLS server of the node, in format http://host:port.
+	HLSAddress string `json:"hlsAddress"`
+}
+
+// Store is a path directory shared among the nodes of a cluster.
+type Store interface {
+	// Register announces that a path is published by a node.
+	Register(pathName string, node Node) error
+
+	// Unregister announces that a path is not published by a node anymore.
+	Unregister(pathName string, node Node) error
+
+	// Lookup returns the node that is publishing a path,
+	// or nil if the path is not published by any node.
+	Lookup(pathName string) (*Node, error)
+
+	// Close closes the store.
+	Close()
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s1 := NewMemoryStore()
+	defer s1.Close()
+
+	s2 := NewMemoryStore()
+	defer s2.Close()
+
+	node1 := Node{Name: "node1", RTSPAddress: "rtsp://node1:8554"}
+
+	err := s1.Register("mypath", node1)
+	require.NoError(t, err)
+
+	node, err := s2.Lookup("mypath")
+	require.NoError(t, err)
+	require.Equal(t, &node1, node)
+
+	// only the owner can unregister a path
+	err = s2.Unregister("mypath", Node{Name: "node2"})
+	require.NoError(t, err)
+
+	node, err = s2.Lookup("mypath")
+	require.NoError(t, err)
+	require.Equal(t, &node1, node)
+
+	err = s1.Unregister("mypath", node1)
+	require.NoError(t, err)
+
+	node, err = s2.Lookup("mypath")
+	require.NoError(t, err)
+	require.Nil(t, node)
+}
+
+func TestPeersStore(t *testing.T) {
+	s1, err := NewPeersStore("127.0.0.1:9410", []string{"http://127.0.0.1:9411"}, 5*time.Second)
+	require.NoError(t, err)
+	defer s1.Close()
+
+	s2, err := NewPeersStore("127.0.0.1:9411", []string{"http://127.0.0.1:9410"}, 5*time.Second)
+	require.NoError(t, err)
+	defer s2.Close()
+
+	node1 := Node{Name: "node1", RTSPAddress: "rtsp://node1:8554", HLSAddress: "http://node1:8888"}
+
+	err = s1.Register("my/path", node1)
+	require.NoError(t, err)
+
+	node, err := s2.Lookup("my/path")
+	require.NoError(t, err)
+	require.Equal(t, &node1, node)
+
+	node, err = s2.Lookup("otherpath")
+	require.NoError(t, err)
+	require.Nil(t, node)
+
+	err = s1.Unregister("my/path", node1)
+	require.NoError(t, err)
+
+	node, err = s2.Lookup("my/path")
+	require.NoError(t, err)
+	require.Nil(t, node)
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"sync"
+)
+
+type memoryEntry struct {
+	store *MemoryStore
+	node  Node
+}
+
+// directory shared among all the MemoryStores of the process.
+var memoryDirectory = struct {
+	mutex   sync.Mutex
+	entries map[string]memoryEntry
+}{
+	entries: make(map[string]memoryEntry),
+}
+
+// MemoryStore is a Store that shares the directory among the nodes
+// that are running inside the same process.
+// It is meant for tests and for running multiple nodes in a single process.
+type MemoryStore struct{}
+
+// NewMemoryStore allocates a MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Close implements Store.
+func (s *MemoryStore) Close() {
+	memoryDirectory.mutex.Lock()
+	defer memoryDirectory.mutex.Unlock()
+
+	// remove paths registered by this store
+	for pathName, e := range memoryDirectory.entries {
+		if e.store == s {
+			delete(memoryDirectory.entries, pathName)
+		}
+	}
+}
+
+// Register implements Store.
+func (s *MemoryStore) Register(pathName string, node Node) error {
+	memoryDirectory.mutex.Lock()
+	defer memoryDirectory.mutex.Unlock()
+
+	memoryDirectory.entries[pathName] = memoryEntry{
+		store: s,
+		node:  node,
+	}
+	return nil
+}
+
+// Unregister implements Store.
+func (s *MemoryStore) Unregister(pathName string, node Node) error {
+	memoryDirectory.mutex.Lock()
+	defer memoryDirectory.mutex.Unlock()
+
+	if e, ok := memoryDirectory.entries[pathName]; ok && e.node.Name == node.Name {
+		delete(memoryDirectory.entries, pathName)
+	}
+	return nil
+}
+
+// Lookup implements Store.
+func (s *MemoryStore) Lookup(pathName string) (*Node, error) {
+	memoryDirectory.mutex.Lock()
+	defer memoryDirectory.mutex.Unlock()
+
+	e, ok := memoryDirectory.entries[pathName]
+	if !ok {
+		return nil, nil
+	}
+
+	node := e.node
+	return &node, nil
+}
@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	peersPathPrefix = "/v1/paths/"
+)
+
+// PeersStore is a Store that keeps track of the paths published by the local node
+// and asks the other nodes about the others.
+// Each node exposes its own paths through a HTTP listener.
+type PeersStore struct {
+	peers   []string
+	timeout time.Duration
+
+	ln      net.Listener
+	hs      *http.Server
+	client  *http.Client
+	mutex   sync.RWMutex
+	entries map[string]Node
+}
+
+// NewPeersStore allocates a PeersStore.
+// address is the address of the HTTP listener; peers are the URLs
+// of the HTTP listeners of the other nodes, in format http://host:port.
+func NewPeersStore(address string, peers []string, timeout time.Duration) (*PeersStore, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PeersStore{
+		peers:   peers,
+		timeout: timeout,
+		ln:      ln,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		entries: make(map[string]Node),
+	}
+
+	s.hs = &http.Server{Handler: s}
+	go s.hs.Serve(s.ln)
+
+	return s, nil
+}
+
+// Close implements Store.
+func (s *PeersStore) Close() {
+	s.hs.Shutdown(context.Background())
+}
+
+// Register implements Store.
+func (s *PeersStore) Register(pathName string, node Node) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries[pathName] = node
+	return nil
+}
+
+// Unregister implements Store.
+func (s *PeersStore) Unregister(pathName string, node Node) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e, ok := s.entries[pathName]; ok && e.Name == node.Name {
+		delete(s.entries, pathName)
+	}
+	return nil
+}
+
+// Lookup implements Store.
+func (s *PeersStore) Lookup(pathName string) (*Node, error) {
+	if node, ok := s.localLookup(pathName); ok {
+		return node, nil
+	}
+
+	var lastErr error
+
+	for _, peer := range s.peers {
+		node, err := s.peerLookup(peer, pathName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if node != nil {
+			return node, nil
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (s *PeersStore) localLookup(pathName string) (*Node, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	node, ok := s.entries[pathName]
+	if !ok {
+		return nil, false
+	}
+	return &node, true
+}
+
+func (s *PeersStore) peerLookup(peer string, pathName string) (*Node, error) {
+	u := strings.TrimSuffix(peer, "/") + peersPathPrefix + (&url.URL{Path: pathName}).EscapedPath()
+
+	res, err := s.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+
+	case http.StatusNotFound:
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("peer %s replied with code %d", peer, res.StatusCode)
+	}
+
+	var node Node
+	err = json.NewDecoder(res.Body).Decode(&node)
+	if err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *PeersStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, peersPathPrefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	node, ok := s.localLookup(r.URL.Path[len(peersPathPrefix):])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	byts, _ := json.Marshal(node)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(byts)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aler9/gortsplib/pkg/headers"
@@ -12,6 +14,7 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/aler9/rtsp-simple-server/internal/confenv"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -35,6 +38,15 @@ const (
 	ProtocolTCP
 )
 
+// ClusterMode is the way readers of paths published on other nodes are served.
+type ClusterMode int
+
+// cluster modes.
+const (
+	ClusterModeRedirect ClusterMode = iota
+	ClusterModeProxy
+)
+
 func decrypt(key string, byts []byte) ([]byte, error) {
 	enc, err := base64.StdEncoding.DecodeString(string(byts))
 	if err != nil {
@@ -54,6 +66,58 @@ func decrypt(key string, byts []byte) ([]byte, error) {
 	return decrypted, nil
 }
 
+// WebhookConf is the configuration of a webhook.
+type WebhookConf struct {
+	URL     string        `yaml:"url" json:"url"`
+	Events  []string      `yaml:"events" json:"events"`
+	Paths   []string      `yaml:"paths" json:"paths"`
+	Secret  string        `yaml:"secret" json:"secret"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	Retries *int          `yaml:"retries" json:"retries"`
+}
+
+func (wconf *WebhookConf) checkAndFillMissing() error {
+	if !strings.HasPrefix(wconf.URL, "http://") &&
+		!strings.HasPrefix(wconf.URL, "https://") {
+		return fmt.Errorf("invalid webhook URL: '%s'", wconf.URL)
+	}
+
+	for _, typ := range wconf.Events {
+		err := eventbus.CheckFilterType(eventbus.Type(typ))
+		if err != nil {
+			return fmt.Errorf("invalid webhook '%s': %s", wconf.URL, err)
+		}
+	}
+
+	for _, pa := range wconf.Paths {
+		err := CheckPathName(pa)
+		if err != nil {
+			return fmt.Errorf("invalid webhook '%s': invalid path name: %s (%s)", wconf.URL, err, pa)
+		}
+	}
+
+	if len(wconf.Events) == 0 {
+		wconf.Events = nil
+	}
+	if len(wconf.Paths) == 0 {
+		wconf.Paths = nil
+	}
+
+	if wconf.Timeout == 0 {
+		wconf.Timeout = 5 * time.Second
+	}
+	// retries can be explicitly set to zero
+	if wconf.Retries == nil {
+		v := 3
+		wconf.Retries = &v
+	}
+	if *wconf.Retries < 0 {
+		return fmt.Errorf("invalid webhook '%s': 'retries' must not be negative", wconf.URL)
+	}
+
+	return nil
+}
+
 // Conf is the main program configuration.
 type Conf struct {
 	// general
@@ -65,8 +129,10 @@ type Conf struct {
 	ReadTimeout           time.Duration                   `yaml:"readTimeout" json:"readTimeout"`
 	WriteTimeout          time.Duration                   `yaml:"writeTimeout" json:"writeTimeout"`
 	ReadBufferCount       int                             `yaml:"readBufferCount" json:"readBufferCount"`
+	SlowReaderTimeout     time.Duration                   `yaml:"slowReaderTimeout" json:"slowReaderTimeout"`
 	API                   bool                            `yaml:"api" json:"api"`
 	APIAddress            string                          `yaml:"apiAddress" json:"apiAddress"`
+	APIPersistConfig      bool                            `yaml:"apiPersistConfig" json:"apiPersistConfig"`
 	Metrics               bool                            `yaml:"metrics" json:"metrics"`
 	MetricsAddress        string                          `yaml:"metricsAddress" json:"metricsAddress"`
 	PPROF                 bool                            `yaml:"pprof" json:"pprof"`
@@ -94,8 +160,13 @@ type Conf struct {
 	ReadBufferSize    int                   `yaml:"readBufferSize" json:"readBufferSize"`
 
 	// rtmp
-	RTMPDisable bool   `yaml:"rtmpDisable" json:"rtmpDisable"`
-	RTMPAddress string `yaml:"rtmpAddress" json:"rtmpAddress"`
+	RTMPDisable          bool       `yaml:"rtmpDisable" json:"rtmpDisable"`
+	RTMPEncryption       string     `yaml:"rtmpEncryption" json:"rtmpEncryption"`
+	RTMPEncryptionParsed Encryption `yaml:"-" json:"-"`
+	RTMPAddress          string     `yaml:"rtmpAddress" json:"rtmpAddress"`
+	RTMPSAddress         string     `yaml:"rtmpsAddress" json:"rtmpsAddress"`
+	RTMPServerKey        string     `yaml:"rtmpServerKey" json:"rtmpServerKey"`
+	RTMPServerCert       string     `yaml:"rtmpServerCert" json:"rtmpServerCert"`
 
 	// hls
 	HLSDisable         bool          `yaml:"hlsDisable" json:"hlsDisable"`
@@ -104,43 +175,66 @@ type Conf struct {
 	HLSSegmentCount    int           `yaml:"hlsSegmentCount" json:"hlsSegmentCount"`
 	HLSSegmentDuration time.Duration `yaml:"hlsSegmentDuration" json:"hlsSegmentDuration"`
 	HLSAllowOrigin     string        `yaml:"hlsAllowOrigin" json:"hlsAllowOrigin"`
+	MJPEGFPS           int           `yaml:"mjpegFPS" json:"mjpegFPS"`
+	MJPEGKeyframesOnly bool          `yaml:"mjpegKeyframesOnly" json:"mjpegKeyframesOnly"`
+
+	// flv
+	FLVDisable     bool   `yaml:"flvDisable" json:"flvDisable"`
+	FLVAddress     string `yaml:"flvAddress" json:"flvAddress"`
+	FLVAllowOrigin string `yaml:"flvAllowOrigin" json:"flvAllowOrigin"`
+
+	// cluster
+	Cluster                bool        `yaml:"cluster" json:"cluster"`
+	ClusterNodeName        string      `yaml:"clusterNodeName" json:"clusterNodeName"`
+	ClusterNodeRTSPAddress string      `yaml:"clusterNodeRTSPAddress" json:"clusterNodeRTSPAddress"`
+	ClusterNodeHLSAddress  string      `yaml:"clusterNodeHLSAddress" json:"clusterNodeHLSAddress"`
+	ClusterMode            string      `yaml:"clusterMode" json:"clusterMode"`
+	ClusterModeParsed      ClusterMode `yaml:"-" json:"-"`
+	ClusterStore           string      `yaml:"clusterStore" json:"clusterStore"`
+	ClusterAddress         string      `yaml:"clusterAddress" json:"clusterAddress"`
+	ClusterPeers           []string    `yaml:"clusterPeers" json:"clusterPeers"`
+
+	// webhooks
+	Webhooks []*WebhookConf `yaml:"webhooks" json:"webhooks"`
 
 	// paths
 	Paths map[string]*PathConf `yaml:"paths" json:"paths"`
 }
 
-// Load loads a Conf.
-func Load(fpath string) (*Conf, bool, error) {
-	conf := &Conf{}
-
-	// read from file
-	found, err := func() (bool, error) {
-		// rtsp-simple-server.yml is optional
-		if fpath == "rtsp-simple-server.yml" {
-			if _, err := os.Stat(fpath); err != nil {
-				return false, nil
-			}
+func loadFromFile(fpath string, conf *Conf) (bool, error) {
+	// rtsp-simple-server.yml is optional
+	if fpath == "rtsp-simple-server.yml" {
+		if _, err := os.Stat(fpath); err != nil {
+			return false, nil
 		}
+	}
+
+	byts, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return true, err
+	}
 
-		byts, err := ioutil.ReadFile(fpath)
+	if key, ok := os.LookupEnv("RTSP_CONFKEY"); ok {
+		byts, err = decrypt(key, byts)
 		if err != nil {
 			return true, err
 		}
+	}
 
-		if key, ok := os.LookupEnv("RTSP_CONFKEY"); ok {
-			byts, err = decrypt(key, byts)
-			if err != nil {
-				return true, err
-			}
-		}
+	err = yaml.Unmarshal(byts, conf)
+	if err != nil {
+		return true, err
+	}
 
-		err = yaml.Unmarshal(byts, conf)
-		if err != nil {
-			return true, err
-		}
+	return true, nil
+}
 
-		return true, nil
-	}()
+// Load loads a Conf.
+func Load(fpath string) (*Conf, bool, error) {
+	conf := &Conf{}
+
+	// read from file
+	found, err := loadFromFile(fpath, conf)
 	if err != nil {
 		return nil, false, err
 	}
@@ -159,6 +253,26 @@ func Load(fpath string) (*Conf, bool, error) {
 	return conf, found, nil
 }
 
+// LoadFile loads a Conf from a file only, without applying environment variables.
+// Missing fields are not filled, since the configuration can be completed
+// by environment variables.
+func LoadFile(fpath string) (*Conf, error) {
+	conf := &Conf{}
+
+	_, err := loadFromFile(fpath, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// "all" is an alias for "~^.*$"
+	if pconf, ok := conf.Paths["all"]; ok {
+		conf.Paths["~^.*$"] = pconf
+		delete(conf.Paths, "all")
+	}
+
+	return conf, nil
+}
+
 // CheckAndFillMissing checks the configuration for errors and fill missing fields.
 func (conf *Conf) CheckAndFillMissing() error {
 	if conf.LogLevel == "" {
@@ -312,9 +426,35 @@ func (conf *Conf) CheckAndFillMissing() error {
 		}
 	}
 
+	if conf.RTMPEncryption == "" {
+		conf.RTMPEncryption = "no"
+	}
+	switch conf.RTMPEncryption {
+	case "no", "false":
+		conf.RTMPEncryptionParsed = EncryptionNo
+
+	case "optional":
+		conf.RTMPEncryptionParsed = EncryptionOptional
+
+	case "strict", "yes", "true":
+		conf.RTMPEncryptionParsed = EncryptionStrict
+
+	default:
+		return fmt.Errorf("unsupported RTMP encryption value: '%s'", conf.RTMPEncryption)
+	}
+
 	if conf.RTMPAddress == "" {
 		conf.RTMPAddress = ":1935"
 	}
+	if conf.RTMPSAddress == "" {
+		conf.RTMPSAddress = ":1936"
+	}
+	if conf.RTMPServerKey == "" {
+		conf.RTMPServerKey = "server.key"
+	}
+	if conf.RTMPServerCert == "" {
+		conf.RTMPServerCert = "server.crt"
+	}
 
 	if conf.HLSAddress == "" {
 		conf.HLSAddress = ":8888"
@@ -328,6 +468,82 @@ func (conf *Conf) CheckAndFillMissing() error {
 	if conf.HLSAllowOrigin == "" {
 		conf.HLSAllowOrigin = "*"
 	}
+	if conf.MJPEGFPS == 0 {
+		conf.MJPEGFPS = 5
+	}
+	if conf.MJPEGFPS < 0 || conf.MJPEGFPS > 60 {
+		return fmt.Errorf("'mjpegFPS' must be between 1 and 60")
+	}
+
+	if conf.FLVAddress == "" {
+		conf.FLVAddress = ":8889"
+	}
+	if conf.FLVAllowOrigin == "" {
+		conf.FLVAllowOrigin = "*"
+	}
+
+	if conf.ClusterNodeName == "" {
+		conf.ClusterNodeName, _ = os.Hostname()
+	}
+	if conf.ClusterNodeRTSPAddress != "" &&
+		!strings.HasPrefix(conf.ClusterNodeRTSPAddress, "rtsp://") &&
+		!strings.HasPrefix(conf.ClusterNodeRTSPAddress, "rtsps://") {
+		return fmt.Errorf("'clusterNodeRTSPAddress' must be a RTSP URL")
+	}
+	if conf.ClusterNodeHLSAddress != "" &&
+		!strings.HasPrefix(conf.ClusterNodeHLSAddress, "http://") &&
+		!strings.HasPrefix(conf.ClusterNodeHLSAddress, "https://") {
+		return fmt.Errorf("'clusterNodeHLSAddress' must be a HTTP URL")
+	}
+	if conf.Cluster && conf.ClusterNodeRTSPAddress == "" {
+		return fmt.Errorf("'clusterNodeRTSPAddress' is required when cluster is enabled")
+	}
+
+	if conf.ClusterMode == "" {
+		conf.ClusterMode = "redirect"
+	}
+	switch conf.ClusterMode {
+	case "redirect":
+		conf.ClusterModeParsed = ClusterModeRedirect
+
+	case "proxy":
+		conf.ClusterModeParsed = ClusterModeProxy
+
+	default:
+		return fmt.Errorf("unsupported cluster mode: %s", conf.ClusterMode)
+	}
+
+	if conf.ClusterStore == "" {
+		conf.ClusterStore = "memory"
+	}
+	switch conf.ClusterStore {
+	case "memory", "peers":
+
+	default:
+		return fmt.Errorf("unsupported cluster store: %s", conf.ClusterStore)
+	}
+
+	if conf.ClusterAddress == "" {
+		conf.ClusterAddress = ":9410"
+	}
+
+	if len(conf.ClusterPeers) == 0 {
+		conf.ClusterPeers = nil
+	}
+
+	if len(conf.Webhooks) == 0 {
+		conf.Webhooks = nil
+	}
+	for _, wconf := range conf.Webhooks {
+		if wconf == nil {
+			return fmt.Errorf("webhooks can not be empty")
+		}
+
+		err := wconf.checkAndFillMissing()
+		if err != nil {
+			return err
+		}
+	}
 
 	if len(conf.Paths) == 0 {
 		conf.Paths = map[string]*PathConf{
@@ -353,5 +569,23 @@ func (conf *Conf) CheckAndFillMissing() error {
 		}
 	}
 
+	// paths with a regular expression are checked when they are created,
+	// since the paths they read depend on their name
+	names := make([]string, 0, len(conf.Paths))
+	for name, pconf := range conf.Paths {
+		if pconf.Regexp == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	checker := newPathSourceCycleChecker(conf.Paths)
+	for _, name := range names {
+		err := checker.check(name)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,10 +17,43 @@ import (
 
 const userPassSupportedChars = "A-Z,0-9,!,$,(,),*,+,.,;,<,=,>,[,],^,_,-,{,}"
 
+// maximum number of paths that can read from each other in a chain.
+const pathSourceMaxChainLength = 32
+
+// maximum delay of path sources. Delayed frames are kept in memory.
+const pathSourceMaxDelay = 5 * time.Minute
+
 var reUserPass = regexp.MustCompile(`^[a-zA-Z0-9!\$\(\)\*\+\.;<=>\[\]\^_\-\{\}]+$`)
 
 var rePathName = regexp.MustCompile(`^[0-9a-zA-Z_\-/\.~]+$`)
 
+// reference to a capture group of the regular expression of a path, in the form $G1 or $G{name}.
+var reGroupRef = regexp.MustCompile(`\$G(?:([0-9]+)|\{(\w+)\})`)
+
+// groupIndex returns the index of the capture group referenced by ref.
+func groupIndex(re *regexp.Regexp, ref string) int {
+	m := reGroupRef.FindStringSubmatch(ref)
+	if m[1] != "" {
+		i, _ := strconv.Atoi(m[1])
+		if i > re.NumSubexp() {
+			return -1
+		}
+		return i
+	}
+	return re.SubexpIndex(m[2])
+}
+
+// expandGroupRefs replaces references to capture groups with their values.
+func expandGroupRefs(re *regexp.Regexp, match []string, s string) string {
+	return reGroupRef.ReplaceAllStringFunc(s, func(ref string) string {
+		i := groupIndex(re, ref)
+		if i < 0 {
+			return ref
+		}
+		return match[i]
+	})
+}
+
 func parseIPCidrList(in []string) ([]interface{}, error) {
 	if len(in) == 0 {
 		return nil, nil
@@ -64,6 +99,112 @@ func CheckPathName(name string) error {
 	return nil
 }
 
+// PathSourceURL is a source in the form path://name?tracks=video&delay=30s,
+// that reads the stream of another path.
+type PathSourceURL struct {
+	// name of the path to read.
+	PathName string
+
+	// media types of the tracks to read (video, audio).
+	// If nil, all tracks are read.
+	Tracks []string
+
+	// delay applied to the stream.
+	Delay time.Duration
+}
+
+// ParsePathSourceURL parses a source in the form path://name?tracks=video&delay=30s.
+func ParsePathSourceURL(s string) (*PathSourceURL, error) {
+	if !strings.HasPrefix(s, "path://") {
+		return nil, fmt.Errorf("'%s' is not a valid path URL", s)
+	}
+	s = s[len("path://"):]
+
+	u := &PathSourceURL{}
+
+	rawQuery := ""
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		s, rawQuery = s[:i], s[i+1:]
+	}
+
+	err := CheckPathName(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path name: %s (%s)", err, s)
+	}
+	u.PathName = s
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, vals := range query {
+		switch key {
+		case "tracks":
+			for _, val := range vals {
+				for _, typ := range strings.Split(val, ",") {
+					if typ != "video" && typ != "audio" {
+						return nil, fmt.Errorf("unsupported track type: '%s'", typ)
+					}
+					u.Tracks = append(u.Tracks, typ)
+				}
+			}
+
+		case "delay":
+			d, err := time.ParseDuration(vals[0])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid delay: '%s'", vals[0])
+			}
+			if d > pathSourceMaxDelay {
+				return nil, fmt.Errorf("delay must not be greater than %v", pathSourceMaxDelay)
+			}
+			u.Delay = d
+
+		default:
+			return nil, fmt.Errorf("unsupported parameter: '%s'", key)
+		}
+	}
+
+	return u, nil
+}
+
+func parseBitrate(s string) (int, error) {
+	mul := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mul = 1000
+		s = s[:len(s)-1]
+
+	case strings.HasSuffix(s, "M"):
+		mul = 1000000
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseUint(s, 10, 31)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid bitrate")
+	}
+
+	return int(v) * mul, nil
+}
+
+// PathConfTranscode is a transcoding profile.
+type PathConfTranscode struct {
+	Name          string `yaml:"name" json:"name"`
+	Width         int    `yaml:"width" json:"width"`
+	Height        int    `yaml:"height" json:"height"`
+	Bitrate       string `yaml:"bitrate" json:"bitrate"`
+	BitrateParsed int    `yaml:"-" json:"-"`
+}
+
+// PathConfMotionRegion is a region of interest of the motion detector.
+type PathConfMotionRegion struct {
+	X      float64 `yaml:"x" json:"x"`
+	Y      float64 `yaml:"y" json:"y"`
+	Width  float64 `yaml:"width" json:"width"`
+	Height float64 `yaml:"height" json:"height"`
+}
+
 // PathConf is a path configuration.
 type PathConf struct {
 	Regexp *regexp.Regexp `yaml:"-" json:"-"`
@@ -78,8 +219,11 @@ type PathConf struct {
 	SourceOnDemandStartTimeout time.Duration             `yaml:"sourceOnDemandStartTimeout" json:"sourceOnDemandStartTimeout"`
 	SourceOnDemandCloseAfter   time.Duration             `yaml:"sourceOnDemandCloseAfter" json:"sourceOnDemandCloseAfter"`
 	SourceRedirect             string                    `yaml:"sourceRedirect" json:"sourceRedirect"`
+	SourceCompose              []string                  `yaml:"sourceCompose" json:"sourceCompose"`
 	DisablePublisherOverride   bool                      `yaml:"disablePublisherOverride" json:"disablePublisherOverride"`
 	Fallback                   string                    `yaml:"fallback" json:"fallback"`
+	GOPCache                   bool                      `yaml:"gopCache" json:"gopCache"`
+	GOPCacheMaxDuration        time.Duration             `yaml:"gopCacheMaxDuration" json:"gopCacheMaxDuration"`
 
 	// authentication
 	PublishUser      string        `yaml:"publishUser" json:"publishUser"`
@@ -102,6 +246,37 @@ type PathConf struct {
 	RunOnPublishRestart     bool          `yaml:"runOnPublishRestart" json:"runOnPublishRestart"`
 	RunOnRead               string        `yaml:"runOnRead" json:"runOnRead"`
 	RunOnReadRestart        bool          `yaml:"runOnReadRestart" json:"runOnReadRestart"`
+	RunOnMotion             string        `yaml:"runOnMotion" json:"runOnMotion"`
+	RunOnMotionRestart      bool          `yaml:"runOnMotionRestart" json:"runOnMotionRestart"`
+
+	// transcoding
+	Transcode        []*PathConfTranscode `yaml:"transcode" json:"transcode"`
+	TranscodeCommand string               `yaml:"transcodeCommand" json:"transcodeCommand"`
+	TranscodeConfs   map[string]*PathConf `yaml:"-" json:"-"`
+	TranscodeProfile *PathConfTranscode   `yaml:"-" json:"-"`
+
+	// configuration with a regular expression this one is instantiated from
+	Template *PathConf `yaml:"-" json:"-"`
+
+	// HLS
+	HLSVariants     []string      `yaml:"hlsVariants" json:"hlsVariants"`
+	HLSDVRDuration  time.Duration `yaml:"hlsDVRDuration" json:"hlsDVRDuration"`
+	HLSDVRDirectory string        `yaml:"hlsDVRDirectory" json:"hlsDVRDirectory"`
+
+	// motion detection
+	MotionDetection   bool                    `yaml:"motionDetection" json:"motionDetection"`
+	MotionRegions     []*PathConfMotionRegion `yaml:"motionRegions" json:"motionRegions"`
+	MotionSensitivity float64                 `yaml:"motionSensitivity" json:"motionSensitivity"`
+	MotionMinDuration time.Duration           `yaml:"motionMinDuration" json:"motionMinDuration"`
+
+	// recording
+	Record               bool          `yaml:"record" json:"record"`
+	RecordPath           string        `yaml:"recordPath" json:"recordPath"`
+	RecordFormat         string        `yaml:"recordFormat" json:"recordFormat"`
+	RecordPreRoll        time.Duration `yaml:"recordPreRoll" json:"recordPreRoll"`
+	RecordPostRoll       time.Duration `yaml:"recordPostRoll" json:"recordPostRoll"`
+	RecordOnMotion       bool          `yaml:"recordOnMotion" json:"recordOnMotion"`
+	RecordTriggerCommand string        `yaml:"recordTriggerCommand" json:"recordTriggerCommand"`
 }
 
 func (pconf *PathConf) checkAndFillMissing(name string) error {
@@ -123,22 +298,42 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 			return fmt.Errorf("invalid regular expression: %s", name[1:])
 		}
 		pconf.Regexp = pathRegexp
+
+		for _, f := range pconf.groupRefFields() {
+			for _, ref := range reGroupRef.FindAllString(*f, -1) {
+				if groupIndex(pathRegexp, ref) < 0 {
+					return fmt.Errorf("'%s' references a capture group that doesn't exist in the regular expression", ref)
+				}
+			}
+		}
+	}
+
+	// values with references to capture groups are validated with sample values
+	sample := func(s string) string {
+		if pconf.Regexp == nil {
+			return s
+		}
+		return reGroupRef.ReplaceAllString(s, "0")
 	}
 
 	if pconf.Source == "" {
 		pconf.Source = "publisher"
 	}
 
+	if len(pconf.SourceCompose) == 0 {
+		pconf.SourceCompose = nil
+	}
+
 	switch {
 	case pconf.Source == "publisher":
 
 	case strings.HasPrefix(pconf.Source, "rtsp://") ||
 		strings.HasPrefix(pconf.Source, "rtsps://"):
-		if pconf.Regexp != nil {
-			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a RTSP source; use another path")
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a RTSP source only if 'sourceOnDemand' is enabled")
 		}
 
-		_, err := base.ParseURL(pconf.Source)
+		_, err := base.ParseURL(sample(pconf.Source))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.Source)
 		}
@@ -170,16 +365,17 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 			return fmt.Errorf("sourceFingerprint is required with a RTSPS URL")
 		}
 
-	case strings.HasPrefix(pconf.Source, "rtmp://"):
-		if pconf.Regexp != nil {
-			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a RTMP source; use another path")
+	case strings.HasPrefix(pconf.Source, "rtmp://") ||
+		strings.HasPrefix(pconf.Source, "rtmps://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a RTMP source only if 'sourceOnDemand' is enabled")
 		}
 
-		u, err := url.Parse(pconf.Source)
+		u, err := url.Parse(sample(pconf.Source))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid RTMP URL", pconf.Source)
 		}
-		if u.Scheme != "rtmp" {
+		if u.Scheme != "rtmp" && u.Scheme != "rtmps" {
 			return fmt.Errorf("'%s' is not a valid RTMP URL", pconf.Source)
 		}
 
@@ -192,12 +388,95 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 			}
 		}
 
+		if strings.HasPrefix(pconf.Source, "rtmps://") && pconf.SourceFingerprint == "" {
+			return fmt.Errorf("sourceFingerprint is required with a RTMPS URL")
+		}
+
+	case strings.HasPrefix(pconf.Source, "http://") ||
+		strings.HasPrefix(pconf.Source, "https://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a HTTP source only if 'sourceOnDemand' is enabled")
+		}
+
+		u, err := url.Parse(sample(pconf.Source))
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("'%s' is not a valid HTTP URL", pconf.Source)
+		}
+
+	case strings.HasPrefix(pconf.Source, "udp://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a UDP source only if 'sourceOnDemand' is enabled")
+		}
+
+		u, err := url.Parse(sample(pconf.Source))
+		if err != nil || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("'%s' is not a valid UDP URL", pconf.Source)
+		}
+
+		_, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid UDP URL", pconf.Source)
+		}
+
+		_, err = strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid UDP URL", pconf.Source)
+		}
+
+	case strings.HasPrefix(pconf.Source, "file://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a file source only if 'sourceOnDemand' is enabled")
+		}
+
+		u, err := url.Parse(sample(pconf.Source))
+		if err != nil || u.Host != "" || !strings.HasPrefix(u.Path, "/") || u.RawQuery != "" {
+			return fmt.Errorf("'%s' is not a valid file URL", pconf.Source)
+		}
+
+	case strings.HasPrefix(pconf.Source, "path://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a path source only if 'sourceOnDemand' is enabled")
+		}
+
+		u, err := ParsePathSourceURL(sample(pconf.Source))
+		if err != nil {
+			return err
+		}
+
+		if pconf.Regexp == nil && u.PathName == name {
+			return fmt.Errorf("a path can't read from itself")
+		}
+
+	case pconf.Source == "compose":
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a composed source only if 'sourceOnDemand' is enabled")
+		}
+
+		if len(pconf.SourceCompose) == 0 {
+			return fmt.Errorf("source compose must be filled")
+		}
+
+		for _, entry := range pconf.SourceCompose {
+			u, err := ParsePathSourceURL(sample(entry))
+			if err != nil {
+				return err
+			}
+
+			if u.Delay != 0 {
+				return fmt.Errorf("'%s': delay is not supported by composed sources", entry)
+			}
+
+			if pconf.Regexp == nil && u.PathName == name {
+				return fmt.Errorf("a path can't read from itself")
+			}
+		}
+
 	case pconf.Source == "redirect":
 		if pconf.SourceRedirect == "" {
 			return fmt.Errorf("source redirect must be filled")
 		}
 
-		_, err := base.ParseURL(pconf.SourceRedirect)
+		_, err := base.ParseURL(sample(pconf.SourceRedirect))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.SourceRedirect)
 		}
@@ -220,15 +499,19 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		pconf.SourceOnDemandCloseAfter = 10 * time.Second
 	}
 
+	if pconf.GOPCacheMaxDuration == 0 {
+		pconf.GOPCacheMaxDuration = 10 * time.Second
+	}
+
 	if pconf.Fallback != "" {
 		if strings.HasPrefix(pconf.Fallback, "/") {
-			err := CheckPathName(pconf.Fallback[1:])
+			err := CheckPathName(sample(pconf.Fallback[1:]))
 			if err != nil {
 				return fmt.Errorf("'%s': %s", pconf.Fallback, err)
 			}
 
 		} else {
-			_, err := base.ParseURL(pconf.Fallback)
+			_, err := base.ParseURL(sample(pconf.Fallback))
 			if err != nil {
 				return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.Fallback)
 			}
@@ -318,9 +601,390 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		pconf.RunOnDemandCloseAfter = 10 * time.Second
 	}
 
+	err = pconf.checkAndFillMissingTranscode()
+	if err != nil {
+		return err
+	}
+
+	if len(pconf.HLSVariants) == 0 {
+		pconf.HLSVariants = nil
+	}
+
+	for _, variant := range pconf.HLSVariants {
+		err := CheckPathName(variant)
+		if err != nil {
+			return fmt.Errorf("invalid HLS variant: %s (%s)", err, variant)
+		}
+
+		if variant == name {
+			return fmt.Errorf("path '%s' can't be a HLS variant of itself", name)
+		}
+	}
+
+	if pconf.HLSDVRDuration < 0 {
+		return fmt.Errorf("'hlsDVRDuration' must be a positive duration")
+	}
+
+	if pconf.HLSDVRDuration != 0 && pconf.HLSVariants != nil {
+		return fmt.Errorf("'hlsDVRDuration' can't be used with 'hlsVariants'; set it in the variants")
+	}
+
+	err = pconf.checkAndFillMissingMotion()
+	if err != nil {
+		return err
+	}
+
+	err = pconf.checkAndFillMissingRecord()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (pconf *PathConf) checkAndFillMissingMotion() error {
+	if len(pconf.MotionRegions) == 0 {
+		pconf.MotionRegions = nil
+	}
+
+	if pconf.MotionSensitivity == 0 {
+		pconf.MotionSensitivity = 0.95
+	}
+	if pconf.MotionSensitivity < 0 || pconf.MotionSensitivity > 1 {
+		return fmt.Errorf("'motionSensitivity' must be between 0 and 1")
+	}
+
+	if pconf.MotionMinDuration == 0 {
+		pconf.MotionMinDuration = 1 * time.Second
+	}
+	if pconf.MotionMinDuration < 0 {
+		return fmt.Errorf("'motionMinDuration' must be a positive duration")
+	}
+
+	if !pconf.MotionDetection {
+		if pconf.RunOnMotion != "" {
+			return fmt.Errorf("'runOnMotion' can be used only when 'motionDetection' is enabled")
+		}
+		return nil
+	}
+
+	if pconf.Source == "redirect" {
+		return fmt.Errorf("'motionDetection' is useless when source is 'redirect'")
+	}
+
+	for i, region := range pconf.MotionRegions {
+		if region == nil {
+			return fmt.Errorf("motion regions can not be empty")
+		}
+
+		if region.X < 0 || region.Y < 0 ||
+			region.Width <= 0 || region.Height <= 0 ||
+			(region.X+region.Width) > 1 || (region.Y+region.Height) > 1 {
+			return fmt.Errorf("invalid motion region %d: coordinates and sizes must be fractions "+
+				"of the picture size, between 0 and 1", i+1)
+		}
+	}
+
+	return nil
+}
+
+func (pconf *PathConf) checkAndFillMissingTranscode() error {
+	if len(pconf.Transcode) == 0 {
+		pconf.Transcode = nil
+		pconf.TranscodeConfs = nil
+		return nil
+	}
+
+	if pconf.Source == "redirect" {
+		return fmt.Errorf("'transcode' is useless when source is 'redirect'")
+	}
+
+	if pconf.TranscodeCommand == "" {
+		pconf.TranscodeCommand = "ffmpeg -hide_banner -loglevel error -f mpegts -i -" +
+			" -c:v libx264 -preset veryfast -tune zerolatency" +
+			" -vf scale=$TRANSCODE_WIDTH:$TRANSCODE_HEIGHT -b:v $TRANSCODE_BITRATE" +
+			" -c:a copy -f mpegts -"
+	}
+
+	pconf.TranscodeConfs = make(map[string]*PathConf)
+
+	for _, profile := range pconf.Transcode {
+		if profile == nil {
+			return fmt.Errorf("transcoding profiles can not be empty")
+		}
+
+		if profile.Name == "" || strings.Contains(profile.Name, "/") {
+			return fmt.Errorf("invalid transcoding profile name: '%s'", profile.Name)
+		}
+		err := CheckPathName(profile.Name)
+		if err != nil {
+			return fmt.Errorf("invalid transcoding profile name: %s (%s)", err, profile.Name)
+		}
+
+		if _, ok := pconf.TranscodeConfs[profile.Name]; ok {
+			return fmt.Errorf("transcoding profile '%s' is defined twice", profile.Name)
+		}
+
+		if profile.Width < 0 || profile.Height < 0 {
+			return fmt.Errorf("invalid size of transcoding profile '%s'", profile.Name)
+		}
+
+		if profile.Bitrate == "" {
+			return fmt.Errorf("bitrate of transcoding profile '%s' must be filled", profile.Name)
+		}
+		profile.BitrateParsed, err = parseBitrate(profile.Bitrate)
+		if err != nil {
+			return fmt.Errorf("invalid bitrate of transcoding profile '%s': %s", profile.Name, profile.Bitrate)
+		}
+
+		pconf.TranscodeConfs[profile.Name] = pconf.transcodePathConf(profile)
+	}
+
+	return nil
+}
+
+func (pconf *PathConf) checkAndFillMissingRecord() error {
+	if pconf.RecordPath == "" {
+		pconf.RecordPath = "./recordings/%path/%Y-%m-%d_%H-%M-%S"
+	}
+	if filepath.Ext(pconf.RecordPath) != "" {
+		return fmt.Errorf("'recordPath' must not contain an extension, that is added automatically depending on 'recordFormat'")
+	}
+
+	if pconf.RecordFormat == "" {
+		pconf.RecordFormat = "ts"
+	}
+	switch pconf.RecordFormat {
+	case "ts", "mp4":
+	default:
+		return fmt.Errorf("unsupported record format '%s'", pconf.RecordFormat)
+	}
+
+	if pconf.RecordPreRoll == 0 {
+		pconf.RecordPreRoll = 10 * time.Second
+	}
+	if pconf.RecordPreRoll < 0 {
+		return fmt.Errorf("'recordPreRoll' must be a positive duration")
+	}
+
+	if pconf.RecordPostRoll == 0 {
+		pconf.RecordPostRoll = 10 * time.Second
+	}
+	if pconf.RecordPostRoll < 0 {
+		return fmt.Errorf("'recordPostRoll' must be a positive duration")
+	}
+
+	if !pconf.Record {
+		if pconf.RecordOnMotion {
+			return fmt.Errorf("'recordOnMotion' can be used only when 'record' is enabled")
+		}
+		if pconf.RecordTriggerCommand != "" {
+			return fmt.Errorf("'recordTriggerCommand' can be used only when 'record' is enabled")
+		}
+		return nil
+	}
+
+	if pconf.Source == "redirect" {
+		return fmt.Errorf("'record' is useless when source is 'redirect'")
+	}
+
+	if pconf.RecordOnMotion && !pconf.MotionDetection {
+		return fmt.Errorf("'recordOnMotion' can be used only when 'motionDetection' is enabled")
+	}
+
+	return nil
+}
+
+// groupRefFields returns the fields that can reference capture groups of the regular expression.
+func (pconf *PathConf) groupRefFields() []*string {
+	ret := []*string{
+		&pconf.Source,
+		&pconf.SourceRedirect,
+		&pconf.Fallback,
+		&pconf.PublishUser,
+		&pconf.PublishPass,
+		&pconf.ReadUser,
+		&pconf.ReadPass,
+		&pconf.RunOnDemand,
+	}
+
+	for i := range pconf.SourceCompose {
+		ret = append(ret, &pconf.SourceCompose[i])
+	}
+
+	return ret
+}
+
+// Instantiate returns the configuration of a path whose name matches the regular expression,
+// in which the references to capture groups are replaced with their values.
+func (pconf *PathConf) Instantiate(name string) *PathConf {
+	if pconf.Regexp == nil {
+		return pconf
+	}
+
+	match := pconf.Regexp.FindStringSubmatch(name)
+	if match == nil {
+		return pconf
+	}
+
+	ret := *pconf
+	ret.Template = pconf
+	ret.SourceCompose = append([]string(nil), pconf.SourceCompose...)
+
+	for _, f := range ret.groupRefFields() {
+		*f = expandGroupRefs(pconf.Regexp, match, *f)
+	}
+
+	return &ret
+}
+
+// FindPathConf returns the name and the configuration of the path configuration
+// that matches a path name.
+func FindPathConf(pathConfs map[string]*PathConf, name string) (string, *PathConf, error) {
+	// normal path
+	if pconf, ok := pathConfs[name]; ok {
+		return name, pconf, nil
+	}
+
+	// path derived from another path with a transcoding profile
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		if confName, pconf, err := FindPathConf(pathConfs, name[:i]); err == nil {
+			if dconf, ok := pconf.TranscodeConfs[name[i+1:]]; ok {
+				return confName, dconf, nil
+			}
+		}
+	}
+
+	// regular expression path
+	for confName, pconf := range pathConfs {
+		if pconf.Regexp != nil && pconf.Regexp.MatchString(name) {
+			return confName, pconf.Instantiate(name), nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("unable to find a valid configuration for path '%s'", name)
+}
+
+// sourcePathNames returns the names of the paths that are read by the source of a path.
+func (pconf *PathConf) sourcePathNames(name string) []string {
+	var ret []string
+
+	switch {
+	case strings.HasPrefix(pconf.Source, "path://"):
+		if u, err := ParsePathSourceURL(pconf.Source); err == nil {
+			ret = append(ret, u.PathName)
+		}
+
+	case pconf.Source == "compose":
+		for _, entry := range pconf.SourceCompose {
+			if u, err := ParsePathSourceURL(entry); err == nil {
+				ret = append(ret, u.PathName)
+			}
+		}
+	}
+
+	if pconf.TranscodeProfile != nil {
+		ret = append(ret, strings.TrimSuffix(name, "/"+pconf.TranscodeProfile.Name))
+	}
+
+	return ret
+}
+
+// CheckPathSourceCycle follows the paths that are read by the source of a path,
+// and the ones read by their sources, and returns an error if a path is visited twice,
+// i.e. if paths read from each other.
+func CheckPathSourceCycle(pathConfs map[string]*PathConf, name string) error {
+	return newPathSourceCycleChecker(pathConfs).check(name)
+}
+
+// pathSourceCycleChecker performs a depth-first visit of the paths read by sources.
+// Paths whose sources have already been visited entirely are not visited again,
+// since paths can be read by multiple paths (i.e. by composed sources).
+type pathSourceCycleChecker struct {
+	pathConfs map[string]*PathConf
+	chain     []string
+	done      map[string]struct{}
+}
+
+func newPathSourceCycleChecker(pathConfs map[string]*PathConf) *pathSourceCycleChecker {
+	return &pathSourceCycleChecker{
+		pathConfs: pathConfs,
+		done:      make(map[string]struct{}),
+	}
+}
+
+func (c *pathSourceCycleChecker) check(name string) error {
+	for i, visited := range c.chain {
+		if visited == name {
+			cycle := append(append([]string(nil), c.chain[i:]...), name)
+			return fmt.Errorf("paths read from each other (%s)", strings.Join(cycle, " -> "))
+		}
+	}
+
+	if _, ok := c.done[name]; ok {
+		return nil
+	}
+
+	c.chain = append(c.chain, name)
+	defer func() {
+		c.chain = c.chain[:len(c.chain)-1]
+	}()
+
+	// paths with a regular expression can generate chains without end
+	if len(c.chain) > pathSourceMaxChainLength {
+		return fmt.Errorf("too many paths read from each other (%s -> ...)", strings.Join(c.chain[:4], " -> "))
+	}
+
+	// paths without a configuration are reported when they are read
+	_, pconf, err := FindPathConf(c.pathConfs, name)
+	if err == nil {
+		for _, sourceName := range pconf.sourcePathNames(name) {
+			err := c.check(sourceName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	c.done[name] = struct{}{}
+	return nil
+}
+
+// transcodePathConf returns the configuration of the path derived with a transcoding profile.
+// The derived path is fed by the encoder on demand, when requested by a reader,
+// and is removed when it's not used anymore, like paths with a regular expression.
+func (pconf *PathConf) transcodePathConf(profile *PathConfTranscode) *PathConf {
+	return &PathConf{
+		Regexp:                     regexp.MustCompile("/" + regexp.QuoteMeta(profile.Name) + "$"),
+		SourceOnDemand:             true,
+		SourceOnDemandStartTimeout: pconf.SourceOnDemandStartTimeout,
+		SourceOnDemandCloseAfter:   pconf.SourceOnDemandCloseAfter,
+		GOPCache:                   pconf.GOPCache,
+		GOPCacheMaxDuration:        pconf.GOPCacheMaxDuration,
+		ReadUser:                   pconf.ReadUser,
+		ReadPass:                   pconf.ReadPass,
+		ReadIPs:                    pconf.ReadIPs,
+		ReadIPsParsed:              pconf.ReadIPsParsed,
+		TranscodeCommand:           pconf.TranscodeCommand,
+		TranscodeProfile:           profile,
+	}
+}
+
+// Includes checks whether other is this configuration or a configuration derived from it.
+func (pconf *PathConf) Includes(other *PathConf) bool {
+	if pconf == other || other.Template == pconf {
+		return true
+	}
+
+	for _, dconf := range pconf.TranscodeConfs {
+		if dconf == other {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Equal checks whether two PathConfs are equal.
 func (pconf *PathConf) Equal(other *PathConf) bool {
 	a, _ := json.Marshal(pconf)
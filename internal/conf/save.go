@@ -0,0 +1,305 @@
+package conf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+func encrypt(key string, byts []byte) ([]byte, error) {
+	var secretKey [32]byte
+	copy(secretKey[:], key)
+
+	var nonce [24]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := secretbox.Seal(nonce[:], byts, &nonce, &secretKey)
+	return []byte(base64.StdEncoding.EncodeToString(encrypted)), nil
+}
+
+// scalarEqual checks whether two scalars have the same meaning,
+// in order to preserve the original formatting (i.e. yes / true, empty / "").
+func scalarEqual(a *yamlv3.Node, b *yamlv3.Node) bool {
+	if a.Kind != yamlv3.ScalarNode || b.Kind != yamlv3.ScalarNode {
+		return false
+	}
+
+	normalize := func(n *yamlv3.Node) string {
+		if n.Tag == "!!null" {
+			return ""
+		}
+
+		switch strings.ToLower(n.Value) {
+		case "yes", "true", "on":
+			return "true"
+
+		case "no", "false", "off":
+			return "false"
+		}
+
+		return n.Value
+	}
+
+	return normalize(a) == normalize(b)
+}
+
+func sequenceEqual(a *yamlv3.Node, b *yamlv3.Node) bool {
+	if a.Kind == yamlv3.ScalarNode && a.Tag == "!!null" {
+		return len(b.Content) == 0
+	}
+
+	if a.Kind != yamlv3.SequenceNode || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	for i := range a.Content {
+		if !scalarEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isEmptyNode checks whether a node contains a zero value, that is equivalent to a missing value.
+func isEmptyNode(n *yamlv3.Node) bool {
+	switch n.Kind {
+	case yamlv3.ScalarNode:
+		if n.Tag == "!!null" {
+			return true
+		}
+		switch n.Value {
+		case "", "0", "0s", "false":
+			return true
+		}
+
+	case yamlv3.SequenceNode:
+		return len(n.Content) == 0
+	}
+
+	return false
+}
+
+// pruneEmptyNodes removes entries with zero values from mappings, in order not to
+// write into the file values that were not set.
+func pruneEmptyNodes(n *yamlv3.Node) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			pruneEmptyNodes(c)
+		}
+
+	case yamlv3.MappingNode:
+		var content []*yamlv3.Node
+		for i := 0; i < len(n.Content); i += 2 {
+			if isEmptyNode(n.Content[i+1]) {
+				continue
+			}
+			pruneEmptyNodes(n.Content[i+1])
+			content = append(content, n.Content[i], n.Content[i+1])
+		}
+		n.Content = content
+	}
+}
+
+// mappingEntry returns the key and value of an entry of a mapping.
+func mappingEntry(n *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	if n.Kind == yamlv3.DocumentNode {
+		n = n.Content[0]
+	}
+
+	if n.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+
+	for i := 0; i < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+
+	return nil, nil
+}
+
+// mergeNodes replaces the content of dest with the one of src,
+// preserving the comments and the key order of dest.
+func mergeNodes(dest *yamlv3.Node, src *yamlv3.Node) {
+	switch {
+	case dest.Kind == yamlv3.DocumentNode && src.Kind == yamlv3.DocumentNode:
+		mergeNodes(dest.Content[0], src.Content[0])
+
+	case dest.Kind == yamlv3.MappingNode && src.Kind == yamlv3.MappingNode:
+		srcValues := make(map[string]*yamlv3.Node)
+		for i := 0; i < len(src.Content); i += 2 {
+			srcValues[src.Content[i].Value] = src.Content[i+1]
+		}
+
+		var content []*yamlv3.Node
+		found := make(map[string]struct{})
+
+		// update existing keys and remove missing ones
+		for i := 0; i < len(dest.Content); i += 2 {
+			key := dest.Content[i].Value
+			srcValue, ok := srcValues[key]
+			if !ok {
+				continue
+			}
+
+			mergeNodes(dest.Content[i+1], srcValue)
+			content = append(content, dest.Content[i], dest.Content[i+1])
+			found[key] = struct{}{}
+		}
+
+		// add new keys, unless they contain zero values
+		for i := 0; i < len(src.Content); i += 2 {
+			if _, ok := found[src.Content[i].Value]; !ok && !isEmptyNode(src.Content[i+1]) {
+				pruneEmptyNodes(src.Content[i+1])
+				content = append(content, src.Content[i], src.Content[i+1])
+			}
+		}
+
+		dest.Content = content
+
+	case src.Kind == yamlv3.ScalarNode && scalarEqual(dest, src):
+
+	case src.Kind == yamlv3.SequenceNode && sequenceEqual(dest, src):
+
+	default:
+		headComment, lineComment, footComment := dest.HeadComment, dest.LineComment, dest.FootComment
+		style := dest.Style
+		wasSequence := dest.Kind == yamlv3.SequenceNode
+
+		pruneEmptyNodes(src)
+		*dest = *src
+
+		dest.HeadComment, dest.LineComment, dest.FootComment = headComment, lineComment, footComment
+		if wasSequence && src.Kind == yamlv3.SequenceNode {
+			dest.Style = style
+		}
+	}
+}
+
+// Encode encodes the configuration into the content of a configuration file.
+// The file at fpath, if it exists, is used as base, in order to preserve comments and key order.
+// The content is encrypted when RTSP_CONFKEY is set.
+func (conf *Conf) Encode(fpath string) ([]byte, error) {
+	byts, err := yamlv3.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var src yamlv3.Node
+	err = yamlv3.Unmarshal(byts, &src)
+	if err != nil {
+		return nil, err
+	}
+
+	key, encrypted := os.LookupEnv("RTSP_CONFKEY")
+
+	// "~^.*$" is written with its alias, unless the file uses the regular expression explicitly
+	_, srcPaths := mappingEntry(&src, "paths")
+	var allKey *yamlv3.Node
+	if srcPaths != nil {
+		allKey, _ = mappingEntry(srcPaths, "~^.*$")
+		if allKey != nil {
+			allKey.Value = "all"
+			allKey.Style = 0
+		}
+	}
+
+	dest := &src
+
+	prev, err := ioutil.ReadFile(fpath)
+	if err == nil {
+		if encrypted {
+			prev, err = decrypt(key, prev)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var tmp yamlv3.Node
+		err = yamlv3.Unmarshal(prev, &tmp)
+		if err == nil && tmp.Kind == yamlv3.DocumentNode && tmp.Content[0].Kind == yamlv3.MappingNode {
+			if _, prevPaths := mappingEntry(&tmp, "paths"); prevPaths != nil && allKey != nil {
+				if k, _ := mappingEntry(prevPaths, "~^.*$"); k != nil {
+					allKey.Value = "~^.*$"
+				}
+			}
+
+			mergeNodes(&tmp, &src)
+			dest = &tmp
+		}
+	}
+
+	if dest == &src {
+		pruneEmptyNodes(dest)
+	}
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(dest)
+	if err != nil {
+		return nil, err
+	}
+	enc.Close()
+
+	if encrypted {
+		return encrypt(key, buf.Bytes())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteFile writes the content of a configuration file atomically,
+// by writing a temporary file and by replacing the original one.
+func WriteFile(fpath string, byts []byte) error {
+	// follow symlinks, in order to replace the target
+	if tmp, err := filepath.EvalSymlinks(fpath); err == nil {
+		fpath = tmp
+	}
+
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(fpath); err == nil {
+		mode = fi.Mode()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(fpath), "."+filepath.Base(fpath)+"-")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	_, err = f.Write(byts)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, fpath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
@@ -50,6 +50,16 @@ func load(env map[string]string, prefix string, rv reflect.Value) error {
 		}
 		return nil
 
+	case reflect.Float64:
+		if ev, ok := env[prefix]; ok {
+			fv, err := strconv.ParseFloat(ev, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %s", prefix, err)
+			}
+			rv.SetFloat(fv)
+		}
+		return nil
+
 	case reflect.Bool:
 		if ev, ok := env[prefix]; ok {
 			switch strings.ToLower(ev) {
@@ -65,6 +75,19 @@ func load(env map[string]string, prefix string, rv reflect.Value) error {
 		}
 		return nil
 
+	case reflect.Ptr:
+		// pointers to values are used to tell missing values from zero values,
+		// therefore they are allocated only when a value is provided
+		if rt.Elem().Kind() != reflect.Struct {
+			if _, ok := env[prefix]; ok {
+				if rv.IsNil() {
+					rv.Set(reflect.New(rt.Elem()))
+				}
+				return load(env, prefix, rv.Elem())
+			}
+			return nil
+		}
+
 	case reflect.Slice:
 		if rt.Elem().Kind() == reflect.String {
 			if ev, ok := env[prefix]; ok {
@@ -77,6 +100,39 @@ func load(env map[string]string, prefix string, rv reflect.Value) error {
 			return nil
 		}
 
+		// slices of structures are filled by index, i.e. PREFIX_0_FIELD
+		if rt.Elem().Kind() == reflect.Ptr && rt.Elem().Elem().Kind() == reflect.Struct {
+			for i := 0; ; i++ {
+				itemPrefix := prefix + "_" + strconv.FormatInt(int64(i), 10)
+
+				found := false
+				for k := range env {
+					if strings.HasPrefix(k, itemPrefix+"_") {
+						found = true
+						break
+					}
+				}
+				if !found {
+					// skip existing items that are not overridden
+					if i < rv.Len() {
+						continue
+					}
+					return nil
+				}
+
+				if i >= rv.Len() {
+					rv.Set(reflect.Append(rv, reflect.New(rt.Elem().Elem())))
+				} else if rv.Index(i).IsNil() {
+					rv.Index(i).Set(reflect.New(rt.Elem().Elem()))
+				}
+
+				err := load(env, itemPrefix, rv.Index(i).Elem())
+				if err != nil {
+					return err
+				}
+			}
+		}
+
 	case reflect.Map:
 		for k := range env {
 			if !strings.HasPrefix(k, prefix+"_") {
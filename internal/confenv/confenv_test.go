@@ -19,6 +19,13 @@ type testStruct struct {
 	// int
 	MyInt int
 
+	// pointer
+	MyIntPtr    *int
+	MyIntPtrNil *int
+
+	// float
+	MyFloat float64
+
 	// bool
 	MyBool bool
 
@@ -30,6 +37,9 @@ type testStruct struct {
 
 	// map
 	MyMap map[string]*mapEntry
+
+	// slice of structs
+	MyStructSlice []*mapEntry
 }
 
 func Test(t *testing.T) {
@@ -39,6 +49,12 @@ func Test(t *testing.T) {
 	os.Setenv("MYPREFIX_MYINT", "123")
 	defer os.Unsetenv("MYPREFIX_MYINT")
 
+	os.Setenv("MYPREFIX_MYINTPTR", "0")
+	defer os.Unsetenv("MYPREFIX_MYINTPTR")
+
+	os.Setenv("MYPREFIX_MYFLOAT", "0.75")
+	defer os.Unsetenv("MYPREFIX_MYFLOAT")
+
 	os.Setenv("MYPREFIX_MYBOOL", "yes")
 	defer os.Unsetenv("MYPREFIX_MYBOOL")
 
@@ -54,12 +70,22 @@ func Test(t *testing.T) {
 	os.Setenv("MYPREFIX_MYMAP_MYKEY2_MYVALUE", "asd")
 	defer os.Unsetenv("MYPREFIX_MYMAP_MYKEY2_MYVALUE")
 
+	os.Setenv("MYPREFIX_MYSTRUCTSLICE_0_MYVALUE", "first")
+	defer os.Unsetenv("MYPREFIX_MYSTRUCTSLICE_0_MYVALUE")
+
+	os.Setenv("MYPREFIX_MYSTRUCTSLICE_1_MYVALUE", "second")
+	defer os.Unsetenv("MYPREFIX_MYSTRUCTSLICE_1_MYVALUE")
+
 	var s testStruct
 	err := Load("MYPREFIX", &s)
 	require.NoError(t, err)
 
 	require.Equal(t, "testcontent", s.MyString)
 	require.Equal(t, 123, s.MyInt)
+	require.NotNil(t, s.MyIntPtr)
+	require.Equal(t, 0, *s.MyIntPtr)
+	require.Nil(t, s.MyIntPtrNil)
+	require.Equal(t, 0.75, s.MyFloat)
 	require.Equal(t, true, s.MyBool)
 	require.Equal(t, 22*time.Second, s.MyDuration)
 	require.Equal(t, []string{"el1", "el2"}, s.MySlice)
@@ -70,4 +96,6 @@ func Test(t *testing.T) {
 	v, ok := s.MyMap["mykey2"]
 	require.Equal(t, true, ok)
 	require.Equal(t, "asd", v.MyValue)
+
+	require.Equal(t, []*mapEntry{{MyValue: "first"}, {MyValue: "second"}}, s.MyStructSlice)
 }
@@ -1,8 +1,11 @@
 package confwatcher
 
 import (
+	"crypto/sha256"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -18,6 +21,9 @@ type ConfWatcher struct {
 	inner       *fsnotify.Watcher
 	watchedPath string
 
+	mutex   sync.Mutex
+	ignored *[sha256.Size]byte
+
 	// out
 	signal chan struct{}
 	done   chan struct{}
@@ -94,6 +100,10 @@ outer:
 				time.Sleep(additionalWait)
 				previousWatchedPath = currentWatchedPath
 
+				if w.isIgnored() {
+					continue
+				}
+
 				lastCalled = time.Now()
 				w.signal <- struct{}{}
 			}
@@ -106,6 +116,32 @@ outer:
 	close(w.signal)
 }
 
+// Ignore prevents the watcher from signaling changes when the file has the given content,
+// i.e. when the file has been written by the program itself.
+func (w *ConfWatcher) Ignore(byts []byte) {
+	sum := sha256.Sum256(byts)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.ignored = &sum
+}
+
+func (w *ConfWatcher) isIgnored() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.ignored == nil {
+		return false
+	}
+
+	byts, err := ioutil.ReadFile(w.watchedPath)
+	if err != nil {
+		return false
+	}
+
+	return sha256.Sum256(byts) == *w.ignored
+}
+
 // Watch returns a channel that is called after the configuration file has changed.
 func (w *ConfWatcher) Watch() chan struct{} {
 	return w.signal
@@ -154,3 +154,34 @@ func TestSymlinkDeleteCreate(t *testing.T) {
 		return
 	}
 }
+
+func TestIgnore(t *testing.T) {
+	fpath, err := writeTempFile([]byte("{}"))
+	require.NoError(t, err)
+
+	w, err := New(fpath)
+	require.NoError(t, err)
+	defer w.Close()
+
+	w.Ignore([]byte("paths: {}\n"))
+
+	err = ioutil.WriteFile(fpath, []byte("paths: {}\n"), 0o644)
+	require.NoError(t, err)
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+	case <-w.Watch():
+		t.Errorf("should not happen")
+		return
+	}
+
+	err = ioutil.WriteFile(fpath, []byte("{}"), 0o644)
+	require.NoError(t, err)
+
+	select {
+	case <-w.Watch():
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("timed out")
+		return
+	}
+}
@@ -5,19 +5,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
+const (
+	apiEventsQueueSize       = 256
+	apiEventsKeepalivePeriod = 15 * time.Second
+)
+
 func interfaceIsEmpty(i interface{}) bool {
 	return reflect.ValueOf(i).Kind() != reflect.Ptr || reflect.ValueOf(i).IsNil()
 }
@@ -53,8 +63,10 @@ func loadConfData(ctx *gin.Context) (interface{}, error) {
 		ReadTimeout         *time.Duration `json:"readTimeout"`
 		WriteTimeout        *time.Duration `json:"writeTimeout"`
 		ReadBufferCount     *int           `json:"readBufferCount"`
+		SlowReaderTimeout   *time.Duration `json:"slowReaderTimeout"`
 		API                 *bool          `json:"api"`
 		APIAddress          *string        `json:"apiAddress"`
+		APIPersistConfig    *bool          `json:"apiPersistConfig"`
 		Metrics             *bool          `json:"metrics"`
 		MetricsAddress      *string        `json:"metricsAddress"`
 		PPROF               *bool          `json:"pprof"`
@@ -79,8 +91,12 @@ func loadConfData(ctx *gin.Context) (interface{}, error) {
 		ReadBufferSize    *int      `json:"readBufferSize"`
 
 		// rtmp
-		RTMPDisable *bool   `json:"rtmpDisable"`
-		RTMPAddress *string `json:"rtmpAddress"`
+		RTMPDisable    *bool   `json:"rtmpDisable"`
+		RTMPEncryption *string `json:"rtmpEncryption"`
+		RTMPAddress    *string `json:"rtmpAddress"`
+		RTMPSAddress   *string `json:"rtmpsAddress"`
+		RTMPServerKey  *string `json:"rtmpServerKey"`
+		RTMPServerCert *string `json:"rtmpServerCert"`
 
 		// hls
 		HLSDisable         *bool          `json:"hlsDisable"`
@@ -89,6 +105,26 @@ func loadConfData(ctx *gin.Context) (interface{}, error) {
 		HLSSegmentCount    *int           `json:"hlsSegmentCount"`
 		HLSSegmentDuration *time.Duration `json:"hlsSegmentDuration"`
 		HLSAllowOrigin     *string        `json:"hlsAllowOrigin"`
+		MJPEGFPS           *int           `json:"mjpegFPS"`
+		MJPEGKeyframesOnly *bool          `json:"mjpegKeyframesOnly"`
+
+		// flv
+		FLVDisable     *bool   `json:"flvDisable"`
+		FLVAddress     *string `json:"flvAddress"`
+		FLVAllowOrigin *string `json:"flvAllowOrigin"`
+
+		// cluster
+		Cluster                *bool     `json:"cluster"`
+		ClusterNodeName        *string   `json:"clusterNodeName"`
+		ClusterNodeRTSPAddress *string   `json:"clusterNodeRTSPAddress"`
+		ClusterNodeHLSAddress  *string   `json:"clusterNodeHLSAddress"`
+		ClusterMode            *string   `json:"clusterMode"`
+		ClusterStore           *string   `json:"clusterStore"`
+		ClusterAddress         *string   `json:"clusterAddress"`
+		ClusterPeers           *[]string `json:"clusterPeers"`
+
+		// webhooks
+		Webhooks *[]*conf.WebhookConf `json:"webhooks"`
 	}
 	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
 	if err != nil {
@@ -109,8 +145,11 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 		SourceOnDemandStartTimeout *time.Duration `json:"sourceOnDemandStartTimeout"`
 		SourceOnDemandCloseAfter   *time.Duration `json:"sourceOnDemandCloseAfter"`
 		SourceRedirect             *string        `json:"sourceRedirect"`
+		SourceCompose              *[]string      `json:"sourceCompose"`
 		DisablePublisherOverride   *bool          `json:"disablePublisherOverride"`
 		Fallback                   *string        `json:"fallback"`
+		GOPCache                   *bool          `json:"gopCache"`
+		GOPCacheMaxDuration        *time.Duration `json:"gopCacheMaxDuration"`
 
 		// authentication
 		PublishUser *string   `json:"publishUser"`
@@ -131,6 +170,13 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 		RunOnPublishRestart     *bool          `json:"runOnPublishRestart"`
 		RunOnRead               *string        `json:"runOnRead"`
 		RunOnReadRestart        *bool          `json:"runOnReadRestart"`
+
+		// transcoding
+		Transcode        *[]*conf.PathConfTranscode `json:"transcode"`
+		TranscodeCommand *string                    `json:"transcodeCommand"`
+
+		// HLS
+		HLSVariants *[]string `json:"hlsVariants"`
 	}
 	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
 	if err != nil {
@@ -140,12 +186,59 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 	return in, err
 }
 
+type apiPathsItemTrack struct {
+	Codec        string  `json:"codec"`
+	Width        int     `json:"width,omitempty"`
+	Height       int     `json:"height,omitempty"`
+	Profile      string  `json:"profile,omitempty"`
+	Level        string  `json:"level,omitempty"`
+	ChromaFormat string  `json:"chromaFormat,omitempty"`
+	FPS          float64 `json:"fps,omitempty"`
+}
+
+func newAPIPathsItemTracks(s *stream) []apiPathsItemTrack {
+	ret := []apiPathsItemTrack{}
+	if s == nil {
+		return ret
+	}
+
+	for i, t := range s.tracks() {
+		var item apiPathsItemTrack
+
+		switch {
+		case t.IsH264():
+			item.Codec = "H264"
+
+			if sps := s.sps(i); sps != nil {
+				item.Width = sps.Width()
+				item.Height = sps.Height()
+				item.Profile = sps.Profile()
+				item.Level = sps.Level()
+				item.ChromaFormat = sps.ChromaFormat()
+				item.FPS = sps.FPS()
+			}
+
+		case t.IsAAC():
+			item.Codec = "AAC"
+
+		default:
+			item.Codec = "unknown"
+		}
+
+		ret = append(ret, item)
+	}
+
+	return ret
+}
+
 type apiPathsItem struct {
-	ConfName    string         `json:"confName"`
-	Conf        *conf.PathConf `json:"conf"`
-	Source      interface{}    `json:"source"`
-	SourceReady bool           `json:"sourceReady"`
-	Readers     []interface{}  `json:"readers"`
+	ConfName    string              `json:"confName"`
+	Conf        *conf.PathConf      `json:"conf"`
+	Source      interface{}         `json:"source"`
+	SourceReady bool                `json:"sourceReady"`
+	Tracks      []apiPathsItemTrack `json:"tracks"`
+	Motion      bool                `json:"motion"`
+	Readers     []interface{}       `json:"readers"`
 }
 
 type apiPathsListData struct {
@@ -167,9 +260,21 @@ type apiPathsListReq2 struct {
 	Res  chan struct{}
 }
 
+type apiPathsTriggerRes struct {
+	Path *path
+	Err  error
+}
+
+type apiPathsTriggerReq struct {
+	Name   string
+	Reason string
+	Res    chan apiPathsTriggerRes
+}
+
 type apiRTSPSessionsListItem struct {
-	RemoteAddr string `json:"remoteAddr"`
-	State      string `json:"state"`
+	RemoteAddr    string `json:"remoteAddr"`
+	State         string `json:"state"`
+	FramesDropped uint64 `json:"framesDropped"`
 }
 
 type apiRTSPSessionsListData struct {
@@ -192,8 +297,9 @@ type apiRTSPSessionsKickReq struct {
 }
 
 type apiRTMPConnsListItem struct {
-	RemoteAddr string `json:"remoteAddr"`
-	State      string `json:"state"`
+	RemoteAddr    string `json:"remoteAddr"`
+	State         string `json:"state"`
+	FramesDropped uint64 `json:"framesDropped"`
 }
 
 type apiRTMPConnsListData struct {
@@ -218,8 +324,57 @@ type apiRTMPConnsKickReq struct {
 	Res chan apiRTMPConnsKickRes
 }
 
+type apiFLVConnsListItem struct {
+	RemoteAddr    string `json:"remoteAddr"`
+	State         string `json:"state"`
+	FramesDropped uint64 `json:"framesDropped"`
+}
+
+type apiFLVConnsListData struct {
+	Items map[string]apiFLVConnsListItem `json:"items"`
+}
+
+type apiFLVConnsListRes struct {
+	Data *apiFLVConnsListData
+	Err  error
+}
+
+type apiFLVConnsListReq struct {
+	Res chan apiFLVConnsListRes
+}
+
+type apiFLVConnsKickRes struct {
+	Err error
+}
+
+type apiFLVConnsKickReq struct {
+	ID  string
+	Res chan apiFLVConnsKickRes
+}
+
+type apiWebhooksListItem struct {
+	URL       string `json:"url"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Retried   uint64 `json:"retried"`
+	Dropped   uint64 `json:"dropped"`
+	LastError string `json:"lastError"`
+}
+
+type apiWebhooksListData struct {
+	Items []apiWebhooksListItem `json:"items"`
+}
+
+type apiWebhooksListRes struct {
+	Data *apiWebhooksListData
+	Err  error
+}
+
+type apiWebhooksListReq struct{}
+
 type apiPathManager interface {
 	OnAPIPathsList(req apiPathsListReq1) apiPathsListRes1
+	OnAPIPathsTrigger(req apiPathsTriggerReq) apiPathsTriggerRes
 }
 
 type apiRTSPServer interface {
@@ -232,21 +387,43 @@ type apiRTMPServer interface {
 	OnAPIRTMPConnsKick(req apiRTMPConnsKickReq) apiRTMPConnsKickRes
 }
 
+type apiFLVServer interface {
+	OnAPIFLVConnsList(req apiFLVConnsListReq) apiFLVConnsListRes
+	OnAPIFLVConnsKick(req apiFLVConnsKickReq) apiFLVConnsKickRes
+}
+
+type apiWebhookManager interface {
+	OnAPIWebhooksList(req apiWebhooksListReq) apiWebhooksListRes
+}
+
+// apiConfigSetReq contains a configuration edited through the API.
+// Edit is the edit itself, that is applied again when the configuration is persisted.
+type apiConfigSetReq struct {
+	Conf *conf.Conf
+	Edit func(*conf.Conf)
+}
+
 type apiParent interface {
 	Log(logger.Level, string, ...interface{})
-	OnAPIConfigSet(conf *conf.Conf)
+	OnAPIConfigSet(req apiConfigSetReq)
 }
 
 type api struct {
-	conf        *conf.Conf
-	pathManager apiPathManager
-	rtspServer  apiRTSPServer
-	rtspsServer apiRTSPServer
-	rtmpServer  apiRTMPServer
-	parent      apiParent
-
-	mutex sync.Mutex
-	s     *http.Server
+	conf           *conf.Conf
+	pathManager    apiPathManager
+	rtspServer     apiRTSPServer
+	rtspsServer    apiRTSPServer
+	rtmpServer     apiRTMPServer
+	rtmpsServer    apiRTMPServer
+	flvServer      apiFLVServer
+	webhookManager apiWebhookManager
+	eventBus       *eventbus.Bus
+	parent         apiParent
+
+	ctx       context.Context
+	ctxCancel func()
+	mutex     sync.Mutex
+	s         *http.Server
 }
 
 func newAPI(
@@ -256,6 +433,10 @@ func newAPI(
 	rtspServer apiRTSPServer,
 	rtspsServer apiRTSPServer,
 	rtmpServer apiRTMPServer,
+	rtmpsServer apiRTMPServer,
+	flvServer apiFLVServer,
+	webhookManager apiWebhookManager,
+	eventBus *eventbus.Bus,
 	parent apiParent,
 ) (*api, error) {
 	ln, err := net.Listen("tcp", address)
@@ -264,14 +445,20 @@ func newAPI(
 	}
 
 	a := &api{
-		conf:        conf,
-		pathManager: pathManager,
-		rtspServer:  rtspServer,
-		rtspsServer: rtspsServer,
-		rtmpServer:  rtmpServer,
-		parent:      parent,
+		conf:           conf,
+		pathManager:    pathManager,
+		rtspServer:     rtspServer,
+		rtspsServer:    rtspsServer,
+		rtmpServer:     rtmpServer,
+		rtmpsServer:    rtmpsServer,
+		flvServer:      flvServer,
+		webhookManager: webhookManager,
+		eventBus:       eventBus,
+		parent:         parent,
 	}
 
+	a.ctx, a.ctxCancel = context.WithCancel(context.Background())
+
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.NoRoute(a.mwLog)
@@ -282,12 +469,21 @@ func newAPI(
 	group.POST("/v1/config/paths/edit/:name", a.onConfigPathsEdit)
 	group.POST("/v1/config/paths/remove/:name", a.onConfigPathsDelete)
 	group.GET("/v1/paths/list", a.onPathsList)
+	group.POST("/v1/paths/trigger/:name", a.onPathsTrigger)
 	group.GET("/v1/rtspsessions/list", a.onRTSPSessionsList)
 	group.POST("/v1/rtspsessions/kick/:id", a.onRTSPSessionsKick)
 	group.GET("/v1/rtspssessions/list", a.onRTSPSSessionsList)
 	group.POST("/v1/rtspssessions/kick/:id", a.onRTSPSSessionsKick)
 	group.GET("/v1/rtmpconns/list", a.onRTMPConnsList)
 	group.POST("/v1/rtmpconns/kick/:id", a.onRTMPConnsKick)
+	group.GET("/v1/rtmpsconns/list", a.onRTMPSConnsList)
+	group.POST("/v1/rtmpsconns/kick/:id", a.onRTMPSConnsKick)
+	group.GET("/v1/flvconns/list", a.onFLVConnsList)
+	group.POST("/v1/flvconns/kick/:id", a.onFLVConnsKick)
+	group.GET("/v1/webhooks/list", a.onWebhooksList)
+
+	// the event stream is not logged, since its response never ends
+	router.GET("/v1/events", a.onEvents)
 
 	a.s = &http.Server{
 		Handler: router,
@@ -301,6 +497,7 @@ func newAPI(
 }
 
 func (a *api) close() {
+	a.ctxCancel()
 	a.s.Shutdown(context.Background())
 	a.log(logger.Info, "closed")
 }
@@ -360,12 +557,16 @@ func (a *api) onConfigSet(ctx *gin.Context) {
 		return
 	}
 
+	edit := func(c *conf.Conf) {
+		fillStruct(c, in)
+	}
+
 	a.mutex.Lock()
 	var newConf conf.Conf
 	cloneStruct(a.conf, &newConf)
 	a.mutex.Unlock()
 
-	fillStruct(&newConf, in)
+	edit(&newConf)
 
 	err = newConf.CheckAndFillMissing()
 	if err != nil {
@@ -379,7 +580,7 @@ func (a *api) onConfigSet(ctx *gin.Context) {
 
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
-	go a.parent.OnAPIConfigSet(&newConf)
+	go a.parent.OnAPIConfigSet(apiConfigSetReq{Conf: &newConf, Edit: edit})
 
 	ctx.Status(http.StatusOK)
 }
@@ -402,10 +603,18 @@ func (a *api) onConfigPathsAdd(ctx *gin.Context) {
 		ctx.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-	newConfPath := &conf.PathConf{}
 
-	fillStruct(newConfPath, in)
-	newConf.Paths[name] = newConfPath
+	edit := func(c *conf.Conf) {
+		if c.Paths == nil {
+			c.Paths = make(map[string]*conf.PathConf)
+		}
+
+		newConfPath := &conf.PathConf{}
+		fillStruct(newConfPath, in)
+		c.Paths[name] = newConfPath
+	}
+
+	edit(&newConf)
 
 	err = newConf.CheckAndFillMissing()
 	if err != nil {
@@ -419,7 +628,7 @@ func (a *api) onConfigPathsAdd(ctx *gin.Context) {
 
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
-	go a.parent.OnAPIConfigSet(&newConf)
+	go a.parent.OnAPIConfigSet(apiConfigSetReq{Conf: &newConf, Edit: edit})
 
 	ctx.Status(http.StatusOK)
 }
@@ -438,13 +647,25 @@ func (a *api) onConfigPathsEdit(ctx *gin.Context) {
 	cloneStruct(a.conf, &newConf)
 	a.mutex.Unlock()
 
-	newConfPath, ok := newConf.Paths[name]
-	if !ok {
+	if _, ok := newConf.Paths[name]; !ok {
 		ctx.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	fillStruct(newConfPath, in)
+	edit := func(c *conf.Conf) {
+		// the path may be missing from the configuration file,
+		// when it is defined with environment variables
+		if c.Paths == nil {
+			c.Paths = make(map[string]*conf.PathConf)
+		}
+		if c.Paths[name] == nil {
+			c.Paths[name] = &conf.PathConf{}
+		}
+
+		fillStruct(c.Paths[name], in)
+	}
+
+	edit(&newConf)
 
 	err = newConf.CheckAndFillMissing()
 	if err != nil {
@@ -458,7 +679,7 @@ func (a *api) onConfigPathsEdit(ctx *gin.Context) {
 
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
-	go a.parent.OnAPIConfigSet(&newConf)
+	go a.parent.OnAPIConfigSet(apiConfigSetReq{Conf: &newConf, Edit: edit})
 
 	ctx.Status(http.StatusOK)
 }
@@ -476,7 +697,11 @@ func (a *api) onConfigPathsDelete(ctx *gin.Context) {
 		return
 	}
 
-	delete(newConf.Paths, name)
+	edit := func(c *conf.Conf) {
+		delete(c.Paths, name)
+	}
+
+	edit(&newConf)
 
 	err := newConf.CheckAndFillMissing()
 	if err != nil {
@@ -490,7 +715,7 @@ func (a *api) onConfigPathsDelete(ctx *gin.Context) {
 
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
-	go a.parent.OnAPIConfigSet(&newConf)
+	go a.parent.OnAPIConfigSet(apiConfigSetReq{Conf: &newConf, Edit: edit})
 
 	ctx.Status(http.StatusOK)
 }
@@ -505,6 +730,36 @@ func (a *api) onPathsList(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, res.Data)
 }
 
+func (a *api) onPathsTrigger(ctx *gin.Context) {
+	var in struct {
+		Reason string `json:"reason"`
+	}
+
+	// the body is optional
+	if ctx.Request.ContentLength != 0 {
+		err := json.NewDecoder(ctx.Request.Body).Decode(&in)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := a.pathManager.OnAPIPathsTrigger(apiPathsTriggerReq{
+		Name:   ctx.Param("name"),
+		Reason: in.Reason,
+	})
+	if res.Err != nil {
+		if res.Path == nil {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
 func (a *api) onRTSPSessionsList(ctx *gin.Context) {
 	if interfaceIsEmpty(a.rtspServer) {
 		ctx.AbortWithStatus(http.StatusNotFound)
@@ -607,3 +862,196 @@ func (a *api) onRTMPConnsKick(ctx *gin.Context) {
 
 	ctx.Status(http.StatusOK)
 }
+
+func (a *api) onRTMPSConnsList(ctx *gin.Context) {
+	if interfaceIsEmpty(a.rtmpsServer) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	res := a.rtmpsServer.OnAPIRTMPConnsList(apiRTMPConnsListReq{})
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res.Data)
+}
+
+func (a *api) onRTMPSConnsKick(ctx *gin.Context) {
+	if interfaceIsEmpty(a.rtmpsServer) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	res := a.rtmpsServer.OnAPIRTMPConnsKick(apiRTMPConnsKickReq{ID: id})
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onFLVConnsList(ctx *gin.Context) {
+	if interfaceIsEmpty(a.flvServer) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	res := a.flvServer.OnAPIFLVConnsList(apiFLVConnsListReq{})
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res.Data)
+}
+
+func (a *api) onFLVConnsKick(ctx *gin.Context) {
+	if interfaceIsEmpty(a.flvServer) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	res := a.flvServer.OnAPIFLVConnsKick(apiFLVConnsKickReq{ID: id})
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onWebhooksList(ctx *gin.Context) {
+	if interfaceIsEmpty(a.webhookManager) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	res := a.webhookManager.OnAPIWebhooksList(apiWebhooksListReq{})
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res.Data)
+}
+
+func (a *api) onEvents(ctx *gin.Context) {
+	a.log(logger.Debug, "[c->s] %s %s", ctx.Request.Method, ctx.Request.URL)
+
+	filter := eventbus.Filter{
+		Paths: ctx.QueryArray("path"),
+	}
+	for _, v := range ctx.QueryArray("type") {
+		for _, typ := range strings.Split(v, ",") {
+			if typ == "" {
+				continue
+			}
+
+			err := eventbus.CheckFilterType(eventbus.Type(typ))
+			if err != nil {
+				ctx.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+
+			filter.Types = append(filter.Types, eventbus.Type(typ))
+		}
+	}
+
+	sub := a.eventBus.Subscribe(filter, apiEventsQueueSize)
+	defer sub.Close()
+
+	if strings.EqualFold(ctx.GetHeader("Upgrade"), "websocket") {
+		a.writeEventsWebSocket(ctx, sub)
+		return
+	}
+
+	a.writeEventsSSE(ctx, sub)
+}
+
+// writeEventsSSE writes events with the Server-Sent Events protocol.
+func (a *api) writeEventsSSE(ctx *gin.Context, sub *eventbus.Subscription) {
+	ctx.Writer.Header().Set("Server", "rtsp-simple-server")
+	ctx.Writer.Header().Set("Content-Type", "text/event-stream")
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	ctx.Writer.WriteHeader(http.StatusOK)
+	ctx.Writer.Flush()
+
+	keepalive := time.NewTicker(apiEventsKeepalivePeriod)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case e := <-sub.Events():
+			byts, _ := json.Marshal(e)
+			_, err := fmt.Fprintf(ctx.Writer, "event: %s\ndata: %s\n\n", e.Type, byts)
+			if err != nil {
+				return
+			}
+			ctx.Writer.Flush()
+
+		case <-keepalive.C:
+			_, err := ctx.Writer.WriteString(":\n\n")
+			if err != nil {
+				return
+			}
+			ctx.Writer.Flush()
+
+		case <-ctx.Request.Context().Done():
+			return
+
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// writeEventsWebSocket writes events as JSON messages of a WebSocket.
+func (a *api) writeEventsWebSocket(ctx *gin.Context, sub *eventbus.Subscription) {
+	a.mutex.Lock()
+	writeTimeout := a.conf.WriteTimeout
+	a.mutex.Unlock()
+
+	srv := websocket.Server{
+		// allow connections from any origin
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// incoming messages are discarded, and are read
+			// only to detect the closure of the connection
+			readDone := make(chan struct{})
+			go func() {
+				defer close(readDone)
+				io.Copy(ioutil.Discard, ws)
+			}()
+
+			for {
+				select {
+				case e := <-sub.Events():
+					ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+					err := websocket.JSON.Send(ws, e)
+					if err != nil {
+						return
+					}
+
+				case <-readDone:
+					return
+
+				case <-a.ctx.Done():
+					return
+				}
+			}
+		},
+	}
+
+	srv.ServeHTTP(ctx.Writer, ctx.Request)
+}
@@ -1,17 +1,21 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 )
 
 func httpRequest(method string, ur string, in interface{}, out interface{}) error {
@@ -149,6 +153,45 @@ func TestAPIConfigPathsRemove(t *testing.T) {
 	require.Equal(t, false, ok)
 }
 
+func TestAPIConfigPersist(t *testing.T) {
+	tmpf, err := writeTempFile([]byte("# enable the API\n" +
+		"api: yes\n" +
+		"apiPersistConfig: yes\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	// values set with environment variables are not written
+	os.Setenv("RTSP_READBUFFERCOUNT", "1024")
+	defer os.Unsetenv("RTSP_READBUFFERCOUNT")
+
+	p, ok := New([]string{tmpf})
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	err = httpRequest(http.MethodPost, "http://localhost:9997/v1/config/paths/add/mypath", map[string]interface{}{
+		"source":         "rtsp://127.0.0.1:9999/mypath",
+		"sourceOnDemand": true,
+	}, nil)
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	byts, err := ioutil.ReadFile(tmpf)
+	require.NoError(t, err)
+	require.Contains(t, string(byts), "# enable the API\napi: yes\napiPersistConfig: yes\n")
+	require.Contains(t, string(byts), "  mypath:\n    source: rtsp://127.0.0.1:9999/mypath\n")
+	require.NotContains(t, string(byts), "readBufferCount: 1024")
+
+	// missing values are not filled
+	require.Equal(t, "# enable the API\n"+
+		"api: yes\n"+
+		"apiPersistConfig: yes\n"+
+		"paths:\n"+
+		"  mypath:\n"+
+		"    source: rtsp://127.0.0.1:9999/mypath\n"+
+		"    sourceOnDemand: true\n", string(byts))
+}
+
 func TestAPIPathsList(t *testing.T) {
 	serverCertFpath, err := writeTempFile(serverCert)
 	require.NoError(t, err)
@@ -205,6 +248,295 @@ func TestAPIPathsList(t *testing.T) {
 	}()
 }
 
+func TestAPIPathsTrigger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, ok := newInstance("api: yes\n" +
+		"paths:\n" +
+		"  mypath:\n" +
+		"    record: yes\n" +
+		"    recordPath: " + dir + "/%path\n" +
+		"    recordPreRoll: 1s\n" +
+		"    recordPostRoll: 10s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/trigger/otherpath", nil, nil)
+	require.EqualError(t, err, "bad status code: 404")
+
+	// the path is not ready
+	err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/trigger/mypath", nil, nil)
+	require.EqualError(t, err, "bad status code: 400")
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	func() {
+		source, err := gortsplib.DialPublish("rtsp://localhost:8554/mypath",
+			gortsplib.Tracks{track})
+		require.NoError(t, err)
+		defer source.Close()
+
+		for i := 0; i < 20; i++ {
+			if i == 10 {
+				err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/trigger/mypath", map[string]interface{}{
+					"reason": "doorbell",
+				}, nil)
+				require.NoError(t, err)
+			}
+
+			// single IDR NALU, with the marker bit
+			err = source.WriteFrame(0, gortsplib.StreamTypeRTP,
+				[]byte{0x80, 0xe0, 0x00, byte(i), 0x00, 0x00, byte(i), 0x00, 0x00, 0x00, 0x00, 0x01, 0x65, 0x01})
+			require.NoError(t, err)
+
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	// the recording is closed when the stream ends
+	time.Sleep(500 * time.Millisecond)
+
+	fi, err := os.Stat(dir + "/mypath.ts")
+	require.NoError(t, err)
+	require.NotEqual(t, int64(0), fi.Size())
+
+	byts, err := ioutil.ReadFile(dir + "/mypath.json")
+	require.NoError(t, err)
+
+	var sidecar struct {
+		Path     string `json:"path"`
+		File     string `json:"file"`
+		Triggers []struct {
+			Source string `json:"source"`
+			Reason string `json:"reason"`
+		} `json:"triggers"`
+	}
+	err = json.Unmarshal(byts, &sidecar)
+	require.NoError(t, err)
+	require.Equal(t, "mypath", sidecar.Path)
+	require.Equal(t, "mypath.ts", sidecar.File)
+	require.Equal(t, 1, len(sidecar.Triggers))
+	require.Equal(t, "api", sidecar.Triggers[0].Source)
+	require.Equal(t, "doorbell", sidecar.Triggers[0].Reason)
+}
+
+func TestAPIPathsTriggerMP4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, ok := newInstance("api: yes\n" +
+		"paths:\n" +
+		"  mypath:\n" +
+		"    record: yes\n" +
+		"    recordPath: " + dir + "/%path\n" +
+		"    recordFormat: mp4\n" +
+		"    recordPreRoll: 1s\n" +
+		"    recordPostRoll: 10s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	func() {
+		source, err := gortsplib.DialPublish("rtsp://localhost:8554/mypath",
+			gortsplib.Tracks{track})
+		require.NoError(t, err)
+		defer source.Close()
+
+		for i := 0; i < 20; i++ {
+			if i == 10 {
+				err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/trigger/mypath", nil, nil)
+				require.NoError(t, err)
+			}
+
+			err = source.WriteFrame(0, gortsplib.StreamTypeRTP,
+				[]byte{0x80, 0xe0, 0x00, byte(i), 0x00, 0x00, byte(i), 0x00, 0x00, 0x00, 0x00, 0x01, 0x65, 0x01})
+			require.NoError(t, err)
+
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	// the recording is closed when the stream ends
+	time.Sleep(500 * time.Millisecond)
+
+	byts, err := ioutil.ReadFile(dir + "/mypath.mp4")
+	require.NoError(t, err)
+	require.Equal(t, []byte("ftyp"), byts[4:8])
+	require.Equal(t, true, bytes.Contains(byts, []byte("moov")))
+
+	byts, err = ioutil.ReadFile(dir + "/mypath.json")
+	require.NoError(t, err)
+
+	var sidecar struct {
+		File string `json:"file"`
+	}
+	err = json.Unmarshal(byts, &sidecar)
+	require.NoError(t, err)
+	require.Equal(t, "mypath.mp4", sidecar.File)
+}
+
+func TestAPIPathsTriggerBeforeKeyframe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, ok := newInstance("api: yes\n" +
+		"paths:\n" +
+		"  mypath:\n" +
+		"    record: yes\n" +
+		"    recordPath: " + dir + "/%path\n" +
+		"    recordPreRoll: 1s\n" +
+		"    recordPostRoll: 10s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	func() {
+		source, err := gortsplib.DialPublish("rtsp://localhost:8554/mypath",
+			gortsplib.Tracks{track})
+		require.NoError(t, err)
+		defer source.Close()
+
+		// the trigger is received before any keyframe
+		err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/trigger/mypath", nil, nil)
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			// non-IDR NALUs first, then IDR NALUs
+			typ := byte(0x41)
+			if i >= 5 {
+				typ = 0x65
+			}
+
+			err = source.WriteFrame(0, gortsplib.StreamTypeRTP,
+				[]byte{0x80, 0xe0, 0x00, byte(i), 0x00, 0x00, byte(i), 0x00, 0x00, 0x00, 0x00, 0x01, typ, 0x01})
+			require.NoError(t, err)
+
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	// the recording is closed when the stream ends
+	time.Sleep(500 * time.Millisecond)
+
+	fi, err := os.Stat(dir + "/mypath.ts")
+	require.NoError(t, err)
+	require.NotEqual(t, int64(0), fi.Size())
+
+	_, err = os.Stat(dir + "/mypath.json")
+	require.NoError(t, err)
+}
+func TestAPIEvents(t *testing.T) {
+	for _, ca := range []string{
+		"sse",
+		"websocket",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			p, ok := newInstance("api: yes\n" +
+				"paths:\n" +
+				"  mypath:\n" +
+				"  otherpath:\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			var readEvent func() (string, string)
+
+			switch ca {
+			case "sse":
+				res, err := http.Get("http://localhost:9997/v1/events?path=mypath&type=publisher.record,path")
+				require.NoError(t, err)
+				defer res.Body.Close()
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+				scanner := bufio.NewScanner(res.Body)
+				readEvent = func() (string, string) {
+					var typ string
+					var data struct {
+						Path string `json:"path"`
+					}
+					for scanner.Scan() {
+						line := scanner.Text()
+						switch {
+						case strings.HasPrefix(line, "event: "):
+							typ = line[len("event: "):]
+
+						case strings.HasPrefix(line, "data: "):
+							err := json.Unmarshal([]byte(line[len("data: "):]), &data)
+							require.NoError(t, err)
+
+						case line == "" && typ != "":
+							return typ, data.Path
+						}
+					}
+					return "", ""
+				}
+
+			case "websocket":
+				ws, err := websocket.Dial("ws://localhost:9997/v1/events?path=mypath&type=publisher.record,path",
+					"", "http://localhost/")
+				require.NoError(t, err)
+				defer ws.Close()
+
+				readEvent = func() (string, string) {
+					var data struct {
+						Type string `json:"type"`
+						Path string `json:"path"`
+					}
+					err := websocket.JSON.Receive(ws, &data)
+					require.NoError(t, err)
+					return data.Type, data.Path
+				}
+			}
+
+			track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+			require.NoError(t, err)
+
+			other, err := gortsplib.DialPublish("rtsp://localhost:8554/otherpath",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+			defer other.Close()
+
+			source, err := gortsplib.DialPublish("rtsp://localhost:8554/mypath",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+
+			typ, pa := readEvent()
+			require.Equal(t, "publisher.record", typ)
+			require.Equal(t, "mypath", pa)
+
+			typ, pa = readEvent()
+			require.Equal(t, "path.ready", typ)
+			require.Equal(t, "mypath", pa)
+
+			source.Close()
+
+			typ, pa = readEvent()
+			require.Equal(t, "path.notReady", typ)
+			require.Equal(t, "mypath", pa)
+		})
+	}
+}
+
+func TestAPIEventsInvalidType(t *testing.T) {
+	p, ok := newInstance("api: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	res, err := http.Get("http://localhost:9997/v1/events?type=path,publisher.unknown")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
 func TestAPIList(t *testing.T) {
 	serverCertFpath, err := writeTempFile(serverCert)
 	require.NoError(t, err)
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/cluster"
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	// results of lookups are cached, in order not to query the store
+	// on every request.
+	clusterDirectoryCacheTTL = 2 * time.Second
+
+	clusterDirectoryCacheMaxEntries = 1024
+)
+
+type clusterDirectoryCacheEntry struct {
+	node   *cluster.Node
+	expire time.Time
+}
+
+type clusterDirectoryParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// clusterDirectory shares the paths published on this node with the other nodes
+// of the cluster and finds the nodes that own the other paths.
+type clusterDirectory struct {
+	mode   conf.ClusterMode
+	node   cluster.Node
+	store  cluster.Store
+	parent clusterDirectoryParent
+
+	cacheMutex sync.Mutex
+	cache      map[string]clusterDirectoryCacheEntry
+}
+
+func newClusterDirectory(
+	mode conf.ClusterMode,
+	nodeName string,
+	nodeRTSPAddress string,
+	nodeHLSAddress string,
+	storeType string,
+	address string,
+	peers []string,
+	readTimeout time.Duration,
+	parent clusterDirectoryParent,
+) (*clusterDirectory, error) {
+	d := &clusterDirectory{
+		mode: mode,
+		node: cluster.Node{
+			Name:        nodeName,
+			RTSPAddress: nodeRTSPAddress,
+			HLSAddress:  nodeHLSAddress,
+		},
+		parent: parent,
+		cache:  make(map[string]clusterDirectoryCacheEntry),
+	}
+
+	switch storeType {
+	case "peers":
+		var err error
+		d.store, err = cluster.NewPeersStore(address, peers, readTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		d.log(logger.Info, "listener opened on "+address)
+
+	default:
+		d.store = cluster.NewMemoryStore()
+	}
+
+	d.log(logger.Info, "node '%s' joined the cluster", nodeName)
+
+	return d, nil
+}
+
+func (d *clusterDirectory) close() {
+	d.store.Close()
+}
+
+func (d *clusterDirectory) log(level logger.Level, format string, args ...interface{}) {
+	d.parent.Log(level, "[cluster] "+format, args...)
+}
+
+func (d *clusterDirectory) register(pathName string) {
+	err := d.store.Register(pathName, d.node)
+	if err != nil {
+		d.log(logger.Info, "ERR: unable to register path '%s': %s", pathName, err)
+	}
+}
+
+func (d *clusterDirectory) unregister(pathName string) {
+	err := d.store.Unregister(pathName, d.node)
+	if err != nil {
+		d.log(logger.Info, "ERR: unable to unregister path '%s': %s", pathName, err)
+	}
+}
+
+// lookup returns the node that owns a path, or nil if the path is not published
+// on any other node. It can block while querying the other nodes, therefore it
+// must not be called by event loops.
+func (d *clusterDirectory) lookup(pathName string) *cluster.Node {
+	now := time.Now()
+
+	d.cacheMutex.Lock()
+	e, ok := d.cache[pathName]
+	d.cacheMutex.Unlock()
+
+	if ok && now.Before(e.expire) {
+		return e.node
+	}
+
+	node, err := d.store.Lookup(pathName)
+	if err != nil {
+		d.log(logger.Info, "ERR: unable to look up path '%s': %s", pathName, err)
+		node = nil
+	}
+
+	if node != nil && node.Name == d.node.Name {
+		node = nil
+	}
+
+	d.cacheMutex.Lock()
+	defer d.cacheMutex.Unlock()
+
+	if len(d.cache) >= clusterDirectoryCacheMaxEntries {
+		for key, e := range d.cache {
+			if !now.Before(e.expire) {
+				delete(d.cache, key)
+			}
+		}
+	}
+
+	if len(d.cache) < clusterDirectoryCacheMaxEntries {
+		d.cache[pathName] = clusterDirectoryCacheEntry{
+			node:   node,
+			expire: now.Add(clusterDirectoryCacheTTL),
+		}
+	}
+
+	return node
+}
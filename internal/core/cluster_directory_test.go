@@ -0,0 +1,148 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterRead(t *testing.T) {
+	for _, ca := range []string{
+		"redirect",
+		"proxy",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			p1, ok := newInstance("rtmpDisable: yes\n" +
+				"protocols: [tcp]\n" +
+				"cluster: yes\n" +
+				"clusterNodeName: node1\n" +
+				"clusterNodeRTSPAddress: rtsp://localhost:8554\n" +
+				"clusterNodeHLSAddress: http://localhost:8888\n" +
+				"clusterMode: " + ca + "\n")
+			require.Equal(t, true, ok)
+			defer p1.close()
+
+			p2, ok := newInstance("rtmpDisable: yes\n" +
+				"protocols: [tcp]\n" +
+				"rtspAddress: :8654\n" +
+				"hlsAddress: :8988\n" +
+				"flvAddress: :8989\n" +
+				"cluster: yes\n" +
+				"clusterNodeName: node2\n" +
+				"clusterNodeRTSPAddress: rtsp://localhost:8654\n" +
+				"clusterNodeHLSAddress: http://localhost:8988\n" +
+				"clusterMode: " + ca + "\n")
+			require.Equal(t, true, ok)
+			defer p2.close()
+
+			track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+			require.NoError(t, err)
+
+			source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+			defer source.Close()
+
+			if ca == "redirect" {
+				hc := &http.Client{
+					CheckRedirect: func(req *http.Request, via []*http.Request) error {
+						return http.ErrUseLastResponse
+					},
+				}
+
+				res, err := hc.Get("http://localhost:8988/teststream/index.m3u8")
+				require.NoError(t, err)
+				defer res.Body.Close()
+				require.Equal(t, http.StatusFound, res.StatusCode)
+				require.Equal(t, "http://localhost:8888/teststream/index.m3u8", res.Header.Get("Location"))
+			}
+
+			var responses []*base.Response
+			c := gortsplib.Client{
+				OnResponse: func(res *base.Response) {
+					responses = append(responses, res)
+				},
+			}
+
+			dest, err := c.DialRead("rtsp://localhost:8654/teststream")
+			require.NoError(t, err)
+			defer dest.Close()
+
+			if ca == "redirect" {
+				require.Equal(t, base.StatusFound, responses[1].StatusCode)
+				require.Equal(t, base.HeaderValue{"rtsp://localhost:8554/teststream"},
+					responses[1].Header["Location"])
+			}
+
+			readDone := make(chan struct{})
+			frameRecv := make(chan struct{})
+			go func() {
+				defer close(readDone)
+				dest.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+					if streamType == gortsplib.StreamTypeRTP {
+						require.Equal(t, []byte{0x05, 0x06, 0x07, 0x08}, payload[len(payload)-4:])
+						select {
+						case <-frameRecv:
+						default:
+							close(frameRecv)
+						}
+					}
+				})
+			}()
+
+			err = source.WriteFrame(0, gortsplib.StreamTypeRTP,
+				[]byte{0x80, 0x60, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x05, 0x06, 0x07, 0x08})
+			require.NoError(t, err)
+
+			<-frameRecv
+
+			dest.Close()
+			<-readDone
+		})
+	}
+}
+
+func TestClusterProxyLocalPublisher(t *testing.T) {
+	p1, ok := newInstance("rtmpDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"cluster: yes\n" +
+		"clusterNodeName: node1\n" +
+		"clusterNodeRTSPAddress: rtsp://localhost:8554\n" +
+		"clusterMode: proxy\n")
+	require.Equal(t, true, ok)
+	defer p1.close()
+
+	p2, ok := newInstance("rtmpDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"rtspAddress: :8654\n" +
+		"hlsAddress: :8988\n" +
+		"flvAddress: :8989\n" +
+		"cluster: yes\n" +
+		"clusterNodeName: node2\n" +
+		"clusterNodeRTSPAddress: rtsp://localhost:8654\n" +
+		"clusterMode: proxy\n")
+	require.Equal(t, true, ok)
+	defer p2.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	source1, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source1.Close()
+
+	// node2 proxies the path from node1
+	dest, err := gortsplib.DialRead("rtsp://localhost:8654/teststream")
+	require.NoError(t, err)
+	dest.Close()
+
+	// a local publisher replaces the proxy
+	source2, err := gortsplib.DialPublish("rtsp://localhost:8654/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source2.Close()
+}
@@ -0,0 +1,325 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
+)
+
+const (
+	composeSourceRetryPause = 5 * time.Second
+)
+
+type composeSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+type composeSourceFrame struct {
+	trackID    int
+	streamType gortsplib.StreamType
+	payload    []byte
+}
+
+// composeSourceInput is the reader of one of the paths that are read by a composeSource.
+type composeSourceInput struct {
+	source    *composeSource
+	ctxCancel func()
+	pathName  string
+	trackIDs  []int // track IDs of the path, mapped to track IDs of the source
+	path      *path
+	buffer    *readerBuffer
+}
+
+// Close implements reader.
+func (in *composeSourceInput) Close() {
+	in.ctxCancel()
+}
+
+// OnReaderAccepted implements reader.
+func (in *composeSourceInput) OnReaderAccepted() {
+	in.source.log(logger.Info, "is reading from path '%s'", in.pathName)
+}
+
+// OnReaderFrame implements reader.
+func (in *composeSourceInput) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	trackID = in.trackIDs[trackID]
+	if trackID < 0 {
+		return
+	}
+
+	in.buffer.push(trackID, streamType, payload, composeSourceFrame{
+		trackID:    trackID,
+		streamType: streamType,
+		payload:    payload,
+	})
+}
+
+// OnReaderAPIDescribe implements reader.
+func (in *composeSourceInput) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"composeSource"}
+}
+
+// composeSource is a static source that merges tracks of other paths.
+type composeSource struct {
+	urls            []string
+	readBufferCount int
+	wg              *sync.WaitGroup
+	pathManager     pathSourcePathManager
+	parent          composeSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newComposeSource(
+	parentCtx context.Context,
+	urls []string,
+	readBufferCount int,
+	wg *sync.WaitGroup,
+	pathManager pathSourcePathManager,
+	parent composeSourceParent) *composeSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &composeSource{
+		urls:            urls,
+		readBufferCount: readBufferCount,
+		wg:              wg,
+		pathManager:     pathManager,
+		parent:          parent,
+		ctx:             ctx,
+		ctxCancel:       ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *composeSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *composeSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[compose source] "+format, args...)
+}
+
+func (s *composeSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(composeSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *composeSource) runInner() bool {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- s.runRead(innerCtx, innerCtxCancel)
+	}()
+
+	select {
+	case err := <-runErr:
+		innerCtxCancel()
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+
+	case <-s.ctx.Done():
+		innerCtxCancel()
+		<-runErr
+		return false
+	}
+}
+
+func (s *composeSource) runRead(ctx context.Context, ctxCancel func()) error {
+	var inputs []*composeSourceInput
+
+	defer func() {
+		for _, in := range inputs {
+			in.path.OnReaderRemove(pathReaderRemoveReq{Author: in})
+		}
+	}()
+
+	var tracks gortsplib.Tracks
+
+	for _, ur := range s.urls {
+		u, err := conf.ParsePathSourceURL(ur)
+		if err != nil {
+			return err
+		}
+
+		in := &composeSourceInput{
+			source:    s,
+			ctxCancel: ctxCancel,
+			pathName:  u.PathName,
+		}
+
+		res := s.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+			Author:              in,
+			PathName:            u.PathName,
+			IP:                  nil,
+			ValidateCredentials: nil,
+		})
+		if res.Err != nil {
+			return res.Err
+		}
+
+		in.path = res.Path
+		inputs = append(inputs, in)
+
+		videoTrackID := -1
+		found := false
+
+		for _, t := range res.Stream.tracks() {
+			if !pathSourceTrackSelected(u.Tracks, t) {
+				in.trackIDs = append(in.trackIDs, -1)
+				continue
+			}
+
+			if videoTrackID < 0 && t.IsH264() {
+				videoTrackID = len(tracks)
+			}
+
+			found = true
+			in.trackIDs = append(in.trackIDs, len(tracks))
+			tracks = append(tracks, t)
+		}
+
+		if !found {
+			return fmt.Errorf("path '%s' doesn't contain any of the requested tracks", u.PathName)
+		}
+
+		in.buffer = newReaderBuffer(s.readBufferCount, videoTrackID, 0, new(uint64))
+	}
+
+	sres := s.parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		Source: s,
+		Tracks: tracks,
+	})
+	if sres.Err != nil {
+		return sres.Err
+	}
+
+	s.log(logger.Info, "ready")
+
+	defer func() {
+		s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
+	}()
+
+	// timestamps of all tracks are converted into the same clock, and sender reports
+	// are generated with this clock, in order to allow readers to synchronize tracks.
+	base := time.Now()
+	clocks := make([]*streamClock, len(tracks))
+	for i, t := range tracks {
+		clocks[i] = newStreamClock(t)
+	}
+
+	rtcpSenders := rtcpsenderset.New(tracks, sres.Stream.onFrame)
+	defer rtcpSenders.Close()
+
+	var writeMutex sync.Mutex
+
+	processFrame := func(f composeSourceFrame) {
+		clock := clocks[f.trackID]
+		clock.processFrame(f.streamType, f.payload, time.Now())
+
+		if f.streamType == gortsplib.StreamTypeRTCP {
+			return
+		}
+
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(f.payload)
+		if err != nil {
+			return
+		}
+
+		ntp, ok := clock.ntp(pkt.Timestamp)
+		if !ok {
+			return
+		}
+		pkt.Timestamp = clock.rtpTime(base, ntp)
+
+		byts, err := pkt.Marshal()
+		if err != nil {
+			return
+		}
+
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+
+		rtcpSenders.OnFrameAt(f.trackID, gortsplib.StreamTypeRTP, byts, ntp)
+		sres.Stream.onFrame(f.trackID, gortsplib.StreamTypeRTP, byts)
+	}
+
+	go func() {
+		<-ctx.Done()
+		for _, in := range inputs {
+			in.buffer.close()
+		}
+	}()
+
+	readErr := make(chan error, len(inputs))
+
+	for _, in := range inputs {
+		in.path.OnReaderPlay(pathReaderPlayReq{Author: in})
+
+		go func(in *composeSourceInput) {
+			readErr <- func() error {
+				for {
+					data, err := in.buffer.pull()
+					if err != nil {
+						return err
+					}
+
+					processFrame(data.(composeSourceFrame))
+				}
+			}()
+		}(in)
+	}
+
+	err := <-readErr
+	ctxCancel()
+
+	for i := 1; i < len(inputs); i++ {
+		<-readErr
+	}
+
+	return err
+}
+
+// OnSourceAPIDescribe implements source.
+func (*composeSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"composeSource"}
+}
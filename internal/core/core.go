@@ -11,6 +11,7 @@ import (
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/confwatcher"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 	"github.com/aler9/rtsp-simple-server/internal/rlimit"
 )
@@ -19,25 +20,30 @@ var version = "v1.0-alpha"
 
 // Core is an instance of rtsp-simple-server.
 type Core struct {
-	ctx         context.Context
-	ctxCancel   func()
-	confPath    string
-	conf        *conf.Conf
-	confFound   bool
-	stats       *stats
-	logger      *logger.Logger
-	metrics     *metrics
-	pprof       *pprof
-	pathManager *pathManager
-	rtspServer  *rtspServer
-	rtspsServer *rtspServer
-	rtmpServer  *rtmpServer
-	hlsServer   *hlsServer
-	api         *api
-	confWatcher *confwatcher.ConfWatcher
+	ctx            context.Context
+	ctxCancel      func()
+	confPath       string
+	conf           *conf.Conf
+	confFound      bool
+	stats          *stats
+	eventBus       *eventbus.Bus
+	logger         *logger.Logger
+	metrics        *metrics
+	pprof          *pprof
+	clusterDir     *clusterDirectory
+	pathManager    *pathManager
+	rtspServer     *rtspServer
+	rtspsServer    *rtspServer
+	rtmpServer     *rtmpServer
+	rtmpsServer    *rtmpServer
+	hlsServer      *hlsServer
+	flvServer      *flvServer
+	webhookManager *webhookManager
+	api            *api
+	confWatcher    *confwatcher.ConfWatcher
 
 	// in
-	apiConfigSet chan *conf.Conf
+	apiConfigSet chan apiConfigSetReq
 
 	// out
 	done chan struct{}
@@ -69,7 +75,7 @@ func New(args []string) (*Core, bool) {
 		ctx:          ctx,
 		ctxCancel:    ctxCancel,
 		confPath:     *argConfPath,
-		apiConfigSet: make(chan *conf.Conf),
+		apiConfigSet: make(chan apiConfigSetReq),
 		done:         make(chan struct{}),
 	}
 
@@ -149,15 +155,22 @@ outer:
 				break outer
 			}
 
-		case newConf := <-p.apiConfigSet:
+		case req := <-p.apiConfigSet:
 			p.Log(logger.Info, "reloading configuration (API request)")
 
-			err := p.reloadConf(newConf)
+			err := p.reloadConf(req.Conf)
 			if err != nil {
 				p.Log(logger.Info, "ERR: %s", err)
 				break outer
 			}
 
+			if p.conf.APIPersistConfig {
+				err := p.persistConf(req.Edit)
+				if err != nil {
+					p.Log(logger.Warn, "unable to write the configuration file: %s", err)
+				}
+			}
+
 		case <-p.ctx.Done():
 			break outer
 		}
@@ -179,6 +192,10 @@ func (p *Core) createResources(initial bool) error {
 		p.stats = newStats()
 	}
 
+	if p.eventBus == nil {
+		p.eventBus = eventbus.New()
+	}
+
 	if p.logger == nil {
 		p.logger, err = logger.New(
 			p.conf.LogLevelParsed,
@@ -218,6 +235,24 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	if p.conf.Cluster {
+		if p.clusterDir == nil {
+			p.clusterDir, err = newClusterDirectory(
+				p.conf.ClusterModeParsed,
+				p.conf.ClusterNodeName,
+				p.conf.ClusterNodeRTSPAddress,
+				p.conf.ClusterNodeHLSAddress,
+				p.conf.ClusterStore,
+				p.conf.ClusterAddress,
+				p.conf.ClusterPeers,
+				p.conf.ReadTimeout,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if p.pathManager == nil {
 		p.pathManager = newPathManager(
 			p.ctx,
@@ -229,6 +264,8 @@ func (p *Core) createResources(initial bool) error {
 			p.conf.Paths,
 			p.stats,
 			p.metrics,
+			p.eventBus,
+			p.clusterDir,
 			p)
 	}
 
@@ -246,6 +283,7 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
 				p.conf.ReadBufferSize,
+				p.conf.SlowReaderTimeout,
 				useUDP,
 				useMulticast,
 				p.conf.RTPAddress,
@@ -261,6 +299,7 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
 				p.metrics,
+				p.eventBus,
 				p.pathManager,
 				p)
 			if err != nil {
@@ -281,6 +320,7 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
 				p.conf.ReadBufferSize,
+				p.conf.SlowReaderTimeout,
 				false,
 				false,
 				"",
@@ -296,6 +336,7 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
 				p.metrics,
+				p.eventBus,
 				p.pathManager,
 				p)
 			if err != nil {
@@ -304,7 +345,9 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
-	if !p.conf.RTMPDisable {
+	if !p.conf.RTMPDisable &&
+		(p.conf.RTMPEncryptionParsed == conf.EncryptionNo ||
+			p.conf.RTMPEncryptionParsed == conf.EncryptionOptional) {
 		if p.rtmpServer == nil {
 			p.rtmpServer, err = newRTMPServer(
 				p.ctx,
@@ -312,10 +355,42 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.ReadTimeout,
 				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
+				p.conf.SlowReaderTimeout,
+				false,
+				"",
+				"",
+				p.conf.RTSPAddress,
+				p.conf.RunOnConnect,
+				p.conf.RunOnConnectRestart,
+				p.metrics,
+				p.eventBus,
+				p.pathManager,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !p.conf.RTMPDisable &&
+		(p.conf.RTMPEncryptionParsed == conf.EncryptionStrict ||
+			p.conf.RTMPEncryptionParsed == conf.EncryptionOptional) {
+		if p.rtmpsServer == nil {
+			p.rtmpsServer, err = newRTMPServer(
+				p.ctx,
+				p.conf.RTMPSAddress,
+				p.conf.ReadTimeout,
+				p.conf.WriteTimeout,
+				p.conf.ReadBufferCount,
+				p.conf.SlowReaderTimeout,
+				true,
+				p.conf.RTMPServerCert,
+				p.conf.RTMPServerKey,
 				p.conf.RTSPAddress,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
 				p.metrics,
+				p.eventBus,
 				p.pathManager,
 				p)
 			if err != nil {
@@ -333,7 +408,29 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.HLSSegmentCount,
 				p.conf.HLSSegmentDuration,
 				p.conf.HLSAllowOrigin,
+				p.conf.MJPEGFPS,
+				p.conf.MJPEGKeyframesOnly,
+				p.conf.ReadBufferCount,
+				p.conf.SlowReaderTimeout,
+				p.clusterDir,
+				p.pathManager,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !p.conf.FLVDisable {
+		if p.flvServer == nil {
+			p.flvServer, err = newFLVServer(
+				p.ctx,
+				p.conf.FLVAddress,
+				p.conf.FLVAllowOrigin,
+				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
+				p.conf.SlowReaderTimeout,
+				p.eventBus,
 				p.pathManager,
 				p)
 			if err != nil {
@@ -342,6 +439,16 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	if p.conf.Webhooks != nil {
+		if p.webhookManager == nil {
+			p.webhookManager = newWebhookManager(
+				p.ctx,
+				p.conf.Webhooks,
+				p.eventBus,
+				p)
+		}
+	}
+
 	if p.conf.API {
 		if p.api == nil {
 			p.api, err = newAPI(
@@ -351,6 +458,10 @@ func (p *Core) createResources(initial bool) error {
 				p.rtspServer,
 				p.rtspsServer,
 				p.rtmpServer,
+				p.rtmpsServer,
+				p.flvServer,
+				p.webhookManager,
+				p.eventBus,
 				p)
 			if err != nil {
 				return err
@@ -388,6 +499,20 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		closePPROF = true
 	}
 
+	closeClusterDir := false
+	if newConf == nil ||
+		newConf.Cluster != p.conf.Cluster ||
+		newConf.ClusterNodeName != p.conf.ClusterNodeName ||
+		newConf.ClusterNodeRTSPAddress != p.conf.ClusterNodeRTSPAddress ||
+		newConf.ClusterNodeHLSAddress != p.conf.ClusterNodeHLSAddress ||
+		newConf.ClusterModeParsed != p.conf.ClusterModeParsed ||
+		newConf.ClusterStore != p.conf.ClusterStore ||
+		newConf.ClusterAddress != p.conf.ClusterAddress ||
+		!reflect.DeepEqual(newConf.ClusterPeers, p.conf.ClusterPeers) ||
+		newConf.ReadTimeout != p.conf.ReadTimeout {
+		closeClusterDir = true
+	}
+
 	closePathManager := false
 	if newConf == nil ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
@@ -396,7 +521,8 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
 		newConf.ReadBufferSize != p.conf.ReadBufferSize ||
 		closeStats ||
-		closeMetrics {
+		closeMetrics ||
+		closeClusterDir {
 		closePathManager = true
 	} else if !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
 		p.pathManager.OnConfReload(newConf.Paths)
@@ -411,6 +537,7 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.SlowReaderTimeout != p.conf.SlowReaderTimeout ||
 		!reflect.DeepEqual(newConf.ProtocolsParsed, p.conf.ProtocolsParsed) ||
 		newConf.RTPAddress != p.conf.RTPAddress ||
 		newConf.RTCPAddress != p.conf.RTCPAddress ||
@@ -435,6 +562,7 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.SlowReaderTimeout != p.conf.SlowReaderTimeout ||
 		newConf.ServerCert != p.conf.ServerCert ||
 		newConf.ServerKey != p.conf.ServerKey ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
@@ -449,10 +577,12 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 	closeRTMPServer := false
 	if newConf == nil ||
 		newConf.RTMPDisable != p.conf.RTMPDisable ||
+		newConf.RTMPEncryptionParsed != p.conf.RTMPEncryptionParsed ||
 		newConf.RTMPAddress != p.conf.RTMPAddress ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.SlowReaderTimeout != p.conf.SlowReaderTimeout ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
 		newConf.RunOnConnect != p.conf.RunOnConnect ||
 		newConf.RunOnConnectRestart != p.conf.RunOnConnectRestart ||
@@ -461,6 +591,25 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		closeRTMPServer = true
 	}
 
+	closeRTMPSServer := false
+	if newConf == nil ||
+		newConf.RTMPDisable != p.conf.RTMPDisable ||
+		newConf.RTMPEncryptionParsed != p.conf.RTMPEncryptionParsed ||
+		newConf.RTMPSAddress != p.conf.RTMPSAddress ||
+		newConf.RTMPServerCert != p.conf.RTMPServerCert ||
+		newConf.RTMPServerKey != p.conf.RTMPServerKey ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		newConf.WriteTimeout != p.conf.WriteTimeout ||
+		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.SlowReaderTimeout != p.conf.SlowReaderTimeout ||
+		newConf.RTSPAddress != p.conf.RTSPAddress ||
+		newConf.RunOnConnect != p.conf.RunOnConnect ||
+		newConf.RunOnConnectRestart != p.conf.RunOnConnectRestart ||
+		closeMetrics ||
+		closePathManager {
+		closeRTMPSServer = true
+	}
+
 	closeHLSServer := false
 	if newConf == nil ||
 		newConf.HLSDisable != p.conf.HLSDisable ||
@@ -469,11 +618,33 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.HLSSegmentCount != p.conf.HLSSegmentCount ||
 		newConf.HLSSegmentDuration != p.conf.HLSSegmentDuration ||
 		newConf.HLSAllowOrigin != p.conf.HLSAllowOrigin ||
+		newConf.MJPEGFPS != p.conf.MJPEGFPS ||
+		newConf.MJPEGKeyframesOnly != p.conf.MJPEGKeyframesOnly ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.SlowReaderTimeout != p.conf.SlowReaderTimeout ||
+		closeClusterDir ||
 		closePathManager {
 		closeHLSServer = true
 	}
 
+	closeFLVServer := false
+	if newConf == nil ||
+		newConf.FLVDisable != p.conf.FLVDisable ||
+		newConf.FLVAddress != p.conf.FLVAddress ||
+		newConf.FLVAllowOrigin != p.conf.FLVAllowOrigin ||
+		newConf.WriteTimeout != p.conf.WriteTimeout ||
+		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.SlowReaderTimeout != p.conf.SlowReaderTimeout ||
+		closePathManager {
+		closeFLVServer = true
+	}
+
+	closeWebhookManager := false
+	if newConf == nil ||
+		!reflect.DeepEqual(newConf.Webhooks, p.conf.Webhooks) {
+		closeWebhookManager = true
+	}
+
 	closeAPI := false
 	if newConf == nil ||
 		newConf.API != p.conf.API ||
@@ -481,7 +652,10 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		closePathManager ||
 		closeRTSPServer ||
 		closeRTSPSServer ||
-		closeRTMPServer {
+		closeRTMPServer ||
+		closeRTMPSServer ||
+		closeFLVServer ||
+		closeWebhookManager {
 		closeAPI = true
 	}
 
@@ -494,6 +668,11 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		}
 	}
 
+	if closeWebhookManager && p.webhookManager != nil {
+		p.webhookManager.close()
+		p.webhookManager = nil
+	}
+
 	if closeRTSPSServer && p.rtspsServer != nil {
 		p.rtspsServer.close()
 		p.rtspsServer = nil
@@ -509,16 +688,31 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		p.pathManager = nil
 	}
 
+	if closeFLVServer && p.flvServer != nil {
+		p.flvServer.close()
+		p.flvServer = nil
+	}
+
 	if closeHLSServer && p.hlsServer != nil {
 		p.hlsServer.close()
 		p.hlsServer = nil
 	}
 
+	if closeRTMPSServer && p.rtmpsServer != nil {
+		p.rtmpsServer.close()
+		p.rtmpsServer = nil
+	}
+
 	if closeRTMPServer && p.rtmpServer != nil {
 		p.rtmpServer.close()
 		p.rtmpServer = nil
 	}
 
+	if closeClusterDir && p.clusterDir != nil {
+		p.clusterDir.close()
+		p.clusterDir = nil
+	}
+
 	if closePPROF && p.pprof != nil {
 		p.pprof.close()
 		p.pprof = nil
@@ -546,10 +740,43 @@ func (p *Core) reloadConf(newConf *conf.Conf) error {
 	return p.createResources(false)
 }
 
+// persistConf applies an edit performed through the API to the configuration file.
+// Only the values loaded from the file are written, together with the edit;
+// values set with environment variables are not written.
+func (p *Core) persistConf(edit func(*conf.Conf)) error {
+	fileConf, err := conf.LoadFile(p.confPath)
+	if err != nil {
+		return err
+	}
+
+	edit(fileConf)
+
+	// the configuration is validated without filling missing values,
+	// in order not to write them into the file
+	var tmp conf.Conf
+	cloneStruct(fileConf, &tmp)
+	err = tmp.CheckAndFillMissing()
+	if err != nil {
+		return err
+	}
+
+	byts, err := fileConf.Encode(p.confPath)
+	if err != nil {
+		return err
+	}
+
+	// do not reload the configuration written by ourselves
+	if p.confWatcher != nil {
+		p.confWatcher.Ignore(byts)
+	}
+
+	return conf.WriteFile(p.confPath, byts)
+}
+
 // OnAPIConfigSet is called by api.
-func (p *Core) OnAPIConfigSet(conf *conf.Conf) {
+func (p *Core) OnAPIConfigSet(req apiConfigSetReq) {
 	select {
-	case p.apiConfigSet <- conf:
+	case p.apiConfigSet <- req:
 	case <-p.ctx.Done():
 	}
 }
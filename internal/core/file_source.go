@@ -0,0 +1,225 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
+)
+
+const (
+	fileSourceRetryPause = 5 * time.Second
+)
+
+type fileSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+// fileSource is a static source that reads a MPEG-TS file in loop.
+type fileSource struct {
+	ur     string
+	wg     *sync.WaitGroup
+	parent fileSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newFileSource(
+	parentCtx context.Context,
+	ur string,
+	wg *sync.WaitGroup,
+	parent fileSourceParent) *fileSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &fileSource{
+		ur:        ur,
+		wg:        wg,
+		parent:    parent,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *fileSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *fileSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[file source] "+format, args...)
+}
+
+func (s *fileSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(fileSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *fileSource) runInner() bool {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- s.runReader(innerCtx)
+	}()
+
+	select {
+	case err := <-runErr:
+		innerCtxCancel()
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+
+	case <-s.ctx.Done():
+		innerCtxCancel()
+		<-runErr
+		return false
+	}
+}
+
+func (s *fileSource) runReader(ctx context.Context) error {
+	u, err := url.Parse(s.ur)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var router *mpegtsSourceRouter
+
+	defer func() {
+		if router != nil {
+			router.close()
+			s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
+		}
+	}()
+
+	// timestamps of each loop are shifted by the duration of the previous loops,
+	// in order to keep them monotonic.
+	var offset time.Duration
+
+	for {
+		dem := mpegts.NewDemuxer(ctx, f)
+
+		videoTrack, audioTrack, err := dem.Tracks()
+		if err != nil {
+			return err
+		}
+
+		if router == nil {
+			router, err = newMPEGTSSourceRouter(ctx, true, videoTrack, audioTrack, s, s.parent)
+			if err != nil {
+				return err
+			}
+
+			s.log(logger.Info, "ready")
+		}
+
+		duration, err := s.readLoop(dem, router, offset)
+		if err != nil {
+			return err
+		}
+
+		offset += duration
+
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLoop routes the frames of the file and returns its duration.
+func (s *fileSource) readLoop(dem *mpegts.Demuxer, router *mpegtsSourceRouter, offset time.Duration) (time.Duration, error) {
+	count := 0
+	var maxPTS time.Duration
+
+	// the duration of the last frame is assumed to be equal to the distance between
+	// the last two frames of the first track type found in the file.
+	var refType mpegts.FrameType
+	var refPrevPTS time.Duration
+	var refDelta time.Duration
+
+	for {
+		f, err := dem.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if count == 0 {
+			refType = f.Type
+			refPrevPTS = f.PTS
+		} else if f.Type == refType {
+			refDelta = f.PTS - refPrevPTS
+			refPrevPTS = f.PTS
+		}
+
+		count++
+
+		if f.PTS > maxPTS {
+			maxPTS = f.PTS
+		}
+
+		f.PTS += offset
+
+		err = router.writeFrame(f)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if count == 0 {
+		return 0, fmt.Errorf("file does not contain any frame")
+	}
+
+	duration := maxPTS + refDelta
+	if duration <= 0 {
+		return 0, fmt.Errorf("file has an invalid duration")
+	}
+
+	return duration, nil
+}
+
+// OnSourceAPIDescribe implements source.
+func (*fileSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"fileSource"}
+}
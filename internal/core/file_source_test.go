@@ -0,0 +1,57 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSource(t *testing.T) {
+	tmpf, err := ioutil.TempFile(os.TempDir(), "rtsp-")
+	require.NoError(t, err)
+	defer os.Remove(tmpf.Name())
+
+	// 5 frames with a distance of 100ms between them
+	mux := newTestTSMuxer(tmpf)
+	for i := 0; i < 5; i++ {
+		writeTestTSFrame(t, mux, i)
+	}
+	tmpf.Close()
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  slate:\n" +
+		"    source: file://" + tmpf.Name() + "\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, recv := readTestFrames(t, "slate")
+	defer conn.Close()
+
+	var prev testReceivedFrame
+	select {
+	case prev = <-recv:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	// the file is read twice, frames are paced and timestamps keep increasing
+	for i := 0; i < 10; i++ {
+		var cur testReceivedFrame
+		select {
+		case cur = <-recv:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+		}
+
+		require.Equal(t, (prev.index+1)%5, cur.index)
+		require.InDelta(t, 9000, cur.timestamp-prev.timestamp, 1)
+		require.InDelta(t, 0.1, cur.t.Sub(prev.t).Seconds(), 0.05)
+		prev = cur
+	}
+}
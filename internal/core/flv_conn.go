@@ -0,0 +1,338 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/notedit/rtmp/av"
+	"golang.org/x/net/websocket"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
+)
+
+const (
+	flvConnPauseAfterAuthError = 2 * time.Second
+)
+
+// flvConnHTTPWriter flushes every write, in order to send each packet
+// as soon as it is available.
+type flvConnHTTPWriter struct {
+	w http.ResponseWriter
+}
+
+func (w flvConnHTTPWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, nil
+}
+
+type flvConnPathManager interface {
+	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+}
+
+type flvConnParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnConnClose(*flvConn)
+}
+
+// flvConn is a connection that reads a path in the FLV format,
+// with a plain HTTP response or with a WebSocket.
+type flvConn struct {
+	id                string
+	writeTimeout      time.Duration
+	readBufferCount   int
+	slowReaderTimeout time.Duration
+	wg                *sync.WaitGroup
+	remoteAddr        net.Addr
+	pathName          string
+	query             url.Values
+	httpW             http.ResponseWriter // http
+	ws                *websocket.Conn     // websocket
+	pathManager       flvConnPathManager
+	parent            flvConnParent
+
+	ctx           context.Context
+	ctxCancel     func()
+	path          *path
+	buffer        *readerBuffer
+	framesDropped *uint64
+	state         gortsplib.ServerSessionState
+	stateMutex    sync.Mutex
+
+	// out
+	done chan struct{}
+}
+
+func newFLVConn(
+	parentCtx context.Context,
+	id string,
+	writeTimeout time.Duration,
+	readBufferCount int,
+	slowReaderTimeout time.Duration,
+	wg *sync.WaitGroup,
+	remoteAddr net.Addr,
+	pathName string,
+	query url.Values,
+	httpW http.ResponseWriter,
+	ws *websocket.Conn,
+	pathManager flvConnPathManager,
+	parent flvConnParent) *flvConn {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	c := &flvConn{
+		id:                id,
+		writeTimeout:      writeTimeout,
+		readBufferCount:   readBufferCount,
+		slowReaderTimeout: slowReaderTimeout,
+		wg:                wg,
+		remoteAddr:        remoteAddr,
+		pathName:          pathName,
+		query:             query,
+		httpW:             httpW,
+		ws:                ws,
+		pathManager:       pathManager,
+		parent:            parent,
+		ctx:               ctx,
+		ctxCancel:         ctxCancel,
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
+		done: make(chan struct{}),
+	}
+
+	c.log(logger.Info, "opened")
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// Close closes a Conn.
+func (c *flvConn) Close() {
+	c.ctxCancel()
+}
+
+// ID returns the ID of the Conn.
+func (c *flvConn) ID() string {
+	return c.id
+}
+
+// RemoteAddr returns the remote address of the Conn.
+func (c *flvConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *flvConn) log(level logger.Level, format string, args ...interface{}) {
+	c.parent.Log(level, "[conn %v] "+format, append([]interface{}{c.remoteAddr}, args...)...)
+}
+
+func (c *flvConn) ip() net.IP {
+	return c.remoteAddr.(*net.TCPAddr).IP
+}
+
+func (c *flvConn) framesDroppedCount() uint64 {
+	return atomic.LoadUint64(c.framesDropped)
+}
+
+func (c *flvConn) safeState() gortsplib.ServerSessionState {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.state
+}
+
+// wait waits until the Conn is closed. After that, the response can't be used anymore.
+func (c *flvConn) wait() {
+	<-c.done
+}
+
+func (c *flvConn) run() {
+	defer close(c.done)
+	defer c.wg.Done()
+	defer c.log(logger.Info, "closed")
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	runErr := make(chan error)
+	go func() {
+		runErr <- c.runInner(ctx)
+	}()
+
+	select {
+	case err := <-runErr:
+		cancel()
+
+		if err != io.EOF {
+			c.log(logger.Info, "ERR: %s", err)
+		}
+
+	case <-c.ctx.Done():
+		cancel()
+		<-runErr
+	}
+
+	c.ctxCancel()
+
+	c.parent.OnConnClose(c)
+}
+
+func (c *flvConn) runInner(ctx context.Context) error {
+	if c.ws != nil {
+		go func() {
+			<-ctx.Done()
+			c.ws.Close()
+		}()
+
+		// incoming messages are discarded, and are read
+		// only to detect the closure of the connection
+		go func() {
+			io.Copy(ioutil.Discard, c.ws)
+			c.ctxCancel()
+		}()
+	}
+
+	res := c.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+		Author:   c,
+		PathName: c.pathName,
+		IP:       c.ip(),
+		ValidateCredentials: func(pathUser string, pathPass string) error {
+			return c.validateCredentials(pathUser, pathPass)
+		},
+	})
+
+	if res.Err != nil {
+		if terr, ok := res.Err.(pathErrAuthCritical); ok {
+			// wait some seconds to stop brute force attacks
+			<-time.After(flvConnPauseAfterAuthError)
+			c.writeError(http.StatusUnauthorized)
+			return errors.New(terr.Message)
+		}
+
+		c.writeError(http.StatusNotFound)
+		return res.Err
+	}
+
+	c.path = res.Path
+
+	defer func() {
+		c.path.OnReaderRemove(pathReaderRemoveReq{Author: c})
+	}()
+
+	c.stateMutex.Lock()
+	c.state = gortsplib.ServerSessionStateRead
+	c.stateMutex.Unlock()
+
+	packetizer, err := newRTMPPacketizer(res.Stream.tracks(), "FLV", c.log)
+	if err != nil {
+		c.writeError(http.StatusBadRequest)
+		return err
+	}
+
+	var w *rtmp.FLVWriter
+	if c.ws != nil {
+		w = rtmp.NewFLVWriter(c.ws)
+	} else {
+		c.httpW.Header().Set("Content-Type", "video/x-flv")
+		c.httpW.Header().Set("Cache-Control", "no-cache")
+		c.httpW.WriteHeader(http.StatusOK)
+		w = rtmp.NewFLVWriter(flvConnHTTPWriter{c.httpW})
+	}
+
+	c.setWriteDeadline()
+	err = w.WriteMetadata(packetizer.videoTrack, packetizer.audioTrack)
+	if err != nil {
+		return err
+	}
+
+	c.buffer = newReaderBuffer(c.readBufferCount, packetizer.videoTrackID, c.slowReaderTimeout, c.framesDropped)
+
+	go func() {
+		<-ctx.Done()
+		c.buffer.close()
+	}()
+
+	c.path.OnReaderPlay(pathReaderPlayReq{
+		Author: c,
+	})
+
+	for {
+		data, err := c.buffer.pull()
+		if err != nil {
+			return err
+		}
+		pair := data.(rtmpConnTrackIDPayloadPair)
+
+		err = packetizer.process(pair.trackID, pair.buf, func(pkt av.Packet) error {
+			c.setWriteDeadline()
+			return w.WritePacket(pkt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setWriteDeadline sets the write deadline of WebSockets.
+// Plain HTTP responses are closed when the client disconnects.
+func (c *flvConn) setWriteDeadline() {
+	if c.ws != nil {
+		c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+}
+
+// writeError writes a status code, if the response has not been sent yet.
+func (c *flvConn) writeError(statusCode int) {
+	if c.httpW != nil {
+		c.httpW.WriteHeader(statusCode)
+	}
+}
+
+func (c *flvConn) validateCredentials(
+	pathUser string,
+	pathPass string,
+) error {
+	if c.query.Get("user") != pathUser ||
+		c.query.Get("pass") != pathPass {
+		return pathErrAuthCritical{
+			Message: "wrong username or password",
+		}
+	}
+
+	return nil
+}
+
+// OnReaderAccepted implements reader.
+func (c *flvConn) OnReaderAccepted() {
+	c.log(logger.Info, "is reading from path '%s'", c.path.Name())
+}
+
+// OnReaderFrame implements reader.
+func (c *flvConn) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP {
+		c.buffer.push(trackID, streamType, payload, rtmpConnTrackIDPayloadPair{trackID, payload})
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (c *flvConn) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}{"flvConn", c.id}
+}
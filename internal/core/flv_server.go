@@ -0,0 +1,360 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"golang.org/x/net/websocket"
+
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type flvServerParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+type flvServerConnNewReq struct {
+	remoteAddr net.Addr
+	pathName   string
+	req        *http.Request
+	httpW      http.ResponseWriter
+	ws         *websocket.Conn
+	res        chan *flvConn
+}
+
+type flvServer struct {
+	flvAllowOrigin    string
+	writeTimeout      time.Duration
+	readBufferCount   int
+	slowReaderTimeout time.Duration
+	eventBus          *eventbus.Bus
+	pathManager       *pathManager
+	parent            flvServerParent
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	ln        net.Listener
+	conns     map[*flvConn]struct{}
+
+	// in
+	connNew         chan flvServerConnNewReq
+	connClose       chan *flvConn
+	apiFLVConnsList chan apiFLVConnsListReq
+	apiFLVConnsKick chan apiFLVConnsKickReq
+}
+
+func newFLVServer(
+	parentCtx context.Context,
+	address string,
+	flvAllowOrigin string,
+	writeTimeout time.Duration,
+	readBufferCount int,
+	slowReaderTimeout time.Duration,
+	eventBus *eventbus.Bus,
+	pathManager *pathManager,
+	parent flvServerParent,
+) (*flvServer, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &flvServer{
+		flvAllowOrigin:    flvAllowOrigin,
+		writeTimeout:      writeTimeout,
+		readBufferCount:   readBufferCount,
+		slowReaderTimeout: slowReaderTimeout,
+		eventBus:          eventBus,
+		pathManager:       pathManager,
+		parent:            parent,
+		ctx:               ctx,
+		ctxCancel:         ctxCancel,
+		ln:                ln,
+		conns:             make(map[*flvConn]struct{}),
+		connNew:           make(chan flvServerConnNewReq),
+		connClose:         make(chan *flvConn),
+		apiFLVConnsList:   make(chan apiFLVConnsListReq),
+		apiFLVConnsKick:   make(chan apiFLVConnsKickReq),
+	}
+
+	s.Log(logger.Info, "listener opened on "+address)
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Log is the main logging function.
+func (s *flvServer) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[FLV] "+format, append([]interface{}{}, args...)...)
+}
+
+func (s *flvServer) close() {
+	s.ctxCancel()
+	s.wg.Wait()
+	s.Log(logger.Info, "closed")
+}
+
+func (s *flvServer) run() {
+	defer s.wg.Done()
+
+	hs := &http.Server{Handler: s}
+	go hs.Serve(s.ln)
+
+outer:
+	for {
+		select {
+		case req := <-s.connNew:
+			id, _ := s.newConnID()
+
+			c := newFLVConn(
+				s.ctx,
+				id,
+				s.writeTimeout,
+				s.readBufferCount,
+				s.slowReaderTimeout,
+				&s.wg,
+				req.remoteAddr,
+				req.pathName,
+				req.req.URL.Query(),
+				req.httpW,
+				req.ws,
+				s.pathManager,
+				s)
+			s.conns[c] = struct{}{}
+
+			s.eventBus.Publish(eventbus.Event{
+				Type:       eventbus.TypeConnOpen,
+				Protocol:   "flv",
+				ID:         id,
+				RemoteAddr: req.remoteAddr.String(),
+			})
+
+			req.res <- c
+
+		case c := <-s.connClose:
+			if _, ok := s.conns[c]; !ok {
+				continue
+			}
+			delete(s.conns, c)
+
+			s.eventBus.Publish(eventbus.Event{
+				Type:       eventbus.TypeConnClose,
+				Protocol:   "flv",
+				ID:         c.ID(),
+				RemoteAddr: c.RemoteAddr().String(),
+			})
+
+		case req := <-s.apiFLVConnsList:
+			data := &apiFLVConnsListData{
+				Items: make(map[string]apiFLVConnsListItem),
+			}
+
+			for c := range s.conns {
+				data.Items[c.ID()] = apiFLVConnsListItem{
+					RemoteAddr: c.RemoteAddr().String(),
+					State: func() string {
+						if c.safeState() == gortsplib.ServerSessionStateRead {
+							return "read"
+						}
+						return "idle"
+					}(),
+					FramesDropped: c.framesDroppedCount(),
+				}
+			}
+
+			req.Res <- apiFLVConnsListRes{Data: data}
+
+		case req := <-s.apiFLVConnsKick:
+			res := func() bool {
+				for c := range s.conns {
+					if c.ID() == req.ID {
+						delete(s.conns, c)
+						c.Close()
+						return true
+					}
+				}
+				return false
+			}()
+			if res {
+				req.Res <- apiFLVConnsKickRes{}
+			} else {
+				req.Res <- apiFLVConnsKickRes{fmt.Errorf("not found")}
+			}
+
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+
+	hs.Shutdown(context.Background())
+}
+
+// ServeHTTP implements http.Handler.
+func (s *flvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Log(logger.Debug, "[conn %v] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+
+	w.Header().Add("Access-Control-Allow-Origin", s.flvAllowOrigin)
+	w.Header().Add("Access-Control-Allow-Credentials", "true")
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodOptions:
+		w.Header().Add("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Add("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.WriteHeader(http.StatusOK)
+		return
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// path is in the format /<path>.flv
+	if !strings.HasSuffix(r.URL.Path, ".flv") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	pathName := strings.TrimSuffix(strings.TrimLeft(r.URL.Path, "/"), ".flv")
+	if pathName == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		srv := websocket.Server{
+			// allow connections from any origin
+			Handshake: func(*websocket.Config, *http.Request) error {
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				ws.PayloadType = websocket.BinaryFrame
+
+				c := s.newConn(flvServerConnNewReq{
+					remoteAddr: remoteAddr,
+					pathName:   pathName,
+					req:        r,
+					ws:         ws,
+				})
+				if c == nil {
+					return
+				}
+
+				c.wait()
+			},
+		}
+
+		srv.ServeHTTP(w, r)
+		return
+	}
+
+	c := s.newConn(flvServerConnNewReq{
+		remoteAddr: remoteAddr,
+		pathName:   pathName,
+		req:        r,
+		httpW:      w,
+	})
+	if c == nil {
+		return
+	}
+
+	// the response can be used until the Conn is closed
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		c.Close()
+		c.wait()
+	}
+}
+
+func (s *flvServer) newConn(req flvServerConnNewReq) *flvConn {
+	req.res = make(chan *flvConn)
+	select {
+	case s.connNew <- req:
+		return <-req.res
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+func (s *flvServer) newConnID() (string, error) {
+	for {
+		b := make([]byte, 4)
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+
+		u := binary.LittleEndian.Uint32(b)
+		u %= 899999999
+		u += 100000000
+
+		id := strconv.FormatUint(uint64(u), 10)
+
+		alreadyPresent := func() bool {
+			for c := range s.conns {
+				if c.ID() == id {
+					return true
+				}
+			}
+			return false
+		}()
+		if !alreadyPresent {
+			return id, nil
+		}
+	}
+}
+
+// OnConnClose is called by flvConn.
+func (s *flvServer) OnConnClose(c *flvConn) {
+	select {
+	case s.connClose <- c:
+	case <-s.ctx.Done():
+	}
+}
+
+// OnAPIFLVConnsList is called by api.
+func (s *flvServer) OnAPIFLVConnsList(req apiFLVConnsListReq) apiFLVConnsListRes {
+	req.Res = make(chan apiFLVConnsListRes)
+	select {
+	case s.apiFLVConnsList <- req:
+		return <-req.Res
+	case <-s.ctx.Done():
+		return apiFLVConnsListRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
+// OnAPIFLVConnsKick is called by api.
+func (s *flvServer) OnAPIFLVConnsKick(req apiFLVConnsKickReq) apiFLVConnsKickRes {
+	req.Res = make(chan apiFLVConnsKickRes)
+	select {
+	case s.apiFLVConnsKick <- req:
+		return <-req.Res
+	case <-s.ctx.Done():
+		return apiFLVConnsKickRes{Err: fmt.Errorf("terminated")}
+	}
+}
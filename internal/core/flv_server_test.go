@@ -0,0 +1,116 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/notedit/rtmp/av"
+	"github.com/notedit/rtmp/format/flv"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestFLVServerRead(t *testing.T) {
+	for _, ca := range []string{
+		"http",
+		"websocket",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			p, ok := newInstance("rtmpDisable: yes\n" +
+				"hlsDisable: yes\n" +
+				"api: yes\n" +
+				"paths:\n" +
+				"  all:\n" +
+				"    readUser: testuser\n" +
+				"    readPass: testpass\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+			require.NoError(t, err)
+
+			source, err := gortsplib.DialPublish("rtsp://localhost:8554/mystream",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+			defer source.Close()
+
+			terminate := make(chan struct{})
+			writeDone := make(chan struct{})
+			defer func() { <-writeDone }()
+			defer close(terminate)
+
+			go func() {
+				defer close(writeDone)
+
+				for i := 0; ; i++ {
+					select {
+					case <-time.After(100 * time.Millisecond):
+					case <-terminate:
+						return
+					}
+
+					// single IDR NALU, with the marker bit
+					source.WriteFrame(0, gortsplib.StreamTypeRTP,
+						[]byte{0x80, 0xe0, 0x00, byte(i), 0x00, 0x00, 0x00, byte(i), 0x00, 0x00, 0x00, 0x01, 0x65, 0x01})
+				}
+			}()
+
+			var r io.Reader
+
+			if ca == "http" {
+				res, err := http.Get("http://localhost:8889/mystream.flv")
+				require.NoError(t, err)
+				res.Body.Close()
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+				res, err = http.Get("http://localhost:8889/mystream.flv?user=testuser&pass=testpass")
+				require.NoError(t, err)
+				defer res.Body.Close()
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.Equal(t, "video/x-flv", res.Header.Get("Content-Type"))
+				r = res.Body
+			} else {
+				ws, err := websocket.Dial("ws://localhost:8889/mystream.flv?user=testuser&pass=testpass",
+					"", "http://localhost/")
+				require.NoError(t, err)
+				defer ws.Close()
+				r = ws
+			}
+
+			dem := flv.NewDemuxer(r)
+
+			pkt, err := dem.ReadPacket()
+			require.NoError(t, err)
+			require.Equal(t, av.Metadata, pkt.Type)
+
+			pkt, err = dem.ReadPacket()
+			require.NoError(t, err)
+			require.Equal(t, av.H264DecoderConfig, pkt.Type)
+
+			pkt, err = dem.ReadPacket()
+			require.NoError(t, err)
+			require.Equal(t, av.H264, pkt.Type)
+			require.Equal(t, true, pkt.IsKeyFrame)
+			require.Equal(t, []byte{0x00, 0x00, 0x00, 0x02, 0x65, 0x01}, pkt.Data)
+
+			var out struct {
+				Items map[string]struct {
+					State string `json:"state"`
+				} `json:"items"`
+			}
+			err = httpRequest(http.MethodGet, "http://localhost:9997/v1/flvconns/list", nil, &out)
+			require.NoError(t, err)
+
+			var firstID string
+			for id := range out.Items {
+				firstID = id
+			}
+
+			require.Equal(t, 1, len(out.Items))
+			require.Equal(t, "read", out.Items[firstID].State)
+		})
+	}
+}
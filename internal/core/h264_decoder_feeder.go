@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+// h264DecoderFeeder reads the RTP packets of a H264 track from a readerBuffer,
+// and passes them to a H264Decoder, grouped into access units.
+type h264DecoderFeeder struct {
+	h264Conf      *gortsplib.TrackConfigH264
+	decoder       *h264.H264Decoder
+	keyframesOnly bool
+	log           func(logger.Level, string, ...interface{})
+}
+
+// run feeds the decoder until the buffer is closed or the decoder goes out of service.
+func (f *h264DecoderFeeder) run(buffer *readerBuffer) error {
+	rtpDecoder := rtph264.NewDecoder()
+	var videoBuf [][]byte
+
+	// decoding can start only from a IDR, and must restart from a IDR
+	// when an access unit is discarded.
+	waitIDR := true
+
+	for {
+		data, err := buffer.pull()
+		if err != nil {
+			return err
+		}
+
+		var pkt rtp.Packet
+		err = pkt.Unmarshal(data.([]byte))
+		if err != nil {
+			f.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			continue
+		}
+
+		nalus, _, err := rtpDecoder.DecodeRTP(&pkt)
+		if err != nil {
+			if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+				f.log(logger.Warn, "unable to decode video track: %v", err)
+			}
+			continue
+		}
+
+		videoBuf = append(videoBuf, nalus...)
+
+		if !pkt.Marker {
+			continue
+		}
+
+		au := videoBuf
+		videoBuf = nil
+
+		idrPresent := false
+		for _, nalu := range au {
+			if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeIDR {
+				idrPresent = true
+				break
+			}
+		}
+
+		if !idrPresent && (waitIDR || f.keyframesOnly) {
+			continue
+		}
+
+		if !f.decoder.InService() {
+			return fmt.Errorf("the decoder is out of service")
+		}
+
+		if idrPresent {
+			// parameters are sent with every IDR, since
+			// they are needed to initialize the decoder.
+			au = append([][]byte{f.h264Conf.SPS, f.h264Conf.PPS}, au...)
+		}
+
+		enc, err := h264.EncodeAnnexB(au)
+		if err != nil {
+			return err
+		}
+
+		waitIDR = !f.decoder.TryGatherData(enc)
+	}
+}
@@ -13,11 +13,11 @@ import (
 	"time"
 
 	"github.com/aler9/gortsplib"
-	"github.com/aler9/gortsplib/pkg/ringbuffer"
 	"github.com/aler9/gortsplib/pkg/rtpaac"
 	"github.com/aler9/gortsplib/pkg/rtph264"
 	"github.com/pion/rtp"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/hls"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
@@ -102,18 +102,30 @@ type hlsMuxerRequest struct {
 	Res  chan io.Reader
 }
 
+type hlsMuxerVariantRes struct {
+	Muxer *hls.Muxer
+	Err   error
+}
+
+type hlsMuxerVariantReq struct {
+	PathName string
+	Res      chan hlsMuxerVariantRes
+}
+
 type hlsMuxerTrackIDPayloadPair struct {
 	trackID int
 	buf     []byte
 }
 
 type hlsMuxerPathManager interface {
+	OnConfGet(req pathManagerConfGetReq) pathManagerConfGetRes
 	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
 }
 
 type hlsMuxerParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnMuxerClose(*hlsMuxer)
+	OnMuxerVariant(req hlsMuxerVariantReq) hlsMuxerVariantRes
 }
 
 type hlsMuxer struct {
@@ -121,6 +133,7 @@ type hlsMuxer struct {
 	hlsSegmentCount    int
 	hlsSegmentDuration time.Duration
 	readBufferCount    int
+	slowReaderTimeout  time.Duration
 	wg                 *sync.WaitGroup
 	pathName           string
 	pathManager        hlsMuxerPathManager
@@ -128,14 +141,18 @@ type hlsMuxer struct {
 
 	ctx             context.Context
 	ctxCancel       func()
+	conf            *conf.PathConf
 	path            *path
-	ringBuffer      *ringbuffer.RingBuffer
+	buffer          *readerBuffer
+	framesDropped   *uint64
 	lastRequestTime *int64
 	muxer           *hls.Muxer
 	requests        []hlsMuxerRequest
+	variantRequests []hlsMuxerVariantReq
 
 	// in
-	request chan hlsMuxerRequest
+	request    chan hlsMuxerRequest
+	variantReq chan hlsMuxerVariantReq
 }
 
 func newHLSMuxer(
@@ -144,6 +161,7 @@ func newHLSMuxer(
 	hlsSegmentCount int,
 	hlsSegmentDuration time.Duration,
 	readBufferCount int,
+	slowReaderTimeout time.Duration,
 	wg *sync.WaitGroup,
 	pathName string,
 	pathManager hlsMuxerPathManager,
@@ -155,17 +173,23 @@ func newHLSMuxer(
 		hlsSegmentCount:    hlsSegmentCount,
 		hlsSegmentDuration: hlsSegmentDuration,
 		readBufferCount:    readBufferCount,
+		slowReaderTimeout:  slowReaderTimeout,
 		wg:                 wg,
 		pathName:           pathName,
 		pathManager:        pathManager,
 		parent:             parent,
 		ctx:                ctx,
 		ctxCancel:          ctxCancel,
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
 		lastRequestTime: func() *int64 {
 			v := time.Now().Unix()
 			return &v
 		}(),
-		request: make(chan hlsMuxerRequest),
+		request:    make(chan hlsMuxerRequest),
+		variantReq: make(chan hlsMuxerVariantReq),
 	}
 
 	r.log(logger.Info, "created")
@@ -217,12 +241,23 @@ outer:
 				r.requests = append(r.requests, req)
 			}
 
+		case req := <-r.variantReq:
+			if isReady {
+				r.handleVariantRequest(req)
+			} else {
+				r.variantRequests = append(r.variantRequests, req)
+			}
+
 		case <-innerReady:
 			isReady = true
 			for _, req := range r.requests {
 				r.handleRequest(req)
 			}
 			r.requests = nil
+			for _, req := range r.variantRequests {
+				r.handleVariantRequest(req)
+			}
+			r.variantRequests = nil
 
 		case err := <-innerErr:
 			innerCtxCancel()
@@ -240,10 +275,27 @@ outer:
 		req.Res <- nil
 	}
 
+	for _, req := range r.variantRequests {
+		req.Res <- hlsMuxerVariantRes{Err: fmt.Errorf("terminated")}
+	}
+
 	r.parent.OnMuxerClose(r)
 }
 
 func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{}) error {
+	confRes := r.pathManager.OnConfGet(pathManagerConfGetReq{PathName: r.pathName})
+	if confRes.Err != nil {
+		return confRes.Err
+	}
+
+	r.conf = confRes.Conf
+
+	// the path is composed of other paths, that are muxed separately
+	if len(r.conf.HLSVariants) != 0 {
+		innerReady <- struct{}{}
+		return r.runComposite(innerCtx)
+	}
+
 	res := r.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
 		Author:              r,
 		PathName:            r.pathName,
@@ -255,6 +307,7 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 	}
 
 	r.path = res.Path
+	r.conf = r.path.Conf()
 
 	defer func() {
 		r.path.OnReaderRemove(pathReaderRemoveReq{Author: r})
@@ -326,15 +379,26 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 		videoTrack,
 		audioTrack,
 		r.pathName,
+		r.variants(),
 	)
 	if err != nil {
 		return err
 	}
 	defer r.muxer.Close()
 
+	if r.conf.HLSDVRDuration != 0 {
+		err := r.muxer.EnableDVR(r.conf.HLSDVRDuration, r.conf.HLSDVRDirectory)
+		if err != nil {
+			return err
+		}
+	}
+
 	innerReady <- struct{}{}
 
-	r.ringBuffer = ringbuffer.New(uint64(r.readBufferCount))
+	r.buffer = newReaderBuffer(r.readBufferCount, videoTrackID, r.slowReaderTimeout, r.framesDropped)
+
+	// the first segment can be filled with the frames of the GOP cache
+	r.muxer.Prime(res.Stream.gopCacheDuration())
 
 	r.path.OnReaderPlay(pathReaderPlayReq{Author: r})
 
@@ -344,9 +408,9 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 			var videoBuf [][]byte
 
 			for {
-				data, ok := r.ringBuffer.Pull()
-				if !ok {
-					return fmt.Errorf("terminated")
+				data, err := r.buffer.pull()
+				if err != nil {
+					return err
 				}
 				pair := data.(hlsMuxerTrackIDPayloadPair)
 
@@ -371,6 +435,10 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 					// RTP marker means that all the NALUs with the same PTS have been received.
 					// send them together.
 					if pkt.Marker {
+						if ntp, ok := res.Stream.ntp(pair.trackID, pkt.Timestamp); ok {
+							r.muxer.SetNTP(pts, ntp)
+						}
+
 						err := r.muxer.WriteH264(pts, videoBuf)
 						if err != nil {
 							return err
@@ -395,6 +463,12 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 						continue
 					}
 
+					if videoTrack == nil {
+						if ntp, ok := res.Stream.ntp(pair.trackID, pkt.Timestamp); ok {
+							r.muxer.SetNTP(pts, ntp)
+						}
+					}
+
 					err = r.muxer.WriteAAC(pts, aus)
 					if err != nil {
 						return err
@@ -412,7 +486,7 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 		case <-closeCheckTicker.C:
 			t := time.Unix(atomic.LoadInt64(r.lastRequestTime), 0)
 			if !r.hlsAlwaysRemux && time.Since(t) >= closeAfterInactivity {
-				r.ringBuffer.Close()
+				r.buffer.close()
 				<-writerDone
 				return nil
 			}
@@ -421,17 +495,49 @@ func (r *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 			return err
 
 		case <-innerCtx.Done():
-			r.ringBuffer.Close()
+			r.buffer.close()
 			<-writerDone
 			return nil
 		}
 	}
 }
 
+func (r *hlsMuxer) runComposite(innerCtx context.Context) error {
+	closeCheckTicker := time.NewTicker(closeCheckPeriod)
+	defer closeCheckTicker.Stop()
+
+	for {
+		select {
+		case <-closeCheckTicker.C:
+			t := time.Unix(atomic.LoadInt64(r.lastRequestTime), 0)
+			if time.Since(t) >= closeAfterInactivity {
+				return nil
+			}
+
+		case <-innerCtx.Done():
+			return nil
+		}
+	}
+}
+
+// variants returns the renditions produced by the transcoding profiles of the path.
+func (r *hlsMuxer) variants() []hls.Variant {
+	var ret []hls.Variant
+	for _, profile := range r.path.Conf().Transcode {
+		ret = append(ret, hls.Variant{
+			URI:       profile.Name + "/stream.m3u8",
+			Bandwidth: profile.BitrateParsed,
+			Width:     profile.Width,
+			Height:    profile.Height,
+		})
+	}
+	return ret
+}
+
 func (r *hlsMuxer) handleRequest(req hlsMuxerRequest) {
 	atomic.StoreInt64(r.lastRequestTime, time.Now().Unix())
 
-	conf := r.path.Conf()
+	conf := r.conf
 
 	if conf.ReadIPsParsed != nil {
 		tmp, _, _ := net.SplitHostPort(req.Req.RemoteAddr)
@@ -455,6 +561,14 @@ func (r *hlsMuxer) handleRequest(req hlsMuxerRequest) {
 	}
 
 	switch {
+	case r.muxer == nil && req.File == "index.m3u8":
+		// variants may need some time to produce their first segment
+		go r.writeMultivariantPlaylist(req)
+
+	case r.muxer == nil && req.File != "":
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+
 	case req.File == "index.m3u8":
 		req.W.Header().Set("Content-Type", `application/x-mpegURL`)
 		req.Res <- r.muxer.PrimaryPlaylist()
@@ -483,6 +597,69 @@ func (r *hlsMuxer) handleRequest(req hlsMuxerRequest) {
 	}
 }
 
+// writeMultivariantPlaylist writes a playlist that lists the variants of the path.
+func (r *hlsMuxer) writeMultivariantPlaylist(req hlsMuxerRequest) {
+	// variants are served by their own muxers
+	prefix := strings.Repeat("../", strings.Count(r.pathName, "/")+1)
+
+	variants := make([]*hls.Variant, len(r.conf.HLSVariants))
+	var wg sync.WaitGroup
+
+	for i, pathName := range r.conf.HLSVariants {
+		wg.Add(1)
+		go func(i int, pathName string) {
+			defer wg.Done()
+
+			res := r.parent.OnMuxerVariant(hlsMuxerVariantReq{PathName: pathName})
+			if res.Err != nil {
+				r.log(logger.Warn, "unable to use variant '%s': %s", pathName, res.Err)
+				return
+			}
+
+			v := res.Muxer.Variant(prefix + pathName + "/stream.m3u8")
+			variants[i] = &v
+		}(i, pathName)
+	}
+
+	wg.Wait()
+
+	var available []hls.Variant
+	for _, v := range variants {
+		if v != nil {
+			available = append(available, *v)
+		}
+	}
+
+	if len(available) == 0 {
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+		return
+	}
+
+	req.W.Header().Set("Content-Type", `application/x-mpegURL`)
+	req.Res <- hls.MultivariantPlaylist(available)
+}
+
+func (r *hlsMuxer) handleVariantRequest(req hlsMuxerVariantReq) {
+	atomic.StoreInt64(r.lastRequestTime, time.Now().Unix())
+
+	if r.muxer == nil {
+		req.Res <- hlsMuxerVariantRes{Err: fmt.Errorf("path is composed of variants")}
+		return
+	}
+
+	req.Res <- hlsMuxerVariantRes{Muxer: r.muxer}
+}
+
+// OnVariant is called by hlsServer.
+func (r *hlsMuxer) OnVariant(req hlsMuxerVariantReq) {
+	select {
+	case r.variantReq <- req:
+	case <-r.ctx.Done():
+		req.Res <- hlsMuxerVariantRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnRequest is called by hlsserver.Server (forwarded from ServeHTTP).
 func (r *hlsMuxer) OnRequest(req hlsMuxerRequest) {
 	select {
@@ -501,7 +678,7 @@ func (r *hlsMuxer) OnReaderAccepted() {
 // OnReaderFrame implements reader.
 func (r *hlsMuxer) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
 	if streamType == gortsplib.StreamTypeRTP {
-		r.ringBuffer.Push(hlsMuxerTrackIDPayloadPair{trackID, payload})
+		r.buffer.push(trackID, streamType, payload, hlsMuxerTrackIDPayloadPair{trackID, payload})
 	}
 }
 
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -22,20 +24,28 @@ type hlsServer struct {
 	hlsSegmentCount    int
 	hlsSegmentDuration time.Duration
 	hlsAllowOrigin     string
+	mjpegFPS           int
+	mjpegKeyframesOnly bool
 	readBufferCount    int
+	slowReaderTimeout  time.Duration
+	clusterDir         *clusterDirectory
 	pathManager        *pathManager
 	parent             hlsServerParent
 
-	ctx       context.Context
-	ctxCancel func()
-	wg        sync.WaitGroup
-	ln        net.Listener
-	muxers    map[string]*hlsMuxer
+	ctx         context.Context
+	ctxCancel   func()
+	wg          sync.WaitGroup
+	ln          net.Listener
+	muxers      map[string]*hlsMuxer
+	mjpegMuxers map[string]*mjpegMuxer
 
 	// in
 	pathSourceReady chan *path
 	request         chan hlsMuxerRequest
 	muxerClose      chan *hlsMuxer
+	muxerVariant    chan hlsMuxerVariantReq
+	mjpegRequest    chan mjpegMuxerRequest
+	mjpegMuxerClose chan *mjpegMuxer
 }
 
 func newHLSServer(
@@ -45,7 +55,11 @@ func newHLSServer(
 	hlsSegmentCount int,
 	hlsSegmentDuration time.Duration,
 	hlsAllowOrigin string,
+	mjpegFPS int,
+	mjpegKeyframesOnly bool,
 	readBufferCount int,
+	slowReaderTimeout time.Duration,
+	clusterDir *clusterDirectory,
 	pathManager *pathManager,
 	parent hlsServerParent,
 ) (*hlsServer, error) {
@@ -61,16 +75,24 @@ func newHLSServer(
 		hlsSegmentCount:    hlsSegmentCount,
 		hlsSegmentDuration: hlsSegmentDuration,
 		hlsAllowOrigin:     hlsAllowOrigin,
+		mjpegFPS:           mjpegFPS,
+		mjpegKeyframesOnly: mjpegKeyframesOnly,
 		readBufferCount:    readBufferCount,
+		slowReaderTimeout:  slowReaderTimeout,
+		clusterDir:         clusterDir,
 		pathManager:        pathManager,
 		parent:             parent,
 		ctx:                ctx,
 		ctxCancel:          ctxCancel,
 		ln:                 ln,
 		muxers:             make(map[string]*hlsMuxer),
+		mjpegMuxers:        make(map[string]*mjpegMuxer),
 		pathSourceReady:    make(chan *path),
 		request:            make(chan hlsMuxerRequest),
 		muxerClose:         make(chan *hlsMuxer),
+		muxerVariant:       make(chan hlsMuxerVariantReq),
+		mjpegRequest:       make(chan mjpegMuxerRequest),
+		mjpegMuxerClose:    make(chan *mjpegMuxer),
 	}
 
 	s.Log(logger.Info, "listener opened on "+address)
@@ -112,12 +134,39 @@ outer:
 			r := s.findOrCreateMuxer(req.Dir)
 			r.OnRequest(req)
 
+		case req := <-s.muxerVariant:
+			r := s.findOrCreateMuxer(req.PathName)
+			r.OnVariant(req)
+
 		case c := <-s.muxerClose:
 			if c2, ok := s.muxers[c.PathName()]; !ok || c2 != c {
 				continue
 			}
 			delete(s.muxers, c.PathName())
 
+		case req := <-s.mjpegRequest:
+			r, ok := s.mjpegMuxers[req.PathName]
+			if !ok {
+				r = newMJPEGMuxer(
+					s.ctx,
+					s.mjpegFPS,
+					s.mjpegKeyframesOnly,
+					s.readBufferCount,
+					s.slowReaderTimeout,
+					&s.wg,
+					req.PathName,
+					s.pathManager,
+					s)
+				s.mjpegMuxers[req.PathName] = r
+			}
+			r.OnRequest(req)
+
+		case c := <-s.mjpegMuxerClose:
+			if c2, ok := s.mjpegMuxers[c.PathName()]; !ok || c2 != c {
+				continue
+			}
+			delete(s.mjpegMuxers, c.PathName())
+
 		case <-s.ctx.Done():
 			break outer
 		}
@@ -168,6 +217,12 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// MJPEG streams are in the format /<path>/mjpeg
+	if strings.HasSuffix(pa, "/mjpeg") {
+		s.serveMJPEG(w, r, strings.TrimSuffix(pa, "/mjpeg"))
+		return
+	}
+
 	dir, fname := func() (string, string) {
 		if strings.HasSuffix(pa, ".ts") || strings.HasSuffix(pa, ".m3u8") {
 			return gopath.Dir(pa), gopath.Base(pa)
@@ -183,6 +238,18 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	dir = strings.TrimSuffix(dir, "/")
 
+	// redirect to the node of the cluster that is publishing the path.
+	// segments are not looked up, since playlists of other nodes
+	// are redirected before.
+	if s.clusterDir != nil && s.clusterDir.mode == conf.ClusterModeRedirect &&
+		!strings.HasSuffix(fname, ".ts") {
+		if node := s.clusterDir.lookup(dir); node != nil && node.HLSAddress != "" {
+			w.Header().Add("Location", strings.TrimSuffix(node.HLSAddress, "/")+"/"+dir+"/"+fname)
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+	}
+
 	cres := make(chan io.Reader)
 	hreq := hlsMuxerRequest{
 		Dir:  dir,
@@ -217,6 +284,25 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *hlsServer) serveMJPEG(w http.ResponseWriter, r *http.Request, pathName string) {
+	req := mjpegMuxerRequest{
+		PathName: pathName,
+		Req:      r,
+		W:        w,
+		Res:      make(chan *mjpegMuxerViewer),
+	}
+
+	select {
+	case s.mjpegRequest <- req:
+		v := <-req.Res
+		if v != nil {
+			v.serve(w, r)
+		}
+
+	case <-s.ctx.Done():
+	}
+}
+
 func (s *hlsServer) findOrCreateMuxer(pathName string) *hlsMuxer {
 	r, ok := s.muxers[pathName]
 	if !ok {
@@ -226,6 +312,7 @@ func (s *hlsServer) findOrCreateMuxer(pathName string) *hlsMuxer {
 			s.hlsSegmentCount,
 			s.hlsSegmentDuration,
 			s.readBufferCount,
+			s.slowReaderTimeout,
 			&s.wg,
 			pathName,
 			s.pathManager,
@@ -243,6 +330,26 @@ func (s *hlsServer) OnMuxerClose(c *hlsMuxer) {
 	}
 }
 
+// OnMJPEGMuxerClose is called by mjpegMuxer.
+func (s *hlsServer) OnMJPEGMuxerClose(c *mjpegMuxer) {
+	select {
+	case s.mjpegMuxerClose <- c:
+	case <-s.ctx.Done():
+	}
+}
+
+// OnMuxerVariant is called by hlsMuxer.
+func (s *hlsServer) OnMuxerVariant(req hlsMuxerVariantReq) hlsMuxerVariantRes {
+	req.Res = make(chan hlsMuxerVariantRes)
+	select {
+	case s.muxerVariant <- req:
+		return <-req.Res
+
+	case <-s.ctx.Done():
+		return hlsMuxerVariantRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnPathSourceReady is called by core.
 func (s *hlsServer) OnPathSourceReady(pa *path) {
 	select {
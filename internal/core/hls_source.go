@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	hlsSourceRetryPause = 5 * time.Second
+)
+
+// hlsSourceIsHLS checks whether a source is a HLS stream.
+func hlsSourceIsHLS(ur string) bool {
+	if !strings.HasPrefix(ur, "http://") && !strings.HasPrefix(ur, "https://") {
+		return false
+	}
+
+	u, err := url.Parse(ur)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasSuffix(strings.ToLower(u.Path), ".m3u8")
+}
+
+type hlsSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+// hlsSource is a static source that reads a HLS stream.
+type hlsSource struct {
+	ur          string
+	readTimeout time.Duration
+	wg          *sync.WaitGroup
+	parent      hlsSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newHLSSource(
+	parentCtx context.Context,
+	ur string,
+	readTimeout time.Duration,
+	wg *sync.WaitGroup,
+	parent hlsSourceParent) *hlsSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &hlsSource{
+		ur:          ur,
+		readTimeout: readTimeout,
+		wg:          wg,
+		parent:      parent,
+		ctx:         ctx,
+		ctxCancel:   ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *hlsSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *hlsSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[hls source] "+format, args...)
+}
+
+func (s *hlsSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(hlsSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *hlsSource) runInner() bool {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- func() error {
+			s.log(logger.Debug, "connecting")
+
+			c, err := hls.NewClient(s.ur, s.readTimeout)
+			if err != nil {
+				return err
+			}
+
+			// segments are downloaded by the client and demuxed as a single stream.
+			// Since segments are downloaded faster than real time, frames are paced.
+			pr, pw := io.Pipe()
+
+			clientDone := make(chan struct{})
+			go func() {
+				defer close(clientDone)
+				pw.CloseWithError(c.Run(innerCtx, pw))
+			}()
+
+			readDone := make(chan error)
+			go func() {
+				readDone <- mpegtsSourceReadStream(innerCtx, pr, true, s, s.parent, s.log)
+			}()
+
+			select {
+			case err := <-readDone:
+				innerCtxCancel()
+				pr.CloseWithError(err)
+				<-clientDone
+				return err
+
+			case <-innerCtx.Done():
+				pr.Close()
+				<-readDone
+				<-clientDone
+				return nil
+			}
+		}()
+	}()
+
+	select {
+	case err := <-runErr:
+		innerCtxCancel()
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+
+	case <-s.ctx.Done():
+		innerCtxCancel()
+		<-runErr
+		return false
+	}
+}
+
+// OnSourceAPIDescribe implements source.
+func (*hlsSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"hlsSource"}
+}
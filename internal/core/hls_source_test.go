@@ -0,0 +1,80 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLSSource(t *testing.T) {
+	// 3 segments of 10 frames each, with a distance of 100ms between frames
+	var segments [][]byte
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		mux := newTestTSMuxer(&buf)
+		for j := 0; j < 10; j++ {
+			writeTestTSFrame(t, mux, i*10+j)
+		}
+		segments = append(segments, buf.Bytes())
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream.m3u8":
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-VERSION:3\n" +
+				"#EXT-X-TARGETDURATION:1\n" +
+				"#EXT-X-MEDIA-SEQUENCE:0\n" +
+				"#EXTINF:1,\n0.ts\n" +
+				"#EXTINF:1,\n1.ts\n" +
+				"#EXTINF:1,\n2.ts\n" +
+				"#EXT-X-ENDLIST\n"))
+
+		case "/0.ts", "/1.ts", "/2.ts":
+			var i int
+			fmt.Sscanf(r.URL.Path, "/%d.ts", &i)
+			w.Write(segments[i])
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  proxied:\n" +
+		"    source: " + s.URL + "/stream.m3u8\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, recv := readTestFrames(t, "proxied")
+	defer conn.Close()
+
+	var first testReceivedFrame
+	select {
+	case first = <-recv:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	var last testReceivedFrame
+	for i := 0; i < 5; i++ {
+		select {
+		case last = <-recv:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+		}
+	}
+
+	// frames are routed with the speed given by their timestamps
+	require.Equal(t, first.index+5, last.index)
+	require.InDelta(t, 0.5, last.t.Sub(first.t).Seconds(), 0.1)
+}
@@ -43,6 +43,7 @@ type metrics struct {
 	rtspServer  metricsRTSPServer
 	rtspsServer metricsRTSPServer
 	rtmpServer  metricsRTMPServer
+	rtmpsServer metricsRTMPServer
 }
 
 func newMetrics(
@@ -105,6 +106,17 @@ func (m *metrics) onMetrics(w http.ResponseWriter, req *http.Request) {
 			readyCount, nowUnix)
 		out += formatMetric("paths{state=\"notReady\"}",
 			notReadyCount, nowUnix)
+
+		for name, p := range res.Data.Items {
+			if p.Conf.MotionDetection && p.SourceReady {
+				v := int64(0)
+				if p.Motion {
+					v = 1
+				}
+				out += formatMetric("paths_motion{name=\""+name+"\"}",
+					v, nowUnix)
+			}
+		}
 	}
 
 	if !interfaceIsEmpty(m.rtspServer) {
@@ -131,6 +143,15 @@ func (m *metrics) onMetrics(w http.ResponseWriter, req *http.Request) {
 				readCount, nowUnix)
 			out += formatMetric("rtsp_sessions{state=\"publish\"}",
 				publishCount, nowUnix)
+
+			framesDropped := int64(0)
+			for _, i := range res.Data.Items {
+				if i.State == "read" {
+					framesDropped += int64(i.FramesDropped)
+				}
+			}
+			out += formatMetric("rtsp_sessions_frames_dropped",
+				framesDropped, nowUnix)
 		}
 	}
 
@@ -158,6 +179,15 @@ func (m *metrics) onMetrics(w http.ResponseWriter, req *http.Request) {
 				readCount, nowUnix)
 			out += formatMetric("rtsps_sessions{state=\"publish\"}",
 				publishCount, nowUnix)
+
+			framesDropped := int64(0)
+			for _, i := range res.Data.Items {
+				if i.State == "read" {
+					framesDropped += int64(i.FramesDropped)
+				}
+			}
+			out += formatMetric("rtsps_sessions_frames_dropped",
+				framesDropped, nowUnix)
 		}
 	}
 
@@ -185,6 +215,51 @@ func (m *metrics) onMetrics(w http.ResponseWriter, req *http.Request) {
 				readCount, nowUnix)
 			out += formatMetric("rtmp_conns{state=\"publish\"}",
 				publishCount, nowUnix)
+
+			framesDropped := int64(0)
+			for _, i := range res.Data.Items {
+				if i.State == "read" {
+					framesDropped += int64(i.FramesDropped)
+				}
+			}
+			out += formatMetric("rtmp_conns_frames_dropped",
+				framesDropped, nowUnix)
+		}
+	}
+
+	if !interfaceIsEmpty(m.rtmpsServer) {
+		res := m.rtmpsServer.OnAPIRTMPConnsList(apiRTMPConnsListReq{})
+		if res.Err == nil {
+			idleCount := int64(0)
+			readCount := int64(0)
+			publishCount := int64(0)
+
+			for _, i := range res.Data.Items {
+				switch i.State {
+				case "idle":
+					idleCount++
+				case "read":
+					readCount++
+				case "publish":
+					publishCount++
+				}
+			}
+
+			out += formatMetric("rtmps_conns{state=\"idle\"}",
+				idleCount, nowUnix)
+			out += formatMetric("rtmps_conns{state=\"read\"}",
+				readCount, nowUnix)
+			out += formatMetric("rtmps_conns{state=\"publish\"}",
+				publishCount, nowUnix)
+
+			framesDropped := int64(0)
+			for _, i := range res.Data.Items {
+				if i.State == "read" {
+					framesDropped += int64(i.FramesDropped)
+				}
+			}
+			out += formatMetric("rtmps_conns_frames_dropped",
+				framesDropped, nowUnix)
 		}
 	}
 
@@ -213,9 +288,16 @@ func (m *metrics) OnRTSPSServerSet(s metricsRTSPServer) {
 	m.rtspsServer = s
 }
 
-// OnRTMPServerSet is called by rtmpServer.
+// OnRTMPServerSet is called by rtmpServer (plain).
 func (m *metrics) OnRTMPServerSet(s metricsRTMPServer) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.rtmpServer = s
 }
+
+// OnRTMPSServerSet is called by rtmpServer (tls).
+func (m *metrics) OnRTMPSServerSet(s metricsRTMPServer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rtmpsServer = s
+}
@@ -0,0 +1,428 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	// decoding is expensive, therefore muxers are closed shortly after the last viewer leaves.
+	mjpegMuxerCloseAfterInactivity = 10 * time.Second
+
+	mjpegMuxerBoundary = "frame"
+)
+
+type mjpegMuxerRequest struct {
+	PathName string
+	Req      *http.Request
+	W        http.ResponseWriter
+	Res      chan *mjpegMuxerViewer
+}
+
+// mjpegMuxerViewer is a HTTP client that is receiving the pictures of a mjpegMuxer.
+type mjpegMuxerViewer struct {
+	muxer  *mjpegMuxer
+	frames chan []byte
+}
+
+// serve writes pictures as parts of a multipart response, until the client disconnects
+// or the muxer is closed.
+func (v *mjpegMuxerViewer) serve(w http.ResponseWriter, r *http.Request) {
+	defer v.muxer.OnViewerClose(v)
+
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(mjpegMuxerBoundary)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegMuxerBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case frame, ok := <-v.frames:
+			if !ok {
+				return
+			}
+
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":   []string{"image/jpeg"},
+				"Content-Length": []string{strconv.FormatInt(int64(len(frame)), 10)},
+			})
+			if err != nil {
+				return
+			}
+
+			_, err = part.Write(frame)
+			if err != nil {
+				return
+			}
+
+			w.(http.Flusher).Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+type mjpegMuxerPathManager interface {
+	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+}
+
+type mjpegMuxerParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnMJPEGMuxerClose(*mjpegMuxer)
+}
+
+// mjpegMuxer decodes the H264 track of a path into JPEG pictures,
+// that are shared between all the viewers of the path.
+type mjpegMuxer struct {
+	mjpegFPS           int
+	mjpegKeyframesOnly bool
+	readBufferCount    int
+	slowReaderTimeout  time.Duration
+	wg                 *sync.WaitGroup
+	pathName           string
+	pathManager        mjpegMuxerPathManager
+	parent             mjpegMuxerParent
+
+	ctx           context.Context
+	ctxCancel     func()
+	conf          *conf.PathConf
+	path          *path
+	videoTrackID  int
+	buffer        *readerBuffer
+	framesDropped *uint64
+	viewers       map[*mjpegMuxerViewer]struct{}
+	requests      []mjpegMuxerRequest
+	lastFrame     []byte
+	lastFrameNew  bool
+	lastFrameMu   sync.Mutex
+
+	// in
+	request     chan mjpegMuxerRequest
+	viewerClose chan *mjpegMuxerViewer
+}
+
+func newMJPEGMuxer(
+	parentCtx context.Context,
+	mjpegFPS int,
+	mjpegKeyframesOnly bool,
+	readBufferCount int,
+	slowReaderTimeout time.Duration,
+	wg *sync.WaitGroup,
+	pathName string,
+	pathManager mjpegMuxerPathManager,
+	parent mjpegMuxerParent) *mjpegMuxer {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	r := &mjpegMuxer{
+		mjpegFPS:           mjpegFPS,
+		mjpegKeyframesOnly: mjpegKeyframesOnly,
+		readBufferCount:    readBufferCount,
+		slowReaderTimeout:  slowReaderTimeout,
+		wg:                 wg,
+		pathName:           pathName,
+		pathManager:        pathManager,
+		parent:             parent,
+		ctx:                ctx,
+		ctxCancel:          ctxCancel,
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
+		viewers:     make(map[*mjpegMuxerViewer]struct{}),
+		request:     make(chan mjpegMuxerRequest),
+		viewerClose: make(chan *mjpegMuxerViewer),
+	}
+
+	r.log(logger.Info, "created")
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r
+}
+
+func (r *mjpegMuxer) Close() {
+	r.ctxCancel()
+}
+
+func (r *mjpegMuxer) log(level logger.Level, format string, args ...interface{}) {
+	r.parent.Log(level, "[mjpeg muxer %s] "+format, append([]interface{}{r.pathName}, args...)...)
+}
+
+// PathName returns the path name.
+func (r *mjpegMuxer) PathName() string {
+	return r.pathName
+}
+
+func (r *mjpegMuxer) run() {
+	defer r.wg.Done()
+	defer r.log(logger.Info, "destroyed")
+
+	innerCtx, innerCtxCancel := context.WithCancel(context.Background())
+	innerReady := make(chan struct{})
+	innerErr := make(chan error)
+	go func() {
+		innerErr <- r.runInner(innerCtx, innerReady)
+	}()
+
+	isReady := false
+	lastViewerTime := time.Now()
+
+	frameTicker := time.NewTicker(time.Second / time.Duration(r.mjpegFPS))
+	defer frameTicker.Stop()
+
+	closeCheckTicker := time.NewTicker(closeCheckPeriod)
+	defer closeCheckTicker.Stop()
+
+outer:
+	for {
+		select {
+		case <-r.ctx.Done():
+			innerCtxCancel()
+			<-innerErr
+			break outer
+
+		case req := <-r.request:
+			if isReady {
+				r.handleRequest(req)
+			} else {
+				r.requests = append(r.requests, req)
+			}
+
+		case v := <-r.viewerClose:
+			if _, ok := r.viewers[v]; !ok {
+				continue
+			}
+			delete(r.viewers, v)
+			lastViewerTime = time.Now()
+
+		case <-frameTicker.C:
+			r.lastFrameMu.Lock()
+			frame := r.lastFrame
+			isNew := r.lastFrameNew
+			r.lastFrameNew = false
+			r.lastFrameMu.Unlock()
+
+			if isNew {
+				for v := range r.viewers {
+					// slow viewers receive only the most recent picture
+					select {
+					case <-v.frames:
+					default:
+					}
+					v.frames <- frame
+				}
+			}
+
+		case <-closeCheckTicker.C:
+			if len(r.viewers) == 0 && len(r.requests) == 0 &&
+				time.Since(lastViewerTime) >= mjpegMuxerCloseAfterInactivity {
+				innerCtxCancel()
+				<-innerErr
+				break outer
+			}
+
+		case <-innerReady:
+			isReady = true
+			for _, req := range r.requests {
+				r.handleRequest(req)
+			}
+			r.requests = nil
+
+		case err := <-innerErr:
+			innerCtxCancel()
+			if err != nil {
+				r.log(logger.Info, "ERR: %s", err)
+			}
+			break outer
+		}
+	}
+
+	r.ctxCancel()
+
+	for _, req := range r.requests {
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+	}
+
+	for v := range r.viewers {
+		close(v.frames)
+	}
+
+	r.parent.OnMJPEGMuxerClose(r)
+}
+
+func (r *mjpegMuxer) runInner(innerCtx context.Context, innerReady chan struct{}) error {
+	res := r.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+		Author:              r,
+		PathName:            r.pathName,
+		IP:                  nil,
+		ValidateCredentials: nil,
+	})
+	if res.Err != nil {
+		return res.Err
+	}
+
+	r.path = res.Path
+	r.conf = r.path.Conf()
+
+	defer func() {
+		r.path.OnReaderRemove(pathReaderRemoveReq{Author: r})
+	}()
+
+	var videoTrack *gortsplib.Track
+	videoTrackID := -1
+
+	for i, t := range res.Stream.tracks() {
+		if t.IsH264() {
+			if videoTrack != nil {
+				return fmt.Errorf("can't read track %d with MJPEG: too many tracks", i+1)
+			}
+
+			videoTrack = t
+			videoTrackID = i
+		}
+	}
+
+	if videoTrack == nil {
+		return fmt.Errorf("the stream doesn't contain an H264 track")
+	}
+
+	h264Conf, err := videoTrack.ExtractConfigH264()
+	if err != nil {
+		return err
+	}
+
+	decoderCtx, decoderCtxCancel := context.WithCancel(context.Background())
+	defer decoderCtxCancel()
+
+	decoder, err := h264.NewH264JPEGDecoder(decoderCtx, r.pathName, func(frame []byte) {
+		r.lastFrameMu.Lock()
+		r.lastFrame = frame
+		r.lastFrameNew = true
+		r.lastFrameMu.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case innerReady <- struct{}{}:
+	case <-innerCtx.Done():
+		return nil
+	}
+
+	r.videoTrackID = videoTrackID
+	r.buffer = newReaderBuffer(r.readBufferCount, videoTrackID, r.slowReaderTimeout, r.framesDropped)
+
+	r.path.OnReaderPlay(pathReaderPlayReq{Author: r})
+
+	feeder := &h264DecoderFeeder{
+		h264Conf:      h264Conf,
+		decoder:       decoder,
+		keyframesOnly: r.mjpegKeyframesOnly,
+		log:           r.log,
+	}
+
+	writerDone := make(chan error)
+	go func() {
+		writerDone <- feeder.run(r.buffer)
+	}()
+
+	select {
+	case err := <-writerDone:
+		return err
+
+	case <-innerCtx.Done():
+		r.buffer.close()
+		<-writerDone
+		return nil
+	}
+}
+
+func (r *mjpegMuxer) handleRequest(req mjpegMuxerRequest) {
+	conf := r.conf
+
+	if conf.ReadIPsParsed != nil {
+		tmp, _, _ := net.SplitHostPort(req.Req.RemoteAddr)
+		ip := net.ParseIP(tmp)
+		if !ipEqualOrInRange(ip, conf.ReadIPsParsed) {
+			r.log(logger.Info, "ERR: ip '%s' not allowed", ip)
+			req.W.WriteHeader(http.StatusUnauthorized)
+			req.Res <- nil
+			return
+		}
+	}
+
+	if conf.ReadUser != "" {
+		user, pass, ok := req.Req.BasicAuth()
+		if !ok || user != conf.ReadUser || pass != conf.ReadPass {
+			req.W.Header().Set("WWW-Authenticate", `Basic realm="rtsp-simple-server"`)
+			req.W.WriteHeader(http.StatusUnauthorized)
+			req.Res <- nil
+			return
+		}
+	}
+
+	v := &mjpegMuxerViewer{
+		muxer:  r,
+		frames: make(chan []byte, 1),
+	}
+	r.viewers[v] = struct{}{}
+
+	req.Res <- v
+}
+
+// OnRequest is called by hlsServer.
+func (r *mjpegMuxer) OnRequest(req mjpegMuxerRequest) {
+	select {
+	case r.request <- req:
+	case <-r.ctx.Done():
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+	}
+}
+
+// OnViewerClose is called by mjpegMuxerViewer.
+func (r *mjpegMuxer) OnViewerClose(v *mjpegMuxerViewer) {
+	select {
+	case r.viewerClose <- v:
+	case <-r.ctx.Done():
+	}
+}
+
+// OnReaderAccepted implements reader.
+func (r *mjpegMuxer) OnReaderAccepted() {
+	r.log(logger.Info, "is converting into MJPEG")
+}
+
+// OnReaderFrame implements reader.
+func (r *mjpegMuxer) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP && trackID == r.videoTrackID {
+		r.buffer.push(trackID, streamType, payload, payload)
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (r *mjpegMuxer) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"mjpegMuxer"}
+}
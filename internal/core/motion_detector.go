@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/motion"
+)
+
+type motionDetectorParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnMotionDetectorMotion(pathMotionDetectorMotionReq)
+}
+
+// motionDetector decodes the H264 track of a path and detects motion
+// by comparing consecutive pictures.
+type motionDetector struct {
+	readBufferCount int
+	wg              *sync.WaitGroup
+	pathName        string
+	parent          motionDetectorParent
+
+	ctx           context.Context
+	ctxCancel     func()
+	videoTrackID  int
+	h264Conf      *gortsplib.TrackConfigH264
+	detector      *motion.Detector
+	buffer        *readerBuffer
+	framesDropped *uint64
+}
+
+func newMotionDetector(
+	parentCtx context.Context,
+	readBufferCount int,
+	wg *sync.WaitGroup,
+	pathName string,
+	pathConf *conf.PathConf,
+	tracks gortsplib.Tracks,
+	parent motionDetectorParent) (*motionDetector, error) {
+	videoTrackID := -1
+	for i, t := range tracks {
+		if t.IsH264() {
+			videoTrackID = i
+			break
+		}
+	}
+
+	if videoTrackID < 0 {
+		return nil, fmt.Errorf("the stream doesn't contain an H264 track")
+	}
+
+	h264Conf, err := tracks[videoTrackID].ExtractConfigH264()
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []motion.Region
+	for _, r := range pathConf.MotionRegions {
+		regions = append(regions, motion.Region{
+			X:      r.X,
+			Y:      r.Y,
+			Width:  r.Width,
+			Height: r.Height,
+		})
+	}
+
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	d := &motionDetector{
+		readBufferCount: readBufferCount,
+		wg:              wg,
+		pathName:        pathName,
+		parent:          parent,
+		ctx:             ctx,
+		ctxCancel:       ctxCancel,
+		videoTrackID:    videoTrackID,
+		h264Conf:        h264Conf,
+		detector:        motion.NewDetector(regions, pathConf.MotionSensitivity, pathConf.MotionMinDuration),
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
+	}
+
+	// the detector is never considered a slow reader, since it skips
+	// the pictures it can't decode in time.
+	d.buffer = newReaderBuffer(d.readBufferCount, d.videoTrackID, 0, d.framesDropped)
+
+	d.log(logger.Info, "created")
+
+	d.wg.Add(1)
+	go d.run()
+
+	return d, nil
+}
+
+func (d *motionDetector) Close() {
+	d.ctxCancel()
+}
+
+func (d *motionDetector) log(level logger.Level, format string, args ...interface{}) {
+	d.parent.Log(level, "[motion detector] "+format, args...)
+}
+
+func (d *motionDetector) run() {
+	defer d.wg.Done()
+	defer d.log(logger.Info, "destroyed")
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- d.runInner()
+	}()
+
+	select {
+	case err := <-runErr:
+		d.log(logger.Info, "ERR: %s", err)
+
+	case <-d.ctx.Done():
+		d.buffer.close()
+		<-runErr
+	}
+
+	d.ctxCancel()
+}
+
+func (d *motionDetector) runInner() error {
+	decoder, err := h264.NewH264ImageDecoder(d.ctx, d.pathName, d.onImage)
+	if err != nil {
+		return err
+	}
+
+	feeder := &h264DecoderFeeder{
+		h264Conf: d.h264Conf,
+		decoder:  decoder,
+		log:      d.log,
+	}
+
+	return feeder.run(d.buffer)
+}
+
+// onImage is called by the decoder.
+func (d *motionDetector) onImage(img *image.YCbCr) {
+	switch d.detector.Process(img, time.Now()) {
+	case motion.EventStart:
+		d.parent.OnMotionDetectorMotion(pathMotionDetectorMotionReq{Author: d, Motion: true})
+
+	case motion.EventStop:
+		d.parent.OnMotionDetectorMotion(pathMotionDetectorMotionReq{Author: d, Motion: false})
+	}
+}
+
+// OnReaderAccepted implements reader.
+func (d *motionDetector) OnReaderAccepted() {
+}
+
+// OnReaderFrame implements reader.
+func (d *motionDetector) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP && trackID == d.videoTrackID {
+		d.buffer.push(trackID, streamType, payload, payload)
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (d *motionDetector) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"motionDetector"}
+}
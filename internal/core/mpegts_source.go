@@ -0,0 +1,441 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
+)
+
+const (
+	mpegtsSourceRetryPause = 5 * time.Second
+
+	// maximum difference between the pacing clock and a PTS; above that, the PTS
+	// is considered discontinuous and pacing restarts from it
+	mpegtsSourceMaxPaceDrift = 5 * time.Second
+
+	// maximum size of a UDP datagram
+	mpegtsSourceUDPReadBufferSize = 65536
+)
+
+type mpegtsSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+// mpegtsSourceRouter routes frames read from MPEG-TS streams to a path.
+// When pace is true, frames are routed with the speed given by their timestamps; this is needed
+// when the stream is not received in real time.
+type mpegtsSourceRouter struct {
+	ctx  context.Context
+	pace bool
+
+	videoTrackID int
+	audioTrackID int
+	h264Encoder  *rtph264.Encoder
+	aacEncoder   *rtpaac.Encoder
+	stream       *stream
+	rtcpSenders  *rtcpsenderset.RTCPSenderSet
+
+	startTime time.Time
+	startPTS  time.Duration
+}
+
+// newMPEGTSSourceRouter allocates a mpegtsSourceRouter and sets the path as ready.
+func newMPEGTSSourceRouter(
+	ctx context.Context,
+	pace bool,
+	videoTrack *gortsplib.Track,
+	audioTrack *gortsplib.Track,
+	source sourceStatic,
+	parent mpegtsSourceParent,
+) (*mpegtsSourceRouter, error) {
+	r := &mpegtsSourceRouter{
+		ctx:          ctx,
+		pace:         pace,
+		videoTrackID: -1,
+		audioTrackID: -1,
+	}
+
+	var tracks gortsplib.Tracks
+
+	if videoTrack != nil {
+		r.h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
+		r.videoTrackID = len(tracks)
+		tracks = append(tracks, videoTrack)
+	}
+
+	if audioTrack != nil {
+		clockRate, _ := audioTrack.ClockRate()
+		r.aacEncoder = rtpaac.NewEncoder(96, clockRate, nil, nil, nil)
+		r.audioTrackID = len(tracks)
+		tracks = append(tracks, audioTrack)
+	}
+
+	res := parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		Source: source,
+		Tracks: tracks,
+	})
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	r.stream = res.Stream
+	r.rtcpSenders = rtcpsenderset.New(tracks, res.Stream.onFrame)
+
+	return r, nil
+}
+
+func (r *mpegtsSourceRouter) close() {
+	r.rtcpSenders.Close()
+}
+
+func (r *mpegtsSourceRouter) onFrame(trackID int, payload []byte) {
+	r.rtcpSenders.OnFrame(trackID, gortsplib.StreamTypeRTP, payload)
+	r.stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
+}
+
+func (r *mpegtsSourceRouter) writeFrame(f *mpegts.Frame) error {
+	if r.pace {
+		if r.startTime.IsZero() {
+			r.startTime = time.Now()
+			r.startPTS = f.PTS
+		}
+
+		wait := time.Until(r.startTime.Add(f.PTS - r.startPTS))
+
+		// the PTS jumped (i.e. the stream was restarted or a segment was skipped)
+		if wait > mpegtsSourceMaxPaceDrift || wait < -mpegtsSourceMaxPaceDrift {
+			r.startTime = time.Now()
+			r.startPTS = f.PTS
+			wait = 0
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-r.ctx.Done():
+				return fmt.Errorf("terminated")
+			}
+		}
+	}
+
+	switch f.Type {
+	case mpegts.FrameTypeH264:
+		if r.h264Encoder == nil {
+			return nil
+		}
+
+		pkts, err := r.h264Encoder.Encode(f.NALUs, f.PTS)
+		if err != nil {
+			return fmt.Errorf("ERR while encoding H264: %v", err)
+		}
+
+		for _, pkt := range pkts {
+			r.onFrame(r.videoTrackID, pkt)
+		}
+
+	case mpegts.FrameTypeAAC:
+		if r.aacEncoder == nil {
+			return nil
+		}
+
+		pkts, err := r.aacEncoder.Encode(f.AUs, f.PTS)
+		if err != nil {
+			return fmt.Errorf("ERR while encoding AAC: %v", err)
+		}
+
+		for _, pkt := range pkts {
+			r.onFrame(r.audioTrackID, pkt)
+		}
+	}
+
+	return nil
+}
+
+// mpegtsSourceReadStream reads H264 and AAC frames from a MPEG-TS stream and routes them to the path.
+func mpegtsSourceReadStream(
+	ctx context.Context,
+	r io.Reader,
+	pace bool,
+	source sourceStatic,
+	parent mpegtsSourceParent,
+	log func(logger.Level, string, ...interface{}),
+) error {
+	dem := mpegts.NewDemuxer(ctx, r)
+
+	videoTrack, audioTrack, err := dem.Tracks()
+	if err != nil {
+		return err
+	}
+
+	router, err := newMPEGTSSourceRouter(ctx, pace, videoTrack, audioTrack, source, parent)
+	if err != nil {
+		return err
+	}
+
+	log(logger.Info, "ready")
+
+	defer func() {
+		parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: source})
+	}()
+
+	defer router.close()
+
+	for {
+		f, err := dem.ReadFrame()
+		if err != nil {
+			return err
+		}
+
+		err = router.writeFrame(f)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// mpegtsSourceUDPReader reads a MPEG-TS stream from UDP datagrams.
+// Datagrams are read entirely, in order to prevent them from being truncated.
+type mpegtsSourceUDPReader struct {
+	conn        *net.UDPConn
+	readTimeout time.Duration
+	buf         []byte
+	pending     []byte
+}
+
+func (r *mpegtsSourceUDPReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+		n, err := r.conn.Read(r.buf)
+		if err != nil {
+			return 0, err
+		}
+		r.pending = r.buf[:n]
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *mpegtsSourceUDPReader) Close() error {
+	return r.conn.Close()
+}
+
+// mpegtsSourceHTTPReader reads a MPEG-TS stream from a HTTP response.
+// The response is closed when no data is received for a while.
+type mpegtsSourceHTTPReader struct {
+	body        io.ReadCloser
+	readTimeout time.Duration
+	timer       *time.Timer
+}
+
+func newMPEGTSSourceHTTPReader(body io.ReadCloser, readTimeout time.Duration) *mpegtsSourceHTTPReader {
+	return &mpegtsSourceHTTPReader{
+		body:        body,
+		readTimeout: readTimeout,
+		timer: time.AfterFunc(readTimeout, func() {
+			body.Close()
+		}),
+	}
+}
+
+func (r *mpegtsSourceHTTPReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.timer.Reset(r.readTimeout)
+	return n, err
+}
+
+func (r *mpegtsSourceHTTPReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
+
+// mpegtsSource is a static source that reads a MPEG-TS stream from HTTP or UDP.
+type mpegtsSource struct {
+	ur          string
+	readTimeout time.Duration
+	wg          *sync.WaitGroup
+	parent      mpegtsSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newMPEGTSSource(
+	parentCtx context.Context,
+	ur string,
+	readTimeout time.Duration,
+	wg *sync.WaitGroup,
+	parent mpegtsSourceParent) *mpegtsSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &mpegtsSource{
+		ur:          ur,
+		readTimeout: readTimeout,
+		wg:          wg,
+		parent:      parent,
+		ctx:         ctx,
+		ctxCancel:   ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *mpegtsSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *mpegtsSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[mpegts source] "+format, args...)
+}
+
+func (s *mpegtsSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(mpegtsSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *mpegtsSource) runInner() bool {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- func() error {
+			s.log(logger.Debug, "connecting")
+
+			r, err := s.open(innerCtx)
+			if err != nil {
+				return err
+			}
+
+			readDone := make(chan error)
+			go func() {
+				readDone <- mpegtsSourceReadStream(innerCtx, r, false, s, s.parent, s.log)
+			}()
+
+			select {
+			case err := <-readDone:
+				r.Close()
+				return err
+
+			case <-innerCtx.Done():
+				r.Close()
+				<-readDone
+				return nil
+			}
+		}()
+	}()
+
+	select {
+	case err := <-runErr:
+		innerCtxCancel()
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+
+	case <-s.ctx.Done():
+		innerCtxCancel()
+		<-runErr
+		return false
+	}
+}
+
+func (s *mpegtsSource) open(ctx context.Context) (io.ReadCloser, error) {
+	u, err := url.Parse(s.ur)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "udp" {
+		addr, err := net.ResolveUDPAddr("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		var conn *net.UDPConn
+		if addr.IP.IsMulticast() {
+			conn, err = net.ListenMulticastUDP("udp", nil, addr)
+		} else {
+			conn, err = net.ListenUDP("udp", addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &mpegtsSourceUDPReader{
+			conn:        conn,
+			readTimeout: s.readTimeout,
+			buf:         make([]byte, mpegtsSourceUDPReadBufferSize),
+		}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.ur, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// timeouts are applied to the connection and to the response headers;
+	// the body is handled by mpegtsSourceHTTPReader.
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: s.readTimeout}).DialContext,
+			TLSHandshakeTimeout:   s.readTimeout,
+			ResponseHeaderTimeout: s.readTimeout,
+		},
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return newMPEGTSSourceHTTPReader(res.Body, s.readTimeout), nil
+}
+
+// OnSourceAPIDescribe implements source.
+func (*mpegtsSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"mpegtsSource"}
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/asticode/go-astits"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+// newTestTSMuxer allocates a MPEG-TS muxer with a H264 track.
+func newTestTSMuxer(w io.Writer) *astits.Muxer {
+	mux := astits.NewMuxer(context.Background(), w)
+	mux.AddElementaryStream(astits.PMTElementaryStream{
+		ElementaryPID: 256,
+		StreamType:    astits.StreamTypeH264Video,
+	})
+	mux.SetPCRPID(256)
+	return mux
+}
+
+// writeTestTSFrame writes a IDR frame, whose second byte is the index of the frame.
+func writeTestTSFrame(t *testing.T, mux *astits.Muxer, i int) {
+	enc, err := h264.EncodeAnnexB([][]byte{
+		{0x67, 0x01, 0x02, 0x03},
+		{0x68, 0x04},
+		{0x65, byte(i)},
+	})
+	require.NoError(t, err)
+
+	_, err = mux.WriteData(&astits.MuxerData{
+		PID: 256,
+		AdaptationField: &astits.PacketAdaptationField{
+			RandomAccessIndicator: true,
+		},
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: int64(i) * 9000},
+				},
+				StreamID: 224,
+			},
+			Data: enc,
+		},
+	})
+	require.NoError(t, err)
+}
+
+type testReceivedFrame struct {
+	index     int
+	t         time.Time
+	timestamp uint32
+}
+
+// readTestFrames reads the frames written by writeTestTSFrame from a path.
+func readTestFrames(t *testing.T, pathName string) (*gortsplib.ClientConn, chan testReceivedFrame) {
+	var conn *gortsplib.ClientConn
+	for i := 0; ; i++ {
+		var err error
+		conn, err = gortsplib.DialRead("rtsp://localhost:8554/" + pathName)
+		if err == nil {
+			break
+		}
+
+		require.Less(t, i, 50, "source is not ready")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	require.Equal(t, 1, len(conn.Tracks()))
+
+	recv := make(chan testReceivedFrame, 100)
+	go func() {
+		conn.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+			if streamType != gortsplib.StreamTypeRTP {
+				return
+			}
+
+			var pkt rtp.Packet
+			err := pkt.Unmarshal(payload)
+			if err != nil || len(pkt.Payload) != 2 || pkt.Payload[0] != 0x65 {
+				return
+			}
+
+			select {
+			case recv <- testReceivedFrame{int(pkt.Payload[1]), time.Now(), pkt.Timestamp}:
+			default:
+			}
+		})
+	}()
+
+	return conn, recv
+}
+
+func TestMPEGTSSource(t *testing.T) {
+	for _, ca := range []string{
+		"udp",
+		"http",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			terminate := make(chan struct{})
+			done := make(chan struct{})
+
+			var source string
+
+			switch ca {
+			case "udp":
+				source = "udp://127.0.0.1:9832"
+
+				conn, err := net.Dial("udp", "127.0.0.1:9832")
+				require.NoError(t, err)
+				defer conn.Close()
+
+				go func() {
+					defer close(done)
+
+					var buf bytes.Buffer
+					mux := newTestTSMuxer(&buf)
+
+					for i := 0; ; i++ {
+						select {
+						case <-time.After(100 * time.Millisecond):
+						case <-terminate:
+							return
+						}
+
+						writeTestTSFrame(t, mux, i%256)
+
+						// send 7 TS packets per datagram
+						for buf.Len() >= 7*188 {
+							conn.Write(buf.Next(7 * 188))
+						}
+					}
+				}()
+
+			case "http":
+				s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mux := newTestTSMuxer(w)
+
+					for i := 0; ; i++ {
+						select {
+						case <-time.After(100 * time.Millisecond):
+						case <-r.Context().Done():
+							return
+						case <-terminate:
+							return
+						}
+
+						writeTestTSFrame(t, mux, i%256)
+						w.(http.Flusher).Flush()
+					}
+				}))
+				defer s.Close()
+
+				source = s.URL + "/stream.ts"
+				close(done)
+			}
+
+			defer func() { <-done }()
+			defer close(terminate)
+
+			p, ok := newInstance("rtmpDisable: yes\n" +
+				"hlsDisable: yes\n" +
+				"protocols: [tcp]\n" +
+				"paths:\n" +
+				"  proxied:\n" +
+				"    source: " + source + "\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			conn, recv := readTestFrames(t, "proxied")
+			defer conn.Close()
+
+			select {
+			case <-recv:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -12,7 +13,9 @@ import (
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
 
+	"github.com/aler9/rtsp-simple-server/internal/cluster"
 	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
@@ -55,6 +58,7 @@ type pathParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnPathSourceReady(*path)
 	OnPathClose(*path)
+	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
 }
 
 type pathRTSPSession interface {
@@ -113,10 +117,11 @@ type pathPublisherRemoveReq struct {
 }
 
 type pathDescribeRes struct {
-	Path     *path
-	Stream   *stream
-	Redirect string
-	Err      error
+	Path              *path
+	Stream            *stream
+	Redirect          string
+	RedirectTemporary bool
+	Err               error
 }
 
 type pathDescribeReq struct {
@@ -180,6 +185,16 @@ type pathPublisherPauseReq struct {
 	Res    chan struct{}
 }
 
+type pathMotionDetectorMotionReq struct {
+	Author *motionDetector
+	Motion bool
+}
+
+type pathAPIPathsTriggerReq struct {
+	Reason string
+	Res    chan error
+}
+
 type path struct {
 	rtspAddress     string
 	readTimeout     time.Duration
@@ -191,22 +206,33 @@ type path struct {
 	name            string
 	wg              *sync.WaitGroup
 	stats           *stats
+	eventBus        *eventbus.Bus
+	clusterDir      *clusterDirectory
 	parent          pathParent
 
 	ctx                context.Context
 	ctxCancel          func()
+	clusterSourceURL   string
 	source             source
 	sourceReady        bool
 	sourceStaticWg     sync.WaitGroup
 	readers            map[reader]pathReaderState
 	describeRequests   []pathDescribeReq
 	setupPlayRequests  []pathReaderSetupPlayReq
+	clusterLookup      bool
+	clusterDescribes   []pathDescribeReq
+	clusterSetupPlays  []pathReaderSetupPlayReq
 	stream             *stream
 	onDemandCmd        *externalcmd.Cmd
 	onPublishCmd       *externalcmd.Cmd
 	onDemandReadyTimer *time.Timer
 	onDemandCloseTimer *time.Timer
 	onDemandState      pathOnDemandState
+	motionDetector     *motionDetector
+	motion             bool
+	onMotionCmd        *externalcmd.Cmd
+	recorder           *recorder
+	recordTriggerCmd   *externalcmd.Cmd
 
 	// in
 	sourceStaticSetReady    chan pathSourceStaticSetReadyReq
@@ -221,6 +247,9 @@ type path struct {
 	readerPlay              chan pathReaderPlayReq
 	readerPause             chan pathReaderPauseReq
 	apiPathsList            chan apiPathsListReq2
+	motionDetectorMotion    chan pathMotionDetectorMotionReq
+	apiPathsTrigger         chan pathAPIPathsTriggerReq
+	clusterLookupDone       chan *cluster.Node
 }
 
 func newPath(
@@ -235,6 +264,8 @@ func newPath(
 	name string,
 	wg *sync.WaitGroup,
 	stats *stats,
+	eventBus *eventbus.Bus,
+	clusterDir *clusterDirectory,
 	parent pathParent) *path {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
@@ -249,6 +280,8 @@ func newPath(
 		name:                    name,
 		wg:                      wg,
 		stats:                   stats,
+		eventBus:                eventBus,
+		clusterDir:              clusterDir,
 		parent:                  parent,
 		ctx:                     ctx,
 		ctxCancel:               ctxCancel,
@@ -267,6 +300,9 @@ func newPath(
 		readerPlay:              make(chan pathReaderPlayReq),
 		readerPause:             make(chan pathReaderPauseReq),
 		apiPathsList:            make(chan apiPathsListReq2),
+		motionDetectorMotion:    make(chan pathMotionDetectorMotionReq),
+		apiPathsTrigger:         make(chan pathAPIPathsTriggerReq),
+		clusterLookupDone:       make(chan *cluster.Node),
 	}
 
 	pa.Log(logger.Info, "created")
@@ -408,6 +444,17 @@ outer:
 		case req := <-pa.apiPathsList:
 			pa.handleAPIPathsList(req)
 
+		case req := <-pa.motionDetectorMotion:
+			if req.Author == pa.motionDetector {
+				pa.handleMotion(req.Motion)
+			}
+
+		case req := <-pa.apiPathsTrigger:
+			pa.handleAPIPathsTrigger(req)
+
+		case node := <-pa.clusterLookupDone:
+			pa.handleClusterLookupDone(node)
+
 		case <-pa.ctx.Done():
 			break outer
 		}
@@ -431,6 +478,14 @@ outer:
 		req.Res <- pathReaderSetupPlayRes{Err: fmt.Errorf("terminated")}
 	}
 
+	for _, req := range pa.clusterDescribes {
+		req.Res <- pathDescribeRes{Err: fmt.Errorf("terminated")}
+	}
+
+	for _, req := range pa.clusterSetupPlays {
+		req.Res <- pathReaderSetupPlayRes{Err: fmt.Errorf("terminated")}
+	}
+
 	for rp, state := range pa.readers {
 		if state == pathReaderStatePlay {
 			atomic.AddInt64(pa.stats.CountReaders, -1)
@@ -444,6 +499,9 @@ outer:
 	}
 
 	if pa.stream != nil {
+		pa.motionDetectorClose()
+		pa.recorderClose()
+		pa.clusterUnregister()
 		pa.stream.close()
 	}
 
@@ -465,11 +523,122 @@ outer:
 func (pa *path) hasStaticSource() bool {
 	return strings.HasPrefix(pa.conf.Source, "rtsp://") ||
 		strings.HasPrefix(pa.conf.Source, "rtsps://") ||
-		strings.HasPrefix(pa.conf.Source, "rtmp://")
+		strings.HasPrefix(pa.conf.Source, "rtmp://") ||
+		strings.HasPrefix(pa.conf.Source, "rtmps://") ||
+		strings.HasPrefix(pa.conf.Source, "http://") ||
+		strings.HasPrefix(pa.conf.Source, "https://") ||
+		strings.HasPrefix(pa.conf.Source, "udp://") ||
+		strings.HasPrefix(pa.conf.Source, "file://") ||
+		strings.HasPrefix(pa.conf.Source, "path://") ||
+		pa.conf.Source == "compose" ||
+		pa.conf.TranscodeProfile != nil ||
+		pa.clusterSourceURL != ""
 }
 
 func (pa *path) isOnDemand() bool {
-	return (pa.hasStaticSource() && pa.conf.SourceOnDemand) || pa.conf.RunOnDemand != ""
+	return (pa.hasStaticSource() && (pa.conf.SourceOnDemand || pa.clusterSourceURL != "")) ||
+		pa.conf.RunOnDemand != ""
+}
+
+// clusterLookupStart looks up the node of the cluster that is publishing this path.
+// The lookup can involve the other nodes, therefore it is performed in a
+// separate routine, that sends the result to clusterLookupDone.
+func (pa *path) clusterLookupStart() {
+	if pa.clusterLookup {
+		return
+	}
+	pa.clusterLookup = true
+
+	go func() {
+		node := pa.clusterDir.lookup(pa.name)
+
+		select {
+		case pa.clusterLookupDone <- node:
+		case <-pa.ctx.Done():
+		}
+	}()
+}
+
+func (pa *path) handleClusterLookupDone(node *cluster.Node) {
+	pa.clusterLookup = false
+
+	describes := pa.clusterDescribes
+	pa.clusterDescribes = nil
+
+	setupPlays := pa.clusterSetupPlays
+	pa.clusterSetupPlays = nil
+
+	for _, req := range describes {
+		// the path may have become available in the meanwhile
+		if pa.sourceReady || pa.isOnDemand() {
+			pa.handleDescribe(req)
+			continue
+		}
+
+		pa.handleDescribeNotPublished(req, node)
+	}
+
+	for _, req := range setupPlays {
+		if pa.sourceReady || pa.isOnDemand() {
+			pa.handleReaderSetupPlay(req)
+			continue
+		}
+
+		if node != nil {
+			pa.clusterProxyStart(node, nil)
+			if pa.clusterSourceURL != "" {
+				pa.setupPlayRequests = append(pa.setupPlayRequests, req)
+				continue
+			}
+		}
+
+		req.Res <- pathReaderSetupPlayRes{Err: pathErrNoOnePublishing{PathName: pa.name}}
+	}
+}
+
+// clusterProxyStart starts pulling the path from the node that owns it.
+func (pa *path) clusterProxyStart(node *cluster.Node, user *url.Userinfo) {
+	ur, err := base.ParseURL(strings.TrimSuffix(node.RTSPAddress, "/") + "/" + pa.name)
+	if err != nil {
+		pa.Log(logger.Info, "ERR: invalid address of node '%s': %s", node.Name, err)
+		return
+	}
+	ur.User = user
+
+	pa.Log(logger.Info, "proxying from node '%s'", node.Name)
+	pa.clusterSourceURL = ur.String()
+	pa.onDemandStartSource()
+}
+
+// clusterProxyStop stops pulling the path from another node.
+func (pa *path) clusterProxyStop() {
+	for _, req := range pa.describeRequests {
+		req.Res <- pathDescribeRes{Err: fmt.Errorf("source of path '%s' has changed", pa.name)}
+	}
+	pa.describeRequests = nil
+
+	for _, req := range pa.setupPlayRequests {
+		req.Res <- pathReaderSetupPlayRes{Err: fmt.Errorf("source of path '%s' has changed", pa.name)}
+	}
+	pa.setupPlayRequests = nil
+
+	pa.onDemandReadyTimer.Stop()
+	pa.onDemandReadyTimer = newEmptyTimer()
+
+	pa.onDemandCloseSource()
+}
+
+func (pa *path) clusterRegister() {
+	// do not advertise paths that are proxied from other nodes
+	if pa.clusterDir != nil && pa.clusterSourceURL == "" {
+		pa.clusterDir.register(pa.name)
+	}
+}
+
+func (pa *path) clusterUnregister() {
+	if pa.clusterDir != nil && pa.clusterSourceURL == "" {
+		pa.clusterDir.unregister(pa.name)
+	}
 }
 
 func (pa *path) onDemandStartSource() {
@@ -517,6 +686,7 @@ func (pa *path) onDemandCloseSource() {
 		}
 		pa.source.(sourceStatic).Close()
 		pa.source = nil
+		pa.clusterSourceURL = ""
 	} else {
 		pa.Log(logger.Info, "on demand command stopped")
 		pa.onDemandCmd.Close()
@@ -531,7 +701,12 @@ func (pa *path) onDemandCloseSource() {
 
 func (pa *path) sourceSetReady(tracks gortsplib.Tracks) {
 	pa.sourceReady = true
-	pa.stream = newStream(tracks)
+	var gopCacheMaxDuration time.Duration
+	if pa.conf.GOPCache {
+		gopCacheMaxDuration = pa.conf.GOPCacheMaxDuration
+	}
+
+	pa.stream = newStream(tracks, gopCacheMaxDuration)
 
 	if pa.isOnDemand() {
 		pa.onDemandReadyTimer.Stop()
@@ -556,7 +731,23 @@ func (pa *path) sourceSetReady(tracks gortsplib.Tracks) {
 		}
 	}
 
+	pa.clusterRegister()
+
+	if pa.conf.MotionDetection {
+		pa.motionDetectorCreate()
+	}
+
+	if pa.conf.Record {
+		pa.recorderCreate()
+	}
+
 	pa.parent.OnPathSourceReady(pa)
+
+	pa.eventBus.Publish(eventbus.Event{
+		Type:   eventbus.TypePathReady,
+		Path:   pa.name,
+		Author: pa.source.OnSourceAPIDescribe(),
+	})
 }
 
 func (pa *path) sourceSetNotReady() {
@@ -570,13 +761,180 @@ func (pa *path) sourceSetNotReady() {
 		r.Close()
 	}
 
+	pa.motionDetectorClose()
+	pa.recorderClose()
+
+	pa.clusterUnregister()
+
 	pa.sourceReady = false
 	pa.stream.close()
 	pa.stream = nil
+
+	pa.eventBus.Publish(eventbus.Event{
+		Type: eventbus.TypePathNotReady,
+		Path: pa.name,
+	})
+}
+
+func (pa *path) motionDetectorCreate() {
+	md, err := newMotionDetector(
+		pa.ctx,
+		pa.readBufferCount,
+		pa.wg,
+		pa.name,
+		pa.conf,
+		pa.stream.tracks(),
+		pa)
+	if err != nil {
+		pa.Log(logger.Warn, "unable to start motion detection: %s", err)
+		return
+	}
+
+	// the detector is not a reader of the path, therefore it doesn't keep
+	// on-demand sources alive and it's not listed among readers.
+	pa.motionDetector = md
+	pa.stream.readerAdd(md)
+}
+
+func (pa *path) motionDetectorClose() {
+	if pa.motionDetector == nil {
+		return
+	}
+
+	pa.stream.readerRemove(pa.motionDetector)
+	pa.motionDetector.Close()
+	pa.motionDetector = nil
+
+	if pa.motion {
+		pa.handleMotion(false)
+	}
+}
+
+func (pa *path) recorderCreate() {
+	rec, err := newRecorder(
+		pa.ctx,
+		pa.readBufferCount,
+		pa.wg,
+		pa.name,
+		pa.conf,
+		pa.stream,
+		pa)
+	if err != nil {
+		pa.Log(logger.Warn, "unable to start recorder: %s", err)
+		return
+	}
+
+	// like the motion detector, the recorder is not a reader of the path.
+	pa.recorder = rec
+	pa.stream.readerAdd(rec)
+
+	if pa.conf.RecordTriggerCommand != "" {
+		pa.Log(logger.Info, "record trigger command started")
+		_, port, _ := net.SplitHostPort(pa.rtspAddress)
+		pa.recordTriggerCmd = externalcmd.NewWithOnExit(pa.conf.RecordTriggerCommand, true, externalcmd.Environment{
+			Path: pa.name,
+			Port: port,
+		}, func(code int) {
+			if code == 0 {
+				rec.trigger(recorderTrigger{
+					Time:   time.Now(),
+					Source: "command",
+				})
+			}
+		})
+	}
+}
+
+func (pa *path) recorderClose() {
+	if pa.recorder == nil {
+		return
+	}
+
+	if pa.recordTriggerCmd != nil {
+		pa.Log(logger.Info, "record trigger command stopped")
+		pa.recordTriggerCmd.Close()
+		pa.recordTriggerCmd = nil
+	}
+
+	pa.stream.readerRemove(pa.recorder)
+	pa.recorder.Close()
+	pa.recorder = nil
+}
+
+func (pa *path) handleMotion(motion bool) {
+	pa.motion = motion
+
+	if motion {
+		pa.Log(logger.Info, "motion started")
+
+		if pa.conf.RunOnMotion != "" {
+			pa.Log(logger.Info, "on motion command started")
+			_, port, _ := net.SplitHostPort(pa.rtspAddress)
+			pa.onMotionCmd = externalcmd.New(pa.conf.RunOnMotion, pa.conf.RunOnMotionRestart, externalcmd.Environment{
+				Path: pa.name,
+				Port: port,
+			})
+		}
+
+		if pa.recorder != nil && pa.conf.RecordOnMotion {
+			pa.recorder.setHold(true)
+			pa.recorder.trigger(recorderTrigger{
+				Time:   time.Now(),
+				Source: "motion",
+			})
+		}
+
+		pa.eventBus.Publish(eventbus.Event{
+			Type: eventbus.TypeMotionStart,
+			Path: pa.name,
+		})
+		return
+	}
+
+	pa.Log(logger.Info, "motion stopped")
+
+	if pa.recorder != nil && pa.conf.RecordOnMotion {
+		pa.recorder.setHold(false)
+	}
+
+	if pa.onMotionCmd != nil {
+		pa.Log(logger.Info, "on motion command stopped")
+		pa.onMotionCmd.Close()
+		pa.onMotionCmd = nil
+	}
+
+	pa.eventBus.Publish(eventbus.Event{
+		Type: eventbus.TypeMotionStop,
+		Path: pa.name,
+	})
 }
 
 func (pa *path) staticSourceCreate() {
-	if strings.HasPrefix(pa.conf.Source, "rtsp://") ||
+	if pa.clusterSourceURL != "" {
+		pa.source = newRTSPSource(
+			pa.ctx,
+			pa.clusterSourceURL,
+			pa.conf.SourceProtocolParsed,
+			false,
+			"",
+			pa.readTimeout,
+			pa.writeTimeout,
+			pa.readBufferCount,
+			pa.readBufferSize,
+			&pa.sourceStaticWg,
+			pa)
+	} else if pa.conf.TranscodeProfile != nil {
+		pa.source = newTranscodeSource(
+			pa.ctx,
+			pa.name,
+			pa.conf.TranscodeProfile,
+			pa.conf.TranscodeCommand,
+			pa.rtspAddress,
+			pa.readBufferCount,
+			&pa.sourceStaticWg,
+			pa.parent,
+			pa)
+	} else if strings.HasPrefix(pa.conf.Source, "rtsp://") ||
 		strings.HasPrefix(pa.conf.Source, "rtsps://") {
 		pa.source = newRTSPSource(
 			pa.ctx,
@@ -590,14 +948,54 @@ func (pa *path) staticSourceCreate() {
 			pa.readBufferSize,
 			&pa.sourceStaticWg,
 			pa)
-	} else if strings.HasPrefix(pa.conf.Source, "rtmp://") {
+	} else if strings.HasPrefix(pa.conf.Source, "rtmp://") ||
+		strings.HasPrefix(pa.conf.Source, "rtmps://") {
 		pa.source = newRTMPSource(
 			pa.ctx,
 			pa.conf.Source,
+			pa.conf.SourceFingerprint,
 			pa.readTimeout,
 			pa.writeTimeout,
 			&pa.sourceStaticWg,
 			pa)
+	} else if hlsSourceIsHLS(pa.conf.Source) {
+		pa.source = newHLSSource(
+			pa.ctx,
+			pa.conf.Source,
+			pa.readTimeout,
+			&pa.sourceStaticWg,
+			pa)
+	} else if strings.HasPrefix(pa.conf.Source, "http://") ||
+		strings.HasPrefix(pa.conf.Source, "https://") ||
+		strings.HasPrefix(pa.conf.Source, "udp://") {
+		pa.source = newMPEGTSSource(
+			pa.ctx,
+			pa.conf.Source,
+			pa.readTimeout,
+			&pa.sourceStaticWg,
+			pa)
+	} else if strings.HasPrefix(pa.conf.Source, "file://") {
+		pa.source = newFileSource(
+			pa.ctx,
+			pa.conf.Source,
+			&pa.sourceStaticWg,
+			pa)
+	} else if strings.HasPrefix(pa.conf.Source, "path://") {
+		pa.source = newPathSource(
+			pa.ctx,
+			pa.conf.Source,
+			pa.readBufferCount,
+			&pa.sourceStaticWg,
+			pa.parent,
+			pa)
+	} else if pa.conf.Source == "compose" {
+		pa.source = newComposeSource(
+			pa.ctx,
+			pa.conf.SourceCompose,
+			pa.readBufferCount,
+			&pa.sourceStaticWg,
+			pa.parent,
+			pa)
 	}
 }
 
@@ -610,6 +1008,12 @@ func (pa *path) doReaderRemove(r reader) {
 	}
 
 	delete(pa.readers, r)
+
+	pa.eventBus.Publish(eventbus.Event{
+		Type:   eventbus.TypeReaderRemove,
+		Path:   pa.name,
+		Author: r.OnReaderAPIDescribe(),
+	})
 }
 
 func (pa *path) doPublisherRemove() {
@@ -623,6 +1027,14 @@ func (pa *path) doPublisherRemove() {
 		}
 	}
 
+	if pa.source != nil {
+		pa.eventBus.Publish(eventbus.Event{
+			Type:   eventbus.TypePublisherRemove,
+			Path:   pa.name,
+			Author: pa.source.OnSourceAPIDescribe(),
+		})
+	}
+
 	pa.source = nil
 
 	for r := range pa.readers {
@@ -654,6 +1066,39 @@ func (pa *path) handleDescribe(req pathDescribeReq) {
 		return
 	}
 
+	if pa.clusterDir != nil {
+		pa.clusterDescribes = append(pa.clusterDescribes, req)
+		pa.clusterLookupStart()
+		return
+	}
+
+	pa.handleDescribeNotPublished(req, nil)
+}
+
+// handleDescribeNotPublished handles a describe request of a path that is not
+// published on this node. node is the node of the cluster that is publishing it, if any.
+func (pa *path) handleDescribeNotPublished(req pathDescribeReq, node *cluster.Node) {
+	if node != nil {
+		if pa.clusterDir.mode == conf.ClusterModeProxy {
+			var user *url.Userinfo
+			if req.URL != nil {
+				user = req.URL.User
+			}
+
+			pa.clusterProxyStart(node, user)
+			if pa.clusterSourceURL != "" {
+				pa.describeRequests = append(pa.describeRequests, req)
+				return
+			}
+		} else {
+			req.Res <- pathDescribeRes{
+				Redirect:          strings.TrimSuffix(node.RTSPAddress, "/") + "/" + pa.name,
+				RedirectTemporary: true,
+			}
+			return
+		}
+	}
+
 	if pa.conf.Fallback != "" {
 		fallbackURL := func() string {
 			if strings.HasPrefix(pa.conf.Fallback, "/") {
@@ -682,6 +1127,12 @@ func (pa *path) handlePublisherRemove(req pathPublisherRemoveReq) {
 }
 
 func (pa *path) handlePublisherAnnounce(req pathPublisherAnnounceReq) {
+	// a local publisher replaces the proxy from another node
+	if pa.clusterSourceURL != "" {
+		pa.Log(logger.Info, "closing proxy, path is published on this node")
+		pa.clusterProxyStop()
+	}
+
 	if pa.source != nil {
 		if pa.hasStaticSource() {
 			req.Res <- pathPublisherAnnounceRes{Err: fmt.Errorf("path '%s' is assigned to a static source", pa.name)}
@@ -700,6 +1151,12 @@ func (pa *path) handlePublisherAnnounce(req pathPublisherAnnounceReq) {
 
 	pa.source = req.Author
 
+	pa.eventBus.Publish(eventbus.Event{
+		Type:   eventbus.TypePublisherAnnounce,
+		Path:   pa.name,
+		Author: req.Author.OnSourceAPIDescribe(),
+	})
+
 	req.Res <- pathPublisherAnnounceRes{Path: pa}
 }
 
@@ -713,6 +1170,12 @@ func (pa *path) handlePublisherRecord(req pathPublisherRecordReq) {
 
 	req.Author.OnPublisherAccepted(len(req.Tracks))
 
+	pa.eventBus.Publish(eventbus.Event{
+		Type:   eventbus.TypePublisherRecord,
+		Path:   pa.name,
+		Author: req.Author.OnSourceAPIDescribe(),
+	})
+
 	pa.sourceSetReady(req.Tracks)
 
 	if pa.conf.RunOnPublish != "" {
@@ -766,6 +1229,12 @@ func (pa *path) handleReaderSetupPlay(req pathReaderSetupPlayReq) {
 		return
 	}
 
+	if pa.clusterDir != nil && pa.clusterDir.mode == conf.ClusterModeProxy {
+		pa.clusterSetupPlays = append(pa.clusterSetupPlays, req)
+		pa.clusterLookupStart()
+		return
+	}
+
 	req.Res <- pathReaderSetupPlayRes{Err: pathErrNoOnePublishing{PathName: pa.name}}
 }
 
@@ -778,6 +1247,12 @@ func (pa *path) handleReaderSetupPlayPost(req pathReaderSetupPlayReq) {
 		pa.onDemandCloseTimer = newEmptyTimer()
 	}
 
+	pa.eventBus.Publish(eventbus.Event{
+		Type:   eventbus.TypeReaderAdd,
+		Path:   pa.name,
+		Author: req.Author.OnReaderAPIDescribe(),
+	})
+
 	req.Res <- pathReaderSetupPlayRes{
 		Path:   pa,
 		Stream: pa.stream,
@@ -815,6 +1290,8 @@ func (pa *path) handleAPIPathsList(req apiPathsListReq2) {
 			return pa.source.OnSourceAPIDescribe()
 		}(),
 		SourceReady: pa.sourceReady,
+		Tracks:      newAPIPathsItemTracks(pa.stream),
+		Motion:      pa.motion,
 		Readers: func() []interface{} {
 			ret := []interface{}{}
 			for r := range pa.readers {
@@ -826,6 +1303,20 @@ func (pa *path) handleAPIPathsList(req apiPathsListReq2) {
 	close(req.Res)
 }
 
+func (pa *path) handleAPIPathsTrigger(req pathAPIPathsTriggerReq) {
+	if pa.recorder == nil {
+		req.Res <- fmt.Errorf("recording is not enabled or the path is not ready")
+		return
+	}
+
+	pa.recorder.trigger(recorderTrigger{
+		Time:   time.Now(),
+		Source: "api",
+		Reason: req.Reason,
+	})
+	req.Res <- nil
+}
+
 // OnSourceStaticSetReady is called by a sourceStatic.
 func (pa *path) OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes {
 	req.Res = make(chan pathSourceStaticSetReadyRes)
@@ -938,6 +1429,14 @@ func (pa *path) OnReaderPause(req pathReaderPauseReq) {
 	}
 }
 
+// OnMotionDetectorMotion is called by motionDetector.
+func (pa *path) OnMotionDetectorMotion(req pathMotionDetectorMotionReq) {
+	select {
+	case pa.motionDetectorMotion <- req:
+	case <-pa.ctx.Done():
+	}
+}
+
 // OnAPIPathsList is called by api.
 func (pa *path) OnAPIPathsList(req apiPathsListReq2) {
 	req.Res = make(chan struct{})
@@ -948,3 +1447,15 @@ func (pa *path) OnAPIPathsList(req apiPathsListReq2) {
 	case <-pa.ctx.Done():
 	}
 }
+
+// OnAPIPathsTrigger is called by api through pathManager.
+func (pa *path) OnAPIPathsTrigger(req pathAPIPathsTriggerReq) error {
+	req.Res = make(chan error)
+	select {
+	case pa.apiPathsTrigger <- req:
+		return <-req.Res
+
+	case <-pa.ctx.Done():
+		return fmt.Errorf("terminated")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/aler9/gortsplib/pkg/base"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -17,6 +18,16 @@ type pathManagerHLSServer interface {
 	OnPathSourceReady(pa *path)
 }
 
+type pathManagerConfGetRes struct {
+	Conf *conf.PathConf
+	Err  error
+}
+
+type pathManagerConfGetReq struct {
+	PathName string
+	Res      chan pathManagerConfGetRes
+}
+
 type pathManagerParent interface {
 	Log(logger.Level, string, ...interface{})
 }
@@ -30,6 +41,8 @@ type pathManager struct {
 	pathConfs       map[string]*conf.PathConf
 	stats           *stats
 	metrics         *metrics
+	eventBus        *eventbus.Bus
+	clusterDir      *clusterDirectory
 	parent          pathManagerParent
 
 	ctx       context.Context
@@ -47,6 +60,8 @@ type pathManager struct {
 	publisherAnnounce chan pathPublisherAnnounceReq
 	hlsServerSet      chan pathManagerHLSServer
 	apiPathsList      chan apiPathsListReq1
+	apiPathsTrigger   chan apiPathsTriggerReq
+	confGet           chan pathManagerConfGetReq
 }
 
 func newPathManager(
@@ -59,6 +74,8 @@ func newPathManager(
 	pathConfs map[string]*conf.PathConf,
 	stats *stats,
 	metrics *metrics,
+	eventBus *eventbus.Bus,
+	clusterDir *clusterDirectory,
 	parent pathManagerParent) *pathManager {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
@@ -71,6 +88,8 @@ func newPathManager(
 		pathConfs:         pathConfs,
 		stats:             stats,
 		metrics:           metrics,
+		eventBus:          eventBus,
+		clusterDir:        clusterDir,
 		parent:            parent,
 		ctx:               ctx,
 		ctxCancel:         ctxCancel,
@@ -83,6 +102,8 @@ func newPathManager(
 		publisherAnnounce: make(chan pathPublisherAnnounceReq),
 		hlsServerSet:      make(chan pathManagerHLSServer),
 		apiPathsList:      make(chan apiPathsListReq1),
+		apiPathsTrigger:   make(chan apiPathsTriggerReq),
+		confGet:           make(chan pathManagerConfGetReq),
 	}
 
 	for pathName, pathConf := range pm.pathConfs {
@@ -142,7 +163,7 @@ outer:
 			// remove paths associated with a conf which doesn't exist anymore
 			// or has changed
 			for _, pa := range pm.paths {
-				if pathConf, ok := pm.pathConfs[pa.ConfName()]; !ok || pathConf != pa.Conf() {
+				if pathConf, ok := pm.pathConfs[pa.ConfName()]; !ok || !pathConf.Includes(pa.Conf()) {
 					delete(pm.paths, pa.Name())
 					pa.Close()
 				}
@@ -262,6 +283,19 @@ outer:
 				Paths: paths,
 			}
 
+		case req := <-pm.apiPathsTrigger:
+			pa, ok := pm.paths[req.Name]
+			if !ok {
+				req.Res <- apiPathsTriggerRes{Err: fmt.Errorf("path not found")}
+				continue
+			}
+
+			req.Res <- apiPathsTriggerRes{Path: pa}
+
+		case req := <-pm.confGet:
+			_, pathConf, err := pm.findPathConf(req.PathName)
+			req.Res <- pathManagerConfGetRes{Conf: pathConf, Err: err}
+
 		case <-pm.ctx.Done():
 			break outer
 		}
@@ -287,6 +321,8 @@ func (pm *pathManager) createPath(confName string, conf *conf.PathConf, name str
 		name,
 		&pm.wg,
 		pm.stats,
+		pm.eventBus,
+		pm.clusterDir,
 		pm)
 }
 
@@ -296,19 +332,21 @@ func (pm *pathManager) findPathConf(name string) (string, *conf.PathConf, error)
 		return "", nil, fmt.Errorf("invalid path name: %s (%s)", err, name)
 	}
 
-	// normal path
-	if pathConf, ok := pm.pathConfs[name]; ok {
-		return name, pathConf, nil
+	confName, pathConf, err := conf.FindPathConf(pm.pathConfs, name)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// regular expression path
-	for pathName, pathConf := range pm.pathConfs {
-		if pathConf.Regexp != nil && pathConf.Regexp.MatchString(name) {
-			return pathName, pathConf, nil
+	// paths without a regular expression are checked when the configuration is loaded,
+	// the other ones when they are created.
+	if _, ok := pm.paths[name]; !ok && pathConf.Regexp != nil {
+		err = conf.CheckPathSourceCycle(pm.pathConfs, name)
+		if err != nil {
+			return "", nil, err
 		}
 	}
 
-	return "", nil, fmt.Errorf("unable to find a valid configuration for path '%s'", name)
+	return confName, pathConf, nil
 }
 
 func (pm *pathManager) authenticate(
@@ -318,6 +356,30 @@ func (pm *pathManager) authenticate(
 	pathIPs []interface{},
 	pathUser string,
 	pathPass string,
+) error {
+	err := pm.authenticateInner(ip, validateCredentials, pathIPs, pathUser, pathPass)
+
+	if terr, ok := err.(pathErrAuthCritical); ok {
+		e := eventbus.Event{
+			Type:    eventbus.TypeAuthFailure,
+			Path:    pathName,
+			Message: terr.Message,
+		}
+		if ip != nil {
+			e.RemoteAddr = ip.String()
+		}
+		pm.eventBus.Publish(e)
+	}
+
+	return err
+}
+
+func (pm *pathManager) authenticateInner(
+	ip net.IP,
+	validateCredentials func(pathUser string, pathPass string) error,
+	pathIPs []interface{},
+	pathUser string,
+	pathPass string,
 ) error {
 	// validate ip
 	if pathIPs != nil && ip != nil {
@@ -417,6 +479,18 @@ func (pm *pathManager) OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderS
 	}
 }
 
+// OnConfGet is called by hlsMuxer.
+func (pm *pathManager) OnConfGet(req pathManagerConfGetReq) pathManagerConfGetRes {
+	req.Res = make(chan pathManagerConfGetRes)
+	select {
+	case pm.confGet <- req:
+		return <-req.Res
+
+	case <-pm.ctx.Done():
+		return pathManagerConfGetRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnHLSServerSet is called by hlsServer.
 func (pm *pathManager) OnHLSServerSet(s pathManagerHLSServer) {
 	select {
@@ -446,3 +520,21 @@ func (pm *pathManager) OnAPIPathsList(req apiPathsListReq1) apiPathsListRes1 {
 		return apiPathsListRes1{Err: fmt.Errorf("terminated")}
 	}
 }
+
+// OnAPIPathsTrigger is called by api.
+func (pm *pathManager) OnAPIPathsTrigger(req apiPathsTriggerReq) apiPathsTriggerRes {
+	req.Res = make(chan apiPathsTriggerRes)
+	select {
+	case pm.apiPathsTrigger <- req:
+		res := <-req.Res
+		if res.Err != nil {
+			return res
+		}
+
+		res.Err = res.Path.OnAPIPathsTrigger(pathAPIPathsTriggerReq{Reason: req.Reason})
+		return res
+
+	case <-pm.ctx.Done():
+		return apiPathsTriggerRes{Err: fmt.Errorf("terminated")}
+	}
+}
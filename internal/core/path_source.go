@@ -0,0 +1,430 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
+)
+
+const (
+	pathSourceRetryPause = 5 * time.Second
+
+	// maximum size of the frames that are waiting to be sent after a delay.
+	pathSourceDelayQueueMaxSize = 64 * 1024 * 1024
+)
+
+type pathSourcePathManager interface {
+	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+}
+
+type pathSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+type pathSourceFrame struct {
+	trackID int
+	payload []byte
+	t       time.Time
+	ntp     time.Time // capture time, as reported by the source path
+}
+
+// pathSourceReader is the reader of the path that is read by a pathSource.
+type pathSourceReader struct {
+	source    *pathSource
+	ctxCancel func()
+	trackIDs  []int // track IDs of the path, mapped to track IDs of the source
+	delay     time.Duration
+	stream    *stream
+	buffer    *readerBuffer
+}
+
+// Close implements reader.
+func (r *pathSourceReader) Close() {
+	r.ctxCancel()
+}
+
+// OnReaderAccepted implements reader.
+func (r *pathSourceReader) OnReaderAccepted() {
+	r.source.log(logger.Info, "is reading")
+}
+
+// OnReaderFrame implements reader.
+func (r *pathSourceReader) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType != gortsplib.StreamTypeRTP {
+		return
+	}
+
+	ntp, _ := r.stream.ntpOfFrame(trackID, payload)
+
+	trackID = r.trackIDs[trackID]
+	if trackID < 0 {
+		return
+	}
+
+	f := pathSourceFrame{
+		trackID: trackID,
+		payload: payload,
+		ntp:     ntp,
+	}
+
+	// frames are stored for a long time, detach them from the buffers of the source
+	if r.delay != 0 {
+		f.payload = append([]byte(nil), payload...)
+		f.t = time.Now()
+	}
+
+	r.buffer.push(trackID, streamType, payload, f)
+}
+
+// OnReaderAPIDescribe implements reader.
+func (r *pathSourceReader) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"pathSource"}
+}
+
+// pathSourceDelayQueue contains the frames that are waiting to be sent after a delay.
+// When the size of the frames exceeds maxSize, the oldest frames are dropped,
+// up to the next H264 keyframe, in order not to corrupt the stream.
+type pathSourceDelayQueue struct {
+	videoTrackID int
+	maxSize      int
+
+	mutex  sync.Mutex
+	frames []pathSourceFrame
+	size   int
+	notify chan struct{}
+}
+
+func newPathSourceDelayQueue(videoTrackID int, maxSize int) *pathSourceDelayQueue {
+	return &pathSourceDelayQueue{
+		videoTrackID: videoTrackID,
+		maxSize:      maxSize,
+		notify:       make(chan struct{}, 1),
+	}
+}
+
+// push adds a frame to the queue and returns the number of frames that were dropped.
+func (q *pathSourceDelayQueue) push(f pathSourceFrame) int {
+	q.mutex.Lock()
+	q.frames = append(q.frames, f)
+	q.size += len(f.payload)
+
+	dropped := 0
+	for q.size > q.maxSize && len(q.frames) > 0 {
+		q.popUnsafe()
+		dropped++
+
+		// drop the rest of the GOP
+		if q.videoTrackID >= 0 {
+			for len(q.frames) > 0 && !q.isRandomAccess(q.frames[0]) {
+				q.popUnsafe()
+				dropped++
+			}
+		}
+	}
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return dropped
+}
+
+func (q *pathSourceDelayQueue) isRandomAccess(f pathSourceFrame) bool {
+	if f.trackID != q.videoTrackID {
+		return false
+	}
+
+	var pkt rtp.Packet
+	err := pkt.Unmarshal(f.payload)
+	return err == nil && rtph264.IsRandomAccess(pkt.Payload)
+}
+
+func (q *pathSourceDelayQueue) first() (pathSourceFrame, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.frames) == 0 {
+		return pathSourceFrame{}, false
+	}
+	return q.frames[0], true
+}
+
+func (q *pathSourceDelayQueue) pop() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.popUnsafe()
+}
+
+func (q *pathSourceDelayQueue) popUnsafe() {
+	q.size -= len(q.frames[0].payload)
+	q.frames[0] = pathSourceFrame{}
+	q.frames = q.frames[1:]
+}
+
+// pathSource is a static source that reads the stream of another path.
+type pathSource struct {
+	ur              string
+	readBufferCount int
+	wg              *sync.WaitGroup
+	pathManager     pathSourcePathManager
+	parent          pathSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newPathSource(
+	parentCtx context.Context,
+	ur string,
+	readBufferCount int,
+	wg *sync.WaitGroup,
+	pathManager pathSourcePathManager,
+	parent pathSourceParent) *pathSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &pathSource{
+		ur:              ur,
+		readBufferCount: readBufferCount,
+		wg:              wg,
+		pathManager:     pathManager,
+		parent:          parent,
+		ctx:             ctx,
+		ctxCancel:       ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *pathSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *pathSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[path source] "+format, args...)
+}
+
+func (s *pathSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(pathSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *pathSource) runInner() bool {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- s.runRead(innerCtx, innerCtxCancel)
+	}()
+
+	select {
+	case err := <-runErr:
+		innerCtxCancel()
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+
+	case <-s.ctx.Done():
+		innerCtxCancel()
+		<-runErr
+		return false
+	}
+}
+
+func (s *pathSource) runRead(ctx context.Context, ctxCancel func()) error {
+	u, err := conf.ParsePathSourceURL(s.ur)
+	if err != nil {
+		return err
+	}
+
+	r := &pathSourceReader{
+		source:    s,
+		ctxCancel: ctxCancel,
+		delay:     u.Delay,
+	}
+
+	res := s.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+		Author:              r,
+		PathName:            u.PathName,
+		IP:                  nil,
+		ValidateCredentials: nil,
+	})
+	if res.Err != nil {
+		return res.Err
+	}
+
+	defer res.Path.OnReaderRemove(pathReaderRemoveReq{Author: r})
+
+	r.stream = res.Stream
+
+	var tracks gortsplib.Tracks
+	videoTrackID := -1
+
+	for _, t := range res.Stream.tracks() {
+		if !pathSourceTrackSelected(u.Tracks, t) {
+			r.trackIDs = append(r.trackIDs, -1)
+			continue
+		}
+
+		if videoTrackID < 0 && t.IsH264() {
+			videoTrackID = len(tracks)
+		}
+
+		r.trackIDs = append(r.trackIDs, len(tracks))
+		tracks = append(tracks, t)
+	}
+
+	if len(tracks) == 0 {
+		return fmt.Errorf("path '%s' doesn't contain any of the requested tracks", u.PathName)
+	}
+
+	sres := s.parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		Source: s,
+		Tracks: tracks,
+	})
+	if sres.Err != nil {
+		return sres.Err
+	}
+
+	s.log(logger.Info, "ready")
+
+	defer func() {
+		s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
+	}()
+
+	rtcpSenders := rtcpsenderset.New(tracks, sres.Stream.onFrame)
+	defer rtcpSenders.Close()
+
+	writeFrame := func(f pathSourceFrame) {
+		// sender reports contain the capture time of the source path,
+		// that doesn't change when frames are delayed.
+		if !f.ntp.IsZero() {
+			rtcpSenders.OnFrameAt(f.trackID, gortsplib.StreamTypeRTP, f.payload, f.ntp)
+		} else {
+			rtcpSenders.OnFrame(f.trackID, gortsplib.StreamTypeRTP, f.payload)
+		}
+		sres.Stream.onFrame(f.trackID, gortsplib.StreamTypeRTP, f.payload)
+	}
+
+	r.buffer = newReaderBuffer(s.readBufferCount, videoTrackID, 0, new(uint64))
+
+	go func() {
+		<-ctx.Done()
+		r.buffer.close()
+	}()
+
+	res.Path.OnReaderPlay(pathReaderPlayReq{Author: r})
+
+	if u.Delay == 0 {
+		for {
+			data, err := r.buffer.pull()
+			if err != nil {
+				return err
+			}
+
+			writeFrame(data.(pathSourceFrame))
+		}
+	}
+
+	// frames are moved from the buffer, that is limited, into the delay queue,
+	// that is able to contain all the frames received during the delay.
+	queue := newPathSourceDelayQueue(videoTrackID, pathSourceDelayQueueMaxSize)
+
+	readDone := make(chan error)
+	go func() {
+		readDone <- func() error {
+			for {
+				data, err := r.buffer.pull()
+				if err != nil {
+					return err
+				}
+
+				dropped := queue.push(data.(pathSourceFrame))
+				if dropped > 0 {
+					s.log(logger.Warn, "delay queue is full, %d frames dropped", dropped)
+				}
+			}
+		}()
+	}()
+
+	timer := newEmptyTimer()
+	defer timer.Stop()
+
+	for {
+		f, ok := queue.first()
+		if ok {
+			wait := time.Until(f.t.Add(u.Delay))
+			if wait <= 0 {
+				queue.pop()
+				writeFrame(f)
+				continue
+			}
+
+			timer.Stop()
+			timer = time.NewTimer(wait)
+		}
+
+		select {
+		case <-timer.C:
+		case <-queue.notify:
+		case err := <-readDone:
+			return err
+		}
+	}
+}
+
+// OnSourceAPIDescribe implements source.
+func (*pathSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"pathSource"}
+}
+
+func pathSourceTrackSelected(selected []string, t *gortsplib.Track) bool {
+	if selected == nil {
+		return true
+	}
+
+	for _, typ := range selected {
+		if t.Media.MediaName.Media == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathSourceDelayQueueDrop(t *testing.T) {
+	frame := func(naluType byte) pathSourceFrame {
+		byts, err := (&rtp.Packet{
+			Header: rtp.Header{
+				Version:     2,
+				PayloadType: 96,
+			},
+			Payload: []byte{naluType, 0x01, 0x02, 0x03},
+		}).Marshal()
+		require.NoError(t, err)
+
+		return pathSourceFrame{trackID: 0, payload: byts}
+	}
+
+	idr := frame(0x65)
+	nonIDR := frame(0x41)
+	size := len(idr.payload)
+
+	q := newPathSourceDelayQueue(0, 5*size)
+
+	// two GOPs of 3 frames
+	for i := 0; i < 2; i++ {
+		require.Equal(t, 0, q.push(idr))
+		require.Equal(t, 0, q.push(nonIDR))
+		if i == 0 {
+			require.Equal(t, 0, q.push(nonIDR))
+		}
+	}
+
+	// the first GOP is dropped entirely
+	require.Equal(t, 3, q.push(nonIDR))
+	require.Equal(t, 3, len(q.frames))
+	require.Equal(t, 3*size, q.size)
+
+	f, ok := q.first()
+	require.Equal(t, true, ok)
+	require.Equal(t, idr, f)
+}
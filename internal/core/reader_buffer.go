@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/framedropper"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+)
+
+// readerBuffer contains the frames that are waiting to be processed by a non-RTSP reader.
+// When the reader can't keep up with the stream, frames are dropped without
+// corrupting the stream, and the buffer is closed if the reader keeps lagging
+// behind for longer than slowReaderTimeout.
+type readerBuffer struct {
+	ringBuffer        *ringbuffer.RingBuffer
+	frameDropper      *framedropper.FrameDropper
+	slowReaderTimeout time.Duration
+	framesDropped     *uint64
+	slow              *int32
+
+	// the frame dropper can't be used by multiple goroutines, while
+	// the stream can call push from multiple goroutines (UDP listeners, RTCP senders).
+	mutex sync.Mutex
+}
+
+func newReaderBuffer(
+	size int,
+	videoTrackID int,
+	slowReaderTimeout time.Duration,
+	framesDropped *uint64) *readerBuffer {
+	return &readerBuffer{
+		ringBuffer:        ringbuffer.New(uint64(size)),
+		frameDropper:      framedropper.New(videoTrackID, uint64(size)),
+		slowReaderTimeout: slowReaderTimeout,
+		framesDropped:     framesDropped,
+		slow: func() *int32 {
+			v := int32(0)
+			return &v
+		}(),
+	}
+}
+
+func (b *readerBuffer) close() {
+	b.ringBuffer.Close()
+}
+
+// push is called by the stream.
+// It can be called by multiple goroutines.
+func (b *readerBuffer) push(trackID int, streamType gortsplib.StreamType, payload []byte, data interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if atomic.LoadInt32(b.slow) == 1 {
+		return
+	}
+
+	ok := b.frameDropper.Filter(trackID, streamType, payload, b.ringBuffer.Len())
+	if !ok {
+		atomic.AddUint64(b.framesDropped, 1)
+	}
+
+	if b.slowReaderTimeout != 0 && b.frameDropper.Behind() >= b.slowReaderTimeout {
+		atomic.StoreInt32(b.slow, 1)
+		b.ringBuffer.Close()
+		return
+	}
+
+	if ok {
+		b.ringBuffer.Push(data)
+	}
+}
+
+// pull is called by the reader.
+func (b *readerBuffer) pull() (interface{}, error) {
+	data, ok := b.ringBuffer.Pull()
+	if !ok {
+		if atomic.LoadInt32(b.slow) == 1 {
+			return nil, fmt.Errorf("reader is too slow")
+		}
+		return nil, fmt.Errorf("terminated")
+	}
+	return data, nil
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderBufferSlowReader(t *testing.T) {
+	framesDropped := uint64(0)
+	b := newReaderBuffer(8, 0, 100*time.Millisecond, &framesDropped)
+
+	frame, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+		},
+		Payload: []byte{0x41, 0x9a, 0x24},
+	}).Marshal()
+	require.NoError(t, err)
+
+	// the reader never pulls frames
+	for i := 0; i < 8; i++ {
+		b.push(0, gortsplib.StreamTypeRTP, frame, i)
+	}
+	require.Equal(t, uint64(1), framesDropped)
+
+	time.Sleep(100 * time.Millisecond)
+	b.push(0, gortsplib.StreamTypeRTP, frame, 8)
+
+	for i := 0; i < 7; i++ {
+		data, err := b.pull()
+		require.NoError(t, err)
+		require.Equal(t, i, data)
+	}
+
+	_, err = b.pull()
+	require.EqualError(t, err, "reader is too slow")
+}
+
+func TestReaderBufferConcurrentPush(t *testing.T) {
+	framesDropped := uint64(0)
+	b := newReaderBuffer(16, 0, 0, &framesDropped)
+	defer b.close()
+
+	frame, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+			Marker:      true,
+		},
+		Payload: []byte{0x65, 0x88, 0x84},
+	}).Marshal()
+	require.NoError(t, err)
+
+	// the stream can call push from multiple goroutines.
+	// The reader doesn't pull frames, therefore the frame dropper changes state.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.push(0, gortsplib.StreamTypeRTP, frame, j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NotEqual(t, uint64(0), framesDropped)
+}
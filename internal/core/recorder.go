@@ -0,0 +1,565 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mp4"
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
+)
+
+const (
+	// an offset is needed to avoid negative timestamps,
+	// since audio can precede the first video frame.
+	recorderPTSOffset = 2 * time.Second
+)
+
+type recorderTrackIDPayloadPair struct {
+	trackID int
+	buf     []byte
+	time    time.Time
+	ntp     time.Time
+}
+
+// recorderTrigger is an event that starts or extends a recording.
+type recorderTrigger struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// recorderAU is an access unit of the pre-roll buffer.
+type recorderAU struct {
+	time    time.Time // arrival time
+	ntp     time.Time // capture time
+	trackID int
+	pts     time.Duration
+	nalus   [][]byte // H264
+	aus     [][]byte // AAC
+	idr     bool
+}
+
+// recorderSidecar is the description of a recording, that is saved next to it.
+// Start and end are the capture times of the first and last frames.
+type recorderSidecar struct {
+	Path     string            `json:"path"`
+	File     string            `json:"file"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	PreRoll  time.Duration     `json:"preRoll"`
+	PostRoll time.Duration     `json:"postRoll"`
+	Triggers []recorderTrigger `json:"triggers"`
+}
+
+// recorderWriter is implemented by the writers of the supported record formats.
+type recorderWriter interface {
+	WriteH264(time.Duration, time.Duration, bool, [][]byte) error
+	WriteAAC(time.Duration, []byte) error
+	Close() error
+}
+
+// recorderTSWriter flushes the buffer of a MPEG-TS writer when closed.
+type recorderTSWriter struct {
+	*mpegts.Writer
+	bw *bufio.Writer
+}
+
+func (w *recorderTSWriter) Close() error {
+	return w.bw.Flush()
+}
+
+type recorderRecording struct {
+	fpath        string
+	f            *os.File
+	w            recorderWriter
+	videoDTSExtr *h264.DTSExtractor
+	startPTS     time.Duration
+	start        time.Time
+	last         time.Time
+	end          time.Time
+	triggers     []recorderTrigger
+}
+
+type recorderParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// recorder keeps the last seconds of a path in memory and, when triggered,
+// writes them into a file, together with the frames that follow.
+type recorder struct {
+	readBufferCount int
+	wg              *sync.WaitGroup
+	pathName        string
+	pathConf        *conf.PathConf
+	stream          *stream
+	parent          recorderParent
+
+	ctx           context.Context
+	ctxCancel     func()
+	videoTrackID  int
+	h264Conf      *gortsplib.TrackConfigH264
+	audioTrackID  int
+	aacConf       *gortsplib.TrackConfigAAC
+	buffer        *readerBuffer
+	framesDropped *uint64
+	preRoll       []*recorderAU
+	rec           *recorderRecording
+
+	triggersMutex sync.Mutex
+	triggers      []recorderTrigger
+	hold          bool
+}
+
+func newRecorder(
+	parentCtx context.Context,
+	readBufferCount int,
+	wg *sync.WaitGroup,
+	pathName string,
+	pathConf *conf.PathConf,
+	stream *stream,
+	parent recorderParent) (*recorder, error) {
+	r := &recorder{
+		readBufferCount: readBufferCount,
+		wg:              wg,
+		pathName:        pathName,
+		pathConf:        pathConf,
+		stream:          stream,
+		parent:          parent,
+		videoTrackID:    -1,
+		audioTrackID:    -1,
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
+	}
+
+	for i, t := range stream.tracks() {
+		if t.IsH264() {
+			if r.h264Conf != nil {
+				return nil, fmt.Errorf("can't record track %d: too many tracks", i+1)
+			}
+
+			var err error
+			r.h264Conf, err = t.ExtractConfigH264()
+			if err != nil {
+				return nil, err
+			}
+			r.videoTrackID = i
+
+		} else if t.IsAAC() {
+			if r.aacConf != nil {
+				return nil, fmt.Errorf("can't record track %d: too many tracks", i+1)
+			}
+
+			var err error
+			r.aacConf, err = t.ExtractConfigAAC()
+			if err != nil {
+				return nil, err
+			}
+			r.audioTrackID = i
+		}
+	}
+
+	if r.h264Conf == nil && r.aacConf == nil {
+		return nil, fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+	}
+
+	r.ctx, r.ctxCancel = context.WithCancel(parentCtx)
+
+	// the recorder is never considered a slow reader, in order not to
+	// interrupt recordings, that are written to disk.
+	r.buffer = newReaderBuffer(r.readBufferCount, r.videoTrackID, 0, r.framesDropped)
+
+	r.log(logger.Info, "created")
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r, nil
+}
+
+func (r *recorder) Close() {
+	r.ctxCancel()
+}
+
+func (r *recorder) log(level logger.Level, format string, args ...interface{}) {
+	r.parent.Log(level, "[recorder] "+format, args...)
+}
+
+// trigger starts a recording, or extends the current one.
+// It can be called by any goroutine.
+func (r *recorder) trigger(t recorderTrigger) {
+	r.triggersMutex.Lock()
+	defer r.triggersMutex.Unlock()
+	r.triggers = append(r.triggers, t)
+}
+
+// setHold prevents the current recording from ending, i.e. while motion is in progress.
+// It can be called by any goroutine.
+func (r *recorder) setHold(hold bool) {
+	r.triggersMutex.Lock()
+	defer r.triggersMutex.Unlock()
+	r.hold = hold
+}
+
+func (r *recorder) run() {
+	defer r.wg.Done()
+	defer r.log(logger.Info, "destroyed")
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- r.runInner()
+	}()
+
+	select {
+	case err := <-runErr:
+		r.log(logger.Info, "ERR: %s", err)
+
+	case <-r.ctx.Done():
+		r.buffer.close()
+		<-runErr
+	}
+
+	r.ctxCancel()
+}
+
+func (r *recorder) runInner() error {
+	defer r.recordingClose()
+
+	var h264Decoder *rtph264.Decoder
+	if r.h264Conf != nil {
+		h264Decoder = rtph264.NewDecoder()
+	}
+
+	var aacDecoder *rtpaac.Decoder
+	if r.aacConf != nil {
+		aacDecoder = rtpaac.NewDecoder(r.aacConf.SampleRate)
+	}
+
+	var videoBuf [][]byte
+
+	for {
+		data, err := r.buffer.pull()
+		if err != nil {
+			return err
+		}
+		pair := data.(recorderTrackIDPayloadPair)
+
+		var pkt rtp.Packet
+		err = pkt.Unmarshal(pair.buf)
+		if err != nil {
+			r.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			continue
+		}
+
+		if pair.trackID == r.videoTrackID {
+			nalus, pts, err := h264Decoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+					r.log(logger.Warn, "unable to decode video track: %v", err)
+				}
+				continue
+			}
+
+			// NALUs are kept in the pre-roll buffer, therefore they are copied
+			for _, nalu := range nalus {
+				videoBuf = append(videoBuf, append([]byte(nil), nalu...))
+			}
+
+			if !pkt.Marker {
+				continue
+			}
+
+			au := &recorderAU{
+				time:    pair.time,
+				ntp:     pair.ntp,
+				trackID: pair.trackID,
+				pts:     pts,
+				nalus:   videoBuf,
+			}
+			videoBuf = nil
+
+			for _, nalu := range au.nalus {
+				if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeIDR {
+					au.idr = true
+					break
+				}
+			}
+
+			r.processAU(au)
+
+		} else {
+			aus, pts, err := aacDecoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtpaac.ErrMorePacketsNeeded {
+					r.log(logger.Warn, "unable to decode audio track: %v", err)
+				}
+				continue
+			}
+
+			au := &recorderAU{
+				time:    pair.time,
+				ntp:     pair.ntp,
+				trackID: pair.trackID,
+				pts:     pts,
+			}
+			for _, a := range aus {
+				au.aus = append(au.aus, append([]byte(nil), a...))
+			}
+
+			r.processAU(au)
+		}
+	}
+}
+
+// isRandomAccess checks whether decoding can start from an access unit.
+func (r *recorder) isRandomAccess(au *recorderAU) bool {
+	if r.h264Conf != nil {
+		return au.idr
+	}
+	return true
+}
+
+func (r *recorder) processAU(au *recorderAU) {
+	r.preRollAdd(au)
+
+	// recordings start from a random access unit; until one is received,
+	// triggers are kept pending.
+	if r.rec == nil && len(r.preRoll) == 0 {
+		return
+	}
+
+	r.triggersMutex.Lock()
+	triggers := r.triggers
+	r.triggers = nil
+	hold := r.hold
+	r.triggersMutex.Unlock()
+
+	if r.rec == nil {
+		if len(triggers) == 0 {
+			return
+		}
+
+		err := r.recordingOpen()
+		if err != nil {
+			r.log(logger.Warn, "unable to start recording: %s", err)
+			return
+		}
+	} else {
+		err := r.recordingWrite(au)
+		if err != nil {
+			r.log(logger.Warn, "unable to write recording: %s", err)
+			r.recordingClose()
+			return
+		}
+	}
+
+	for _, t := range triggers {
+		r.log(logger.Info, "triggered by %s", t.Source)
+		r.rec.triggers = append(r.rec.triggers, t)
+		if end := t.Time.Add(r.pathConf.RecordPostRoll); end.After(r.rec.end) {
+			r.rec.end = end
+		}
+	}
+
+	if hold {
+		if end := au.time.Add(r.pathConf.RecordPostRoll); end.After(r.rec.end) {
+			r.rec.end = end
+		}
+	}
+
+	if au.time.After(r.rec.end) {
+		r.recordingClose()
+	}
+}
+
+// preRollAdd adds an access unit to the pre-roll buffer, and removes the
+// oldest GOPs, provided that the buffer still contains at least the pre-roll duration.
+func (r *recorder) preRollAdd(au *recorderAU) {
+	if len(r.preRoll) == 0 && !r.isRandomAccess(au) {
+		return
+	}
+
+	r.preRoll = append(r.preRoll, au)
+
+	limit := au.time.Add(-r.pathConf.RecordPreRoll)
+	cut := 0
+	for i, e := range r.preRoll {
+		if e.time.After(limit) {
+			break
+		}
+		if r.isRandomAccess(e) {
+			cut = i
+		}
+	}
+
+	if cut > 0 {
+		r.preRoll = append([]*recorderAU(nil), r.preRoll[cut:]...)
+	}
+}
+
+// recordingOpen opens a file and writes the pre-roll buffer into it.
+func (r *recorder) recordingOpen() error {
+	start := r.preRoll[0].ntp
+	fpath := recorderFilePath(r.pathConf.RecordPath, r.pathName, start) + "." + r.pathConf.RecordFormat
+
+	err := os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+
+	var w recorderWriter
+	if r.pathConf.RecordFormat == "mp4" {
+		w, err = mp4.NewWriter(f, r.h264Conf, r.aacConf)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	} else {
+		bw := bufio.NewWriter(f)
+		w = &recorderTSWriter{
+			Writer: mpegts.NewWriter(bw, r.h264Conf, r.aacConf),
+			bw:     bw,
+		}
+	}
+
+	r.rec = &recorderRecording{
+		fpath:    fpath,
+		f:        f,
+		w:        w,
+		startPTS: r.preRoll[0].pts,
+		start:    start,
+	}
+
+	if r.h264Conf != nil {
+		r.rec.videoDTSExtr = h264.NewDTSExtractor(r.h264Conf.SPS, r.h264Conf.PPS)
+	}
+
+	r.log(logger.Info, "recording to %s", fpath)
+
+	for _, au := range r.preRoll {
+		err := r.recordingWrite(au)
+		if err != nil {
+			r.recordingClose()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *recorder) recordingWrite(au *recorderAU) error {
+	r.rec.last = au.ntp
+
+	pts := au.pts - r.rec.startPTS + recorderPTSOffset
+
+	if au.trackID == r.videoTrackID {
+		return r.rec.w.WriteH264(r.rec.videoDTSExtr.Extract(au.nalus, pts), pts, au.idr, au.nalus)
+	}
+
+	for i, a := range au.aus {
+		auPTS := pts + time.Duration(i)*1000*time.Second/time.Duration(r.aacConf.SampleRate)
+
+		err := r.rec.w.WriteAAC(auPTS, a)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingClose closes the file and writes the sidecar file.
+func (r *recorder) recordingClose() {
+	if r.rec == nil {
+		return
+	}
+
+	rec := r.rec
+	r.rec = nil
+
+	err := rec.w.Close()
+	if err != nil {
+		r.log(logger.Warn, "unable to write recording: %s", err)
+	}
+	rec.f.Close()
+
+	sidecar := recorderSidecar{
+		Path:     r.pathName,
+		File:     filepath.Base(rec.fpath),
+		Start:    rec.start,
+		End:      rec.last,
+		PreRoll:  r.pathConf.RecordPreRoll,
+		PostRoll: r.pathConf.RecordPostRoll,
+		Triggers: rec.triggers,
+	}
+
+	byts, _ := json.MarshalIndent(sidecar, "", "  ")
+	err = ioutil.WriteFile(strings.TrimSuffix(rec.fpath, filepath.Ext(rec.fpath))+".json", byts, 0o644)
+	if err != nil {
+		r.log(logger.Warn, "unable to write recording description: %s", err)
+	}
+
+	r.log(logger.Info, "recording to %s completed", rec.fpath)
+}
+
+// OnReaderAccepted implements reader.
+func (r *recorder) OnReaderAccepted() {
+}
+
+// OnReaderFrame implements reader.
+func (r *recorder) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP &&
+		(trackID == r.videoTrackID || trackID == r.audioTrackID) {
+		now := time.Now()
+
+		// when the capture time is not available, the arrival time is used
+		ntp, ok := r.stream.ntpOfFrame(trackID, payload)
+		if !ok {
+			ntp = now
+		}
+
+		r.buffer.push(trackID, streamType, payload, recorderTrackIDPayloadPair{trackID, payload, now, ntp})
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (r *recorder) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"recorder"}
+}
+
+// recorderFilePath fills a recording path pattern, in which %path is replaced
+// with the path name and %Y, %m, %d, %H, %M, %S with the date of the recording.
+func recorderFilePath(pattern string, pathName string, t time.Time) string {
+	return strings.NewReplacer(
+		"%path", pathName,
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%H", t.Format("15"),
+		"%M", t.Format("04"),
+		"%S", t.Format("05"),
+	).Replace(pattern)
+}
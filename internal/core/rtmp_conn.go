@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,14 +10,13 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aler9/gortsplib"
-	"github.com/aler9/gortsplib/pkg/ringbuffer"
 	"github.com/aler9/gortsplib/pkg/rtpaac"
 	"github.com/aler9/gortsplib/pkg/rtph264"
 	"github.com/notedit/rtmp/av"
-	"github.com/pion/rtp"
 
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
 	"github.com/aler9/rtsp-simple-server/internal/h264"
@@ -47,6 +47,87 @@ type rtmpConnTrackIDPayloadPair struct {
 	buf     []byte
 }
 
+// rtmpH264Remuxer converts the H264 NALUs received from a RTMP publisher into NALUs
+// that can be routed to the path. SPS and PPS sent with an updated AVC sequence header
+// during the stream are sent in-band, before the next IDR.
+type rtmpH264Remuxer struct {
+	sps     []byte
+	pps     []byte
+	changed bool
+}
+
+func newRTMPH264Remuxer(track *gortsplib.Track) *rtmpH264Remuxer {
+	r := &rtmpH264Remuxer{}
+	if conf, err := track.ExtractConfigH264(); err == nil {
+		r.sps, r.pps = conf.SPS, conf.PPS
+	}
+	return r
+}
+
+// onDecoderConfig is called when a AVC sequence header is received.
+func (r *rtmpH264Remuxer) onDecoderConfig(byts []byte) error {
+	conf, err := rtmp.DecodeH264DecoderConfig(byts)
+	if err != nil {
+		return err
+	}
+
+	// the sequence header can be sent again without changes
+	if bytes.Equal(conf.SPS, r.sps) && bytes.Equal(conf.PPS, r.pps) {
+		return nil
+	}
+
+	r.sps = conf.SPS
+	r.pps = conf.PPS
+	r.changed = true
+	return nil
+}
+
+func (r *rtmpH264Remuxer) remux(nalus [][]byte) [][]byte {
+	var outNALUs [][]byte
+	idr := false
+
+	for _, nalu := range nalus {
+		typ := h264.NALUType(nalu[0] & 0x1F)
+		switch typ {
+		// remove SPS, PPS and AUD, not needed by RTSP
+		case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+			continue
+
+		case h264.NALUTypeIDR:
+			idr = true
+		}
+
+		outNALUs = append(outNALUs, nalu)
+	}
+
+	if idr && r.changed {
+		r.changed = false
+		outNALUs = append([][]byte{r.sps, r.pps}, outNALUs...)
+	}
+
+	return outNALUs
+}
+
+// rtmpAACConfigChanged checks whether an AAC sequence header received during the stream
+// differs from the configuration of the track. Since the AAC configuration is not
+// transmitted in-band, changes can't be applied without recreating the track.
+func rtmpAACConfigChanged(track *gortsplib.Track, byts []byte) bool {
+	conf, err := track.ExtractConfigAAC()
+	if err != nil {
+		return false
+	}
+
+	var mpegConf rtpaac.MPEG4AudioConfig
+	err = mpegConf.Decode(byts)
+	if err != nil {
+		return true
+	}
+
+	return int(mpegConf.Type) != conf.Type ||
+		mpegConf.SampleRate != conf.SampleRate ||
+		mpegConf.ChannelCount != conf.ChannelCount
+}
+
 type rtmpConnPathManager interface {
 	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
 	OnPublisherAnnounce(req pathPublisherAnnounceReq) pathPublisherAnnounceRes
@@ -58,11 +139,13 @@ type rtmpConnParent interface {
 }
 
 type rtmpConn struct {
+	isTLS               bool
 	id                  string
 	rtspAddress         string
 	readTimeout         time.Duration
 	writeTimeout        time.Duration
 	readBufferCount     int
+	slowReaderTimeout   time.Duration
 	runOnConnect        string
 	runOnConnectRestart bool
 	wg                  *sync.WaitGroup
@@ -70,21 +153,24 @@ type rtmpConn struct {
 	pathManager         rtmpConnPathManager
 	parent              rtmpConnParent
 
-	ctx        context.Context
-	ctxCancel  func()
-	path       *path
-	ringBuffer *ringbuffer.RingBuffer // read
-	state      gortsplib.ServerSessionState
-	stateMutex sync.Mutex
+	ctx           context.Context
+	ctxCancel     func()
+	path          *path
+	buffer        *readerBuffer // read
+	framesDropped *uint64       // read
+	state         gortsplib.ServerSessionState
+	stateMutex    sync.Mutex
 }
 
 func newRTMPConn(
 	parentCtx context.Context,
+	isTLS bool,
 	id string,
 	rtspAddress string,
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
 	readBufferCount int,
+	slowReaderTimeout time.Duration,
 	runOnConnect string,
 	runOnConnectRestart bool,
 	wg *sync.WaitGroup,
@@ -94,11 +180,13 @@ func newRTMPConn(
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	c := &rtmpConn{
+		isTLS:               isTLS,
 		id:                  id,
 		rtspAddress:         rtspAddress,
 		readTimeout:         readTimeout,
 		writeTimeout:        writeTimeout,
 		readBufferCount:     readBufferCount,
+		slowReaderTimeout:   slowReaderTimeout,
 		runOnConnect:        runOnConnect,
 		runOnConnectRestart: runOnConnectRestart,
 		wg:                  wg,
@@ -107,6 +195,10 @@ func newRTMPConn(
 		parent:              parent,
 		ctx:                 ctx,
 		ctxCancel:           ctxCancel,
+		framesDropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
 	}
 
 	c.log(logger.Info, "opened")
@@ -140,6 +232,10 @@ func (c *rtmpConn) ip() net.IP {
 	return c.conn.NetConn().RemoteAddr().(*net.TCPAddr).IP
 }
 
+func (c *rtmpConn) framesDroppedCount() uint64 {
+	return atomic.LoadUint64(c.framesDropped)
+}
+
 func (c *rtmpConn) safeState() gortsplib.ServerSessionState {
 	c.stateMutex.Lock()
 	defer c.stateMutex.Unlock()
@@ -233,48 +329,19 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 	c.state = gortsplib.ServerSessionStateRead
 	c.stateMutex.Unlock()
 
-	var videoTrack *gortsplib.Track
-	videoTrackID := -1
-	var h264Decoder *rtph264.Decoder
-	var audioTrack *gortsplib.Track
-	audioTrackID := -1
-	var audioClockRate int
-	var aacDecoder *rtpaac.Decoder
-
-	for i, t := range res.Stream.tracks() {
-		if t.IsH264() {
-			if videoTrack != nil {
-				return fmt.Errorf("can't read track %d with RTMP: too many tracks", i+1)
-			}
-
-			videoTrack = t
-			videoTrackID = i
-			h264Decoder = rtph264.NewDecoder()
-
-		} else if t.IsAAC() {
-			if audioTrack != nil {
-				return fmt.Errorf("can't read track %d with RTMP: too many tracks", i+1)
-			}
-
-			audioTrack = t
-			audioTrackID = i
-			audioClockRate, _ = audioTrack.ClockRate()
-			aacDecoder = rtpaac.NewDecoder(audioClockRate)
-		}
-	}
-
-	if videoTrack == nil && audioTrack == nil {
-		return fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+	packetizer, err := newRTMPPacketizer(res.Stream.tracks(), "RTMP", c.log)
+	if err != nil {
+		return err
 	}
 
 	c.conn.NetConn().SetWriteDeadline(time.Now().Add(c.writeTimeout))
-	c.conn.WriteMetadata(videoTrack, audioTrack)
+	c.conn.WriteMetadata(packetizer.videoTrack, packetizer.audioTrack)
 
-	c.ringBuffer = ringbuffer.New(uint64(c.readBufferCount))
+	c.buffer = newReaderBuffer(c.readBufferCount, packetizer.videoTrackID, c.slowReaderTimeout, c.framesDropped)
 
 	go func() {
 		<-ctx.Done()
-		c.ringBuffer.Close()
+		c.buffer.close()
 	}()
 
 	c.path.OnReaderPlay(pathReaderPlayReq{
@@ -284,95 +351,19 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 	// disable read deadline
 	c.conn.NetConn().SetReadDeadline(time.Time{})
 
-	var videoBuf [][]byte
-	videoDTSEst := h264.NewDTSEstimator()
-
 	for {
-		data, ok := c.ringBuffer.Pull()
-		if !ok {
-			return fmt.Errorf("terminated")
+		data, err := c.buffer.pull()
+		if err != nil {
+			return err
 		}
 		pair := data.(rtmpConnTrackIDPayloadPair)
 
-		if videoTrack != nil && pair.trackID == videoTrackID {
-			var pkt rtp.Packet
-			err := pkt.Unmarshal(pair.buf)
-			if err != nil {
-				c.log(logger.Warn, "unable to decode RTP packet: %v", err)
-				continue
-			}
-
-			nalus, pts, err := h264Decoder.DecodeRTP(&pkt)
-			if err != nil {
-				if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
-					c.log(logger.Warn, "unable to decode video track: %v", err)
-				}
-				continue
-			}
-
-			for _, nalu := range nalus {
-				// remove SPS, PPS and AUD, not needed by RTMP
-				typ := h264.NALUType(nalu[0] & 0x1F)
-				switch typ {
-				case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
-					continue
-				}
-
-				videoBuf = append(videoBuf, nalu)
-			}
-
-			// RTP marker means that all the NALUs with the same PTS have been received.
-			// send them together.
-			if pkt.Marker {
-				data, err := h264.EncodeAVCC(videoBuf)
-				if err != nil {
-					return err
-				}
-
-				dts := videoDTSEst.Feed(pts + rtmpConnPTSOffset)
-				c.conn.NetConn().SetWriteDeadline(time.Now().Add(c.writeTimeout))
-				err = c.conn.WritePacket(av.Packet{
-					Type:  av.H264,
-					Data:  data,
-					Time:  dts,
-					CTime: pts + rtmpConnPTSOffset - dts,
-				})
-				if err != nil {
-					return err
-				}
-
-				videoBuf = nil
-			}
-
-		} else if audioTrack != nil && pair.trackID == audioTrackID {
-			var pkt rtp.Packet
-			err := pkt.Unmarshal(pair.buf)
-			if err != nil {
-				c.log(logger.Warn, "unable to decode RTP packet: %v", err)
-				continue
-			}
-
-			aus, pts, err := aacDecoder.DecodeRTP(&pkt)
-			if err != nil {
-				if err != rtpaac.ErrMorePacketsNeeded {
-					c.log(logger.Warn, "unable to decode audio track: %v", err)
-				}
-				continue
-			}
-
-			for i, au := range aus {
-				auPTS := pts + rtmpConnPTSOffset + time.Duration(i)*1000*time.Second/time.Duration(audioClockRate)
-
-				c.conn.NetConn().SetWriteDeadline(time.Now().Add(c.writeTimeout))
-				err := c.conn.WritePacket(av.Packet{
-					Type: av.AAC,
-					Data: au,
-					Time: auPTS,
-				})
-				if err != nil {
-					return err
-				}
-			}
+		err = packetizer.process(pair.trackID, pair.buf, func(pkt av.Packet) error {
+			c.conn.NetConn().SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			return c.conn.WritePacket(pkt)
+		})
+		if err != nil {
+			return err
 		}
 	}
 }
@@ -389,8 +380,10 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 	audioTrackID := -1
 
 	var h264Encoder *rtph264.Encoder
+	var h264Remuxer *rtmpH264Remuxer
 	if videoTrack != nil {
 		h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
+		h264Remuxer = newRTMPH264Remuxer(videoTrack)
 		videoTrackID = len(tracks)
 		tracks = append(tracks, videoTrack)
 	}
@@ -460,29 +453,42 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 		}
 
 		switch pkt.Type {
-		case av.H264:
+		case av.Metadata:
+			// metadata can be sent again during the stream;
+			// tracks have already been set up, therefore it is ignored.
+			continue
+
+		case av.H264DecoderConfig:
 			if videoTrack == nil {
-				return fmt.Errorf("ERR: received an H264 frame, but track is not set up")
+				continue
 			}
 
-			nalus, err := h264.DecodeAVCC(pkt.Data)
+			err := h264Remuxer.onDecoderConfig(pkt.Data)
 			if err != nil {
 				return err
 			}
 
-			var outNALUs [][]byte
+		case av.AACDecoderConfig:
+			if audioTrack == nil {
+				continue
+			}
+
+			if rtmpAACConfigChanged(audioTrack, pkt.Data) {
+				c.log(logger.Warn, "the AAC configuration changed during the stream, the new configuration is ignored")
+			}
 
-			for _, nalu := range nalus {
-				// remove SPS, PPS and AUD, not needed by RTSP
-				typ := h264.NALUType(nalu[0] & 0x1F)
-				switch typ {
-				case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
-					continue
-				}
+		case av.H264:
+			// frames of tracks that were not found during the probe are discarded
+			if videoTrack == nil {
+				continue
+			}
 
-				outNALUs = append(outNALUs, nalu)
+			nalus, err := h264.DecodeAVCC(pkt.Data)
+			if err != nil {
+				return err
 			}
 
+			outNALUs := h264Remuxer.remux(nalus)
 			if len(outNALUs) == 0 {
 				continue
 			}
@@ -498,7 +504,7 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 
 		case av.AAC:
 			if audioTrack == nil {
-				return fmt.Errorf("ERR: received an AAC frame, but track is not set up")
+				continue
 			}
 
 			frames, err := aacEncoder.Encode([][]byte{pkt.Data}, pkt.Time+pkt.CTime)
@@ -539,24 +545,38 @@ func (c *rtmpConn) OnReaderAccepted() {
 // OnReaderFrame implements reader.
 func (c *rtmpConn) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
 	if streamType == gortsplib.StreamTypeRTP {
-		c.ringBuffer.Push(rtmpConnTrackIDPayloadPair{trackID, payload})
+		c.buffer.push(trackID, streamType, payload, rtmpConnTrackIDPayloadPair{trackID, payload})
 	}
 }
 
 // OnReaderAPIDescribe implements reader.
 func (c *rtmpConn) OnReaderAPIDescribe() interface{} {
+	var typ string
+	if c.isTLS {
+		typ = "rtmpsConn"
+	} else {
+		typ = "rtmpConn"
+	}
+
 	return struct {
 		Type string `json:"type"`
 		ID   string `json:"id"`
-	}{"rtmpConn", c.id}
+	}{typ, c.id}
 }
 
 // OnSourceAPIDescribe implements source.
 func (c *rtmpConn) OnSourceAPIDescribe() interface{} {
+	var typ string
+	if c.isTLS {
+		typ = "rtmpsConn"
+	} else {
+		typ = "rtmpConn"
+	}
+
 	return struct {
 		Type string `json:"type"`
 		ID   string `json:"id"`
-	}{"rtmpConn", c.id}
+	}{typ, c.id}
 }
 
 // OnPublisherAccepted implements publisher.
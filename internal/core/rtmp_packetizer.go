@@ -0,0 +1,167 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/notedit/rtmp/av"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+// rtmpPacketizer converts the RTP packets of a H264 track and of an AAC track
+// into RTMP packets, that are used by both RTMP and FLV readers.
+type rtmpPacketizer struct {
+	log func(logger.Level, string, ...interface{})
+
+	videoTrack     *gortsplib.Track
+	videoTrackID   int
+	h264Decoder    *rtph264.Decoder
+	videoBuf       [][]byte
+	videoDTSExtr   *h264.DTSExtractor
+	audioTrack     *gortsplib.Track
+	audioTrackID   int
+	audioClockRate int
+	aacDecoder     *rtpaac.Decoder
+}
+
+func newRTMPPacketizer(
+	tracks gortsplib.Tracks,
+	protocolName string,
+	log func(logger.Level, string, ...interface{})) (*rtmpPacketizer, error) {
+	p := &rtmpPacketizer{
+		log:          log,
+		videoTrackID: -1,
+		audioTrackID: -1,
+	}
+
+	for i, t := range tracks {
+		if t.IsH264() {
+			if p.videoTrack != nil {
+				return nil, fmt.Errorf("can't read track %d with %s: too many tracks", i+1, protocolName)
+			}
+
+			p.videoTrack = t
+			p.videoTrackID = i
+			p.h264Decoder = rtph264.NewDecoder()
+			// SPS and PPS can also be sent in-band, in this case the
+			// DTS extractor falls back to estimating the DTS.
+			var sps, pps []byte
+			if conf, err := t.ExtractConfigH264(); err == nil {
+				sps, pps = conf.SPS, conf.PPS
+			}
+			p.videoDTSExtr = h264.NewDTSExtractor(sps, pps)
+
+		} else if t.IsAAC() {
+			if p.audioTrack != nil {
+				return nil, fmt.Errorf("can't read track %d with %s: too many tracks", i+1, protocolName)
+			}
+
+			p.audioTrack = t
+			p.audioTrackID = i
+			p.audioClockRate, _ = t.ClockRate()
+			p.aacDecoder = rtpaac.NewDecoder(p.audioClockRate)
+		}
+	}
+
+	if p.videoTrack == nil && p.audioTrack == nil {
+		return nil, fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+	}
+
+	return p, nil
+}
+
+// process decodes a RTP packet and passes the resulting RTMP packets to cb.
+func (p *rtmpPacketizer) process(trackID int, payload []byte, cb func(av.Packet) error) error {
+	if p.videoTrack != nil && trackID == p.videoTrackID {
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(payload)
+		if err != nil {
+			p.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			return nil
+		}
+
+		nalus, pts, err := p.h264Decoder.DecodeRTP(&pkt)
+		if err != nil {
+			if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+				p.log(logger.Warn, "unable to decode video track: %v", err)
+			}
+			return nil
+		}
+
+		for _, nalu := range nalus {
+			// remove SPS, PPS and AUD, not needed by RTMP
+			typ := h264.NALUType(nalu[0] & 0x1F)
+			switch typ {
+			case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+				continue
+			}
+
+			p.videoBuf = append(p.videoBuf, nalu)
+		}
+
+		// RTP marker means that all the NALUs with the same PTS have been received.
+		// send them together.
+		if pkt.Marker {
+			idrPresent := false
+			for _, nalu := range p.videoBuf {
+				if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeIDR {
+					idrPresent = true
+					break
+				}
+			}
+
+			data, err := h264.EncodeAVCC(p.videoBuf)
+			if err != nil {
+				return err
+			}
+
+			dts := p.videoDTSExtr.Extract(p.videoBuf, pts+rtmpConnPTSOffset)
+			p.videoBuf = nil
+
+			return cb(av.Packet{
+				Type:       av.H264,
+				Data:       data,
+				Time:       dts,
+				CTime:      pts + rtmpConnPTSOffset - dts,
+				IsKeyFrame: idrPresent,
+			})
+		}
+
+	} else if p.audioTrack != nil && trackID == p.audioTrackID {
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(payload)
+		if err != nil {
+			p.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			return nil
+		}
+
+		aus, pts, err := p.aacDecoder.DecodeRTP(&pkt)
+		if err != nil {
+			if err != rtpaac.ErrMorePacketsNeeded {
+				p.log(logger.Warn, "unable to decode audio track: %v", err)
+			}
+			return nil
+		}
+
+		for i, au := range aus {
+			auPTS := pts + rtmpConnPTSOffset + time.Duration(i)*1000*time.Second/time.Duration(p.audioClockRate)
+
+			err := cb(av.Packet{
+				Type: av.AAC,
+				Data: au,
+				Time: auPTS,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/aler9/gortsplib"
 
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -20,13 +22,16 @@ type rtmpServerParent interface {
 }
 
 type rtmpServer struct {
+	isTLS               bool
 	readTimeout         time.Duration
 	writeTimeout        time.Duration
 	readBufferCount     int
+	slowReaderTimeout   time.Duration
 	rtspAddress         string
 	runOnConnect        string
 	runOnConnectRestart bool
 	metrics             *metrics
+	eventBus            *eventbus.Bus
 	pathManager         *pathManager
 	parent              rtmpServerParent
 
@@ -48,27 +53,49 @@ func newRTMPServer(
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
 	readBufferCount int,
+	slowReaderTimeout time.Duration,
+	isTLS bool,
+	serverCert string,
+	serverKey string,
 	rtspAddress string,
 	runOnConnect string,
 	runOnConnectRestart bool,
 	metrics *metrics,
+	eventBus *eventbus.Bus,
 	pathManager *pathManager,
 	parent rtmpServerParent) (*rtmpServer, error) {
+	var tlsConfig *tls.Config
+	if isTLS {
+		cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
 	l, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, err
 	}
 
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &rtmpServer{
+		isTLS:               isTLS,
 		readTimeout:         readTimeout,
 		writeTimeout:        writeTimeout,
 		readBufferCount:     readBufferCount,
+		slowReaderTimeout:   slowReaderTimeout,
 		rtspAddress:         rtspAddress,
 		runOnConnect:        runOnConnect,
 		runOnConnectRestart: runOnConnectRestart,
 		metrics:             metrics,
+		eventBus:            eventBus,
 		pathManager:         pathManager,
 		parent:              parent,
 		ctx:                 ctx,
@@ -83,7 +110,11 @@ func newRTMPServer(
 	s.Log(logger.Info, "listener opened on %s", address)
 
 	if s.metrics != nil {
-		s.metrics.OnRTMPServerSet(s)
+		if !isTLS {
+			s.metrics.OnRTMPServerSet(s)
+		} else {
+			s.metrics.OnRTMPSServerSet(s)
+		}
 	}
 
 	s.wg.Add(1)
@@ -93,7 +124,20 @@ func newRTMPServer(
 }
 
 func (s *rtmpServer) Log(level logger.Level, format string, args ...interface{}) {
-	s.parent.Log(level, "[RTMP] "+format, append([]interface{}{}, args...)...)
+	label := func() string {
+		if s.isTLS {
+			return "RTMPS"
+		}
+		return "RTMP"
+	}()
+	s.parent.Log(level, "[%s] "+format, append([]interface{}{label}, args...)...)
+}
+
+func (s *rtmpServer) protocolName() string {
+	if s.isTLS {
+		return "rtmps"
+	}
+	return "rtmp"
 }
 
 func (s *rtmpServer) close() {
@@ -143,11 +187,13 @@ outer:
 
 			c := newRTMPConn(
 				s.ctx,
+				s.isTLS,
 				id,
 				s.rtspAddress,
 				s.readTimeout,
 				s.writeTimeout,
 				s.readBufferCount,
+				s.slowReaderTimeout,
 				s.runOnConnect,
 				s.runOnConnectRestart,
 				&s.wg,
@@ -156,12 +202,26 @@ outer:
 				s)
 			s.conns[c] = struct{}{}
 
+			s.eventBus.Publish(eventbus.Event{
+				Type:       eventbus.TypeConnOpen,
+				Protocol:   s.protocolName(),
+				ID:         id,
+				RemoteAddr: nconn.RemoteAddr().String(),
+			})
+
 		case c := <-s.connClose:
 			if _, ok := s.conns[c]; !ok {
 				continue
 			}
 			delete(s.conns, c)
 
+			s.eventBus.Publish(eventbus.Event{
+				Type:       eventbus.TypeConnClose,
+				Protocol:   s.protocolName(),
+				ID:         c.ID(),
+				RemoteAddr: c.RemoteAddr().String(),
+			})
+
 		case req := <-s.apiRTMPConnsList:
 			data := &apiRTMPConnsListData{
 				Items: make(map[string]apiRTMPConnsListItem),
@@ -180,6 +240,7 @@ outer:
 						}
 						return "idle"
 					}(),
+					FramesDropped: c.framesDroppedCount(),
 				}
 			}
 
@@ -212,7 +273,11 @@ outer:
 	s.l.Close()
 
 	if s.metrics != nil {
-		s.metrics.OnRTMPServerSet(s)
+		if !s.isTLS {
+			s.metrics.OnRTMPServerSet(nil)
+		} else {
+			s.metrics.OnRTMPSServerSet(nil)
+		}
 	}
 }
 
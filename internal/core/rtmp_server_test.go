@@ -1,9 +1,19 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/aler9/gortsplib"
+	"github.com/notedit/rtmp/av"
+	nh264 "github.com/notedit/rtmp/codec/h264"
+	"github.com/notedit/rtmp/format/flv/flvio"
+	nrtmp "github.com/notedit/rtmp/format/rtmp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -214,3 +224,180 @@ func TestRTMPServerAuthFail(t *testing.T) {
 		require.NotEqual(t, 0, cnt2.wait())
 	})
 }
+
+func TestRTMPSServer(t *testing.T) {
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	block, _ := pem.Decode(serverCert)
+	fingerprint := sha256.Sum256(block.Bytes)
+
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"api: yes\n" +
+		"protocols: [tcp]\n" +
+		"rtmpEncryption: strict\n" +
+		"rtmpServerCert: " + serverCertFpath + "\n" +
+		"rtmpServerKey: " + serverKeyFpath + "\n" +
+		"paths:\n" +
+		"  mystream:\n" +
+		"  proxied:\n" +
+		"    source: rtmps://localhost:1936/mystream\n" +
+		"    sourceOnDemand: yes\n" +
+		"    sourceFingerprint: " + hex.EncodeToString(fingerprint[:]) + "\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, nconn, err := nrtmp.NewClient().Dial("rtmps://localhost:1936/mystream", nrtmp.PrepareWriting)
+	require.NoError(t, err)
+	defer nconn.Close()
+
+	codec := nh264.Codec{
+		SPS: map[int][]byte{0: {
+			0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+			0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+			0x00, 0x03, 0x00, 0x3d, 0x08,
+		}},
+		PPS: map[int][]byte{0: {0x68, 0xee, 0x3c, 0x80}},
+	}
+	b := make([]byte, 128)
+	var n int
+	codec.ToConfig(b, &n)
+
+	err = conn.WritePacket(av.Packet{
+		Type: av.Metadata,
+		Data: flvio.FillAMF0ValMalloc(flvio.AMFMap{
+			{K: "videocodecid", V: float64(7)},
+		}),
+	})
+	require.NoError(t, err)
+
+	err = conn.WritePacket(av.Packet{Type: av.H264DecoderConfig, Data: b[:n]})
+	require.NoError(t, err)
+	err = conn.FlushWrite()
+	require.NoError(t, err)
+
+	terminate := make(chan struct{})
+	done := make(chan struct{})
+	defer func() { <-done }()
+	defer close(terminate)
+
+	go func() {
+		defer close(done)
+
+		for i := 0; ; i++ {
+			err := conn.WritePacket(av.Packet{
+				Type: av.H264,
+				Data: []byte{0x00, 0x00, 0x00, 0x02, 0x05, byte(i)},
+				Time: time.Duration(i) * 100 * time.Millisecond,
+			})
+			if err != nil {
+				return
+			}
+			conn.FlushWrite()
+
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-terminate:
+				return
+			}
+		}
+	}()
+
+	// the static source reads the stream through the RTMPS listener
+	var dest *gortsplib.ClientConn
+	for i := 0; ; i++ {
+		dest, err = gortsplib.DialRead("rtsp://localhost:8554/proxied")
+		if err == nil {
+			break
+		}
+
+		require.Less(t, i, 50, "source is not ready")
+		time.Sleep(100 * time.Millisecond)
+	}
+	defer dest.Close()
+
+	recv := make(chan struct{})
+	go func() {
+		dest.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+			if streamType == gortsplib.StreamTypeRTP {
+				select {
+				case recv <- struct{}{}:
+				default:
+				}
+			}
+		})
+	}()
+
+	select {
+	case <-recv:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	var out struct {
+		Items map[string]struct {
+			State string `json:"state"`
+		} `json:"items"`
+	}
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/rtmpsconns/list", nil, &out)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(out.Items))
+
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/rtmpconns/list", nil, &out)
+	require.EqualError(t, err, "bad status code: 404")
+}
+
+func TestRTMPH264RemuxerConfigUpdate(t *testing.T) {
+	sps1 := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	pps1 := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	sps2 := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+		0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+		0xcb,
+	}
+	pps2 := []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: sps1, PPS: pps1})
+	require.NoError(t, err)
+
+	r := newRTMPH264Remuxer(track)
+
+	decoderConfig := func(sps []byte, pps []byte) []byte {
+		codec := nh264.Codec{
+			SPS: map[int][]byte{0: sps},
+			PPS: map[int][]byte{0: pps},
+		}
+		b := make([]byte, 128)
+		var n int
+		codec.ToConfig(b, &n)
+		return b[:n]
+	}
+
+	// the same configuration is not sent in-band
+	err = r.onDecoderConfig(decoderConfig(sps1, pps1))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x65, 0x01}}, r.remux([][]byte{{0x65, 0x01}}))
+
+	err = r.onDecoderConfig(decoderConfig(sps2, pps2))
+	require.NoError(t, err)
+
+	// the new configuration is sent before the next IDR
+	require.Equal(t, [][]byte{{0x41, 0x01}}, r.remux([][]byte{{0x09, 0xF0}, {0x41, 0x01}}))
+	require.Equal(t, [][]byte{
+		sps2,
+		pps2,
+		{0x65, 0x01},
+	}, r.remux([][]byte{{0x09, 0xF0}, sps1, pps1, {0x65, 0x01}}))
+	require.Equal(t, [][]byte{{0x65, 0x01}}, r.remux([][]byte{{0x65, 0x01}}))
+}
@@ -2,7 +2,11 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +33,7 @@ type rtmpSourceParent interface {
 
 type rtmpSource struct {
 	ur           string
+	fingerprint  string
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	wg           *sync.WaitGroup
@@ -41,6 +46,7 @@ type rtmpSource struct {
 func newRTMPSource(
 	parentCtx context.Context,
 	ur string,
+	fingerprint string,
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
 	wg *sync.WaitGroup,
@@ -49,6 +55,7 @@ func newRTMPSource(
 
 	s := &rtmpSource{
 		ur:           ur,
+		fingerprint:  fingerprint,
 		readTimeout:  readTimeout,
 		writeTimeout: writeTimeout,
 		wg:           wg,
@@ -106,7 +113,22 @@ func (s *rtmpSource) runInner() bool {
 			ctx2, cancel2 := context.WithTimeout(innerCtx, s.readTimeout)
 			defer cancel2()
 
-			conn, err := rtmp.DialContext(ctx2, s.ur)
+			conn, err := rtmp.DialContext(ctx2, s.ur, &tls.Config{
+				InsecureSkipVerify: true,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					h := sha256.New()
+					h.Write(cs.PeerCertificates[0].Raw)
+					hstr := hex.EncodeToString(h.Sum(nil))
+					fingerprintLower := strings.ToLower(s.fingerprint)
+
+					if hstr != fingerprintLower {
+						return fmt.Errorf("server fingerprint do not match: expected %s, got %s",
+							fingerprintLower, hstr)
+					}
+
+					return nil
+				},
+			})
 			if err != nil {
 				return err
 			}
@@ -134,8 +156,10 @@ func (s *rtmpSource) runInner() bool {
 					audioTrackID := -1
 
 					var h264Encoder *rtph264.Encoder
+					var h264Remuxer *rtmpH264Remuxer
 					if videoTrack != nil {
 						h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
+						h264Remuxer = newRTMPH264Remuxer(videoTrack)
 						videoTrackID = len(tracks)
 						tracks = append(tracks, videoTrack)
 					}
@@ -178,9 +202,34 @@ func (s *rtmpSource) runInner() bool {
 						}
 
 						switch pkt.Type {
+						case av.Metadata:
+							// metadata can be sent again during the stream;
+							// tracks have already been set up, therefore it is ignored.
+							continue
+
+						case av.H264DecoderConfig:
+							if videoTrack == nil {
+								continue
+							}
+
+							err := h264Remuxer.onDecoderConfig(pkt.Data)
+							if err != nil {
+								return err
+							}
+
+						case av.AACDecoderConfig:
+							if audioTrack == nil {
+								continue
+							}
+
+							if rtmpAACConfigChanged(audioTrack, pkt.Data) {
+								s.log(logger.Warn, "the AAC configuration changed during the stream, the new configuration is ignored")
+							}
+
 						case av.H264:
+							// frames of tracks that were not found during the probe are discarded
 							if videoTrack == nil {
-								return fmt.Errorf("ERR: received an H264 frame, but track is not set up")
+								continue
 							}
 
 							nalus, err := h264.DecodeAVCC(pkt.Data)
@@ -188,16 +237,9 @@ func (s *rtmpSource) runInner() bool {
 								return err
 							}
 
-							var outNALUs [][]byte
-							for _, nalu := range nalus {
-								// remove SPS, PPS and AUD, not needed by RTSP / RTMP
-								typ := h264.NALUType(nalu[0] & 0x1F)
-								switch typ {
-								case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
-									continue
-								}
-
-								outNALUs = append(outNALUs, nalu)
+							outNALUs := h264Remuxer.remux(nalus)
+							if len(outNALUs) == 0 {
+								continue
 							}
 
 							pkts, err := h264Encoder.Encode(outNALUs, pkt.Time+pkt.CTime)
@@ -211,7 +253,7 @@ func (s *rtmpSource) runInner() bool {
 
 						case av.AAC:
 							if audioTrack == nil {
-								return fmt.Errorf("ERR: received an AAC frame, but track is not set up")
+								continue
 							}
 
 							pkts, err := aacEncoder.Encode([][]byte{pkt.Data}, pkt.Time+pkt.CTime)
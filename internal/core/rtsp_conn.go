@@ -220,8 +220,13 @@ func (c *rtspConn) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.
 	}
 
 	if res.Redirect != "" {
+		statusCode := base.StatusMovedPermanently
+		if res.RedirectTemporary {
+			statusCode = base.StatusFound
+		}
+
 		return &base.Response{
-			StatusCode: base.StatusMovedPermanently,
+			StatusCode: statusCode,
 			Header: base.Header{
 				"Location": base.HeaderValue{res.Redirect},
 			},
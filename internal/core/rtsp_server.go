@@ -15,6 +15,7 @@ import (
 	"github.com/aler9/gortsplib/pkg/headers"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -31,6 +32,7 @@ type rtspServer struct {
 	runOnConnect        string
 	runOnConnectRestart bool
 	metrics             *metrics
+	eventBus            *eventbus.Bus
 	pathManager         *pathManager
 	parent              rtspServerParent
 
@@ -51,6 +53,7 @@ func newRTSPServer(
 	writeTimeout time.Duration,
 	readBufferCount int,
 	readBufferSize int,
+	slowReaderTimeout time.Duration,
 	useUDP bool,
 	useMulticast bool,
 	rtpAddress string,
@@ -66,6 +69,7 @@ func newRTSPServer(
 	runOnConnect string,
 	runOnConnectRestart bool,
 	metrics *metrics,
+	eventBus *eventbus.Bus,
 	pathManager *pathManager,
 	parent rtspServerParent) (*rtspServer, error) {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
@@ -77,6 +81,7 @@ func newRTSPServer(
 		rtspAddress: rtspAddress,
 		protocols:   protocols,
 		metrics:     metrics,
+		eventBus:    eventBus,
 		pathManager: pathManager,
 		parent:      parent,
 		ctx:         ctx,
@@ -86,11 +91,15 @@ func newRTSPServer(
 	}
 
 	s.srv = &gortsplib.Server{
-		Handler:         s,
-		ReadTimeout:     readTimeout,
-		WriteTimeout:    writeTimeout,
-		ReadBufferCount: readBufferCount,
-		ReadBufferSize:  readBufferSize,
+		Handler:           s,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		ReadBufferCount:   readBufferCount,
+		ReadBufferSize:    readBufferSize,
+		SlowReaderTimeout: slowReaderTimeout,
+		SupportedFeatures: []string{
+			headers.FeatureTagONVIFReplay,
+		},
 	}
 
 	if useUDP {
@@ -152,6 +161,13 @@ func (s *rtspServer) Log(level logger.Level, format string, args ...interface{})
 	s.parent.Log(level, "[%s] "+format, append([]interface{}{label}, args...)...)
 }
 
+func (s *rtspServer) protocolName() string {
+	if s.isTLS {
+		return "rtsps"
+	}
+	return "rtsp"
+}
+
 func (s *rtspServer) close() {
 	s.ctxCancel()
 	s.wg.Wait()
@@ -240,6 +256,12 @@ func (s *rtspServer) OnConnOpen(ctx *gortsplib.ServerHandlerOnConnOpenCtx) {
 	s.mutex.Lock()
 	s.conns[ctx.Conn] = c
 	s.mutex.Unlock()
+
+	s.eventBus.Publish(eventbus.Event{
+		Type:       eventbus.TypeConnOpen,
+		Protocol:   s.protocolName(),
+		RemoteAddr: ctx.Conn.NetConn().RemoteAddr().String(),
+	})
 }
 
 // OnConnClose implements gortsplib.ServerHandlerOnConnClose.
@@ -250,6 +272,12 @@ func (s *rtspServer) OnConnClose(ctx *gortsplib.ServerHandlerOnConnCloseCtx) {
 	s.mutex.Unlock()
 
 	c.OnClose(ctx.Error)
+
+	s.eventBus.Publish(eventbus.Event{
+		Type:       eventbus.TypeConnClose,
+		Protocol:   s.protocolName(),
+		RemoteAddr: ctx.Conn.NetConn().RemoteAddr().String(),
+	})
 }
 
 // OnRequest implements gortsplib.ServerHandlerOnRequest.
@@ -288,6 +316,13 @@ func (s *rtspServer) OnSessionOpen(ctx *gortsplib.ServerHandlerOnSessionOpenCtx)
 
 	s.sessions[ctx.Session] = se
 	s.mutex.Unlock()
+
+	s.eventBus.Publish(eventbus.Event{
+		Type:       eventbus.TypeSessionOpen,
+		Protocol:   s.protocolName(),
+		ID:         id,
+		RemoteAddr: se.RemoteAddr().String(),
+	})
 }
 
 // OnSessionClose implements gortsplib.ServerHandlerOnSessionClose.
@@ -299,6 +334,13 @@ func (s *rtspServer) OnSessionClose(ctx *gortsplib.ServerHandlerOnSessionCloseCt
 
 	if se != nil {
 		se.OnClose()
+
+		s.eventBus.Publish(eventbus.Event{
+			Type:       eventbus.TypeSessionClose,
+			Protocol:   s.protocolName(),
+			ID:         se.ID(),
+			RemoteAddr: se.RemoteAddr().String(),
+		})
 	}
 }
 
@@ -390,6 +432,7 @@ func (s *rtspServer) OnAPIRTSPSessionsList(req apiRTSPSessionsListReq) apiRTSPSe
 				}
 				return "idle"
 			}(),
+			FramesDropped: s.ss.FramesDropped(),
 		}
 	}
 
@@ -13,6 +13,9 @@ import (
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
 	"github.com/aler9/gortsplib/pkg/headers"
+	"github.com/aler9/gortsplib/pkg/rtponvif"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -536,6 +539,91 @@ func TestRTSPServerPublisherOverride(t *testing.T) {
 	}
 }
 
+func TestRTSPServerGOPCache(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    gopCache: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	writePacket := func(seq uint16, ts uint32, payload []byte) {
+		byts, err := (&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: seq,
+				Timestamp:      ts,
+				SSRC:           0x9dbb7812,
+			},
+			Payload: payload,
+		}).Marshal()
+		require.NoError(t, err)
+		err = source.WriteFrame(0, gortsplib.StreamTypeRTP, byts)
+		require.NoError(t, err)
+	}
+
+	// a non-IDR frame, that is not cached
+	writePacket(100, 1000, []byte{0x01, 0x01})
+
+	// a IDR frame and a non-IDR frame, with a gap in sequence numbers
+	writePacket(101, 2000, []byte{0x05, 0x02})
+	writePacket(105, 3000, []byte{0x01, 0x03})
+
+	time.Sleep(500 * time.Millisecond)
+
+	dest, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+	require.NoError(t, err)
+	defer dest.Close()
+
+	type recvPacket struct {
+		seq     uint16
+		payload []byte
+	}
+
+	recv := make(chan recvPacket, 10)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		dest.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+			if streamType == gortsplib.StreamTypeRTP {
+				var pkt rtp.Packet
+				err := pkt.Unmarshal(payload)
+				require.NoError(t, err)
+				recv <- recvPacket{pkt.SequenceNumber, append([]byte(nil), pkt.Payload...)}
+			}
+		})
+	}()
+
+	writePacket(106, 4000, []byte{0x01, 0x04})
+
+	for _, expected := range []recvPacket{
+		{104, []byte{0x05, 0x02}},
+		{105, []byte{0x01, 0x03}},
+		{106, []byte{0x01, 0x04}},
+	} {
+		select {
+		case pkt := <-recv:
+			require.Equal(t, expected, pkt)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+		}
+	}
+
+	dest.Close()
+	<-readDone
+}
+
 func TestRTSPServerNonCompliantFrameSize(t *testing.T) {
 	t.Run("publish", func(t *testing.T) {
 		p, ok := newInstance("rtmpDisable: yes\n" +
@@ -612,6 +700,7 @@ func TestRTSPServerNonCompliantFrameSize(t *testing.T) {
 
 		p2, ok := newInstance("rtmpDisable: yes\n" +
 			"hlsDisable: yes\n" +
+			"flvDisable: yes\n" +
 			"protocols: [tcp]\n" +
 			"readBufferSize: 4500\n" +
 			"rtspAddress: :8555\n" +
@@ -738,6 +827,372 @@ func TestRTSPServerFallback(t *testing.T) {
 	}
 }
 
+func TestRTSPServerPathSource(t *testing.T) {
+	for _, ca := range []string{
+		"tracks",
+		"delay",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			src := func() string {
+				if ca == "tracks" {
+					return "path://teststream?tracks=video"
+				}
+				return "path://teststream?delay=1s"
+			}()
+
+			p, ok := newInstance("rtmpDisable: yes\n" +
+				"hlsDisable: yes\n" +
+				"protocols: [tcp]\n" +
+				"paths:\n" +
+				"  teststream:\n" +
+				"  copy:\n" +
+				"    source: " + src + "\n" +
+				"    sourceOnDemand: yes\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			videoTrack, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+			require.NoError(t, err)
+
+			audioTrack, err := gortsplib.NewTrackAAC(97, &gortsplib.TrackConfigAAC{Type: 2, SampleRate: 44100, ChannelCount: 2})
+			require.NoError(t, err)
+
+			source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+				gortsplib.Tracks{videoTrack, audioTrack})
+			require.NoError(t, err)
+			defer source.Close()
+
+			writeDone := make(chan struct{})
+			defer func() { <-writeDone }()
+			writeTerminate := make(chan struct{})
+			defer close(writeTerminate)
+
+			go func() {
+				defer close(writeDone)
+
+				t := time.NewTicker(50 * time.Millisecond)
+				defer t.Stop()
+
+				for seq := uint16(0); ; seq++ {
+					select {
+					case <-t.C:
+					case <-writeTerminate:
+						return
+					}
+
+					for trackID := 0; trackID < 2; trackID++ {
+						byts, _ := (&rtp.Packet{
+							Header: rtp.Header{
+								Version:        2,
+								PayloadType:    96 + uint8(trackID),
+								SequenceNumber: seq,
+								SSRC:           0x9dbb7812,
+							},
+							Payload: []byte{0x05, byte(trackID)},
+						}).Marshal()
+						source.WriteFrame(trackID, gortsplib.StreamTypeRTP, byts)
+					}
+				}
+			}()
+
+			start := time.Now()
+
+			dest, err := gortsplib.DialRead("rtsp://localhost:8554/copy")
+			require.NoError(t, err)
+			defer dest.Close()
+
+			if ca == "tracks" {
+				require.Equal(t, 1, len(dest.Tracks()))
+				require.Equal(t, "video", dest.Tracks()[0].Media.MediaName.Media)
+			} else {
+				require.Equal(t, 2, len(dest.Tracks()))
+			}
+
+			recv := make(chan []byte, 1)
+			readDone := make(chan struct{})
+			go func() {
+				defer close(readDone)
+				dest.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+					if streamType == gortsplib.StreamTypeRTP {
+						var pkt rtp.Packet
+						err := pkt.Unmarshal(payload)
+						require.NoError(t, err)
+
+						select {
+						case recv <- append([]byte(nil), pkt.Payload...):
+						default:
+						}
+					}
+				})
+			}()
+
+			select {
+			case payload := <-recv:
+				require.Equal(t, []byte{0x05, 0x00}, payload)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out")
+			}
+
+			if ca == "delay" {
+				require.Equal(t, true, time.Since(start) >= 1*time.Second)
+			}
+
+			dest.Close()
+			<-readDone
+		})
+	}
+}
+
+func TestRTSPServerComposeSource(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  cam:\n" +
+		"  mic:\n" +
+		"  site:\n" +
+		"    source: compose\n" +
+		"    sourceCompose:\n" +
+		"      - path://cam?tracks=video\n" +
+		"      - path://mic?tracks=audio\n" +
+		"    sourceOnDemand: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoTrack, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, &gortsplib.TrackConfigAAC{Type: 2, SampleRate: 44100, ChannelCount: 2})
+	require.NoError(t, err)
+
+	cam, err := gortsplib.DialPublish("rtsp://localhost:8554/cam", gortsplib.Tracks{videoTrack})
+	require.NoError(t, err)
+	defer cam.Close()
+
+	mic, err := gortsplib.DialPublish("rtsp://localhost:8554/mic", gortsplib.Tracks{audioTrack})
+	require.NoError(t, err)
+	defer mic.Close()
+
+	writeDone := make(chan struct{})
+	defer func() { <-writeDone }()
+	writeTerminate := make(chan struct{})
+	defer close(writeTerminate)
+
+	// timestamps of the publishers start from unrelated values, while sender reports
+	// state that audio has been captured 300ms after the video with the same index.
+	ntpStart := time.Now()
+
+	go func() {
+		defer close(writeDone)
+
+		t := time.NewTicker(50 * time.Millisecond)
+		defer t.Stop()
+
+		for i := 0; ; i++ {
+			select {
+			case <-t.C:
+			case <-writeTerminate:
+				return
+			}
+
+			for _, pub := range []struct {
+				conn        *gortsplib.ClientConn
+				payloadType uint8
+				clockRate   float64
+				rtpStart    uint32
+				ntpOffset   time.Duration
+			}{
+				{cam, 96, 90000, 1000000, 0},
+				{mic, 97, 44100, 5000, 300 * time.Millisecond},
+			} {
+				ntp := ntpStart.Add(time.Duration(i)*100*time.Millisecond + pub.ntpOffset)
+				ts := pub.rtpStart + uint32(float64(i)*0.1*pub.clockRate)
+
+				byts, _ := (&rtcp.SenderReport{
+					SSRC: 0x9dbb7812,
+					NTPTime: func() uint64 {
+						s := float64(ntp.UnixNano())/1000000000 + 2208988800
+						integerPart := uint32(s)
+						fractionalPart := uint32((s - float64(integerPart)) * 0xFFFFFFFF)
+						return uint64(integerPart)<<32 | uint64(fractionalPart)
+					}(),
+					RTPTime: ts,
+				}).Marshal()
+				pub.conn.WriteFrame(0, gortsplib.StreamTypeRTCP, byts)
+
+				byts, _ = (&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						PayloadType:    pub.payloadType,
+						SequenceNumber: uint16(i),
+						Timestamp:      ts,
+						SSRC:           0x9dbb7812,
+					},
+					Payload: []byte{0x05, byte(i)},
+				}).Marshal()
+				pub.conn.WriteFrame(0, gortsplib.StreamTypeRTP, byts)
+			}
+		}
+	}()
+
+	dest, err := gortsplib.DialRead("rtsp://localhost:8554/site")
+	require.NoError(t, err)
+	defer dest.Close()
+
+	require.Equal(t, 2, len(dest.Tracks()))
+	require.Equal(t, "video", dest.Tracks()[0].Media.MediaName.Media)
+	require.Equal(t, "audio", dest.Tracks()[1].Media.MediaName.Media)
+
+	// start time of each packet, computed from its timestamp and index
+	starts := make(chan [2]float64, 10)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+
+		var recv [2]float64
+		var recvSet [2]bool
+
+		dest.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+			if streamType != gortsplib.StreamTypeRTP {
+				return
+			}
+
+			var pkt rtp.Packet
+			err := pkt.Unmarshal(payload)
+			require.NoError(t, err)
+
+			clockRate := []float64{90000, 44100}[trackID]
+			recv[trackID] = float64(int32(pkt.Timestamp))/clockRate - float64(pkt.Payload[1])*0.1
+			recvSet[trackID] = true
+
+			if recvSet[0] && recvSet[1] {
+				select {
+				case starts <- recv:
+				default:
+				}
+			}
+		})
+	}()
+
+	// wait for the sender reports to be received
+	time.Sleep(500 * time.Millisecond)
+	for len(starts) > 0 {
+		<-starts
+	}
+
+	select {
+	case recv := <-starts:
+		require.InDelta(t, 0.3, recv[1]-recv[0], 0.002)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	dest.Close()
+	<-readDone
+}
+
+func TestRTSPServerONVIFReplay(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream", gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	// the absolute time of frames is taken from sender reports
+	ntp := time.Date(2008, 0o5, 20, 22, 15, 20, 0, time.UTC)
+
+	byts, _ := (&rtcp.SenderReport{
+		SSRC:    0x9dbb7812,
+		NTPTime: uint64(ntp.Unix()+2208988800) << 32,
+		RTPTime: 1000,
+	}).Marshal()
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTCP, byts)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	err = base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"1"},
+			"Require": base.HeaderValue{"onvif-replay"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	}.Write(bconn.Writer)
+	require.NoError(t, err)
+
+	var res base.Response
+	err = res.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	err = base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": res.Header["Session"],
+			"Require": base.HeaderValue{"onvif-replay"},
+		},
+	}.Write(bconn.Writer)
+	require.NoError(t, err)
+
+	err = res.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	byts, _ = (&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 946,
+			Timestamp:      1000 + 90000,
+			SSRC:           0x9dbb7812,
+		},
+		Payload: []byte{0x05, 0x02},
+	}).Marshal()
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTP, byts)
+	require.NoError(t, err)
+
+	var fr base.InterleavedFrame
+	for {
+		fr.Payload = make([]byte, 2048)
+		err = fr.Read(bconn.Reader)
+		require.NoError(t, err)
+		if fr.Channel == 0 {
+			break
+		}
+	}
+
+	var ext rtponvif.ReplayExtension
+	err = ext.Read(fr.Payload)
+	require.NoError(t, err)
+	require.Equal(t, ntp.Add(time.Second).UnixNano(), ext.NTPTime.UnixNano())
+}
+
 func TestRTSPServerRunOnDemand(t *testing.T) {
 	doneFile := filepath.Join(os.TempDir(), "ondemand_done")
 	onDemandFile, err := writeTempFile([]byte(fmt.Sprintf(`#!/bin/sh
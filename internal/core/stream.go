@@ -1,11 +1,28 @@
 package core
 
 import (
+	"bytes"
+	"encoding/binary"
 	"sync"
+	"time"
 
 	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
 )
 
+// seconds between 1st January 1900 and 1st January 1970
+const ntpEpochOffset = 2208988800
+
+func decodeNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	ns := int64(((v & 0xFFFFFFFF) * 1000000000) >> 32)
+	return time.Unix(secs, ns)
+}
+
 type streamNonRTSPReadersMap struct {
 	mutex sync.RWMutex
 	ma    map[reader]struct{}
@@ -44,16 +61,293 @@ func (m *streamNonRTSPReadersMap) forwardFrame(trackID int, streamType gortsplib
 	}
 }
 
+type streamGOPCacheFrame struct {
+	trackID int
+	payload []byte
+}
+
+// streamGOPCache contains the RTP packets received since the last H264 keyframe.
+type streamGOPCache struct {
+	maxDuration  time.Duration
+	videoTrackID int
+
+	mutex               sync.Mutex
+	started             bool
+	keyTimestamp        uint32
+	keyTime             time.Time
+	frames              []streamGOPCacheFrame
+	lastSequenceNumbers []uint16
+}
+
+func newStreamGOPCache(tracks gortsplib.Tracks, maxDuration time.Duration) *streamGOPCache {
+	videoTrackID := -1
+	for i, t := range tracks {
+		if t.IsH264() {
+			videoTrackID = i
+			break
+		}
+	}
+
+	// the cache is filled by starting from a H264 keyframe
+	if videoTrackID < 0 {
+		return nil
+	}
+
+	return &streamGOPCache{
+		maxDuration:         maxDuration,
+		videoTrackID:        videoTrackID,
+		lastSequenceNumbers: make([]uint16, len(tracks)),
+	}
+}
+
+func (c *streamGOPCache) add(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType != gortsplib.StreamTypeRTP || len(payload) < 12 {
+		return
+	}
+
+	c.lastSequenceNumbers[trackID] = binary.BigEndian.Uint16(payload[2:4])
+
+	if trackID == c.videoTrackID {
+		timestamp := binary.BigEndian.Uint32(payload[4:8])
+
+		if !c.started || timestamp != c.keyTimestamp {
+			var pkt rtp.Packet
+			err := pkt.Unmarshal(payload)
+			if err == nil && rtph264.IsRandomAccess(pkt.Payload) {
+				c.started = true
+				c.keyTimestamp = timestamp
+				c.keyTime = time.Now()
+				c.frames = c.frames[:0]
+			}
+		}
+	}
+
+	if !c.started {
+		return
+	}
+
+	// the GOP is too long: discard it and wait for the next keyframe
+	if time.Since(c.keyTime) > c.maxDuration {
+		c.started = false
+		c.frames = nil
+		return
+	}
+
+	c.frames = append(c.frames, streamGOPCacheFrame{
+		trackID: trackID,
+		payload: append([]byte(nil), payload...),
+	})
+}
+
+// rtspFrames returns the cached frames, with sequence numbers rewritten
+// in order to be contiguous with the ones of the next frames of the stream.
+func (c *streamGOPCache) rtspFrames() []streamGOPCacheFrame {
+	counts := make([]uint16, len(c.lastSequenceNumbers))
+	for _, f := range c.frames {
+		counts[f.trackID]++
+	}
+
+	next := make([]uint16, len(c.lastSequenceNumbers))
+	for trackID, count := range counts {
+		next[trackID] = c.lastSequenceNumbers[trackID] - count + 1
+	}
+
+	ret := make([]streamGOPCacheFrame, len(c.frames))
+	for i, f := range c.frames {
+		payload := append([]byte(nil), f.payload...)
+		binary.BigEndian.PutUint16(payload[2:4], next[f.trackID])
+		next[f.trackID]++
+
+		ret[i] = streamGOPCacheFrame{
+			trackID: f.trackID,
+			payload: payload,
+		}
+	}
+
+	return ret
+}
+
+// streamClock maps the RTP timestamps of a track to absolute time.
+// The mapping is obtained from RTCP sender reports or, until the first
+// sender report is received, from the arrival time of the first RTP packet.
+type streamClock struct {
+	clockRate float64
+
+	mutex  sync.Mutex
+	refSet bool
+	refRTP uint32
+	refNTP time.Time
+}
+
+func newStreamClock(track *gortsplib.Track) *streamClock {
+	clockRate, _ := track.ClockRate()
+	return &streamClock{
+		clockRate: float64(clockRate),
+	}
+}
+
+func (c *streamClock) processFrame(streamType gortsplib.StreamType, payload []byte, now time.Time) {
+	if streamType == gortsplib.StreamTypeRTCP {
+		pkts, err := rtcp.Unmarshal(payload)
+		if err != nil {
+			return
+		}
+
+		for _, pkt := range pkts {
+			if sr, ok := pkt.(*rtcp.SenderReport); ok {
+				c.mutex.Lock()
+				c.refSet = true
+				c.refRTP = sr.RTPTime
+				c.refNTP = decodeNTP(sr.NTPTime)
+				c.mutex.Unlock()
+			}
+		}
+		return
+	}
+
+	if len(payload) < 12 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.refSet {
+		c.refSet = true
+		c.refRTP = binary.BigEndian.Uint32(payload[4:8])
+		c.refNTP = now
+	}
+}
+
+// ntp returns the absolute time of a RTP timestamp.
+func (c *streamClock) ntp(ts uint32) (time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.refSet || c.clockRate == 0 {
+		return time.Time{}, false
+	}
+
+	diff := float64(int32(ts - c.refRTP))
+	return c.refNTP.Add(time.Duration(diff / c.clockRate * float64(time.Second))), true
+}
+
+// rtpTime returns the RTP timestamp that corresponds to an absolute time,
+// in a clock with the same rate that starts at base.
+func (c *streamClock) rtpTime(base time.Time, ntp time.Time) uint32 {
+	return uint32(int64(ntp.Sub(base).Seconds() * c.clockRate))
+}
+
+// streamH264Params keeps the SPS of a H264 track up to date, by looking for
+// SPS sent in-band, that can replace the one of the track mid-stream.
+type streamH264Params struct {
+	mutex  sync.Mutex
+	spsRaw []byte
+	sps    *h264.SPS
+}
+
+func newStreamH264Params(track *gortsplib.Track) *streamH264Params {
+	p := &streamH264Params{}
+
+	conf, err := track.ExtractConfigH264()
+	if err == nil {
+		p.update(conf.SPS)
+	}
+
+	return p
+}
+
+func (p *streamH264Params) update(nalu []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if bytes.Equal(nalu, p.spsRaw) {
+		return
+	}
+
+	var sps h264.SPS
+	if sps.Unmarshal(nalu) != nil {
+		return
+	}
+
+	p.spsRaw = append([]byte(nil), nalu...)
+	p.sps = &sps
+}
+
+func (p *streamH264Params) processFrame(streamType gortsplib.StreamType, payload []byte) {
+	if streamType != gortsplib.StreamTypeRTP {
+		return
+	}
+
+	var pkt rtp.Packet
+	err := pkt.Unmarshal(payload)
+	if err != nil || len(pkt.Payload) == 0 {
+		return
+	}
+
+	// SPS are small, therefore they're sent in single NALU packets
+	// or in aggregation packets (STAP-A), but not fragmented.
+	switch h264.NALUType(pkt.Payload[0] & 0x1F) {
+	case h264.NALUTypeSPS:
+		p.update(pkt.Payload)
+
+	case 24: // STAP-A
+		buf := pkt.Payload[1:]
+		for len(buf) >= 2 {
+			size := int(binary.BigEndian.Uint16(buf))
+			buf = buf[2:]
+			if size == 0 || size > len(buf) {
+				return
+			}
+
+			if h264.NALUType(buf[0]&0x1F) == h264.NALUTypeSPS {
+				p.update(buf[:size])
+			}
+			buf = buf[size:]
+		}
+	}
+}
+
+// get returns the current SPS, or nil if it's not available.
+func (p *streamH264Params) get() *h264.SPS {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sps
+}
+
 type stream struct {
 	nonRTSPReaders *streamNonRTSPReadersMap
 	rtspStream     *gortsplib.ServerStream
+	gopCache       *streamGOPCache
+	clocks         []*streamClock
+	h264Params     []*streamH264Params
 }
 
-func newStream(tracks gortsplib.Tracks) *stream {
+// newStream allocates a stream.
+// If gopCacheMaxDuration is not zero, the frames of the last GOP are cached
+// and sent to every new reader.
+func newStream(tracks gortsplib.Tracks, gopCacheMaxDuration time.Duration) *stream {
 	s := &stream{
 		nonRTSPReaders: newStreamNonRTSPReadersMap(),
 		rtspStream:     gortsplib.NewServerStream(tracks),
 	}
+
+	s.clocks = make([]*streamClock, len(tracks))
+	s.h264Params = make([]*streamH264Params, len(tracks))
+	for i, t := range tracks {
+		s.clocks[i] = newStreamClock(t)
+		if t.IsH264() {
+			s.h264Params[i] = newStreamH264Params(t)
+		}
+	}
+
+	if gopCacheMaxDuration > 0 {
+		s.gopCache = newStreamGOPCache(tracks, gopCacheMaxDuration)
+		if s.gopCache != nil {
+			s.rtspStream.SetOnReaderActive(s.onRTSPReaderActive)
+		}
+	}
+
 	return s
 }
 
@@ -68,6 +362,15 @@ func (s *stream) tracks() gortsplib.Tracks {
 
 func (s *stream) readerAdd(r reader) {
 	if _, ok := r.(pathRTSPSession); !ok {
+		if s.gopCache != nil {
+			s.gopCache.mutex.Lock()
+			defer s.gopCache.mutex.Unlock()
+
+			for _, f := range s.gopCache.frames {
+				r.OnReaderFrame(f.trackID, gortsplib.StreamTypeRTP, f.payload)
+			}
+		}
+
 		s.nonRTSPReaders.add(r)
 	}
 }
@@ -78,9 +381,77 @@ func (s *stream) readerRemove(r reader) {
 	}
 }
 
+// onRTSPReaderActive is called by the RTSP stream when a reader starts reading.
+func (s *stream) onRTSPReaderActive(ss *gortsplib.ServerSession, replay func(int, []byte), activate func()) {
+	s.gopCache.mutex.Lock()
+	defer s.gopCache.mutex.Unlock()
+
+	for _, f := range s.gopCache.rtspFrames() {
+		replay(f.trackID, f.payload)
+	}
+
+	activate()
+}
+
+// gopCacheDuration returns the time elapsed since the beginning of the cached GOP.
+func (s *stream) gopCacheDuration() time.Duration {
+	if s.gopCache == nil {
+		return 0
+	}
+
+	s.gopCache.mutex.Lock()
+	defer s.gopCache.mutex.Unlock()
+
+	if !s.gopCache.started {
+		return 0
+	}
+
+	return time.Since(s.gopCache.keyTime)
+}
+
+// ntp returns the absolute time of a RTP timestamp of a track, i.e. the
+// capture time of a frame, if the publisher sends RTCP sender reports.
+func (s *stream) ntp(trackID int, ts uint32) (time.Time, bool) {
+	return s.clocks[trackID].ntp(ts)
+}
+
+// ntpOfFrame returns the absolute time of a RTP packet of a track.
+func (s *stream) ntpOfFrame(trackID int, payload []byte) (time.Time, bool) {
+	if len(payload) < 12 {
+		return time.Time{}, false
+	}
+	return s.ntp(trackID, binary.BigEndian.Uint32(payload[4:8]))
+}
+
+// sps returns the current SPS of a H264 track, or nil if it's not available.
+func (s *stream) sps(trackID int) *h264.SPS {
+	if s.h264Params[trackID] == nil {
+		return nil
+	}
+	return s.h264Params[trackID].get()
+}
+
 func (s *stream) onFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
-	// forward to RTSP readers
-	s.rtspStream.WriteFrame(trackID, streamType, payload)
+	s.clocks[trackID].processFrame(streamType, payload, time.Now())
+
+	if s.h264Params[trackID] != nil {
+		s.h264Params[trackID].processFrame(streamType, payload)
+	}
+
+	if s.gopCache != nil {
+		s.gopCache.mutex.Lock()
+		defer s.gopCache.mutex.Unlock()
+
+		s.gopCache.add(trackID, streamType, payload)
+	}
+
+	// forward to RTSP readers.
+	// the absolute time is used by readers that requested the ONVIF replay extension.
+	var ntp time.Time
+	if streamType == gortsplib.StreamTypeRTP {
+		ntp, _ = s.ntpOfFrame(trackID, payload)
+	}
+	s.rtspStream.WriteFrameNTP(trackID, streamType, payload, ntp)
 
 	// forward to non-RTSP readers
 	s.nonRTSPReaders.forwardFrame(trackID, streamType, payload)
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamClock(t *testing.T) {
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	c := newStreamClock(track)
+
+	_, ok := c.ntp(90000)
+	require.Equal(t, false, ok)
+
+	// before the first sender report, the arrival time is used
+	arrival := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	frame, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+			Timestamp:   90000,
+		},
+		Payload: []byte{0x05},
+	}).Marshal()
+	require.NoError(t, err)
+
+	c.processFrame(gortsplib.StreamTypeRTP, frame, arrival)
+
+	ntp, ok := c.ntp(90000 + 45000)
+	require.Equal(t, true, ok)
+	require.Equal(t, arrival.Add(500*time.Millisecond), ntp)
+
+	// sender reports replace the arrival time
+	sr, err := (&rtcp.SenderReport{
+		SSRC:    1,
+		NTPTime: uint64(2208988800+1614852000+3600) << 32, // 2021-03-04 11:00:00 UTC
+		RTPTime: 180000,
+	}).Marshal()
+	require.NoError(t, err)
+
+	c.processFrame(gortsplib.StreamTypeRTCP, sr, arrival)
+
+	ntp, ok = c.ntp(180000 - 90000)
+	require.Equal(t, true, ok)
+	require.Equal(t, time.Date(2021, 3, 4, 10, 59, 59, 0, time.UTC), ntp.UTC())
+
+	// absolute times can be converted into timestamps of another clock
+	require.Equal(t, uint32(135000), c.rtpTime(ntp.Add(-1500*time.Millisecond), ntp))
+}
+
+func TestStreamH264Params(t *testing.T) {
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	p := newStreamH264Params(track)
+	require.Nil(t, p.get())
+
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+		0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+		0xcb,
+	}
+
+	// SPS inside a STAP-A packet
+	payload := []byte{24, 0x00, byte(len(sps))}
+	payload = append(payload, sps...)
+	payload = append(payload, []byte{0x00, 0x01, 0x68}...)
+
+	frame, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+		},
+		Payload: payload,
+	}).Marshal()
+	require.NoError(t, err)
+
+	p.processFrame(gortsplib.StreamTypeRTP, frame)
+
+	require.NotNil(t, p.get())
+	require.Equal(t, 1280, p.get().Width())
+	require.Equal(t, 720, p.get().Height())
+	require.Equal(t, "High", p.get().Profile())
+	require.Equal(t, "3.1", p.get().Level())
+}
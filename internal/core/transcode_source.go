@@ -0,0 +1,451 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
+)
+
+const (
+	transcodeSourceRetryPause = 5 * time.Second
+
+	// an offset is needed to avoid negative timestamps,
+	// since audio can precede the first video frame.
+	transcodeSourcePTSOffset = 2 * time.Second
+)
+
+// transcodeSourceVariables returns the variables passed to the encoder.
+func transcodeSourceVariables(sourcePathName string, profile *conf.PathConfTranscode) map[string]string {
+	width, height := "iw", "ih"
+	switch {
+	case profile.Width != 0 && profile.Height != 0:
+		width, height = strconv.FormatInt(int64(profile.Width), 10), strconv.FormatInt(int64(profile.Height), 10)
+
+	// keep aspect ratio
+	case profile.Width != 0:
+		width, height = strconv.FormatInt(int64(profile.Width), 10), "-2"
+
+	case profile.Height != 0:
+		width, height = "-2", strconv.FormatInt(int64(profile.Height), 10)
+	}
+
+	return map[string]string{
+		"RTSP_SOURCE_PATH":  sourcePathName,
+		"TRANSCODE_NAME":    profile.Name,
+		"TRANSCODE_WIDTH":   width,
+		"TRANSCODE_HEIGHT":  height,
+		"TRANSCODE_BITRATE": profile.Bitrate,
+	}
+}
+
+type transcodeSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+type transcodeSourceFrame struct {
+	trackID int
+	payload []byte
+}
+
+// transcodeSourceReader is the reader of the path that is encoded by a transcodeSource.
+type transcodeSourceReader struct {
+	source    *transcodeSource
+	ctxCancel func()
+	buffer    *readerBuffer
+}
+
+// Close implements reader.
+func (r *transcodeSourceReader) Close() {
+	r.ctxCancel()
+}
+
+// OnReaderAccepted implements reader.
+func (r *transcodeSourceReader) OnReaderAccepted() {
+	r.source.log(logger.Info, "is reading from path '%s'", r.source.sourcePathName)
+}
+
+// OnReaderFrame implements reader.
+func (r *transcodeSourceReader) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP {
+		r.buffer.push(trackID, streamType, payload, transcodeSourceFrame{trackID, payload})
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (r *transcodeSourceReader) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"transcodeSource"}
+}
+
+// transcodeSource is a static source that encodes the stream of another path
+// with a transcoding profile. The stream is sent to the encoder through its
+// standard input, and the encoded stream is read from its standard output,
+// both in MPEG-TS format. The encoder is restarted when it exits.
+type transcodeSource struct {
+	pathName        string
+	sourcePathName  string
+	profile         *conf.PathConfTranscode
+	cmdstr          string
+	rtspAddress     string
+	readBufferCount int
+	wg              *sync.WaitGroup
+	pathManager     pathSourcePathManager
+	parent          transcodeSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newTranscodeSource(
+	parentCtx context.Context,
+	pathName string,
+	profile *conf.PathConfTranscode,
+	cmdstr string,
+	rtspAddress string,
+	readBufferCount int,
+	wg *sync.WaitGroup,
+	pathManager pathSourcePathManager,
+	parent transcodeSourceParent) *transcodeSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &transcodeSource{
+		pathName:        pathName,
+		sourcePathName:  strings.TrimSuffix(pathName, "/"+profile.Name),
+		profile:         profile,
+		cmdstr:          cmdstr,
+		rtspAddress:     rtspAddress,
+		readBufferCount: readBufferCount,
+		wg:              wg,
+		pathManager:     pathManager,
+		parent:          parent,
+		ctx:             ctx,
+		ctxCancel:       ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *transcodeSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *transcodeSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[transcode source] "+format, args...)
+}
+
+func (s *transcodeSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(transcodeSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *transcodeSource) runInner() bool {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- s.runEncoder(innerCtx, innerCtxCancel)
+	}()
+
+	select {
+	case err := <-runErr:
+		innerCtxCancel()
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+
+	case <-s.ctx.Done():
+		innerCtxCancel()
+		<-runErr
+		return false
+	}
+}
+
+func (s *transcodeSource) runEncoder(ctx context.Context, ctxCancel func()) error {
+	r := &transcodeSourceReader{
+		source:    s,
+		ctxCancel: ctxCancel,
+	}
+
+	res := s.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+		Author:              r,
+		PathName:            s.sourcePathName,
+		IP:                  nil,
+		ValidateCredentials: nil,
+	})
+	if res.Err != nil {
+		return res.Err
+	}
+
+	defer res.Path.OnReaderRemove(pathReaderRemoveReq{Author: r})
+
+	videoTrackID := -1
+	audioTrackID := -1
+	var h264Conf *gortsplib.TrackConfigH264
+	var aacConf *gortsplib.TrackConfigAAC
+
+	for i, t := range res.Stream.tracks() {
+		if t.IsH264() && h264Conf == nil {
+			var err error
+			h264Conf, err = t.ExtractConfigH264()
+			if err != nil {
+				return err
+			}
+			videoTrackID = i
+
+		} else if t.IsAAC() && aacConf == nil {
+			var err error
+			aacConf, err = t.ExtractConfigAAC()
+			if err != nil {
+				return err
+			}
+			audioTrackID = i
+		}
+	}
+
+	if h264Conf == nil && aacConf == nil {
+		return fmt.Errorf("path '%s' doesn't contain an H264 track or an AAC track", s.sourcePathName)
+	}
+
+	_, port, _ := net.SplitHostPort(s.rtspAddress)
+	cmd, err := externalcmd.Command(s.cmdstr, externalcmd.Environment{
+		Path:      s.pathName,
+		Port:      port,
+		Variables: transcodeSourceVariables(s.sourcePathName, s.profile),
+	})
+	if err != nil {
+		return err
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	s.log(logger.Info, "encoder started")
+
+	r.buffer = newReaderBuffer(s.readBufferCount, videoTrackID, 0, new(uint64))
+
+	res.Path.OnReaderPlay(pathReaderPlayReq{Author: r})
+
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- s.runWriter(r.buffer, stdin, videoTrackID, h264Conf, audioTrackID, aacConf)
+	}()
+
+	readErr := make(chan error, 1)
+	go func() {
+		// the pipe is wrapped in order to hide its Seek() method, that is
+		// used by the demuxer when available and doesn't work with pipes.
+		err := mpegtsSourceReadStream(ctx, bufio.NewReader(stdout), false, s, s.parent, s.log)
+		if err == io.EOF {
+			err = fmt.Errorf("encoder exited")
+		}
+		readErr <- err
+	}()
+
+	select {
+	case err = <-writeErr:
+		writeErr = nil
+
+	case err = <-readErr:
+		readErr = nil
+
+	case <-ctx.Done():
+		err = fmt.Errorf("terminated")
+	}
+
+	r.buffer.close()
+	stdin.Close()
+	externalcmd.Stop(cmd)
+
+	if writeErr != nil {
+		<-writeErr
+	}
+	if readErr != nil {
+		<-readErr
+	}
+	cmd.Wait()
+
+	return err
+}
+
+// runWriter sends the frames of the source path to the encoder.
+func (s *transcodeSource) runWriter(
+	buffer *readerBuffer,
+	w io.Writer,
+	videoTrackID int,
+	h264Conf *gortsplib.TrackConfigH264,
+	audioTrackID int,
+	aacConf *gortsplib.TrackConfigAAC,
+) error {
+	bw := bufio.NewWriter(w)
+	tw := mpegts.NewWriter(bw, h264Conf, aacConf)
+
+	var h264Decoder *rtph264.Decoder
+	var videoDTSExtr *h264.DTSExtractor
+	if h264Conf != nil {
+		h264Decoder = rtph264.NewDecoder()
+		videoDTSExtr = h264.NewDTSExtractor(h264Conf.SPS, h264Conf.PPS)
+	}
+
+	var aacDecoder *rtpaac.Decoder
+	if aacConf != nil {
+		aacDecoder = rtpaac.NewDecoder(aacConf.SampleRate)
+	}
+
+	// the encoder starts from the first IDR
+	started := (h264Conf == nil)
+	var startPTS time.Duration
+	var videoBuf [][]byte
+
+	for {
+		data, err := buffer.pull()
+		if err != nil {
+			return err
+		}
+		f := data.(transcodeSourceFrame)
+
+		var pkt rtp.Packet
+		err = pkt.Unmarshal(f.payload)
+		if err != nil {
+			s.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			continue
+		}
+
+		switch f.trackID {
+		case videoTrackID:
+			nalus, pts, err := h264Decoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+					s.log(logger.Warn, "unable to decode video track: %v", err)
+				}
+				continue
+			}
+
+			videoBuf = append(videoBuf, nalus...)
+
+			if !pkt.Marker {
+				continue
+			}
+
+			au := videoBuf
+			videoBuf = nil
+
+			idr := false
+			for _, nalu := range au {
+				if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeIDR {
+					idr = true
+					break
+				}
+			}
+
+			if !started {
+				if !idr {
+					continue
+				}
+				started = true
+				startPTS = pts
+			}
+
+			pts = pts - startPTS + transcodeSourcePTSOffset
+
+			err = tw.WriteH264(videoDTSExtr.Extract(au, pts), pts, idr, au)
+			if err != nil {
+				return err
+			}
+
+		case audioTrackID:
+			aus, pts, err := aacDecoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtpaac.ErrMorePacketsNeeded {
+					s.log(logger.Warn, "unable to decode audio track: %v", err)
+				}
+				continue
+			}
+
+			if !started {
+				continue
+			}
+
+			pts = pts - startPTS + transcodeSourcePTSOffset
+
+			for i, au := range aus {
+				auPTS := pts + time.Duration(i)*1000*time.Second/time.Duration(aacConf.SampleRate)
+
+				err := tw.WriteAAC(auPTS, au)
+				if err != nil {
+					return err
+				}
+			}
+
+		default:
+			continue
+		}
+
+		err = bw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// OnSourceAPIDescribe implements source.
+func (*transcodeSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"transcodeSource"}
+}
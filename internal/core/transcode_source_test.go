@@ -0,0 +1,79 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscodeSource(t *testing.T) {
+	// the encoder is replaced by a command that copies the input stream into the output stream
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  cam:\n" +
+		"    transcode:\n" +
+		"      - name: 720p\n" +
+		"        height: 720\n" +
+		"        bitrate: 2M\n" +
+		"    transcodeCommand: cat\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x67, 0x01, 0x02, 0x03}, PPS: []byte{0x68, 0x04}})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/cam", gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	writeDone := make(chan struct{})
+	defer func() { <-writeDone }()
+	writeTerminate := make(chan struct{})
+	defer close(writeTerminate)
+
+	go func() {
+		defer close(writeDone)
+
+		for i := 0; ; i++ {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-writeTerminate:
+				return
+			}
+
+			byts, _ := (&rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         true,
+					PayloadType:    96,
+					SequenceNumber: uint16(i),
+					Timestamp:      uint32(i) * 9000,
+					SSRC:           0x9dbb7812,
+				},
+				Payload: []byte{0x65, byte(i % 256)},
+			}).Marshal()
+			source.WriteFrame(0, gortsplib.StreamTypeRTP, byts)
+		}
+	}()
+
+	conn, recv := readTestFrames(t, "cam/720p")
+	defer conn.Close()
+
+	var prev testReceivedFrame
+	for i := 0; i < 3; i++ {
+		select {
+		case f := <-recv:
+			if i > 0 {
+				require.Equal(t, prev.index+1, f.index)
+			}
+			prev = f
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/eventbus"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	// events that are waiting to be delivered
+	webhookQueueSize = 1024
+
+	// events that are waiting to be delivered again after a failure
+	webhookRetryQueueSize = 256
+
+	webhookRetryPause    = 1 * time.Second
+	webhookRetryPauseMax = 30 * time.Second
+)
+
+type webhookDelivery struct {
+	event    eventbus.Event
+	attempts int
+	next     time.Time
+}
+
+type webhook struct {
+	conf   *conf.WebhookConf
+	parent *webhookManager
+
+	client  *http.Client
+	sub     *eventbus.Subscription
+	retries []webhookDelivery
+
+	mutex     sync.Mutex
+	delivered uint64
+	failed    uint64
+	retried   uint64
+	dropped   uint64
+	lastError string
+}
+
+func newWebhook(
+	wconf *conf.WebhookConf,
+	eventBus *eventbus.Bus,
+	parent *webhookManager) *webhook {
+	filter := eventbus.Filter{
+		Paths: wconf.Paths,
+	}
+	for _, typ := range wconf.Events {
+		filter.Types = append(filter.Types, eventbus.Type(typ))
+	}
+
+	return &webhook{
+		conf:   wconf,
+		parent: parent,
+		client: &http.Client{
+			Timeout: wconf.Timeout,
+		},
+		sub: eventBus.Subscribe(filter, webhookQueueSize),
+	}
+}
+
+func (w *webhook) run() {
+	defer w.parent.wg.Done()
+	defer w.sub.Close()
+
+	retryTimer := newEmptyTimer()
+	defer retryTimer.Stop()
+
+	for {
+		if len(w.retries) > 0 {
+			retryTimer.Stop()
+			retryTimer = time.NewTimer(time.Until(w.retries[0].next))
+		}
+
+		select {
+		case e := <-w.sub.Events():
+			w.deliver(webhookDelivery{event: e})
+
+		case <-retryTimer.C:
+			d := w.retries[0]
+			w.retries = w.retries[1:]
+			w.deliver(d)
+
+		case <-w.parent.ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *webhook) deliver(d webhookDelivery) {
+	d.attempts++
+	if d.attempts > 1 {
+		w.mutex.Lock()
+		w.retried++
+		w.mutex.Unlock()
+	}
+
+	err := w.send(d.event)
+	if err == nil {
+		w.mutex.Lock()
+		w.delivered++
+		w.mutex.Unlock()
+		return
+	}
+
+	w.mutex.Lock()
+	w.lastError = err.Error()
+	w.mutex.Unlock()
+
+	if d.attempts > *w.conf.Retries {
+		w.parent.log(logger.Warn, "unable to deliver event to '%s': %s", w.conf.URL, err)
+		w.mutex.Lock()
+		w.failed++
+		w.mutex.Unlock()
+		return
+	}
+
+	if len(w.retries) >= webhookRetryQueueSize {
+		w.mutex.Lock()
+		w.dropped++
+		w.mutex.Unlock()
+		return
+	}
+
+	// exponential backoff
+	pause := webhookRetryPause << (d.attempts - 1)
+	if pause > webhookRetryPauseMax {
+		pause = webhookRetryPauseMax
+	}
+	d.next = time.Now().Add(pause)
+
+	i := sort.Search(len(w.retries), func(i int) bool {
+		return w.retries[i].next.After(d.next)
+	})
+	w.retries = append(w.retries, webhookDelivery{})
+	copy(w.retries[i+1:], w.retries[i:])
+	w.retries[i] = d
+}
+
+func (w *webhook) send(e eventbus.Event) error {
+	byts, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(w.parent.ctx, http.MethodPost, w.conf.URL, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rtsp-simple-server")
+	req.Header.Set("X-Event-Type", string(e.Type))
+
+	if w.conf.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.conf.Secret))
+		mac.Write(byts)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// read the body in order to reuse the connection
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (w *webhook) apiItem() apiWebhooksListItem {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return apiWebhooksListItem{
+		URL:       w.conf.URL,
+		Delivered: w.delivered,
+		Failed:    w.failed,
+		Retried:   w.retried,
+		Dropped:   w.dropped + w.sub.Dropped(),
+		LastError: w.lastError,
+	}
+}
+
+type webhookManagerParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+type webhookManager struct {
+	parent webhookManagerParent
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	webhooks  []*webhook
+}
+
+func newWebhookManager(
+	parentCtx context.Context,
+	confs []*conf.WebhookConf,
+	eventBus *eventbus.Bus,
+	parent webhookManagerParent) *webhookManager {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	m := &webhookManager{
+		parent:    parent,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+	}
+
+	for _, wconf := range confs {
+		w := newWebhook(wconf, eventBus, m)
+		m.webhooks = append(m.webhooks, w)
+
+		m.wg.Add(1)
+		go w.run()
+	}
+
+	m.log(logger.Info, "%d %s enabled", len(m.webhooks), func() string {
+		if len(m.webhooks) == 1 {
+			return "webhook"
+		}
+		return "webhooks"
+	}())
+
+	return m
+}
+
+func (m *webhookManager) close() {
+	m.ctxCancel()
+	m.wg.Wait()
+}
+
+func (m *webhookManager) log(level logger.Level, format string, args ...interface{}) {
+	m.parent.Log(level, "[webhooks] "+format, args...)
+}
+
+// OnAPIWebhooksList is called by api.
+func (m *webhookManager) OnAPIWebhooksList(req apiWebhooksListReq) apiWebhooksListRes {
+	select {
+	case <-m.ctx.Done():
+		return apiWebhooksListRes{Err: fmt.Errorf("terminated")}
+	default:
+	}
+
+	data := &apiWebhooksListData{
+		Items: []apiWebhooksListItem{},
+	}
+
+	for _, w := range m.webhooks {
+		data.Items = append(data.Items, w.apiItem())
+	}
+
+	return apiWebhooksListRes{Data: data}
+}
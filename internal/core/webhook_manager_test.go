@@ -0,0 +1,106 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	type received struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	}
+
+	recv := make(chan received, 10)
+	failures := 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byts, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		mac := hmac.New(sha256.New, []byte("mysecret"))
+		mac.Write(byts)
+		require.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Signature-256"))
+
+		var e received
+		err = json.Unmarshal(byts, &e)
+		require.NoError(t, err)
+		require.Equal(t, e.Type, r.Header.Get("X-Event-Type"))
+
+		// the first delivery fails, in order to test retries
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		recv <- e
+	}))
+	defer srv.Close()
+
+	p, ok := newInstance("api: yes\n" +
+		"webhooks:\n" +
+		"  - url: " + srv.URL + "\n" +
+		"    events: [publisher.record, publisher.remove]\n" +
+		"    paths: [mypath]\n" +
+		"    secret: mysecret\n" +
+		"paths:\n" +
+		"  mypath:\n" +
+		"  otherpath:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x01, 0x02, 0x03, 0x04}, PPS: []byte{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	other, err := gortsplib.DialPublish("rtsp://localhost:8554/otherpath",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer other.Close()
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/mypath",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+
+	select {
+	case e := <-recv:
+		require.Equal(t, received{Type: "publisher.record", Path: "mypath"}, e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	source.Close()
+
+	select {
+	case e := <-recv:
+		require.Equal(t, received{Type: "publisher.remove", Path: "mypath"}, e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	var out struct {
+		Items []struct {
+			URL       string `json:"url"`
+			Delivered uint64 `json:"delivered"`
+			Retried   uint64 `json:"retried"`
+			LastError string `json:"lastError"`
+		} `json:"items"`
+	}
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/webhooks/list", nil, &out)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(out.Items))
+	require.Equal(t, srv.URL, out.Items[0].URL)
+	require.Equal(t, uint64(2), out.Items[0].Delivered)
+	require.Equal(t, uint64(1), out.Items[0].Retried)
+	require.Equal(t, "bad status code: 500", out.Items[0].LastError)
+}
@@ -0,0 +1,204 @@
+// Package eventbus contains an event bus, that allows subsystems to be notified
+// about the lifecycle of paths, readers, publishers and sessions.
+package eventbus
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is the type of an event.
+type Type string
+
+// event types.
+const (
+	TypePathReady         Type = "path.ready"
+	TypePathNotReady      Type = "path.notReady"
+	TypeReaderAdd         Type = "reader.add"
+	TypeReaderRemove      Type = "reader.remove"
+	TypePublisherAnnounce Type = "publisher.announce"
+	TypePublisherRecord   Type = "publisher.record"
+	TypePublisherRemove   Type = "publisher.remove"
+	TypeConnOpen          Type = "conn.open"
+	TypeConnClose         Type = "conn.close"
+	TypeSessionOpen       Type = "session.open"
+	TypeSessionClose      Type = "session.close"
+	TypeAuthFailure       Type = "auth.failure"
+	TypeMotionStart       Type = "motion.start"
+	TypeMotionStop        Type = "motion.stop"
+)
+
+var types = []Type{
+	TypePathReady,
+	TypePathNotReady,
+	TypeReaderAdd,
+	TypeReaderRemove,
+	TypePublisherAnnounce,
+	TypePublisherRecord,
+	TypePublisherRemove,
+	TypeConnOpen,
+	TypeConnClose,
+	TypeSessionOpen,
+	TypeSessionClose,
+	TypeAuthFailure,
+	TypeMotionStart,
+	TypeMotionStop,
+}
+
+// CheckFilterType checks whether a type can be used in a filter,
+// that is, if it's an event type or a prefix of event types.
+func CheckFilterType(t Type) error {
+	for _, typ := range types {
+		if t == typ || strings.HasPrefix(string(typ), string(t)+".") {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported event type: %s", t)
+}
+
+// Event is an event.
+type Event struct {
+	Type       Type        `json:"type"`
+	Time       time.Time   `json:"time"`
+	Path       string      `json:"path,omitempty"`
+	Protocol   string      `json:"protocol,omitempty"`
+	ID         string      `json:"id,omitempty"`
+	RemoteAddr string      `json:"remoteAddr,omitempty"`
+	Author     interface{} `json:"author,omitempty"`
+	Message    string      `json:"message,omitempty"`
+}
+
+// Filter allows to receive a subset of events.
+type Filter struct {
+	// paths whose events are received. If empty, events of all paths are received.
+	Paths []string
+
+	// types of events that are received. A type can be a prefix, like "reader",
+	// that matches all the types that begin with it.
+	// If empty, all events are received.
+	Types []Type
+}
+
+// Match checks whether an event matches the filter.
+func (f Filter) Match(e Event) bool {
+	if len(f.Paths) != 0 {
+		found := false
+		for _, p := range f.Paths {
+			if p == e.Path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Types) != 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type || strings.HasPrefix(string(e.Type), string(t)+".") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Subscription is a subscription to the events of a Bus.
+type Subscription struct {
+	bus     *Bus
+	filter  Filter
+	events  chan Event
+	dropped *uint64
+}
+
+// Events returns a channel that receives the events.
+// The channel is closed when the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events that were discarded
+// because the queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(s.dropped)
+}
+
+// Close closes the subscription.
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	_, ok := s.bus.subs[s]
+	delete(s.bus.subs, s)
+	s.bus.mutex.Unlock()
+
+	if ok {
+		close(s.events)
+	}
+}
+
+// Bus is an event bus.
+type Bus struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]struct{}
+}
+
+// New allocates a Bus.
+func New() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends an event to the subscribers.
+// It never blocks: events are discarded when the queue of a subscriber is full.
+// It can be called on a nil Bus.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for s := range b.subs {
+		if s.filter.Match(e) {
+			select {
+			case s.events <- e:
+			default:
+				atomic.AddUint64(s.dropped, 1)
+			}
+		}
+	}
+}
+
+// Subscribe adds a subscriber that receives the events that match the filter.
+func (b *Bus) Subscribe(filter Filter, queueSize int) *Subscription {
+	s := &Subscription{
+		bus:    b,
+		filter: filter,
+		events: make(chan Event, queueSize),
+		dropped: func() *uint64 {
+			v := uint64(0)
+			return &v
+		}(),
+	}
+
+	b.mutex.Lock()
+	b.subs[s] = struct{}{}
+	b.mutex.Unlock()
+
+	return s
+}
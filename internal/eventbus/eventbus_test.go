@@ -0,0 +1,95 @@
+package eventbus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMatch(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		filter Filter
+		event  Event
+		match  bool
+	}{
+		{
+			"empty",
+			Filter{},
+			Event{Type: TypeReaderAdd, Path: "mypath"},
+			true,
+		},
+		{
+			"path",
+			Filter{Paths: []string{"otherpath", "mypath"}},
+			Event{Type: TypeReaderAdd, Path: "mypath"},
+			true,
+		},
+		{
+			"path mismatch",
+			Filter{Paths: []string{"otherpath"}},
+			Event{Type: TypeReaderAdd, Path: "mypath"},
+			false,
+		},
+		{
+			"type",
+			Filter{Types: []Type{TypeReaderAdd}},
+			Event{Type: TypeReaderAdd, Path: "mypath"},
+			true,
+		},
+		{
+			"type prefix",
+			Filter{Types: []Type{"reader"}},
+			Event{Type: TypeReaderRemove, Path: "mypath"},
+			true,
+		},
+		{
+			"type mismatch",
+			Filter{Types: []Type{"read"}},
+			Event{Type: TypeReaderRemove, Path: "mypath"},
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.match, ca.filter.Match(ca.event))
+		})
+	}
+}
+
+func TestBus(t *testing.T) {
+	b := New()
+
+	sub1 := b.Subscribe(Filter{Paths: []string{"mypath"}}, 1)
+	defer sub1.Close()
+
+	sub2 := b.Subscribe(Filter{}, 10)
+
+	b.Publish(Event{Type: TypePathReady, Path: "mypath"})
+	b.Publish(Event{Type: TypePathReady, Path: "otherpath"})
+	b.Publish(Event{Type: TypePathNotReady, Path: "mypath"})
+
+	// the second event is discarded since the queue is full
+	e := <-sub1.Events()
+	require.Equal(t, TypePathReady, e.Type)
+	require.Equal(t, "mypath", e.Path)
+	require.Equal(t, false, e.Time.IsZero())
+	select {
+	case <-sub1.Events():
+		t.Errorf("unexpected event")
+	default:
+	}
+
+	require.Equal(t, "mypath", (<-sub2.Events()).Path)
+	require.Equal(t, "otherpath", (<-sub2.Events()).Path)
+	require.Equal(t, "mypath", (<-sub2.Events()).Path)
+
+	sub2.Close()
+	_, ok := <-sub2.Events()
+	require.Equal(t, false, ok)
+
+	b.Publish(Event{Type: TypePathReady, Path: "mypath"})
+	sub2.Close()
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: TypePathReady})
+}
@@ -12,6 +12,9 @@ const (
 type Environment struct {
 	Path string
 	Port string
+
+	// additional variables, in format NAME -> value.
+	Variables map[string]string
 }
 
 // Cmd is an external command.
@@ -19,6 +22,7 @@ type Cmd struct {
 	cmdstr  string
 	restart bool
 	env     Environment
+	onExit  func(int)
 
 	// in
 	terminate chan struct{}
@@ -29,10 +33,17 @@ type Cmd struct {
 
 // New allocates an Cmd.
 func New(cmdstr string, restart bool, env Environment) *Cmd {
+	return NewWithOnExit(cmdstr, restart, env, nil)
+}
+
+// NewWithOnExit allocates an Cmd, that calls onExit with the exit code
+// every time the command exits by itself.
+func NewWithOnExit(cmdstr string, restart bool, env Environment, onExit func(int)) *Cmd {
 	e := &Cmd{
 		cmdstr:    cmdstr,
 		restart:   restart,
 		env:       env,
+		onExit:    onExit,
 		terminate: make(chan struct{}),
 		done:      make(chan struct{}),
 	}
@@ -8,18 +8,36 @@ import (
 	"syscall"
 )
 
-func (e *Cmd) runInner() bool {
-	cmd := exec.Command("/bin/sh", "-c", "exec "+e.cmdstr)
+// Command allocates a exec.Cmd that runs a command with the given environment.
+func Command(cmdstr string, env Environment) (*exec.Cmd, error) {
+	cmd := exec.Command("/bin/sh", "-c", "exec "+cmdstr)
 
 	cmd.Env = append(os.Environ(),
-		"RTSP_PATH="+e.env.Path,
-		"RTSP_PORT="+e.env.Port,
+		"RTSP_PATH="+env.Path,
+		"RTSP_PORT="+env.Port,
 	)
+	for key, val := range env.Variables {
+		cmd.Env = append(cmd.Env, key+"="+val)
+	}
+
+	return cmd, nil
+}
+
+// Stop asks a command allocated with Command to exit.
+func Stop(cmd *exec.Cmd) {
+	syscall.Kill(cmd.Process.Pid, syscall.SIGQUIT)
+}
+
+func (e *Cmd) runInner() bool {
+	cmd, err := Command(e.cmdstr, e.env)
+	if err != nil {
+		return true
+	}
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return true
 	}
@@ -32,11 +50,14 @@ func (e *Cmd) runInner() bool {
 
 	select {
 	case <-e.terminate:
-		syscall.Kill(cmd.Process.Pid, syscall.SIGQUIT)
+		Stop(cmd)
 		<-cmdDone
 		return false
 
 	case <-cmdDone:
+		if e.onExit != nil {
+			e.onExit(cmd.ProcessState.ExitCode())
+		}
 		return true
 	}
 }
@@ -10,23 +10,46 @@ import (
 	"github.com/kballard/go-shellquote"
 )
 
-func (e *Cmd) runInner() bool {
+// Command allocates a exec.Cmd that runs a command with the given environment.
+func Command(cmdstr string, env Environment) (*exec.Cmd, error) {
 	// on Windows the shell is not used and command is started directly
 	// variables are replaced manually in order to guarantee compatibility
 	// with Linux commands
-	tmp := strings.ReplaceAll(e.cmdstr, "$RTSP_PATH", e.env.Path)
-	tmp = strings.ReplaceAll(tmp, "$RTSP_PORT", e.env.Port)
+	tmp := strings.ReplaceAll(cmdstr, "$RTSP_PATH", env.Path)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_PORT", env.Port)
+	for key, val := range env.Variables {
+		tmp = strings.ReplaceAll(tmp, "$"+key, val)
+	}
 	parts, err := shellquote.Split(tmp)
 	if err != nil {
-		return true
+		return nil, err
 	}
 
 	cmd := exec.Command(parts[0], parts[1:]...)
 
 	cmd.Env = append(os.Environ(),
-		"RTSP_PATH="+e.env.Path,
-		"RTSP_PORT="+e.env.Port,
+		"RTSP_PATH="+env.Path,
+		"RTSP_PORT="+env.Port,
 	)
+	for key, val := range env.Variables {
+		cmd.Env = append(cmd.Env, key+"="+val)
+	}
+
+	return cmd, nil
+}
+
+// Stop asks a command allocated with Command to exit.
+func Stop(cmd *exec.Cmd) {
+	// on Windows it's not possible to send os.Interrupt to a process
+	// Kill() is the only supported way
+	cmd.Process.Kill()
+}
+
+func (e *Cmd) runInner() bool {
+	cmd, err := Command(e.cmdstr, e.env)
+	if err != nil {
+		return true
+	}
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -44,13 +67,14 @@ func (e *Cmd) runInner() bool {
 
 	select {
 	case <-e.terminate:
-		// on Windows it's not possible to send os.Interrupt to a process
-		// Kill() is the only supported way
-		cmd.Process.Kill()
+		Stop(cmd)
 		<-cmdDone
 		return false
 
 	case <-cmdDone:
+		if e.onExit != nil {
+			e.onExit(cmd.ProcessState.ExitCode())
+		}
 		return true
 	}
 }
@@ -14,16 +14,27 @@ var casesAntiCompetition = []struct {
 	{
 		"base",
 		[]byte{
-			0x00, 0x00, 0x00,
-			0x00, 0x00, 0x01,
-			0x00, 0x00, 0x02,
-			0x00, 0x00, 0x03,
+			0x00, 0x00, 0x00, 0xff,
+			0x00, 0x00, 0x01, 0xff,
+			0x00, 0x00, 0x02, 0xff,
+			0x00, 0x00, 0x03, 0xff,
 		},
 		[]byte{
-			0x00, 0x00, 0x03, 0x00,
-			0x00, 0x00, 0x03, 0x01,
-			0x00, 0x00, 0x03, 0x02,
-			0x00, 0x00, 0x03, 0x03,
+			0x00, 0x00, 0x03, 0x00, 0xff,
+			0x00, 0x00, 0x03, 0x01, 0xff,
+			0x00, 0x00, 0x03, 0x02, 0xff,
+			0x00, 0x00, 0x03, 0x03, 0xff,
+		},
+	},
+	{
+		"consecutive",
+		[]byte{
+			0x00, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		},
+		[]byte{
+			0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x01,
+			0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x00,
 		},
 	},
 }
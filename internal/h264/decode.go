@@ -165,6 +165,7 @@ import (
 	"C"
 )
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -176,6 +177,7 @@ import (
 	"os/exec"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -200,32 +202,63 @@ func init() {
 	C.libav_init()
 }
 
+// decoderInput is an access unit, together with its capture time.
+type decoderInput struct {
+	data        []byte
+	captureTime time.Time
+}
+
 type H264Decoder struct {
 	m      C.h264dec_t
 	buffer []byte
 	//startPTS      time.Time
 	ctx           context.Context
-	gotNewData    chan struct{}
+	gotNewData    chan decoderInput
 	pathName      string
 	probeFileName string
 	inited        bool //video codec with AVCodecContext ready or not
 	inService     bool //after RETRYLIMIT continuous failures to decode a frame,
 	errChan       chan error
 	contRetries   int
+	captureTime   time.Time          //capture time of the access unit in buffer, zero if unknown
+	onJPEG        func([]byte)       //when set, pictures are passed to it instead of being saved into files
+	onImage       func(*image.YCbCr) //when set, pictures are passed to it without being encoded
 }
 
 func NewH264Decoder(ctx context.Context, pathName string, header []byte) (m *H264Decoder, err error) {
+	return newH264Decoder(ctx, pathName, PROBEPATH+pathName, nil, nil)
+}
+
+// NewH264JPEGDecoder allocates a H264Decoder that decodes a sequence of access units,
+// and passes every decoded picture, encoded in JPEG, to onJPEG.
+// The first access unit must contain SPS, PPS and an IDR.
+func NewH264JPEGDecoder(ctx context.Context, pathName string, onJPEG func([]byte)) (m *H264Decoder, err error) {
+	//the probe file must not collide with the one of the snapshot decoder of the same path
+	return newH264Decoder(ctx, pathName, PROBEPATH+strings.ReplaceAll(pathName, "/", "_")+".mjpeg", onJPEG, nil)
+}
+
+// NewH264ImageDecoder allocates a H264Decoder that decodes a sequence of access units,
+// and passes every decoded picture to onImage.
+// The first access unit must contain SPS, PPS and an IDR.
+func NewH264ImageDecoder(ctx context.Context, pathName string, onImage func(*image.YCbCr)) (m *H264Decoder, err error) {
+	return newH264Decoder(ctx, pathName, PROBEPATH+strings.ReplaceAll(pathName, "/", "_")+".image", nil, onImage)
+}
+
+func newH264Decoder(ctx context.Context, pathName string, probeFileName string,
+	onJPEG func([]byte), onImage func(*image.YCbCr)) (m *H264Decoder, err error) {
 	m = &H264Decoder{}
 
 	m.pathName = pathName
-	m.gotNewData = make(chan struct{}, 10)
+	m.gotNewData = make(chan decoderInput, 10)
 	m.buffer = []byte{}
 	m.errChan = make(chan error, 10)
 	m.ctx = ctx
 	m.inService = true
+	m.onJPEG = onJPEG
+	m.onImage = onImage
 
 	_ = os.MkdirAll(PROBEPATH, 0775)
-	m.probeFileName = PROBEPATH + pathName
+	m.probeFileName = probeFileName
 
 	go func(ctx context.Context) {
 		ticker := time.NewTicker(RETRPAUSEDURITION)
@@ -246,7 +279,10 @@ func NewH264Decoder(ctx context.Context, pathName string, header []byte) (m *H26
 			}
 
 			select {
-			case <-m.gotNewData:
+			case in := <-m.gotNewData:
+				m.buffer = in.data
+				m.captureTime = in.captureTime
+
 				if !m.inited {
 					if err := m.initVideoCodec(); err != nil {
 						log.Println(ERRTAG, "path:", m.pathName, "-", err)
@@ -310,6 +346,11 @@ func (m *H264Decoder) decodeToJpg() {
 		}
 	}
 
+	//pictures produced by FFmpeg are saved into files, therefore they can't be passed to onJPEG
+	if m.onJPEG != nil || m.onImage != nil {
+		return
+	}
+
 	//extraDecode() use ffmpeg in $PATH to do final try if intraDecode() failed
 	if err := m.extraDecode(); err != nil {
 		log.Println(ERRTAG, "path:", m.pathName, "-",  err)
@@ -343,6 +384,21 @@ func (m *H264Decoder) intraDecode() error {
 		return err
 	}
 
+	if m.onImage != nil {
+		m.onImage(yuv)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, yuv, nil); err != nil {
+		return err
+	}
+
+	if m.onJPEG != nil {
+		m.onJPEG(buf.Bytes())
+		return nil
+	}
+
 	jpgFN := m.jpgFileName()
 	f, err := os.Create(jpgFN)
 	if err != nil {
@@ -350,10 +406,12 @@ func (m *H264Decoder) intraDecode() error {
 	}
 	defer f.Close()
 
-	if err = jpeg.Encode(f, yuv, nil); err != nil {
+	if _, err = f.Write(buf.Bytes()); err != nil {
 		return err
 	}
 
+	m.setFileTime(jpgFN)
+
 	log.Println(INFOTAG, "path:", m.pathName, "- intraDecode() snap a picture",  jpgFN)
 
 	return nil
@@ -377,6 +435,8 @@ func (m *H264Decoder) extraDecode() error {
 		return err
 	}
 
+	m.setFileTime(jpgFN)
+
 	log.Println(INFOTAG, "path:", m.pathName, "- intraDecode() snap a picture",  jpgFN)
 	return nil
 }
@@ -390,16 +450,48 @@ func (m *H264Decoder) delProbeFile() error {
 }
 
 func (m *H264Decoder) GatherData(data []byte) {
-	m.buffer = data
-	m.gotNewData <- struct{}{}
+	m.gotNewData <- decoderInput{data: data}
+}
+
+// GatherDataAt passes data to the decoder, together with its capture time,
+// that is used to name and date the snapshot. A zero time means unknown.
+func (m *H264Decoder) GatherDataAt(data []byte, captureTime time.Time) {
+	m.gotNewData <- decoderInput{data: data, captureTime: captureTime}
+}
+
+// TryGatherData passes data to the decoder, unless the decoder is busy.
+// It returns false when data has been discarded.
+func (m *H264Decoder) TryGatherData(data []byte) bool {
+	select {
+	case m.gotNewData <- decoderInput{data: data}:
+		return true
+	default:
+		return false
+	}
 }
 
 func (m *H264Decoder) clearBuffer() {
 	m.buffer = []byte{}
 }
 
+// snapTime returns the capture time of the access unit being decoded,
+// or the current time if it's unknown.
+func (m *H264Decoder) snapTime() time.Time {
+	if !m.captureTime.IsZero() {
+		return m.captureTime
+	}
+	return time.Now()
+}
+
+// setFileTime sets the modification time of a snapshot to the capture time of the picture.
+func (m *H264Decoder) setFileTime(fname string) {
+	if !m.captureTime.IsZero() {
+		_ = os.Chtimes(fname, m.captureTime, m.captureTime)
+	}
+}
+
 func (m *H264Decoder) jpgFileName() string {
-	imgName := OUTIMGPATH + `/` + IMGFILEPREFIX + strconv.FormatInt(time.Now().Unix(), 10) +
+	imgName := OUTIMGPATH + `/` + IMGFILEPREFIX + strconv.FormatInt(m.snapTime().Unix(), 10) +
 		`.` + m.pathName + `.jpg`
 	return imgName
 }
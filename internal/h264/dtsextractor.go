@@ -0,0 +1,213 @@
+package h264
+
+import (
+	"time"
+)
+
+type dtsExtractorFrame struct {
+	gop int
+	poc int32
+	pts time.Duration
+}
+
+func (f dtsExtractorFrame) before(o dtsExtractorFrame) bool {
+	if f.gop != o.gop {
+		return f.gop < o.gop
+	}
+	return f.poc < o.poc
+}
+
+// reorderFrames returns the maximum number of frames that can precede a frame
+// in decoding order and follow it in output order, or -1 if it's unknown.
+func reorderFrames(sps *SPS) int {
+	switch {
+	// output order is the same as decoding order
+	case sps.PicOrderCntType == 2:
+		return 0
+
+	case sps.VUI != nil && sps.VUI.BitstreamRestriction:
+		return int(sps.VUI.MaxNumReorderFrames)
+
+	// the baseline profile doesn't support B-frames
+	case sps.ProfileIdc == 66:
+		return 0
+	}
+
+	return -1
+}
+
+// DTSExtractor computes the DTS of access units from the picture order count (POC)
+// of their slices. The DTS of an access unit is the PTS of the frame that
+// is output reorderFrames frames before the last received one.
+//
+// When the POC or the reorder depth of the stream can't be obtained (i.e. with
+// interlaced streams, with pic_order_cnt_type = 1 or when the SPS doesn't
+// contain bitstream restrictions), DTS is estimated with a DTSEstimator.
+type DTSExtractor struct {
+	sps           *SPS
+	pps           *PPS
+	reorderFrames int
+	estimator     *DTSEstimator
+
+	gop        int
+	prevPOCMsb int32
+	prevPOCLsb int32
+	window     []dtsExtractorFrame // the last reorderFrames+1 frames in output order
+}
+
+// NewDTSExtractor allocates a DTSExtractor.
+func NewDTSExtractor(sps []byte, pps []byte) *DTSExtractor {
+	d := &DTSExtractor{
+		reorderFrames: -1,
+		estimator:     NewDTSEstimator(),
+	}
+	d.setSPS(sps)
+	d.setPPS(pps)
+	return d
+}
+
+func (d *DTSExtractor) setSPS(nalu []byte) {
+	var sps SPS
+	err := sps.Unmarshal(nalu)
+	if err != nil {
+		d.sps = nil
+		d.reorderFrames = -1
+		return
+	}
+
+	d.sps = &sps
+	d.reorderFrames = reorderFrames(&sps)
+}
+
+func (d *DTSExtractor) setPPS(nalu []byte) {
+	var pps PPS
+	err := pps.Unmarshal(nalu)
+	if err != nil {
+		d.pps = nil
+		return
+	}
+
+	d.pps = &pps
+}
+
+// Extract returns the DTS of an access unit.
+func (d *DTSExtractor) Extract(nalus [][]byte, pts time.Duration) time.Duration {
+	// the estimator is always fed, in order to be ready when it's needed
+	estimated := d.estimator.Feed(pts)
+
+	dts, ok := d.extract(nalus, pts)
+	if !ok {
+		return estimated
+	}
+
+	if dts > pts {
+		dts = pts
+	}
+
+	return dts
+}
+
+func (d *DTSExtractor) extract(nalus [][]byte, pts time.Duration) (time.Duration, bool) {
+	var slice []byte
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		switch NALUType(nalu[0] & 0x1F) {
+		case NALUTypeSPS:
+			d.setSPS(nalu)
+
+		case NALUTypePPS:
+			d.setPPS(nalu)
+
+		case NALUTypeNonIDR, NALUTypeIDR:
+			if slice == nil {
+				slice = nalu
+			}
+		}
+	}
+
+	if slice == nil || d.sps == nil || d.reorderFrames < 0 {
+		return 0, false
+	}
+
+	if d.reorderFrames == 0 {
+		return pts, true
+	}
+
+	if d.pps == nil || !d.sps.FrameMbsOnly || d.sps.PicOrderCntType != 0 {
+		return 0, false
+	}
+
+	var h SliceHeader
+	err := h.Unmarshal(slice, d.sps, d.pps)
+	if err != nil {
+		return 0, false
+	}
+
+	poc := d.poc(&h)
+
+	f := dtsExtractorFrame{
+		gop: d.gop,
+		poc: poc,
+		pts: pts,
+	}
+
+	// insert the frame into the window, that is sorted in output order
+	i := len(d.window)
+	for i > 0 && f.before(d.window[i-1]) {
+		i--
+	}
+	d.window = append(d.window, dtsExtractorFrame{})
+	copy(d.window[i+1:], d.window[i:])
+	d.window[i] = f
+
+	if len(d.window) > (d.reorderFrames + 1) {
+		d.window = d.window[1:]
+	}
+
+	// at the beginning of the stream, the window is not full yet:
+	// use DTS values that precede the first PTS.
+	missing := d.reorderFrames + 1 - len(d.window)
+	return d.window[0].pts - time.Duration(missing)*time.Millisecond, true
+}
+
+// poc computes the picture order count of a frame with pic_order_cnt_type = 0.
+func (d *DTSExtractor) poc(h *SliceHeader) int32 {
+	if h.IDR {
+		d.gop++
+		d.prevPOCMsb = 0
+		d.prevPOCLsb = 0
+	}
+
+	maxLsb := int32(1) << d.sps.Log2MaxPicOrderCntLsb
+	lsb := int32(h.PicOrderCntLsb)
+
+	var msb int32
+	switch {
+	case lsb < d.prevPOCLsb && (d.prevPOCLsb-lsb) >= (maxLsb/2):
+		msb = d.prevPOCMsb + maxLsb
+
+	case lsb > d.prevPOCLsb && (lsb-d.prevPOCLsb) > (maxLsb/2):
+		msb = d.prevPOCMsb - maxLsb
+
+	default:
+		msb = d.prevPOCMsb
+	}
+
+	// only reference pictures are used to compute the POC of next pictures
+	if h.NALRefIdc != 0 {
+		d.prevPOCMsb = msb
+		d.prevPOCLsb = lsb
+	}
+
+	// the POC of a frame is the minimum between the one of the top field
+	// and the one of the bottom field
+	top := msb + lsb
+	if h.DeltaPicOrderCntBottom < 0 {
+		return top + h.DeltaPicOrderCntBottom
+	}
+	return top
+}
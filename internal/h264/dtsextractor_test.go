@@ -0,0 +1,211 @@
+package h264
+
+import (
+	"math/bits"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testBitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *testBitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if (w.n % 8) == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if (v>>i)&0x01 != 0 {
+			w.buf[len(w.buf)-1] |= 1 << (7 - (w.n % 8))
+		}
+		w.n++
+	}
+}
+
+func (w *testBitWriter) writeFlag(v bool) {
+	if v {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+func (w *testBitWriter) writeUE(v uint32) {
+	n := bits.Len32(v + 1)
+	w.writeBits(0, n-1)
+	w.writeBits(v+1, n)
+}
+
+// bytes returns the buffer, terminated by rbsp_trailing_bits.
+func (w *testBitWriter) bytes() []byte {
+	w.writeBits(1, 1)
+	return w.buf
+}
+
+// testSPS generates a SPS with pic_order_cnt_type = 0 and log2_max_pic_order_cnt_lsb = 4.
+func testSPS(profileIdc uint8, maxNumReorderFrames int) []byte {
+	w := &testBitWriter{}
+	w.writeBits(0x67, 8)
+	w.writeBits(uint32(profileIdc), 8)
+	w.writeBits(0, 8)  // constraint_set_flags
+	w.writeBits(31, 8) // level_idc
+	w.writeUE(0)       // seq_parameter_set_id
+	w.writeUE(0)       // log2_max_frame_num_minus4
+	w.writeUE(0)       // pic_order_cnt_type
+	w.writeUE(0)       // log2_max_pic_order_cnt_lsb_minus4
+	w.writeUE(2)       // max_num_ref_frames
+	w.writeFlag(false) // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(19)      // pic_width_in_mbs_minus1
+	w.writeUE(14)      // pic_height_in_map_units_minus1
+	w.writeFlag(true)  // frame_mbs_only_flag
+	w.writeFlag(true)  // direct_8x8_inference_flag
+	w.writeFlag(false) // frame_cropping_flag
+
+	w.writeFlag(maxNumReorderFrames >= 0) // vui_parameters_present_flag
+	if maxNumReorderFrames >= 0 {
+		w.writeBits(0, 8) // from aspect_ratio_info_present_flag to pic_struct_present_flag
+		w.writeFlag(true) // bitstream_restriction_flag
+		w.writeFlag(true) // motion_vectors_over_pic_boundaries_flag
+		for i := 0; i < 4; i++ {
+			w.writeUE(0)
+		}
+		w.writeUE(uint32(maxNumReorderFrames))
+		w.writeUE(2) // max_dec_frame_buffering
+	}
+
+	return w.bytes()
+}
+
+func testPPS() []byte {
+	w := &testBitWriter{}
+	w.writeBits(0x68, 8)
+	w.writeUE(0)       // pic_parameter_set_id
+	w.writeUE(0)       // seq_parameter_set_id
+	w.writeFlag(false) // entropy_coding_mode_flag
+	w.writeFlag(false) // bottom_field_pic_order_in_frame_present_flag
+	return w.bytes()
+}
+
+func testSlice(typ NALUType, ref bool, sliceType uint32, frameNum uint32, poc uint32) []byte {
+	w := &testBitWriter{}
+	w.writeBits(0, 1)
+	if ref {
+		w.writeBits(3, 2)
+	} else {
+		w.writeBits(0, 2)
+	}
+	w.writeBits(uint32(typ), 5)
+	w.writeUE(0)         // first_mb_in_slice
+	w.writeUE(sliceType) // slice_type
+	w.writeUE(0)         // pic_parameter_set_id
+	w.writeBits(frameNum%16, 4)
+	if typ == NALUTypeIDR {
+		w.writeUE(0) // idr_pic_id
+	}
+	w.writeBits(poc%16, 4)
+	return w.bytes()
+}
+
+func TestSliceHeaderUnmarshal(t *testing.T) {
+	var sps SPS
+	err := sps.Unmarshal(testSPS(77, 1))
+	require.NoError(t, err)
+
+	var pps PPS
+	err = pps.Unmarshal(testPPS())
+	require.NoError(t, err)
+
+	var h SliceHeader
+	err = h.Unmarshal(testSlice(NALUTypeIDR, true, 7, 0, 0), &sps, &pps)
+	require.NoError(t, err)
+	require.Equal(t, SliceHeader{
+		NALRefIdc: 3,
+		IDR:       true,
+		SliceType: 7,
+	}, h)
+
+	err = h.Unmarshal(testSlice(NALUTypeNonIDR, false, 6, 3, 10), &sps, &pps)
+	require.NoError(t, err)
+	require.Equal(t, SliceHeader{
+		SliceType:      6,
+		FrameNum:       3,
+		PicOrderCntLsb: 10,
+	}, h)
+}
+
+func TestDTSExtractor(t *testing.T) {
+	// I P B B P B B P B B I P B B, with a frame every 40ms
+	type frame struct {
+		display int
+		typ     NALUType
+		ref     bool
+		typSlc  uint32
+	}
+	var frames []frame
+	frameNum := uint32(0)
+	for _, gopStart := range []int{0, 10} {
+		frames = append(frames, frame{gopStart, NALUTypeIDR, true, 7})
+		for p := gopStart + 3; p < gopStart+10; p += 3 {
+			frames = append(frames,
+				frame{p, NALUTypeNonIDR, true, 5},
+				frame{p - 2, NALUTypeNonIDR, false, 6},
+				frame{p - 1, NALUTypeNonIDR, false, 6})
+		}
+	}
+
+	d := NewDTSExtractor(testSPS(77, 1), testPPS())
+
+	gopStart := 0
+	for i, f := range frames {
+		if f.typ == NALUTypeIDR {
+			gopStart = f.display
+			frameNum = 0
+		}
+
+		pts := time.Duration(f.display) * 40 * time.Millisecond
+		dts := d.Extract([][]byte{
+			testSlice(f.typ, f.ref, f.typSlc, frameNum, uint32(2*(f.display-gopStart))),
+		}, pts)
+
+		if f.ref {
+			frameNum++
+		}
+
+		if i == 0 {
+			require.Equal(t, -1*time.Millisecond, dts)
+		} else {
+			require.Equal(t, time.Duration(i-1)*40*time.Millisecond, dts)
+		}
+		require.LessOrEqual(t, int64(dts), int64(pts))
+	}
+}
+
+func TestDTSExtractorFallback(t *testing.T) {
+	// baseline profile: DTS is equal to PTS
+	d := NewDTSExtractor(testSPS(66, -1), testPPS())
+	dts := d.Extract([][]byte{testSlice(NALUTypeIDR, true, 7, 0, 0)}, 2*time.Second)
+	require.Equal(t, 2*time.Second, dts)
+	dts = d.Extract([][]byte{testSlice(NALUTypeNonIDR, true, 5, 1, 2)}, 2*time.Second+40*time.Millisecond)
+	require.Equal(t, 2*time.Second+40*time.Millisecond, dts)
+
+	// reorder depth is unknown: DTS is estimated
+	d = NewDTSExtractor(testSPS(77, -1), testPPS())
+	est := NewDTSEstimator()
+	for _, pts := range []time.Duration{
+		2 * time.Second,
+		2*time.Second - 200*time.Millisecond,
+		2*time.Second - 400*time.Millisecond,
+		2*time.Second + 200*time.Millisecond,
+	} {
+		dts := d.Extract([][]byte{testSlice(NALUTypeNonIDR, true, 5, 0, 0)}, pts)
+		require.Equal(t, est.Feed(pts), dts)
+	}
+
+	// parameters are sent in-band
+	d = NewDTSExtractor(nil, nil)
+	dts = d.Extract([][]byte{testSPS(66, -1), testPPS(), testSlice(NALUTypeIDR, true, 7, 0, 0)}, 3*time.Second)
+	require.Equal(t, 3*time.Second, dts)
+}
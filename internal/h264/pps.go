@@ -0,0 +1,50 @@
+package h264
+
+import (
+	"fmt"
+)
+
+// PPS is a H264 picture parameter set.
+// Only the fields that are needed to parse slice headers are decoded.
+type PPS struct {
+	ID                                uint32
+	SPSID                             uint32
+	EntropyCodingMode                 bool
+	BottomFieldPicOrderInFramePresent bool
+}
+
+// Unmarshal decodes a PPS from a NALU.
+func (p *PPS) Unmarshal(nalu []byte) error {
+	if len(nalu) < 2 {
+		return fmt.Errorf("PPS is too short")
+	}
+
+	if NALUType(nalu[0]&0x1F) != NALUTypePPS {
+		return fmt.Errorf("not a PPS")
+	}
+
+	r := &bitReader{buf: AntiCompetitionRemove(nalu[1:])}
+
+	var err error
+	p.ID, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	p.SPSID, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	p.EntropyCodingMode, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	p.BottomFieldPicOrderInFramePresent, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
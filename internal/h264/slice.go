@@ -0,0 +1,138 @@
+package h264
+
+import (
+	"fmt"
+)
+
+// SliceHeader is the header of a H264 slice.
+// Only the fields up to the picture order count are decoded.
+type SliceHeader struct {
+	NALRefIdc              uint8
+	IDR                    bool
+	FirstMBInSlice         uint32
+	SliceType              uint32
+	PPSID                  uint32
+	FrameNum               uint32
+	FieldPic               bool
+	BottomField            bool
+	IDRPicID               uint32
+	PicOrderCntLsb         uint32
+	DeltaPicOrderCntBottom int32
+	DeltaPicOrderCnt       [2]int32
+}
+
+// Unmarshal decodes a slice header from a NALU, with the given parameters.
+func (h *SliceHeader) Unmarshal(nalu []byte, sps *SPS, pps *PPS) error {
+	if len(nalu) < 2 {
+		return fmt.Errorf("slice is too short")
+	}
+
+	typ := NALUType(nalu[0] & 0x1F)
+	if typ != NALUTypeNonIDR && typ != NALUTypeIDR {
+		return fmt.Errorf("not a slice")
+	}
+
+	h.NALRefIdc = (nalu[0] >> 5) & 0x03
+	h.IDR = (typ == NALUTypeIDR)
+
+	// the header is at the beginning of the slice, therefore it's not
+	// necessary to remove emulation prevention bytes from the entire NALU.
+	buf := nalu[1:]
+	if len(buf) > 64 {
+		buf = buf[:64]
+	}
+	r := &bitReader{buf: AntiCompetitionRemove(buf)}
+
+	var err error
+	h.FirstMBInSlice, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	h.SliceType, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	h.PPSID, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	if h.PPSID != pps.ID {
+		return fmt.Errorf("slice refers to an unknown PPS (%d)", h.PPSID)
+	}
+
+	if sps.SeparateColourPlane {
+		// colour_plane_id
+		_, err = r.readBits(2)
+		if err != nil {
+			return err
+		}
+	}
+
+	h.FrameNum, err = r.readBits(int(sps.Log2MaxFrameNum))
+	if err != nil {
+		return err
+	}
+
+	h.FieldPic = false
+	h.BottomField = false
+
+	if !sps.FrameMbsOnly {
+		h.FieldPic, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		if h.FieldPic {
+			h.BottomField, err = r.readFlag()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if h.IDR {
+		h.IDRPicID, err = r.readUE()
+		if err != nil {
+			return err
+		}
+	}
+
+	h.PicOrderCntLsb = 0
+	h.DeltaPicOrderCntBottom = 0
+	h.DeltaPicOrderCnt = [2]int32{}
+
+	switch sps.PicOrderCntType {
+	case 0:
+		h.PicOrderCntLsb, err = r.readBits(int(sps.Log2MaxPicOrderCntLsb))
+		if err != nil {
+			return err
+		}
+
+		if pps.BottomFieldPicOrderInFramePresent && !h.FieldPic {
+			h.DeltaPicOrderCntBottom, err = r.readSE()
+			if err != nil {
+				return err
+			}
+		}
+
+	case 1:
+		if !sps.DeltaPicOrderAlwaysZero {
+			h.DeltaPicOrderCnt[0], err = r.readSE()
+			if err != nil {
+				return err
+			}
+
+			if pps.BottomFieldPicOrderInFramePresent && !h.FieldPic {
+				h.DeltaPicOrderCnt[1], err = r.readSE()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
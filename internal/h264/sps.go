@@ -0,0 +1,703 @@
+package h264
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type bitReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *bitReader) readBits(n int) (uint32, error) {
+	if n > 32 {
+		return 0, fmt.Errorf("unable to read more than 32 bits at once")
+	}
+
+	if (r.pos + n) > len(r.buf)*8 {
+		return 0, fmt.Errorf("not enough bits")
+	}
+
+	v := uint32(0)
+	for i := 0; i < n; i++ {
+		v <<= 1
+		v |= uint32(r.buf[r.pos>>3]>>(7-(r.pos&0x07))) & 0x01
+		r.pos++
+	}
+
+	return v, nil
+}
+
+func (r *bitReader) readFlag() (bool, error) {
+	v, err := r.readBits(1)
+	return v == 1, err
+}
+
+// readUE reads an unsigned Exp-Golomb code.
+func (r *bitReader) readUE() (uint32, error) {
+	leadingZeros := 0
+	for {
+		b, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+
+		if b != 0 {
+			break
+		}
+
+		leadingZeros++
+		if leadingZeros > 31 {
+			return 0, fmt.Errorf("invalid Exp-Golomb code")
+		}
+	}
+
+	v, err := r.readBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+
+	return (1 << leadingZeros) - 1 + v, nil
+}
+
+// readSE reads a signed Exp-Golomb code.
+func (r *bitReader) readSE() (int32, error) {
+	v, err := r.readUE()
+	if err != nil {
+		return 0, err
+	}
+
+	if (v & 0x01) != 0 {
+		return int32((v + 1) / 2), nil
+	}
+	return -int32(v / 2), nil
+}
+
+func (r *bitReader) skipScalingList(size int) error {
+	lastScale := int32(8)
+	nextScale := int32(8)
+
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			delta, err := r.readSE()
+			if err != nil {
+				return err
+			}
+
+			nextScale = (lastScale + delta + 256) % 256
+		}
+
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+
+	return nil
+}
+
+func (r *bitReader) skipHRDParameters() error {
+	cpbCnt, err := r.readUE()
+	if err != nil {
+		return err
+	}
+
+	// bit_rate_scale, cpb_size_scale
+	_, err = r.readBits(8)
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i <= cpbCnt; i++ {
+		// bit_rate_value_minus1
+		_, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		// cpb_size_value_minus1
+		_, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		// cbr_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+	}
+
+	// initial_cpb_removal_delay_length_minus1, cpb_removal_delay_length_minus1,
+	// dpb_output_delay_length_minus1, time_offset_length
+	_, err = r.readBits(20)
+	return err
+}
+
+// SPSVUI contains the video usability information of a SPS.
+type SPSVUI struct {
+	// sample aspect ratio. Both are zero when unspecified.
+	SARWidth  uint32
+	SARHeight uint32
+
+	VideoFullRange          bool
+	ColourDescriptionExists bool
+	ColourPrimaries         uint8
+	TransferCharacteristics uint8
+	MatrixCoefficients      uint8
+
+	TimingInfoPresent bool
+	NumUnitsInTick    uint32
+	TimeScale         uint32
+	FixedFrameRate    bool
+
+	BitstreamRestriction bool
+	MaxNumReorderFrames  uint32
+	MaxDecFrameBuffering uint32
+}
+
+// predefined sample aspect ratios, indexed by aspect_ratio_idc.
+var spsSampleAspectRatios = [][2]uint32{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11},
+	{32, 11}, {80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+func (v *SPSVUI) read(r *bitReader) error {
+	aspectRatioInfoPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if aspectRatioInfoPresent {
+		idc, err := r.readBits(8)
+		if err != nil {
+			return err
+		}
+
+		if idc == 255 {
+			v.SARWidth, err = r.readBits(16)
+			if err != nil {
+				return err
+			}
+
+			v.SARHeight, err = r.readBits(16)
+			if err != nil {
+				return err
+			}
+		} else if int(idc) < len(spsSampleAspectRatios) {
+			v.SARWidth = spsSampleAspectRatios[idc][0]
+			v.SARHeight = spsSampleAspectRatios[idc][1]
+		}
+	}
+
+	overscanInfoPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if overscanInfoPresent {
+		// overscan_appropriate_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+	}
+
+	videoSignalTypePresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if videoSignalTypePresent {
+		// video_format
+		_, err = r.readBits(3)
+		if err != nil {
+			return err
+		}
+
+		v.VideoFullRange, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		v.ColourDescriptionExists, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		if v.ColourDescriptionExists {
+			tmp, err := r.readBits(24)
+			if err != nil {
+				return err
+			}
+
+			v.ColourPrimaries = uint8(tmp >> 16)
+			v.TransferCharacteristics = uint8(tmp >> 8)
+			v.MatrixCoefficients = uint8(tmp)
+		}
+	}
+
+	chromaLocInfoPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if chromaLocInfoPresent {
+		// chroma_sample_loc_type_top_field
+		_, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		// chroma_sample_loc_type_bottom_field
+		_, err = r.readUE()
+		if err != nil {
+			return err
+		}
+	}
+
+	v.TimingInfoPresent, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if v.TimingInfoPresent {
+		v.NumUnitsInTick, err = r.readBits(32)
+		if err != nil {
+			return err
+		}
+
+		v.TimeScale, err = r.readBits(32)
+		if err != nil {
+			return err
+		}
+
+		v.FixedFrameRate, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+	}
+
+	nalHRDPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if nalHRDPresent {
+		err = r.skipHRDParameters()
+		if err != nil {
+			return err
+		}
+	}
+
+	vclHRDPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if vclHRDPresent {
+		err = r.skipHRDParameters()
+		if err != nil {
+			return err
+		}
+	}
+
+	if nalHRDPresent || vclHRDPresent {
+		// low_delay_hrd_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+	}
+
+	// pic_struct_present_flag
+	_, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	v.BitstreamRestriction, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if v.BitstreamRestriction {
+		// motion_vectors_over_pic_boundaries_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		// max_bytes_per_pic_denom, max_bits_per_mb_denom,
+		// log2_max_mv_length_horizontal, log2_max_mv_length_vertical
+		for i := 0; i < 4; i++ {
+			_, err = r.readUE()
+			if err != nil {
+				return err
+			}
+		}
+
+		v.MaxNumReorderFrames, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		v.MaxDecFrameBuffering, err = r.readUE()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SPS is a H264 sequence parameter set.
+type SPS struct {
+	ProfileIdc         uint8
+	ConstraintSetFlags uint8
+	LevelIdc           uint8
+	ID                 uint32
+	ChromaFormatIdc    uint32
+
+	SeparateColourPlane           bool
+	Log2MaxFrameNum               uint32
+	PicOrderCntType               uint32
+	Log2MaxPicOrderCntLsb         uint32
+	DeltaPicOrderAlwaysZero       bool
+	OffsetForNonRefPic            int32
+	OffsetForTopToBottomField     int32
+	OffsetForRefFrames            []int32
+	MaxNumRefFrames               uint32
+	GapsInFrameNumValueAllowed    bool
+	PicWidthInMbsMinus1           uint32
+	PicHeightInMapUnitsMinus1     uint32
+	FrameMbsOnly                  bool
+	Direct8x8Inference            bool
+	FrameCropLeft, FrameCropRight uint32
+	FrameCropTop, FrameCropBottom uint32
+
+	// video usability information. It is nil when not present.
+	VUI *SPSVUI
+}
+
+// Unmarshal decodes a SPS from a NALU.
+func (s *SPS) Unmarshal(nalu []byte) error {
+	if len(nalu) < 4 {
+		return fmt.Errorf("SPS is too short")
+	}
+
+	if NALUType(nalu[0]&0x1F) != NALUTypeSPS {
+		return fmt.Errorf("not a SPS")
+	}
+
+	buf := AntiCompetitionRemove(nalu[1:])
+
+	s.ProfileIdc = buf[0]
+	s.ConstraintSetFlags = buf[1]
+	s.LevelIdc = buf[2]
+
+	r := &bitReader{buf: buf, pos: 24}
+
+	var err error
+	s.ID, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	s.ChromaFormatIdc = 1
+
+	switch s.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		s.ChromaFormatIdc, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		if s.ChromaFormatIdc == 3 {
+			s.SeparateColourPlane, err = r.readFlag()
+			if err != nil {
+				return err
+			}
+		}
+
+		// bit_depth_luma_minus8
+		_, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		// bit_depth_chroma_minus8
+		_, err = r.readUE()
+		if err != nil {
+			return err
+		}
+
+		// qpprime_y_zero_transform_bypass_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		scalingMatrixPresent, err := r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		if scalingMatrixPresent {
+			count := 8
+			if s.ChromaFormatIdc == 3 {
+				count = 12
+			}
+
+			for i := 0; i < count; i++ {
+				present, err := r.readFlag()
+				if err != nil {
+					return err
+				}
+
+				if present {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+
+					err = r.skipScalingList(size)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	tmp, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	s.Log2MaxFrameNum = tmp + 4
+
+	s.PicOrderCntType, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	switch s.PicOrderCntType {
+	case 0:
+		tmp, err := r.readUE()
+		if err != nil {
+			return err
+		}
+		s.Log2MaxPicOrderCntLsb = tmp + 4
+
+	case 1:
+		s.DeltaPicOrderAlwaysZero, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		s.OffsetForNonRefPic, err = r.readSE()
+		if err != nil {
+			return err
+		}
+
+		s.OffsetForTopToBottomField, err = r.readSE()
+		if err != nil {
+			return err
+		}
+
+		count, err := r.readUE()
+		if err != nil {
+			return err
+		}
+		if count > 255 {
+			return fmt.Errorf("invalid num_ref_frames_in_pic_order_cnt_cycle")
+		}
+
+		s.OffsetForRefFrames = make([]int32, count)
+		for i := range s.OffsetForRefFrames {
+			s.OffsetForRefFrames[i], err = r.readSE()
+			if err != nil {
+				return err
+			}
+		}
+
+	case 2:
+
+	default:
+		return fmt.Errorf("invalid pic_order_cnt_type: %d", s.PicOrderCntType)
+	}
+
+	s.MaxNumRefFrames, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	s.GapsInFrameNumValueAllowed, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	s.PicWidthInMbsMinus1, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	s.PicHeightInMapUnitsMinus1, err = r.readUE()
+	if err != nil {
+		return err
+	}
+
+	s.FrameMbsOnly, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if !s.FrameMbsOnly {
+		// mb_adaptive_frame_field_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.Direct8x8Inference, err = r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	frameCropping, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if frameCropping {
+		for _, v := range []*uint32{&s.FrameCropLeft, &s.FrameCropRight, &s.FrameCropTop, &s.FrameCropBottom} {
+			*v, err = r.readUE()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.SeparateColourPlane {
+		// ChromaArrayType is 0, cropping is performed as with monochrome
+		s.ChromaFormatIdc = 0
+	}
+
+	vuiPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+
+	if vuiPresent {
+		s.VUI = &SPSVUI{}
+		err = s.VUI.read(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Width returns the video width.
+func (s SPS) Width() int {
+	cropUnitX := uint32(1)
+	if s.ChromaFormatIdc == 1 || s.ChromaFormatIdc == 2 {
+		cropUnitX = 2
+	}
+
+	return int((s.PicWidthInMbsMinus1+1)*16 - cropUnitX*(s.FrameCropLeft+s.FrameCropRight))
+}
+
+// Height returns the video height.
+func (s SPS) Height() int {
+	frameHeightFactor := uint32(1)
+	if !s.FrameMbsOnly {
+		frameHeightFactor = 2
+	}
+
+	cropUnitY := frameHeightFactor
+	if s.ChromaFormatIdc == 1 {
+		cropUnitY *= 2
+	}
+
+	return int(frameHeightFactor*(s.PicHeightInMapUnitsMinus1+1)*16 - cropUnitY*(s.FrameCropTop+s.FrameCropBottom))
+}
+
+// FPS returns the frame rate, or zero if it is not available.
+func (s SPS) FPS() float64 {
+	if s.VUI == nil || !s.VUI.TimingInfoPresent || s.VUI.NumUnitsInTick == 0 {
+		return 0
+	}
+
+	// a tick is a field; a frame is made of two fields
+	return float64(s.VUI.TimeScale) / float64(2*s.VUI.NumUnitsInTick)
+}
+
+// Profile returns the name of the profile.
+func (s SPS) Profile() string {
+	switch s.ProfileIdc {
+	case 66:
+		if (s.ConstraintSetFlags & 0x40) != 0 {
+			return "Constrained Baseline"
+		}
+		return "Baseline"
+
+	case 77:
+		return "Main"
+
+	case 88:
+		return "Extended"
+
+	case 100:
+		return "High"
+
+	case 110:
+		return "High 10"
+
+	case 122:
+		return "High 4:2:2"
+
+	case 244:
+		return "High 4:4:4 Predictive"
+
+	case 44:
+		return "CAVLC 4:4:4 Intra"
+	}
+
+	return "unknown (" + strconv.FormatInt(int64(s.ProfileIdc), 10) + ")"
+}
+
+// Level returns the name of the level.
+func (s SPS) Level() string {
+	// level 1b is signaled with level_idc = 11 and constraint_set3_flag
+	// in the baseline, main and extended profiles, or with level_idc = 9.
+	if s.LevelIdc == 9 ||
+		(s.LevelIdc == 11 && (s.ConstraintSetFlags&0x10) != 0 &&
+			(s.ProfileIdc == 66 || s.ProfileIdc == 77 || s.ProfileIdc == 88)) {
+		return "1b"
+	}
+
+	ret := strconv.FormatInt(int64(s.LevelIdc/10), 10)
+	if (s.LevelIdc % 10) != 0 {
+		ret += "." + strconv.FormatInt(int64(s.LevelIdc%10), 10)
+	}
+	return ret
+}
+
+// ChromaFormat returns the chroma subsampling of the video.
+func (s SPS) ChromaFormat() string {
+	switch s.ChromaFormatIdc {
+	case 0:
+		return "4:0:0"
+
+	case 1:
+		return "4:2:0"
+
+	case 2:
+		return "4:2:2"
+	}
+
+	return "4:4:4"
+}
@@ -0,0 +1,166 @@
+package h264
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPSUnmarshal(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		byts    []byte
+		sps     SPS
+		width   int
+		height  int
+		fps     float64
+		profile string
+		level   string
+	}{
+		{
+			"352x288",
+			[]byte{
+				0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+				0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+				0x00, 0x03, 0x00, 0x3d, 0x08,
+			},
+			SPS{
+				ProfileIdc:                 100,
+				LevelIdc:                   12,
+				ChromaFormatIdc:            1,
+				Log2MaxFrameNum:            10,
+				PicOrderCntType:            2,
+				MaxNumRefFrames:            1,
+				GapsInFrameNumValueAllowed: true,
+				PicWidthInMbsMinus1:        21,
+				PicHeightInMapUnitsMinus1:  17,
+				FrameMbsOnly:               true,
+				Direct8x8Inference:         true,
+				VUI: &SPSVUI{
+					TimingInfoPresent: true,
+					NumUnitsInTick:    1,
+					TimeScale:         30,
+					FixedFrameRate:    true,
+				},
+			},
+			352,
+			288,
+			15,
+			"High",
+			"1.2",
+		},
+		{
+			"1280x720",
+			[]byte{
+				0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+				0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+				0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+				0xcb,
+			},
+			SPS{
+				ProfileIdc:                100,
+				LevelIdc:                  31,
+				ChromaFormatIdc:           1,
+				Log2MaxFrameNum:           4,
+				Log2MaxPicOrderCntLsb:     6,
+				MaxNumRefFrames:           4,
+				PicWidthInMbsMinus1:       79,
+				PicHeightInMapUnitsMinus1: 44,
+				FrameMbsOnly:              true,
+				Direct8x8Inference:        true,
+				VUI: &SPSVUI{
+					SARWidth:             1,
+					SARHeight:            1,
+					VideoFullRange:       true,
+					TimingInfoPresent:    true,
+					NumUnitsInTick:       1,
+					TimeScale:            60,
+					BitstreamRestriction: true,
+					MaxNumReorderFrames:  2,
+					MaxDecFrameBuffering: 4,
+				},
+			},
+			1280,
+			720,
+			30,
+			"High",
+			"3.1",
+		},
+		{
+			"1920x1080",
+			[]byte{
+				0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78,
+				0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03,
+				0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c,
+				0x60, 0xc6, 0x58,
+			},
+			SPS{
+				ProfileIdc:                100,
+				LevelIdc:                  40,
+				ChromaFormatIdc:           1,
+				Log2MaxFrameNum:           4,
+				Log2MaxPicOrderCntLsb:     6,
+				MaxNumRefFrames:           4,
+				PicWidthInMbsMinus1:       119,
+				PicHeightInMapUnitsMinus1: 67,
+				FrameMbsOnly:              true,
+				Direct8x8Inference:        true,
+				FrameCropBottom:           4,
+				VUI: &SPSVUI{
+					SARWidth:             1,
+					SARHeight:            1,
+					TimingInfoPresent:    true,
+					NumUnitsInTick:       1,
+					TimeScale:            60,
+					BitstreamRestriction: true,
+					MaxNumReorderFrames:  2,
+					MaxDecFrameBuffering: 4,
+				},
+			},
+			1920,
+			1080,
+			30,
+			"High",
+			"4",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var sps SPS
+			err := sps.Unmarshal(ca.byts)
+			require.NoError(t, err)
+			require.Equal(t, ca.sps, sps)
+			require.Equal(t, ca.width, sps.Width())
+			require.Equal(t, ca.height, sps.Height())
+			require.Equal(t, ca.fps, sps.FPS())
+			require.Equal(t, ca.profile, sps.Profile())
+			require.Equal(t, ca.level, sps.Level())
+			require.Equal(t, "4:2:0", sps.ChromaFormat())
+		})
+	}
+}
+
+func TestSPSUnmarshalErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		byts []byte
+	}{
+		{
+			"too short",
+			[]byte{0x67, 0x64},
+		},
+		{
+			"not a SPS",
+			[]byte{0x68, 0x64, 0x00, 0x1f, 0xac},
+		},
+		{
+			"truncated",
+			[]byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var sps SPS
+			err := sps.Unmarshal(ca.byts)
+			require.Error(t, err)
+		})
+	}
+}
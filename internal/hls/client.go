@@ -0,0 +1,237 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// number of segments that are read from the end of the playlist
+	// when the reading of a live stream begins.
+	clientLiveStartSegmentCount = 3
+)
+
+type clientSegment struct {
+	uri string
+}
+
+type clientPlaylist struct {
+	// primary playlist
+	variants []Variant
+
+	// stream playlist
+	targetDuration time.Duration
+	mediaSequence  int
+	segments       []clientSegment
+	end            bool
+}
+
+func parseClientPlaylist(byts []byte) (*clientPlaylist, error) {
+	p := &clientPlaylist{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(byts))
+
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, fmt.Errorf("invalid playlist")
+	}
+
+	var curVariant *Variant
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			curVariant = &Variant{}
+			for _, attr := range strings.Split(line[len("#EXT-X-STREAM-INF:"):], ",") {
+				if strings.HasPrefix(attr, "BANDWIDTH=") {
+					curVariant.Bandwidth, _ = strconv.Atoi(attr[len("BANDWIDTH="):])
+				}
+			}
+
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			v, err := strconv.ParseUint(line[len("#EXT-X-TARGETDURATION:"):], 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target duration: %s", line)
+			}
+			p.targetDuration = time.Duration(v) * time.Second
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			v, err := strconv.ParseUint(line[len("#EXT-X-MEDIA-SEQUENCE:"):], 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence: %s", line)
+			}
+			p.mediaSequence = int(v)
+
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			return nil, fmt.Errorf("fMP4 segments are not supported")
+
+		case line == "#EXT-X-ENDLIST":
+			p.end = true
+
+		case strings.HasPrefix(line, "#"):
+
+		default:
+			if curVariant != nil {
+				curVariant.URI = line
+				p.variants = append(p.variants, *curVariant)
+				curVariant = nil
+			} else {
+				p.segments = append(p.segments, clientSegment{uri: line})
+			}
+		}
+	}
+
+	return p, scanner.Err()
+}
+
+// Client reads a HLS stream, by downloading its segments.
+type Client struct {
+	ur         *url.URL
+	httpClient *http.Client
+}
+
+// NewClient allocates a Client.
+func NewClient(ur string, readTimeout time.Duration) (*Client, error) {
+	u, err := url.Parse(ur)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	return &Client{
+		ur: u,
+		httpClient: &http.Client{
+			Timeout: readTimeout,
+		},
+	}, nil
+}
+
+func (c *Client) download(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// Run downloads the segments of the stream, in order, and writes their content into w.
+// It returns io.EOF when the stream ends.
+// If the URL points to a primary playlist, the stream with the highest bandwidth is read.
+func (c *Client) Run(ctx context.Context, w io.Writer) error {
+	playlistURL := c.ur
+	nextSequence := -1
+
+	for {
+		byts, err := c.download(ctx, playlistURL)
+		if err != nil {
+			return err
+		}
+
+		pl, err := parseClientPlaylist(byts)
+		if err != nil {
+			return err
+		}
+
+		if pl.variants != nil {
+			if nextSequence >= 0 {
+				return fmt.Errorf("stream playlist has been replaced by a primary playlist")
+			}
+
+			best := pl.variants[0]
+			for _, v := range pl.variants[1:] {
+				if v.Bandwidth > best.Bandwidth {
+					best = v
+				}
+			}
+
+			playlistURL, err = playlistURL.Parse(best.URI)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if nextSequence < 0 {
+			nextSequence = pl.mediaSequence
+			if !pl.end && len(pl.segments) > clientLiveStartSegmentCount {
+				nextSequence += len(pl.segments) - clientLiveStartSegmentCount
+			}
+		}
+
+		// segments have been removed before being read
+		if nextSequence < pl.mediaSequence {
+			nextSequence = pl.mediaSequence
+		}
+
+		downloaded := false
+
+		for i, seg := range pl.segments {
+			seq := pl.mediaSequence + i
+			if seq < nextSequence {
+				continue
+			}
+
+			u, err := playlistURL.Parse(seg.uri)
+			if err != nil {
+				return err
+			}
+
+			byts, err := c.download(ctx, u)
+			if err != nil {
+				return err
+			}
+
+			_, err = w.Write(byts)
+			if err != nil {
+				return err
+			}
+
+			nextSequence = seq + 1
+			downloaded = true
+		}
+
+		if pl.end {
+			return io.EOF
+		}
+
+		// wait before reloading a playlist that has not changed
+		if !downloaded {
+			wait := pl.targetDuration / 2
+			if wait <= 0 {
+				wait = 1 * time.Second
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var mutex sync.Mutex
+	streamRequests := 0
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.m3u8":
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=200000\n" +
+				"low/stream.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.640028,mp4a.40.2\"\n" +
+				"high/stream.m3u8\n"))
+
+		case "/high/stream.m3u8":
+			mutex.Lock()
+			streamRequests++
+			n := streamRequests
+			mutex.Unlock()
+
+			// the first time, the playlist contains 5 segments and the stream is live.
+			// the second time, a segment has been added and the stream has ended.
+			if n == 1 {
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:10\n" +
+					"#EXTINF:2,\n10.ts\n" +
+					"#EXTINF:2,\n11.ts\n" +
+					"#EXTINF:2,\n12.ts\n" +
+					"#EXTINF:2,\n13.ts\n" +
+					"#EXTINF:2,\n14.ts\n"))
+			} else {
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:11\n" +
+					"#EXTINF:2,\n11.ts\n" +
+					"#EXTINF:2,\n12.ts\n" +
+					"#EXTINF:2,\n13.ts\n" +
+					"#EXTINF:2,\n14.ts\n" +
+					"#EXTINF:2,\n/high/15.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+			}
+
+		case "/high/10.ts", "/high/11.ts", "/high/12.ts", "/high/13.ts", "/high/14.ts", "/high/15.ts":
+			w.Write([]byte(r.URL.Path[len("/high/"):]))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewClient(s.URL+"/index.m3u8", 5*time.Second)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = c.Run(context.Background(), &buf)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "12.ts13.ts14.ts15.ts", buf.String())
+}
+
+func TestClientErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		playlist string
+		err      string
+	}{
+		{
+			"invalid playlist",
+			"abc\n",
+			"invalid playlist",
+		},
+		{
+			"fmp4",
+			"#EXTM3U\n" +
+				"#EXT-X-MAP:URI=\"init.mp4\"\n",
+			"fMP4 segments are not supported",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(ca.playlist))
+			}))
+			defer s.Close()
+
+			c, err := NewClient(s.URL+"/stream.m3u8", 5*time.Second)
+			require.NoError(t, err)
+
+			err = c.Run(context.Background(), ioutil.Discard)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package hls
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -17,6 +21,9 @@ const (
 	ptsOffset = 2 * time.Second
 
 	segmentMinAUCount = 100
+
+	// maximum time to wait for the first segment when measuring the bitrate of a variant
+	variantBandwidthTimeout = 5 * time.Second
 )
 
 // Muxer is a HLS muxer.
@@ -28,14 +35,24 @@ type Muxer struct {
 
 	h264Conf        *gortsplib.TrackConfigH264
 	aacConf         *gortsplib.TrackConfigAAC
-	videoDTSEst     *h264.DTSEstimator
+	videoDTSExtr    *h264.DTSExtractor
 	audioAUCount    int
 	currentSegment  *segment
+	segmentStart    time.Time
 	startPCR        time.Time
 	startPTS        time.Duration
+	primeDuration   time.Duration
+	ntpRefSet       bool
+	ntpRefPTS       time.Duration
+	ntpRefTime      time.Time
 	primaryPlaylist *primaryPlaylist
 	streamPlaylist  *streamPlaylist
 
+	// the SPS can change mid-stream
+	spsMutex sync.Mutex
+	spsRaw   []byte
+	sps      *h264.SPS
+
 	pathName      string
 	h264Decoder   *h264.H264Decoder
 	cancelH264Dcd context.CancelFunc
@@ -49,7 +66,8 @@ func NewMuxer(
 	hlsSegmentDuration time.Duration,
 	videoTrack *gortsplib.Track,
 	audioTrack *gortsplib.Track,
-	pathName string) (*Muxer, error) {
+	pathName string,
+	variants []Variant) (*Muxer, error) {
 	var h264Conf *gortsplib.TrackConfigH264
 	if videoTrack != nil {
 		var err error
@@ -90,9 +108,9 @@ func NewMuxer(
 		audioTrack:         audioTrack,
 		h264Conf:           h264Conf,
 		aacConf:            aacConf,
-		videoDTSEst:        h264.NewDTSEstimator(),
+		videoDTSExtr:       h264.NewDTSExtractor(h264Conf.SPS, h264Conf.PPS),
 		currentSegment:     newSegment(videoTrack, audioTrack, h264Conf, aacConf),
-		primaryPlaylist:    newPrimaryPlaylist(videoTrack, audioTrack, h264Conf),
+		primaryPlaylist:    newPrimaryPlaylist(videoTrack, audioTrack, h264Conf, variants),
 		streamPlaylist:     newStreamPlaylist(hlsSegmentCount),
 		pathName:           pathName,
 		h264Decoder:        h264Dec,
@@ -100,6 +118,15 @@ func NewMuxer(
 		snapSignal:         true,
 	}
 
+	if h264Conf != nil {
+		m.spsRaw = h264Conf.SPS
+
+		var tmp h264.SPS
+		if tmp.Unmarshal(h264Conf.SPS) == nil {
+			m.sps = &tmp
+		}
+	}
+
 	ctxTicker, cancelTicker := context.WithCancel(context.Background())
 	ticker := time.NewTicker(h264.SNAPONEPERSECOND)
 	go func() {
@@ -125,6 +152,55 @@ func (m *Muxer) Close() {
 	m.cancelTicker()
 }
 
+// Prime notifies the muxer that the first frames it receives were produced
+// in the given amount of time before now, and are written at once (i.e. when
+// they come from a cache). This allows to fill the first segment with them.
+// It must be called before writing any frame.
+func (m *Muxer) Prime(d time.Duration) {
+	m.primeDuration = d
+}
+
+// SetNTP sets the absolute time of a PTS, i.e. the capture time of a frame,
+// that is used to fill EXT-X-PROGRAM-DATE-TIME and to date snapshots.
+// PTS is the one of the video track, or of the audio track if there's no video track.
+// If it's not called, the absolute time is derived from the arrival time of the first frame.
+func (m *Muxer) SetNTP(pts time.Duration, ntp time.Time) {
+	m.ntpRefSet = true
+	m.ntpRefPTS = pts
+	m.ntpRefTime = ntp
+}
+
+// absoluteTime returns the absolute time of a PTS of a segment.
+func (m *Muxer) absoluteTime(segmentPTS time.Duration) time.Time {
+	pts := segmentPTS - ptsOffset + m.startPTS
+
+	if m.ntpRefSet {
+		return m.ntpRefTime.Add(pts - m.ntpRefPTS)
+	}
+	return m.startPCR.Add(pts - m.startPTS)
+}
+
+// EnableDVR allows viewers to rewind the stream up to the given duration.
+// Segments that exceed hlsSegmentCount are moved into a temporary directory,
+// created inside dir, that is removed when the muxer is closed.
+// It must be called before writing any frame.
+func (m *Muxer) EnableDVR(duration time.Duration, dir string) error {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir(dir, "hls-dvr-")
+	if err != nil {
+		return err
+	}
+
+	m.streamPlaylist.enableDVR(duration, tmpDir)
+	return nil
+}
+
 // WriteH264 writes H264 NALUs, grouped by PTS, into the muxer.
 func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 	/*
@@ -145,6 +221,10 @@ func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 			idrPresent = true
 		}
 
+		if typ == h264.NALUTypeSPS {
+			m.updateSPS(nalu)
+		}
+
 		if m.h264Decoder.InService() {
 			if (h264.SNAP_ALL_IDR || m.snapSignal) && (typ == h264.NALUTypeIDR) {
 				imgNalus := [][]byte{}
@@ -158,7 +238,12 @@ func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 				if err != nil {
 					return err
 				}
-				m.h264Decoder.GatherData(encImgNalus)
+				var captureTime time.Time
+				if m.ntpRefSet {
+					captureTime = m.ntpRefTime.Add(pts - m.ntpRefPTS)
+				}
+
+				m.h264Decoder.GatherDataAt(encImgNalus, captureTime)
 				m.snapSignal = false
 			}
 		}
@@ -169,24 +254,31 @@ func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 		return nil
 	}
 
+	now := time.Now()
+
 	if m.currentSegment.firstPacketWritten {
 		if idrPresent &&
-			m.currentSegment.duration() >= m.hlsSegmentDuration {
-			m.streamPlaylist.pushSegment(m.currentSegment)
+			m.segmentIsComplete(now) {
+			err := m.pushSegment()
+			if err != nil {
+				return err
+			}
 
 			m.currentSegment = newSegment(m.videoTrack, m.audioTrack, m.h264Conf, m.aacConf)
 			m.currentSegment.setStartPCR(m.startPCR)
+			m.segmentStart = now
 		}
 	} else {
-		m.startPCR = time.Now()
+		m.startPCR = now.Add(-m.primeDuration)
 		m.startPTS = pts
 		m.currentSegment.setStartPCR(m.startPCR)
+		m.segmentStart = m.startPCR
 	}
 
 	pts = pts + ptsOffset - m.startPTS
 
 	err := m.currentSegment.writeH264(
-		m.videoDTSEst.Feed(pts),
+		m.videoDTSExtr.Extract(nalus, pts),
 		pts,
 		idrPresent,
 		nalus)
@@ -200,20 +292,27 @@ func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 // WriteAAC writes AAC AUs, grouped by PTS, into the muxer.
 func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
 	if m.videoTrack == nil {
+		now := time.Now()
+
 		if m.currentSegment.firstPacketWritten {
 			if m.audioAUCount >= segmentMinAUCount &&
-				m.currentSegment.duration() >= m.hlsSegmentDuration {
+				m.segmentIsComplete(now) {
 				m.audioAUCount = 0
 
-				m.streamPlaylist.pushSegment(m.currentSegment)
+				err := m.pushSegment()
+				if err != nil {
+					return err
+				}
 
 				m.currentSegment = newSegment(m.videoTrack, m.audioTrack, m.h264Conf, m.aacConf)
 				m.currentSegment.setStartPCR(m.startPCR)
+				m.segmentStart = now
 			}
 		} else {
-			m.startPCR = time.Now()
+			m.startPCR = now.Add(-m.primeDuration)
 			m.startPTS = pts
 			m.currentSegment.setStartPCR(m.startPCR)
+			m.segmentStart = m.startPCR
 		}
 	} else {
 		if !m.currentSegment.firstPacketWritten {
@@ -237,6 +336,70 @@ func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
 	return nil
 }
 
+func (m *Muxer) pushSegment() error {
+	m.currentSegment.programDateTime = m.absoluteTime(m.currentSegment.minPTS)
+	return m.streamPlaylist.pushSegment(m.currentSegment)
+}
+
+// segmentIsComplete checks whether the current segment can be closed.
+// Segments are closed after a multiple of the segment duration in wall-clock time,
+// in order to align the segments of streams that share the same GOP structure,
+// like the renditions of a camera.
+func (m *Muxer) segmentIsComplete(now time.Time) bool {
+	if m.currentSegment.duration() < m.hlsSegmentDuration/2 {
+		return false
+	}
+
+	d := int64(m.hlsSegmentDuration)
+	return now.UnixNano()/d != m.segmentStart.UnixNano()/d
+}
+
+// updateSPS updates the parameters of the video track when the SPS
+// sent in-band is different from the current one.
+func (m *Muxer) updateSPS(nalu []byte) {
+	m.spsMutex.Lock()
+	defer m.spsMutex.Unlock()
+
+	if bytes.Equal(nalu, m.spsRaw) {
+		return
+	}
+
+	// ignore invalid SPS
+	var tmp h264.SPS
+	if tmp.Unmarshal(nalu) != nil {
+		return
+	}
+
+	m.spsRaw = append([]byte(nil), nalu...)
+	m.sps = &tmp
+	m.primaryPlaylist.update(m.spsRaw)
+}
+
+// Variant returns the description of the stream, to be inserted into a
+// multivariant playlist. It waits for the first segment, in order to measure the bitrate;
+// if the segment is not produced in time, a nominal bitrate is used.
+func (m *Muxer) Variant(uri string) Variant {
+	v := Variant{
+		URI:       uri,
+		Bandwidth: m.streamPlaylist.peakBandwidth(variantBandwidthTimeout),
+	}
+	if v.Bandwidth == 0 {
+		v.Bandwidth = nominalBandwidth
+	}
+
+	m.spsMutex.Lock()
+	defer m.spsMutex.Unlock()
+
+	v.Codecs = codecs(m.videoTrack, m.audioTrack, m.spsRaw)
+
+	if m.sps != nil {
+		v.Width = m.sps.Width()
+		v.Height = m.sps.Height()
+	}
+
+	return v
+}
+
 // PrimaryPlaylist returns a reader to read the primary playlist
 func (m *Muxer) PrimaryPlaylist() io.Reader {
 	return m.primaryPlaylist.reader()
@@ -23,7 +23,7 @@ func TestMuxer(t *testing.T) {
 	audioTrack, err := gortsplib.NewTrackAAC(97, &gortsplib.TrackConfigAAC{Type: 2, SampleRate: 44100, ChannelCount: 2})
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, videoTrack, audioTrack, "pathxxx")
+	m, err := NewMuxer(3, 1*time.Second, videoTrack, audioTrack, "pathxxx", nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -34,6 +34,8 @@ func TestMuxer(t *testing.T) {
 	})
 	require.NoError(t, err)
 
+	m.SetNTP(2*time.Second, time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC))
+
 	// group with IDR
 	err = m.WriteH264(2*time.Second, [][]byte{
 		{5}, // IDR
@@ -79,6 +81,7 @@ func TestMuxer(t *testing.T) {
 		`#EXT-X-ALLOW-CACHE:NO\n` +
 		`#EXT-X-TARGETDURATION:2\n` +
 		`#EXT-X-MEDIA-SEQUENCE:0\n` +
+		`#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:00Z\n` +
 		`#EXTINF:2,\n` +
 		`([0-9]+\.ts)\n$`)
 	ma := re.FindStringSubmatch(string(byts))
@@ -106,6 +109,93 @@ func TestMuxer(t *testing.T) {
 	)
 }
 
+func TestMuxerVariants(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x07, 0x01, 0x02, 0x03}, PPS: []byte{0x08}})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, videoTrack, nil, "pathxxx", []Variant{
+		{
+			URI:       "720p/stream.m3u8",
+			Bandwidth: 2000000,
+			Width:     1280,
+			Height:    720,
+		},
+		{
+			URI:       "sub/stream.m3u8",
+			Bandwidth: 500000,
+			Codecs:    []string{"avc1.42c01e"},
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	byts, err := ioutil.ReadAll(m.PrimaryPlaylist())
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.010203\"\n"+
+		"stream.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"avc1.010203\"\n"+
+		"720p/stream.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=500000,CODECS=\"avc1.42c01e\"\n"+
+		"sub/stream.m3u8\n", string(byts))
+}
+
+func TestMuxerSPSChange(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x07, 0x01, 0x02, 0x03}, PPS: []byte{0x08}})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, videoTrack, nil, "pathxxx", nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	// in-band SPS of a 1280x720 stream
+	err = m.WriteH264(2*time.Second, [][]byte{
+		{
+			0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+			0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+			0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+			0xcb,
+		},
+		{8}, // PPS
+		{5}, // IDR
+	})
+	require.NoError(t, err)
+
+	byts, err := ioutil.ReadAll(m.PrimaryPlaylist())
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=200000,RESOLUTION=1280x720,CODECS=\"avc1.64001f\"\n"+
+		"stream.m3u8\n", string(byts))
+}
+
+func TestMultivariantPlaylist(t *testing.T) {
+	byts, err := ioutil.ReadAll(MultivariantPlaylist([]Variant{
+		{
+			URI:       "../cam1_main/stream.m3u8",
+			Bandwidth: 4000000,
+			Width:     1920,
+			Height:    1080,
+			Codecs:    []string{"avc1.640028", "mp4a.40.2"},
+		},
+		{
+			URI:       "../cam1_sub/stream.m3u8",
+			Bandwidth: 600000,
+			Width:     640,
+			Height:    360,
+			Codecs:    []string{"avc1.42c01e"},
+		},
+	}))
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=1920x1080,CODECS=\"avc1.640028,mp4a.40.2\"\n"+
+		"../cam1_main/stream.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=600000,RESOLUTION=640x360,CODECS=\"avc1.42c01e\"\n"+
+		"../cam1_sub/stream.m3u8\n", string(byts))
+}
+
 func TestMuxerCloseBeforeFirstSegment(t *testing.T) {
 	videoTrack, err := gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: []byte{0x07, 0x01, 0x02, 0x03}, PPS: []byte{0x08}})
 	require.NoError(t, err)
@@ -113,7 +203,7 @@ func TestMuxerCloseBeforeFirstSegment(t *testing.T) {
 	audioTrack, err := gortsplib.NewTrackAAC(97, &gortsplib.TrackConfigAAC{Type: 2, SampleRate: 44100, ChannelCount: 2})
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, videoTrack, audioTrack, "pathXXX")
+	m, err := NewMuxer(3, 1*time.Second, videoTrack, audioTrack, "pathXXX", nil)
 	require.NoError(t, err)
 
 	// group with IDR
@@ -4,49 +4,138 @@ import (
 	"bytes"
 	"encoding/hex"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/aler9/gortsplib"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
 )
 
+// bandwidth of streams whose bitrate is unknown, in bits per second.
+const nominalBandwidth = 200000
+
+// Variant is a rendition of a stream, listed in a primary playlist.
+type Variant struct {
+	// URI of the stream playlist, relative to the primary playlist.
+	URI string
+
+	// peak bitrate, in bits per second.
+	Bandwidth int
+
+	// resolution. It is omitted when zero.
+	Width  int
+	Height int
+
+	// codecs. If empty, the codecs of the main stream are used.
+	Codecs []string
+}
+
+func (v Variant) marshal() string {
+	cnt := "#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.FormatInt(int64(v.Bandwidth), 10)
+	if v.Width != 0 && v.Height != 0 {
+		cnt += ",RESOLUTION=" + strconv.FormatInt(int64(v.Width), 10) + "x" + strconv.FormatInt(int64(v.Height), 10)
+	}
+	cnt += ",CODECS=\"" + strings.Join(v.Codecs, ",") + "\"\n"
+	cnt += v.URI + "\n"
+	return cnt
+}
+
+// MultivariantPlaylist returns a reader to read a primary playlist that
+// lists multiple streams.
+func MultivariantPlaylist(variants []Variant) io.Reader {
+	cnt := "#EXTM3U\n"
+	for _, v := range variants {
+		cnt += v.marshal()
+	}
+	return bytes.NewReader([]byte(cnt))
+}
+
+func codecs(
+	videoTrack *gortsplib.Track,
+	audioTrack *gortsplib.Track,
+	sps []byte,
+) []string {
+	var ret []string
+
+	if videoTrack != nil && len(sps) >= 4 {
+		ret = append(ret, "avc1."+hex.EncodeToString(sps[1:4]))
+	}
+
+	if audioTrack != nil {
+		ret = append(ret, "mp4a.40.2")
+	}
+
+	return ret
+}
+
 type primaryPlaylist struct {
 	videoTrack *gortsplib.Track
 	audioTrack *gortsplib.Track
-	h264Conf   *gortsplib.TrackConfigH264
+	variants   []Variant
 
-	breader *bytes.Reader
+	mutex sync.Mutex
+	cnt   []byte
 }
 
 func newPrimaryPlaylist(
 	videoTrack *gortsplib.Track,
 	audioTrack *gortsplib.Track,
 	h264Conf *gortsplib.TrackConfigH264,
+	variants []Variant,
 ) *primaryPlaylist {
 	p := &primaryPlaylist{
 		videoTrack: videoTrack,
 		audioTrack: audioTrack,
-		h264Conf:   h264Conf,
+		variants:   variants,
+	}
+
+	var sps []byte
+	if h264Conf != nil {
+		sps = h264Conf.SPS
 	}
+	p.update(sps)
 
-	var codecs []string
+	return p
+}
 
-	if p.videoTrack != nil {
-		codecs = append(codecs, "avc1."+hex.EncodeToString(p.h264Conf.SPS[1:4]))
+// update regenerates the playlist with the given SPS, in order to keep
+// CODECS and RESOLUTION in sync with the video track.
+func (p *primaryPlaylist) update(sps []byte) {
+	mainCodecs := codecs(p.videoTrack, p.audioTrack, sps)
+
+	main := Variant{
+		URI:       "stream.m3u8",
+		Bandwidth: nominalBandwidth,
+		Codecs:    mainCodecs,
 	}
 
-	if p.audioTrack != nil {
-		codecs = append(codecs, "mp4a.40.2")
+	if p.videoTrack != nil {
+		var tmp h264.SPS
+		if tmp.Unmarshal(sps) == nil {
+			main.Width = tmp.Width()
+			main.Height = tmp.Height()
+		}
 	}
 
 	cnt := "#EXTM3U\n"
-	cnt += "#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"" + strings.Join(codecs, ",") + "\"\n"
-	cnt += "stream.m3u8\n"
+	cnt += main.marshal()
 
-	p.breader = bytes.NewReader([]byte(cnt))
+	for _, v := range p.variants {
+		if len(v.Codecs) == 0 {
+			v.Codecs = mainCodecs
+		}
+		cnt += v.marshal()
+	}
 
-	return p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cnt = []byte(cnt)
 }
 
 func (p *primaryPlaylist) reader() io.Reader {
-	return p.breader
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return bytes.NewReader(p.cnt)
 }
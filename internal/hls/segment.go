@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"time"
 
@@ -27,6 +28,8 @@ type segment struct {
 	maxPTS             time.Duration
 	startPCR           time.Time
 	pcrSendCounter     int
+	programDateTime    time.Time
+	fpath              string
 }
 
 func newSegment(
@@ -84,6 +87,12 @@ func (t *segment) reader() io.Reader {
 	return bytes.NewReader(t.buf.Bytes())
 }
 
+// spill writes the content of the segment into a file.
+// The segment must be complete, since its buffer is read without locks.
+func (t *segment) spill(fpath string) error {
+	return ioutil.WriteFile(fpath, t.buf.Bytes(), 0o644)
+}
+
 func (t *segment) writeH264(
 	dts time.Duration,
 	pts time.Duration,
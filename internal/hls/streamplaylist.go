@@ -3,10 +3,14 @@ package hls
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type readerFunc struct {
@@ -24,6 +28,8 @@ func (r *readerFunc) Read(buf []byte) (int, error) {
 
 type streamPlaylist struct {
 	hlsSegmentCount int
+	dvrDuration     time.Duration
+	dvrDir          string
 
 	mutex              sync.Mutex
 	cond               *sync.Cond
@@ -42,6 +48,13 @@ func newStreamPlaylist(hlsSegmentCount int) *streamPlaylist {
 	return p
 }
 
+// enableDVR allows the playlist to grow up to the given duration, by
+// moving the segments that exceed hlsSegmentCount into a directory.
+func (p *streamPlaylist) enableDVR(duration time.Duration, dir string) {
+	p.dvrDuration = duration
+	p.dvrDir = dir
+}
+
 func (p *streamPlaylist) close() {
 	func() {
 		p.mutex.Lock()
@@ -50,6 +63,10 @@ func (p *streamPlaylist) close() {
 	}()
 
 	p.cond.Broadcast()
+
+	if p.dvrDir != "" {
+		os.RemoveAll(p.dvrDir)
+	}
 }
 
 func (p *streamPlaylist) reader() io.Reader {
@@ -87,6 +104,7 @@ func (p *streamPlaylist) reader() io.Reader {
 		cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(p.segmentDeleteCount), 10) + "\n"
 
 		for _, f := range p.segments {
+			cnt += "#EXT-X-PROGRAM-DATE-TIME:" + f.programDateTime.UTC().Format("2006-01-02T15:04:05.999Z07:00") + "\n"
 			cnt += "#EXTINF:" + strconv.FormatFloat(f.duration().Seconds(), 'f', -1, 64) + ",\n"
 			cnt += f.name + ".ts\n"
 		}
@@ -95,21 +113,77 @@ func (p *streamPlaylist) reader() io.Reader {
 	}}
 }
 
+// peakBandwidth returns the peak bitrate of the available segments, in bits per second.
+// It waits for the first segment, up to the given timeout; it returns zero
+// if the bitrate can't be measured.
+func (p *streamPlaylist) peakBandwidth(timeout time.Duration) int {
+	timedOut := false
+	t := time.AfterFunc(timeout, func() {
+		p.mutex.Lock()
+		timedOut = true
+		p.mutex.Unlock()
+		p.cond.Broadcast()
+	})
+	defer t.Stop()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for !p.closed && !timedOut && len(p.segments) == 0 {
+		p.cond.Wait()
+	}
+
+	ret := 0
+	for _, f := range p.segments {
+		// segments on disk are not taken into account
+		if f.fpath != "" {
+			continue
+		}
+
+		d := f.duration()
+		if d <= 0 {
+			continue
+		}
+
+		v := int(int64(f.buf.Len()) * 8 * int64(time.Second) / int64(d))
+		if v > ret {
+			ret = v
+		}
+	}
+
+	return ret
+}
+
 func (p *streamPlaylist) segment(fname string) io.Reader {
 	base := strings.TrimSuffix(fname, ".ts")
 
 	p.mutex.Lock()
 	f, ok := p.segmentByName[base]
-	p.mutex.Unlock()
-
 	if !ok {
+		p.mutex.Unlock()
 		return nil
 	}
 
-	return f.reader()
+	if f.fpath == "" {
+		r := f.reader()
+		p.mutex.Unlock()
+		return r
+	}
+
+	fpath := f.fpath
+	p.mutex.Unlock()
+
+	// the file may have been removed in the meanwhile
+	byts, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil
+	}
+	return bytes.NewReader(byts)
 }
 
-func (p *streamPlaylist) pushSegment(t *segment) {
+func (p *streamPlaylist) pushSegment(t *segment) error {
+	var toSpill *segment
+
 	func() {
 		p.mutex.Lock()
 		defer p.mutex.Unlock()
@@ -117,12 +191,60 @@ func (p *streamPlaylist) pushSegment(t *segment) {
 		p.segmentByName[t.name] = t
 		p.segments = append(p.segments, t)
 
+		if p.dvrDir == "" {
+			if len(p.segments) > p.hlsSegmentCount {
+				delete(p.segmentByName, p.segments[0].name)
+				p.segments = p.segments[1:]
+				p.segmentDeleteCount++
+			}
+			return
+		}
+
+		// only the last hlsSegmentCount segments are kept in memory
 		if len(p.segments) > p.hlsSegmentCount {
-			delete(p.segmentByName, p.segments[0].name)
-			p.segments = p.segments[1:]
-			p.segmentDeleteCount++
+			toSpill = p.segments[len(p.segments)-p.hlsSegmentCount-1]
 		}
 	}()
 
 	p.cond.Broadcast()
+
+	if toSpill == nil {
+		return nil
+	}
+
+	// the segment is written to disk without holding the mutex,
+	// in order not to block readers.
+	fpath := filepath.Join(p.dvrDir, toSpill.name+".ts")
+	err := toSpill.spill(fpath)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		os.Remove(fpath)
+		return nil
+	}
+
+	toSpill.fpath = fpath
+	toSpill.buf = bytes.Buffer{}
+
+	// remove segments that are out of the DVR window
+	total := time.Duration(0)
+	for _, f := range p.segments {
+		total += f.duration()
+	}
+
+	for len(p.segments) > p.hlsSegmentCount &&
+		(total-p.segments[0].duration()) >= p.dvrDuration {
+		total -= p.segments[0].duration()
+		os.Remove(p.segments[0].fpath)
+		delete(p.segmentByName, p.segments[0].name)
+		p.segments = p.segments[1:]
+		p.segmentDeleteCount++
+	}
+
+	return nil
 }
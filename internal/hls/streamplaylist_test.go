@@ -0,0 +1,109 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamPlaylistDVR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hls-dvr-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := newStreamPlaylist(2)
+	p.enableDVR(3*time.Second, dir)
+
+	start := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	push := func(i int) {
+		seg := &segment{
+			name:               strconv.FormatInt(int64(i), 10),
+			firstPacketWritten: true,
+			minPTS:             ptsOffset + time.Duration(i)*time.Second,
+			maxPTS:             ptsOffset + time.Duration(i+1)*time.Second,
+			programDateTime:    start.Add(time.Duration(i) * time.Second),
+		}
+		seg.buf.Write([]byte{byte(i)})
+
+		err := p.pushSegment(seg)
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		push(i)
+	}
+
+	byts, err := ioutil.ReadAll(p.reader())
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:3\n"+
+		"#EXT-X-ALLOW-CACHE:NO\n"+
+		"#EXT-X-TARGETDURATION:1\n"+
+		"#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:00Z\n"+
+		"#EXTINF:1,\n"+
+		"0.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:01Z\n"+
+		"#EXTINF:1,\n"+
+		"1.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:02Z\n"+
+		"#EXTINF:1,\n"+
+		"2.ts\n", string(byts))
+
+	// the oldest segment has been moved to disk
+	_, err = os.Stat(filepath.Join(p.dvrDir, "0.ts"))
+	require.NoError(t, err)
+
+	for i := 3; i < 5; i++ {
+		push(i)
+	}
+
+	byts, err = ioutil.ReadAll(p.reader())
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:3\n"+
+		"#EXT-X-ALLOW-CACHE:NO\n"+
+		"#EXT-X-TARGETDURATION:1\n"+
+		"#EXT-X-MEDIA-SEQUENCE:2\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:02Z\n"+
+		"#EXTINF:1,\n"+
+		"2.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:03Z\n"+
+		"#EXTINF:1,\n"+
+		"3.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-04T10:00:04Z\n"+
+		"#EXTINF:1,\n"+
+		"4.ts\n", string(byts))
+
+	// segments out of the window have been removed
+	_, err = os.Stat(filepath.Join(p.dvrDir, "0.ts"))
+	require.Error(t, err)
+	require.Nil(t, p.segment("0.ts"))
+
+	// segments are read from disk and from memory
+	byts, err = ioutil.ReadAll(p.segment("2.ts"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, byts)
+
+	byts, err = ioutil.ReadAll(p.segment("4.ts"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, byts)
+
+	p.close()
+
+	_, err = os.Stat(p.dvrDir)
+	require.Error(t, err)
+}
+
+func TestStreamPlaylistPeakBandwidthTimeout(t *testing.T) {
+	p := newStreamPlaylist(2)
+	defer p.close()
+
+	require.Equal(t, 0, p.peakBandwidth(100*time.Millisecond))
+}
@@ -0,0 +1,171 @@
+// Package motion contains a motion detector, that compares the luma planes
+// of consecutive pictures.
+package motion
+
+import (
+	"image"
+	"time"
+)
+
+const (
+	// pictures are compared by sampling a pixel every step pixels,
+	// horizontally and vertically.
+	step = 4
+
+	// minimum difference of luma that is considered a change of a pixel,
+	// in order to ignore sensor noise and compression artifacts.
+	pixelThreshold = 25
+)
+
+// Region is a region of interest.
+// Coordinates and sizes are fractions of the picture size, between 0 and 1.
+type Region struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+func (r Region) contains(x float64, y float64) bool {
+	return x >= r.X && x < (r.X+r.Width) &&
+		y >= r.Y && y < (r.Y+r.Height)
+}
+
+// Event is the result of the processing of a picture.
+type Event int
+
+// events.
+const (
+	EventNone Event = iota
+	EventStart
+	EventStop
+)
+
+// Detector is a motion detector.
+type Detector struct {
+	regions     []Region
+	sensitivity float64
+	minDuration time.Duration
+
+	width         int
+	height        int
+	mask          []bool
+	prev          []uint8
+	motion        bool
+	changingSince time.Time
+}
+
+// NewDetector allocates a Detector.
+// If regions is empty, the whole picture is analyzed.
+// sensitivity is between 0 and 1: a picture contains motion when the fraction
+// of changed pixels is greater than 1 - sensitivity.
+// Motion starts and stops only when the new state lasts at least minDuration.
+func NewDetector(regions []Region, sensitivity float64, minDuration time.Duration) *Detector {
+	return &Detector{
+		regions:     regions,
+		sensitivity: sensitivity,
+		minDuration: minDuration,
+	}
+}
+
+// Motion returns whether motion is in progress.
+func (d *Detector) Motion() bool {
+	return d.motion
+}
+
+// Process processes a picture, received at the given time.
+// It returns EventStart when motion starts and EventStop when motion stops.
+func (d *Detector) Process(img *image.YCbCr, now time.Time) Event {
+	size := img.Rect.Size()
+	w := (size.X + step - 1) / step
+	h := (size.Y + step - 1) / step
+
+	// reset the reference picture when the size changes
+	if w != d.width || h != d.height {
+		d.width = w
+		d.height = h
+		d.mask = d.computeMask()
+		d.prev = nil
+	}
+
+	cur := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Y[(y*step)*img.YStride:]
+		for x := 0; x < w; x++ {
+			cur[y*w+x] = row[x*step]
+		}
+	}
+
+	prev := d.prev
+	d.prev = cur
+	if prev == nil {
+		return EventNone
+	}
+
+	total := 0
+	changed := 0
+	for i, v := range cur {
+		if !d.mask[i] {
+			continue
+		}
+
+		total++
+		diff := int(v) - int(prev[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff >= pixelThreshold {
+			changed++
+		}
+	}
+
+	motion := total > 0 &&
+		float64(changed)/float64(total) > (1-d.sensitivity)
+
+	if motion == d.motion {
+		d.changingSince = time.Time{}
+		return EventNone
+	}
+
+	if d.changingSince.IsZero() {
+		d.changingSince = now
+	}
+
+	if now.Sub(d.changingSince) < d.minDuration {
+		return EventNone
+	}
+
+	d.motion = motion
+	d.changingSince = time.Time{}
+
+	if motion {
+		return EventStart
+	}
+	return EventStop
+}
+
+func (d *Detector) computeMask() []bool {
+	mask := make([]bool, d.width*d.height)
+
+	for y := 0; y < d.height; y++ {
+		fy := (float64(y) + 0.5) / float64(d.height)
+
+		for x := 0; x < d.width; x++ {
+			fx := (float64(x) + 0.5) / float64(d.width)
+
+			if len(d.regions) == 0 {
+				mask[y*d.width+x] = true
+				continue
+			}
+
+			for _, r := range d.regions {
+				if r.contains(fx, fy) {
+					mask[y*d.width+x] = true
+					break
+				}
+			}
+		}
+	}
+
+	return mask
+}
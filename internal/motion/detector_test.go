@@ -0,0 +1,71 @@
+package motion
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newPicture returns a black picture with a white rectangle.
+func newPicture(rect image.Rectangle) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, 64, 64), image.YCbCrSubsampleRatio420)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.Y[y*img.YStride+x] = 255
+		}
+	}
+	return img
+}
+
+func TestDetectorStartStop(t *testing.T) {
+	d := NewDetector(nil, 0.9, 2*time.Second)
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	still := newPicture(image.Rect(0, 0, 32, 64))
+	moved := newPicture(image.Rect(32, 0, 64, 64))
+
+	require.Equal(t, EventNone, d.Process(still, now))
+	require.Equal(t, EventNone, d.Process(still, now.Add(1*time.Second)))
+
+	// motion must last at least minDuration
+	require.Equal(t, EventNone, d.Process(moved, now.Add(2*time.Second)))
+	require.Equal(t, EventNone, d.Process(moved, now.Add(3*time.Second)))
+	require.Equal(t, EventNone, d.Process(still, now.Add(4*time.Second)))
+	require.Equal(t, EventNone, d.Process(moved, now.Add(5*time.Second)))
+	require.Equal(t, EventStart, d.Process(still, now.Add(6*time.Second)))
+	require.Equal(t, true, d.Motion())
+
+	// a short pause does not stop motion
+	require.Equal(t, EventNone, d.Process(still, now.Add(7*time.Second)))
+	require.Equal(t, EventNone, d.Process(moved, now.Add(8*time.Second)))
+	require.Equal(t, EventNone, d.Process(moved, now.Add(9*time.Second)))
+	require.Equal(t, EventNone, d.Process(moved, now.Add(10*time.Second)))
+	require.Equal(t, EventStop, d.Process(moved, now.Add(11*time.Second)))
+	require.Equal(t, false, d.Motion())
+}
+
+func TestDetectorRegions(t *testing.T) {
+	d := NewDetector([]Region{{X: 0, Y: 0, Width: 1, Height: 0.5}}, 0.9, 0)
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// changes outside the region are ignored
+	require.Equal(t, EventNone, d.Process(newPicture(image.Rect(0, 32, 64, 64)), now))
+	require.Equal(t, EventNone, d.Process(newPicture(image.Rect(0, 0, 0, 0)), now))
+
+	require.Equal(t, EventStart, d.Process(newPicture(image.Rect(0, 0, 64, 32)), now))
+}
+
+func TestDetectorSizeChange(t *testing.T) {
+	d := NewDetector(nil, 0.9, 0)
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, EventNone, d.Process(newPicture(image.Rect(0, 0, 0, 0)), now))
+
+	big := image.NewYCbCr(image.Rect(0, 0, 128, 128), image.YCbCrSubsampleRatio420)
+	for i := range big.Y {
+		big.Y[i] = 255
+	}
+	require.Equal(t, EventNone, d.Process(big, now))
+}
@@ -0,0 +1,70 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+func u8(v uint8) []byte {
+	return []byte{v}
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func join(parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+
+	ret := make([]byte, 0, n)
+	for _, p := range parts {
+		ret = append(ret, p...)
+	}
+	return ret
+}
+
+// box encodes a box with the given type and content.
+func box(typ string, content ...[]byte) []byte {
+	body := join(content...)
+	return join(u32(uint32(8+len(body))), []byte(typ), body)
+}
+
+// fullBox encodes a box that begins with a version and flags.
+func fullBox(typ string, version uint8, flags uint32, content ...[]byte) []byte {
+	return box(typ, join(u32(uint32(version)<<24|flags&0xFFFFFF)), join(content...))
+}
+
+// unity matrix, used by mvhd and tkhd.
+var matrix = join(
+	u32(0x00010000), u32(0), u32(0),
+	u32(0), u32(0x00010000), u32(0),
+	u32(0), u32(0), u32(0x40000000),
+)
+
+// descriptor encodes a MPEG-4 descriptor, used by esds.
+func descriptor(tag uint8, content ...[]byte) []byte {
+	body := join(content...)
+	le := len(body)
+
+	// the size is encoded with 4 bytes, 7 bits each
+	return join(
+		u8(tag),
+		u8(0x80|uint8(le>>21)), u8(0x80|uint8(le>>14)), u8(0x80|uint8(le>>7)), u8(uint8(le&0x7F)),
+		body)
+}
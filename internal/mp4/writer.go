@@ -0,0 +1,516 @@
+// Package mp4 contains a MP4 writer.
+package mp4
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	movieTimeScale = 1000
+	videoTimeScale = 90000
+
+	// size of the header of mdat, that uses a 64-bit size.
+	mdatHeaderSize = 16
+)
+
+var ftyp = box("ftyp",
+	[]byte("isom"), // major brand
+	u32(0x200),     // minor version
+	[]byte("isom"), []byte("iso2"), []byte("avc1"), []byte("mp41"))
+
+type writerSample struct {
+	offset    uint64
+	size      uint32
+	dts       int64 // in the time scale of the track
+	ptsOffset int64 // in the time scale of the track
+	sync      bool
+}
+
+type writerTrack struct {
+	id              uint32
+	timeScale       uint32
+	defaultDuration uint32
+	samples         []writerSample
+}
+
+// durations returns the duration of every sample.
+// The duration of the last sample is the one of the previous sample.
+func (t *writerTrack) durations() []uint32 {
+	ret := make([]uint32, len(t.samples))
+
+	for i := 0; i < len(t.samples)-1; i++ {
+		d := t.samples[i+1].dts - t.samples[i].dts
+		if d > 0 {
+			ret[i] = uint32(d)
+		}
+	}
+
+	switch {
+	case len(ret) >= 2:
+		ret[len(ret)-1] = ret[len(ret)-2]
+	case len(ret) == 1:
+		ret[0] = t.defaultDuration
+	}
+
+	return ret
+}
+
+// start returns the presentation time of the first sample.
+func (t *writerTrack) start() time.Duration {
+	s := t.samples[0]
+	return time.Duration(s.dts+s.ptsOffset) * time.Second / time.Duration(t.timeScale)
+}
+
+// Writer writes H264 and AAC frames into a MP4 file.
+// Samples are written as they are received, while sample tables are kept in memory
+// and written at the end of the file when the writer is closed; the file can't be
+// played until then.
+type Writer struct {
+	w        io.WriteSeeker
+	bw       *bufio.Writer
+	h264Conf *gortsplib.TrackConfigH264
+	aacConf  *gortsplib.TrackConfigAAC
+
+	videoTrack *writerTrack
+	audioTrack *writerTrack
+	pos        uint64
+}
+
+// NewWriter allocates a Writer and writes the header of the file.
+// h264Conf or aacConf can be nil when the stream doesn't contain the corresponding track.
+func NewWriter(
+	w io.WriteSeeker,
+	h264Conf *gortsplib.TrackConfigH264,
+	aacConf *gortsplib.TrackConfigAAC) (*Writer, error) {
+	mw := &Writer{
+		w:        w,
+		bw:       bufio.NewWriter(w),
+		h264Conf: h264Conf,
+		aacConf:  aacConf,
+	}
+
+	id := uint32(1)
+
+	if h264Conf != nil {
+		mw.videoTrack = &writerTrack{
+			id:              id,
+			timeScale:       videoTimeScale,
+			defaultDuration: videoTimeScale / 30,
+		}
+		id++
+	}
+
+	if aacConf != nil {
+		mw.audioTrack = &writerTrack{
+			id:              id,
+			timeScale:       uint32(aacConf.SampleRate),
+			defaultDuration: 1024,
+		}
+	}
+
+	err := mw.write(ftyp)
+	if err != nil {
+		return nil, err
+	}
+
+	// the size of mdat is filled when the writer is closed
+	err = mw.write(join(u32(1), []byte("mdat"), u64(0)))
+	if err != nil {
+		return nil, err
+	}
+
+	return mw, nil
+}
+
+func (mw *Writer) write(byts []byte) error {
+	n, err := mw.bw.Write(byts)
+	mw.pos += uint64(n)
+	return err
+}
+
+func durationToTicks(d time.Duration, timeScale uint32) int64 {
+	secs := d / time.Second
+	dec := d % time.Second
+	return int64(secs)*int64(timeScale) + int64(dec)*int64(timeScale)/int64(time.Second)
+}
+
+// WriteH264 writes the NALUs of a H264 access unit.
+func (mw *Writer) WriteH264(
+	dts time.Duration,
+	pts time.Duration,
+	isIDR bool,
+	nalus [][]byte) error {
+	var filteredNALUs [][]byte
+
+	for _, nalu := range nalus {
+		// remove SPS, PPS and AUD, that are stored in the sample description
+		typ := h264.NALUType(nalu[0] & 0x1F)
+		switch typ {
+		case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+			continue
+		}
+
+		filteredNALUs = append(filteredNALUs, nalu)
+	}
+
+	if len(filteredNALUs) == 0 {
+		return nil
+	}
+
+	enc, err := h264.EncodeAVCC(filteredNALUs)
+	if err != nil {
+		return err
+	}
+
+	dtsTicks := durationToTicks(dts, videoTimeScale)
+
+	mw.videoTrack.samples = append(mw.videoTrack.samples, writerSample{
+		offset:    mw.pos,
+		size:      uint32(len(enc)),
+		dts:       dtsTicks,
+		ptsOffset: durationToTicks(pts, videoTimeScale) - dtsTicks,
+		sync:      isIDR,
+	})
+
+	return mw.write(enc)
+}
+
+// WriteAAC writes an AAC access unit.
+func (mw *Writer) WriteAAC(pts time.Duration, au []byte) error {
+	mw.audioTrack.samples = append(mw.audioTrack.samples, writerSample{
+		offset: mw.pos,
+		size:   uint32(len(au)),
+		dts:    durationToTicks(pts, mw.audioTrack.timeScale),
+		sync:   true,
+	})
+
+	return mw.write(au)
+}
+
+// Close writes the sample tables and completes the file.
+// It doesn't close the underlying writer.
+func (mw *Writer) Close() error {
+	mdatSize := mw.pos - uint64(len(ftyp))
+
+	moov, err := mw.moov()
+	if err != nil {
+		return err
+	}
+
+	err = mw.write(moov)
+	if err != nil {
+		return err
+	}
+
+	err = mw.bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = mw.w.Seek(int64(len(ftyp))+8, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = mw.w.Write(u64(mdatSize))
+	if err != nil {
+		return err
+	}
+
+	_, err = mw.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (mw *Writer) tracks() []*writerTrack {
+	var ret []*writerTrack
+	for _, t := range []*writerTrack{mw.videoTrack, mw.audioTrack} {
+		if t != nil && len(t.samples) > 0 {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+func (mw *Writer) moov() ([]byte, error) {
+	tracks := mw.tracks()
+
+	// tracks are aligned with an edit list to the first presented sample
+	var start time.Duration
+	for i, t := range tracks {
+		if i == 0 || t.start() < start {
+			start = t.start()
+		}
+	}
+
+	var traks [][]byte
+	movieDuration := uint64(0)
+	nextTrackID := uint32(1)
+
+	for _, t := range tracks {
+		if t.id >= nextTrackID {
+			nextTrackID = t.id + 1
+		}
+
+		trak, duration, err := mw.trak(t, t.start()-start)
+		if err != nil {
+			return nil, err
+		}
+
+		traks = append(traks, trak)
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+	}
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), // creation time
+		u32(0), // modification time
+		u32(movieTimeScale),
+		u32(uint32(movieDuration)),
+		u32(0x00010000), // rate
+		u16(0x0100),     // volume
+		u16(0),
+		u32(0), u32(0),
+		matrix,
+		make([]byte, 6*4), // pre-defined
+		u32(nextTrackID),
+	)
+
+	return box("moov", mvhd, join(traks...)), nil
+}
+
+// trak encodes a track and returns its duration in the movie time scale.
+func (mw *Writer) trak(t *writerTrack, delay time.Duration) ([]byte, uint64, error) {
+	durations := t.durations()
+
+	mediaDuration := uint64(0)
+	for _, d := range durations {
+		mediaDuration += uint64(d)
+	}
+
+	delayTicks := uint64(durationToTicks(delay, movieTimeScale))
+	trackDuration := mediaDuration * movieTimeScale / uint64(t.timeScale)
+
+	isVideo := (t == mw.videoTrack)
+
+	width, height := 0, 0
+	if isVideo {
+		var sps h264.SPS
+		if err := sps.Unmarshal(mw.h264Conf.SPS); err == nil {
+			width, height = sps.Width(), sps.Height()
+		}
+	}
+
+	volume := uint16(0)
+	if !isVideo {
+		volume = 0x0100
+	}
+
+	tkhd := fullBox("tkhd", 0, 3, // enabled, in movie
+		u32(0), // creation time
+		u32(0), // modification time
+		u32(t.id),
+		u32(0),
+		u32(uint32(delayTicks+trackDuration)),
+		u32(0), u32(0),
+		u16(0), // layer
+		u16(0), // alternate group
+		u16(volume),
+		u16(0),
+		matrix,
+		u32(uint32(width)<<16),
+		u32(uint32(height)<<16),
+	)
+
+	var edits [][]byte
+	if delayTicks > 0 {
+		// empty edit
+		edits = append(edits, join(u32(uint32(delayTicks)), u32(0xFFFFFFFF), u16(1), u16(0)))
+	}
+	edits = append(edits, join(u32(uint32(trackDuration)), u32(uint32(t.samples[0].ptsOffset)), u16(1), u16(0)))
+
+	edts := box("edts",
+		fullBox("elst", 0, 0, u32(uint32(len(edits))), join(edits...)))
+
+	mdhd := fullBox("mdhd", 0, 0,
+		u32(0), // creation time
+		u32(0), // modification time
+		u32(t.timeScale),
+		u32(uint32(mediaDuration)),
+		u16(0x55C4), // language: und
+		u16(0),
+	)
+
+	var hdlr []byte
+	var mhd []byte
+	if isVideo {
+		hdlr = fullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+		mhd = fullBox("vmhd", 0, 1, u16(0), u16(0), u16(0), u16(0))
+	} else {
+		hdlr = fullBox("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+		mhd = fullBox("smhd", 0, 0, u16(0), u16(0))
+	}
+
+	dinf := box("dinf",
+		fullBox("dref", 0, 0, u32(1),
+			fullBox("url ", 0, 1)))
+
+	stbl, err := mw.stbl(t, durations, width, height)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return box("trak",
+		tkhd,
+		edts,
+		box("mdia",
+			mdhd,
+			hdlr,
+			box("minf", mhd, dinf, stbl))), delayTicks + trackDuration, nil
+}
+
+func (mw *Writer) sampleEntry(t *writerTrack, width int, height int) ([]byte, error) {
+	if t == mw.videoTrack {
+		sps := mw.h264Conf.SPS
+		pps := mw.h264Conf.PPS
+
+		var profile, compat, level uint8
+		if len(sps) >= 4 {
+			profile, compat, level = sps[1], sps[2], sps[3]
+		}
+
+		avcC := box("avcC",
+			u8(1), u8(profile), u8(compat), u8(level),
+			u8(0xFF),   // 4-byte lengths
+			u8(0xE0|1), // one SPS
+			u16(uint16(len(sps))), sps,
+			u8(1), // one PPS
+			u16(uint16(len(pps))), pps,
+		)
+
+		return box("avc1",
+			make([]byte, 6), // reserved
+			u16(1),          // data reference index
+			u16(0), u16(0),
+			make([]byte, 12), // pre-defined
+			u16(uint16(width)),
+			u16(uint16(height)),
+			u32(0x00480000), // horizontal resolution
+			u32(0x00480000), // vertical resolution
+			u32(0),
+			u16(1),           // frame count
+			make([]byte, 32), // compressor name
+			u16(0x0018),      // depth
+			u16(0xFFFF),      // pre-defined
+			avcC), nil
+	}
+
+	asc, err := rtpaac.MPEG4AudioConfig{
+		Type:              rtpaac.MPEG4AudioType(mw.aacConf.Type),
+		SampleRate:        mw.aacConf.SampleRate,
+		ChannelCount:      mw.aacConf.ChannelCount,
+		AOTSpecificConfig: mw.aacConf.AOTSpecificConfig,
+	}.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	esds := fullBox("esds", 0, 0,
+		descriptor(0x03, // ES descriptor
+			u16(uint16(t.id)),
+			u8(0),
+			descriptor(0x04, // decoder config descriptor
+				u8(0x40),        // MPEG-4 audio
+				u8(0x15),        // audio stream
+				[]byte{0, 0, 0}, // buffer size
+				u32(0),          // max bitrate
+				u32(0),          // average bitrate
+				descriptor(0x05, asc)),
+			descriptor(0x06, u8(0x02)))) // SL config descriptor
+
+	return box("mp4a",
+		make([]byte, 6), // reserved
+		u16(1),          // data reference index
+		u32(0), u32(0),
+		u16(uint16(mw.aacConf.ChannelCount)),
+		u16(16), // sample size
+		u16(0), u16(0),
+		u32(uint32(mw.aacConf.SampleRate)<<16),
+		esds), nil
+}
+
+func (mw *Writer) stbl(t *writerTrack, durations []uint32, width int, height int) ([]byte, error) {
+	entry, err := mw.sampleEntry(t, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	stsd := fullBox("stsd", 0, 0, u32(1), entry)
+
+	// durations and presentation offsets are run-length encoded
+	var sttsEntries [][]byte
+	for i := 0; i < len(durations); {
+		j := i + 1
+		for j < len(durations) && durations[j] == durations[i] {
+			j++
+		}
+		sttsEntries = append(sttsEntries, join(u32(uint32(j-i)), u32(durations[i])))
+		i = j
+	}
+	stts := fullBox("stts", 0, 0, u32(uint32(len(sttsEntries))), join(sttsEntries...))
+
+	var ctts []byte
+	hasOffsets := false
+	for _, s := range t.samples {
+		if s.ptsOffset != 0 {
+			hasOffsets = true
+			break
+		}
+	}
+	if hasOffsets {
+		var entries [][]byte
+		for i := 0; i < len(t.samples); {
+			j := i + 1
+			for j < len(t.samples) && t.samples[j].ptsOffset == t.samples[i].ptsOffset {
+				j++
+			}
+			entries = append(entries, join(u32(uint32(j-i)), u32(uint32(int32(t.samples[i].ptsOffset)))))
+			i = j
+		}
+		ctts = fullBox("ctts", 1, 0, u32(uint32(len(entries))), join(entries...))
+	}
+
+	// without stss, all samples are sync samples
+	var stss []byte
+	if t == mw.videoTrack {
+		var entries [][]byte
+		for i, s := range t.samples {
+			if s.sync {
+				entries = append(entries, u32(uint32(i+1)))
+			}
+		}
+		stss = fullBox("stss", 0, 0, u32(uint32(len(entries))), join(entries...))
+	}
+
+	// every sample is stored in a dedicated chunk
+	stsc := fullBox("stsc", 0, 0, u32(1), u32(1), u32(1), u32(1))
+
+	sizes := make([][]byte, len(t.samples))
+	offsets := make([][]byte, len(t.samples))
+	for i, s := range t.samples {
+		sizes[i] = u32(s.size)
+		offsets[i] = u64(s.offset)
+	}
+
+	stsz := fullBox("stsz", 0, 0, u32(0), u32(uint32(len(t.samples))), join(sizes...))
+	co64 := fullBox("co64", 0, 0, u32(uint32(len(t.samples))), join(offsets...))
+
+	return box("stbl", stsd, stts, ctts, stss, stsc, stsz, co64), nil
+}
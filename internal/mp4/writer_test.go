@@ -0,0 +1,136 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+// readBoxes returns the top-level boxes contained in buf, by type.
+func readBoxes(t *testing.T, buf []byte) map[string][]byte {
+	ret := make(map[string][]byte)
+
+	for len(buf) > 0 {
+		require.GreaterOrEqual(t, len(buf), 8)
+		size := uint64(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+		hl := uint64(8)
+
+		if size == 1 {
+			size = binary.BigEndian.Uint64(buf[8:16])
+			hl = 16
+		}
+
+		require.LessOrEqual(t, size, uint64(len(buf)))
+		ret[typ] = buf[hl:size]
+		buf = buf[size:]
+	}
+
+	return ret
+}
+
+func TestWriter(t *testing.T) {
+	h264Conf := &gortsplib.TrackConfigH264{
+		SPS: []byte{
+			0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+			0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+			0x00, 0x03, 0x00, 0x3d, 0x08,
+		},
+		PPS: []byte{0x68, 0xee, 0x3c, 0x80},
+	}
+	aacConf := &gortsplib.TrackConfigAAC{
+		Type:         2,
+		SampleRate:   44100,
+		ChannelCount: 2,
+	}
+
+	f, err := ioutil.TempFile("", "mp4test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := NewWriter(f, h264Conf, aacConf)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		start := 2*time.Second + time.Duration(i)*time.Second
+
+		err := w.WriteH264(start, start, true, [][]byte{
+			h264Conf.SPS,
+			h264Conf.PPS,
+			{0x05, 0x01},
+		})
+		require.NoError(t, err)
+
+		err = w.WriteAAC(start, []byte{0x03, 0x04})
+		require.NoError(t, err)
+
+		err = w.WriteH264(start+40*time.Millisecond, start+80*time.Millisecond,
+			false, [][]byte{{0x01, 0x02}})
+		require.NoError(t, err)
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	buf, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+
+	boxes := readBoxes(t, buf)
+	require.Equal(t, []byte("isom"), boxes["ftyp"][:4])
+
+	// 6 video samples of 6 bytes each (4-byte length + NALU),
+	// 3 audio samples of 2 bytes each
+	require.Equal(t, 6*6+3*2, len(boxes["mdat"]))
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x02, 0x05, 0x01}, boxes["mdat"][:6])
+
+	moov := readBoxes(t, boxes["moov"])
+	require.Contains(t, moov, "mvhd")
+	require.Contains(t, moov, "trak")
+}
+
+func TestWriterVideoOnly(t *testing.T) {
+	h264Conf := &gortsplib.TrackConfigH264{
+		SPS: []byte{
+			0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+			0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+			0x00, 0x03, 0x00, 0x3d, 0x08,
+		},
+		PPS: []byte{0x68, 0xee, 0x3c, 0x80},
+	}
+
+	f, err := ioutil.TempFile("", "mp4test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := NewWriter(f, h264Conf, nil)
+	require.NoError(t, err)
+
+	err = w.WriteH264(0, 0, true, [][]byte{{0x05, 0x01}})
+	require.NoError(t, err)
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	buf, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+
+	boxes := readBoxes(t, buf)
+	moov := readBoxes(t, boxes["moov"])
+	trak := readBoxes(t, moov["trak"])
+	mdia := readBoxes(t, trak["mdia"])
+	minf := readBoxes(t, mdia["minf"])
+	stbl := readBoxes(t, minf["stbl"])
+
+	// sample count
+	require.Equal(t, uint32(1), binary.BigEndian.Uint32(stbl["stsz"][8:12]))
+
+	// chunk offset of the first sample, after ftyp and the mdat header
+	require.Equal(t, uint64(len(ftyp)+mdatHeaderSize), binary.BigEndian.Uint64(stbl["co64"][8:16]))
+}
@@ -0,0 +1,285 @@
+// Package mpegts contains a MPEG-TS demuxer and writer.
+package mpegts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	// maximum number of PES packets that are read in order to find
+	// the configuration of all tracks.
+	maxProbePackets = 512
+
+	// PTS is a 33-bit value
+	ptsMask = 0x1FFFFFFFF
+)
+
+// FrameType is the type of a frame.
+type FrameType int
+
+// standard frame types.
+const (
+	FrameTypeH264 FrameType = iota
+	FrameTypeAAC
+)
+
+// Frame is a frame read from a MPEG-TS stream.
+type Frame struct {
+	Type FrameType
+
+	// presentation timestamp, relative to the first one of the stream.
+	PTS time.Duration
+
+	// NALUs of H264 frames, without SPS, PPS and AUD.
+	NALUs [][]byte
+
+	// access units of AAC frames.
+	AUs [][]byte
+}
+
+// Demuxer reads H264 and AAC frames from a MPEG-TS stream.
+type Demuxer struct {
+	dem *astits.Demuxer
+
+	h264PID  uint16
+	aacPID   uint16
+	hasH264  bool
+	hasAAC   bool
+	h264Conf *gortsplib.TrackConfigH264
+	aacConf  *gortsplib.TrackConfigAAC
+
+	ptsSet  bool
+	ptsPrev int64
+	ptsAcc  int64
+
+	// frames read while probing tracks
+	queue []*Frame
+}
+
+// NewDemuxer allocates a Demuxer.
+func NewDemuxer(ctx context.Context, r io.Reader) *Demuxer {
+	return &Demuxer{
+		dem: astits.NewDemuxer(ctx, r),
+	}
+}
+
+// Tracks reads the stream until the configuration of all tracks is available,
+// and returns the tracks. Frames read in the meanwhile are returned by ReadFrame.
+func (d *Demuxer) Tracks() (*gortsplib.Track, *gortsplib.Track, error) {
+	for i := 0; ; i++ {
+		if (d.hasH264 || d.hasAAC) &&
+			(!d.hasH264 || d.h264Conf.PPS != nil) &&
+			(!d.hasAAC || d.aacConf != nil) {
+			break
+		}
+
+		if i >= maxProbePackets {
+			return nil, nil, fmt.Errorf("unable to find the configuration of tracks")
+		}
+
+		f, err := d.readFrame()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if f != nil {
+			d.queue = append(d.queue, f)
+		}
+	}
+
+	var videoTrack *gortsplib.Track
+	if d.hasH264 {
+		var err error
+		videoTrack, err = gortsplib.NewTrackH264(96, d.h264Conf)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var audioTrack *gortsplib.Track
+	if d.hasAAC {
+		var err error
+		audioTrack, err = gortsplib.NewTrackAAC(96, d.aacConf)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return videoTrack, audioTrack, nil
+}
+
+// ReadFrame reads a frame.
+func (d *Demuxer) ReadFrame() (*Frame, error) {
+	if len(d.queue) > 0 {
+		f := d.queue[0]
+		d.queue = d.queue[1:]
+		return f, nil
+	}
+
+	for {
+		f, err := d.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if f != nil {
+			return f, nil
+		}
+	}
+}
+
+// readFrame reads data from the stream. It returns a nil frame when the data
+// is not a frame, or when the frame can't be used yet.
+func (d *Demuxer) readFrame() (*Frame, error) {
+	data, err := d.dem.NextData()
+	if err != nil {
+		if err == astits.ErrNoMorePackets {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	if data.PMT != nil {
+		for _, es := range data.PMT.ElementaryStreams {
+			switch es.StreamType {
+			case astits.StreamTypeH264Video:
+				if !d.hasH264 {
+					d.hasH264 = true
+					d.h264PID = es.ElementaryPID
+					d.h264Conf = &gortsplib.TrackConfigH264{}
+				}
+
+			case astits.StreamTypeAACAudio:
+				if !d.hasAAC {
+					d.hasAAC = true
+					d.aacPID = es.ElementaryPID
+				}
+			}
+		}
+		return nil, nil
+	}
+
+	if data.PES == nil ||
+		data.PES.Header.OptionalHeader == nil ||
+		data.PES.Header.OptionalHeader.PTS == nil {
+		return nil, nil
+	}
+
+	switch {
+	case d.hasH264 && data.PID == d.h264PID:
+		return d.processH264(data.PES)
+
+	case d.hasAAC && data.PID == d.aacPID:
+		return d.processAAC(data.PES)
+	}
+
+	return nil, nil
+}
+
+func (d *Demuxer) processH264(pes *astits.PESData) (*Frame, error) {
+	nalus, err := h264.DecodeAnnexB(pes.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var outNALUs [][]byte
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		typ := h264.NALUType(nalu[0] & 0x1F)
+		switch typ {
+		case h264.NALUTypeSPS:
+			if d.h264Conf.SPS == nil {
+				d.h264Conf.SPS = append([]byte(nil), nalu...)
+			}
+			continue
+
+		case h264.NALUTypePPS:
+			if d.h264Conf.PPS == nil && d.h264Conf.SPS != nil {
+				d.h264Conf.PPS = append([]byte(nil), nalu...)
+			}
+			continue
+
+		case h264.NALUTypeAccessUnitDelimiter:
+			continue
+		}
+
+		outNALUs = append(outNALUs, nalu)
+	}
+
+	pts := d.decodePTS(pes.Header.OptionalHeader.PTS.Base)
+
+	// frames that precede the configuration can't be decoded
+	if d.h264Conf.PPS == nil || len(outNALUs) == 0 {
+		return nil, nil
+	}
+
+	return &Frame{
+		Type:  FrameTypeH264,
+		PTS:   pts,
+		NALUs: outNALUs,
+	}, nil
+}
+
+func (d *Demuxer) processAAC(pes *astits.PESData) (*Frame, error) {
+	pkts, err := aac.DecodeADTS(pes.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pkts) == 0 {
+		return nil, nil
+	}
+
+	if d.aacConf == nil {
+		d.aacConf = &gortsplib.TrackConfigAAC{
+			Type:         2,
+			SampleRate:   pkts[0].SampleRate,
+			ChannelCount: pkts[0].ChannelCount,
+		}
+	}
+
+	aus := make([][]byte, len(pkts))
+	for i, pkt := range pkts {
+		aus[i] = pkt.Frame
+	}
+
+	return &Frame{
+		Type: FrameTypeAAC,
+		PTS:  d.decodePTS(pes.Header.OptionalHeader.PTS.Base),
+		AUs:  aus,
+	}, nil
+}
+
+// decodePTS converts a PTS into a duration relative to the first PTS of the stream,
+// taking into account wrap-arounds.
+func (d *Demuxer) decodePTS(v int64) time.Duration {
+	if !d.ptsSet {
+		d.ptsSet = true
+		d.ptsPrev = v
+	}
+
+	diff := (v - d.ptsPrev) & ptsMask
+	if diff > ptsMask/2 {
+		diff -= ptsMask + 1
+	}
+
+	d.ptsAcc += diff
+	d.ptsPrev = v
+
+	// split the conversion in order to avoid overflows
+	secs := d.ptsAcc / 90000
+	dec := d.ptsAcc % 90000
+	return time.Duration(secs)*time.Second + time.Duration(dec)*time.Second/90000
+}
@@ -0,0 +1,132 @@
+package mpegts
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+func writePES(t *testing.T, mux *astits.Muxer, pid uint16, streamID uint8, pts int64, data []byte) {
+	_, err := mux.WriteData(&astits.MuxerData{
+		PID: pid,
+		AdaptationField: &astits.PacketAdaptationField{
+			RandomAccessIndicator: true,
+		},
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: pts},
+				},
+				StreamID: streamID,
+			},
+			Data: data,
+		},
+	})
+	require.NoError(t, err)
+}
+
+func TestDemuxer(t *testing.T) {
+	var buf bytes.Buffer
+	mux := astits.NewMuxer(context.Background(), &buf)
+	mux.AddElementaryStream(astits.PMTElementaryStream{
+		ElementaryPID: 256,
+		StreamType:    astits.StreamTypeH264Video,
+	})
+	mux.AddElementaryStream(astits.PMTElementaryStream{
+		ElementaryPID: 257,
+		StreamType:    astits.StreamTypeAACAudio,
+	})
+	mux.SetPCRPID(256)
+
+	sps := []byte{0x67, 0x01, 0x02}
+	pps := []byte{0x68, 0x03}
+
+	// a frame before the configuration, that is discarded
+	enc, err := h264.EncodeAnnexB([][]byte{{0x01, 0x01}})
+	require.NoError(t, err)
+	writePES(t, mux, 256, 224, 8589934000, enc)
+
+	enc, err = h264.EncodeAnnexB([][]byte{
+		{byte(h264.NALUTypeAccessUnitDelimiter), 240},
+		sps,
+		pps,
+		{0x05, 0x02},
+	})
+	require.NoError(t, err)
+	writePES(t, mux, 256, 224, 8589934500, enc)
+
+	adts, err := aac.EncodeADTS([]*aac.ADTSPacket{
+		{SampleRate: 44100, ChannelCount: 2, Frame: []byte{0x03, 0x04}},
+		{SampleRate: 44100, ChannelCount: 2, Frame: []byte{0x05, 0x06}},
+	})
+	require.NoError(t, err)
+	writePES(t, mux, 257, 192, 8589934500, adts)
+
+	// PTS wraps around
+	enc, err = h264.EncodeAnnexB([][]byte{{0x01, 0x07}})
+	require.NoError(t, err)
+	writePES(t, mux, 256, 224, 2500, enc)
+
+	d := NewDemuxer(context.Background(), &buf)
+
+	videoTrack, audioTrack, err := d.Tracks()
+	require.NoError(t, err)
+
+	h264Conf, err := videoTrack.ExtractConfigH264()
+	require.NoError(t, err)
+	require.Equal(t, &gortsplib.TrackConfigH264{SPS: sps, PPS: pps}, h264Conf)
+
+	aacConf, err := audioTrack.ExtractConfigAAC()
+	require.NoError(t, err)
+	require.Equal(t, 44100, aacConf.SampleRate)
+	require.Equal(t, 2, aacConf.ChannelCount)
+
+	for _, expected := range []*Frame{
+		{
+			Type:  FrameTypeH264,
+			PTS:   500 * time.Second / 90000,
+			NALUs: [][]byte{{0x05, 0x02}},
+		},
+		{
+			Type:  FrameTypeH264,
+			PTS:   3092 * time.Second / 90000,
+			NALUs: [][]byte{{0x01, 0x07}},
+		},
+		// a PES is emitted when the next one of the same PID begins, or when the stream ends
+		{
+			Type: FrameTypeAAC,
+			PTS:  500 * time.Second / 90000,
+			AUs:  [][]byte{{0x03, 0x04}, {0x05, 0x06}},
+		},
+	} {
+		f, err := d.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, expected, f)
+	}
+
+	_, err = d.ReadFrame()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestDemuxerDecodePTSLong(t *testing.T) {
+	d := &Demuxer{}
+	d.decodePTS(0)
+
+	// a hour of PTS, repeated 30 times, including wrap-arounds
+	var pts time.Duration
+	for i := 1; i <= 30; i++ {
+		pts = d.decodePTS((int64(i) * 90000 * 3600) & ptsMask)
+	}
+	require.Equal(t, 30*time.Hour, pts)
+}
@@ -0,0 +1,188 @@
+package mpegts
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	videoPID = 256
+	audioPID = 257
+)
+
+// Writer writes H264 and AAC frames into a MPEG-TS stream.
+type Writer struct {
+	h264Conf *gortsplib.TrackConfigH264
+	aacConf  *gortsplib.TrackConfigAAC
+
+	mux            *astits.Muxer
+	pcrSendCounter int
+}
+
+// NewWriter allocates a Writer.
+// h264Conf or aacConf can be nil when the stream doesn't contain the corresponding track.
+func NewWriter(
+	w io.Writer,
+	h264Conf *gortsplib.TrackConfigH264,
+	aacConf *gortsplib.TrackConfigAAC) *Writer {
+	tw := &Writer{
+		h264Conf: h264Conf,
+		aacConf:  aacConf,
+	}
+
+	tw.mux = astits.NewMuxer(context.Background(), w)
+
+	if h264Conf != nil {
+		tw.mux.AddElementaryStream(astits.PMTElementaryStream{
+			ElementaryPID: videoPID,
+			StreamType:    astits.StreamTypeH264Video,
+		})
+	}
+
+	if aacConf != nil {
+		tw.mux.AddElementaryStream(astits.PMTElementaryStream{
+			ElementaryPID: audioPID,
+			StreamType:    astits.StreamTypeAACAudio,
+		})
+	}
+
+	if h264Conf != nil {
+		tw.mux.SetPCRPID(videoPID)
+	} else {
+		tw.mux.SetPCRPID(audioPID)
+	}
+
+	return tw
+}
+
+// WriteH264 writes the NALUs of a H264 access unit.
+// Timestamps must be positive; the PCR is derived from the DTS.
+func (tw *Writer) WriteH264(
+	dts time.Duration,
+	pts time.Duration,
+	isIDR bool,
+	nalus [][]byte) error {
+	filteredNALUs := [][]byte{
+		// prepend an AUD. This is required by some players
+		{byte(h264.NALUTypeAccessUnitDelimiter), 240},
+	}
+
+	for _, nalu := range nalus {
+		// remove existing SPS, PPS, AUD
+		typ := h264.NALUType(nalu[0] & 0x1F)
+		switch typ {
+		case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+			continue
+		}
+
+		// add SPS and PPS before IDR
+		if typ == h264.NALUTypeIDR {
+			filteredNALUs = append(filteredNALUs, tw.h264Conf.SPS)
+			filteredNALUs = append(filteredNALUs, tw.h264Conf.PPS)
+		}
+
+		filteredNALUs = append(filteredNALUs, nalu)
+	}
+
+	enc, err := h264.EncodeAnnexB(filteredNALUs)
+	if err != nil {
+		return err
+	}
+
+	var af *astits.PacketAdaptationField
+
+	if isIDR {
+		af = &astits.PacketAdaptationField{}
+		af.RandomAccessIndicator = true
+	}
+
+	// send PCR once in a while
+	if tw.pcrSendCounter == 0 {
+		if af == nil {
+			af = &astits.PacketAdaptationField{}
+		}
+		af.HasPCR = true
+		af.PCR = &astits.ClockReference{Base: int64(dts.Seconds() * 90000)}
+		tw.pcrSendCounter = 3
+	}
+	tw.pcrSendCounter--
+
+	oh := &astits.PESOptionalHeader{
+		MarkerBits: 2,
+	}
+
+	if dts == pts {
+		oh.PTSDTSIndicator = astits.PTSDTSIndicatorOnlyPTS
+		oh.PTS = &astits.ClockReference{Base: int64(pts.Seconds() * 90000)}
+	} else {
+		oh.PTSDTSIndicator = astits.PTSDTSIndicatorBothPresent
+		oh.DTS = &astits.ClockReference{Base: int64(dts.Seconds() * 90000)}
+		oh.PTS = &astits.ClockReference{Base: int64(pts.Seconds() * 90000)}
+	}
+
+	_, err = tw.mux.WriteData(&astits.MuxerData{
+		PID:             videoPID,
+		AdaptationField: af,
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: oh,
+				StreamID:       224, // = video
+			},
+			Data: enc,
+		},
+	})
+	return err
+}
+
+// WriteAAC writes an AAC access unit.
+func (tw *Writer) WriteAAC(pts time.Duration, au []byte) error {
+	adtsPkt, err := aac.EncodeADTS([]*aac.ADTSPacket{
+		{
+			SampleRate:   tw.aacConf.SampleRate,
+			ChannelCount: tw.aacConf.ChannelCount,
+			Frame:        au,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	af := &astits.PacketAdaptationField{
+		RandomAccessIndicator: true,
+	}
+
+	// if audio is the only track, send PCR once in a while
+	if tw.h264Conf == nil {
+		if tw.pcrSendCounter == 0 {
+			af.HasPCR = true
+			af.PCR = &astits.ClockReference{Base: int64(pts.Seconds() * 90000)}
+			tw.pcrSendCounter = 3
+		}
+		tw.pcrSendCounter--
+	}
+
+	_, err = tw.mux.WriteData(&astits.MuxerData{
+		PID:             audioPID,
+		AdaptationField: af,
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: int64(pts.Seconds() * 90000)},
+				},
+				PacketLength: uint16(len(adtsPkt) + 8),
+				StreamID:     192, // = audio
+			},
+			Data: adtsPkt,
+		},
+	})
+	return err
+}
@@ -0,0 +1,99 @@
+package mpegts
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	h264Conf := &gortsplib.TrackConfigH264{
+		SPS: []byte{0x67, 0x01, 0x02},
+		PPS: []byte{0x68, 0x03},
+	}
+	aacConf := &gortsplib.TrackConfigAAC{
+		Type:         2,
+		SampleRate:   44100,
+		ChannelCount: 2,
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, h264Conf, aacConf)
+
+	for i := 0; i < 3; i++ {
+		start := 2*time.Second + time.Duration(i)*time.Second
+
+		err := w.WriteH264(start, start, true, [][]byte{{0x05, 0x01}})
+		require.NoError(t, err)
+
+		err = w.WriteAAC(start, []byte{0x03, 0x04})
+		require.NoError(t, err)
+
+		err = w.WriteH264(start+40*time.Millisecond, start+80*time.Millisecond,
+			false, [][]byte{{0x01, 0x02}})
+		require.NoError(t, err)
+	}
+
+	d := NewDemuxer(context.Background(), &buf)
+
+	videoTrack, audioTrack, err := d.Tracks()
+	require.NoError(t, err)
+
+	conf1, err := videoTrack.ExtractConfigH264()
+	require.NoError(t, err)
+	require.Equal(t, h264Conf, conf1)
+
+	conf2, err := audioTrack.ExtractConfigAAC()
+	require.NoError(t, err)
+	require.Equal(t, 44100, conf2.SampleRate)
+	require.Equal(t, 2, conf2.ChannelCount)
+
+	var videoFrames []*Frame
+	audioFrameCount := 0
+
+	for {
+		f, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if f.Type == FrameTypeH264 {
+			videoFrames = append(videoFrames, f)
+		} else {
+			require.Equal(t, [][]byte{{0x03, 0x04}}, f.AUs)
+			audioFrameCount++
+		}
+	}
+
+	// the demuxer parses the tables when they are repeated, therefore frames of the first GOP
+	// are discarded; PTS is relative to the last frame of the first GOP.
+	require.Equal(t, []*Frame{
+		{
+			Type:  FrameTypeH264,
+			PTS:   920 * time.Millisecond,
+			NALUs: [][]byte{{0x05, 0x01}},
+		},
+		{
+			Type:  FrameTypeH264,
+			PTS:   1000 * time.Millisecond,
+			NALUs: [][]byte{{0x01, 0x02}},
+		},
+		{
+			Type:  FrameTypeH264,
+			PTS:   1920 * time.Millisecond,
+			NALUs: [][]byte{{0x05, 0x01}},
+		},
+		{
+			Type:  FrameTypeH264,
+			PTS:   2000 * time.Millisecond,
+			NALUs: [][]byte{{0x01, 0x02}},
+		},
+	}, videoFrames)
+	require.Equal(t, 3, audioFrameCount)
+}
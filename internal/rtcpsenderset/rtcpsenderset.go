@@ -75,3 +75,10 @@ func (s *RTCPSenderSet) run() {
 func (s *RTCPSenderSet) OnFrame(trackID int, streamType gortsplib.StreamType, f []byte) {
 	s.senders[trackID].ProcessFrame(time.Now(), streamType, f)
 }
+
+// OnFrameAt sends a frame to the senders, together with the time in which the
+// frame was produced. It allows to generate sender reports that are synchronized
+// with a clock different from the system one.
+func (s *RTCPSenderSet) OnFrameAt(trackID int, streamType gortsplib.StreamType, f []byte, t time.Time) {
+	s.senders[trackID].ProcessFrame(t, streamType, f)
+}
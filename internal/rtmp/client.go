@@ -3,6 +3,8 @@ package rtmp
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/url"
 
@@ -10,13 +12,19 @@ import (
 )
 
 // DialContext connects to a server in reading mode.
-func DialContext(ctx context.Context, address string) (*Conn, error) {
+// When the URL scheme is rtmps, the connection is encrypted with TLS, by using tlsConfig.
+func DialContext(ctx context.Context, address string, tlsConfig *tls.Config) (*Conn, error) {
 	// https://github.com/aler9/rtmp/blob/3be4a55359274dcd88762e72aa0a702e2d8ba2fd/format/rtmp/client.go#L74
 
 	u, err := url.Parse(address)
 	if err != nil {
 		return nil, err
 	}
+
+	if u.Scheme != "rtmp" && u.Scheme != "rtmps" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
 	host := rtmp.UrlGetHost(u)
 
 	var d net.Dialer
@@ -25,6 +33,10 @@ func DialContext(ctx context.Context, address string) (*Conn, error) {
 		return nil, err
 	}
 
+	if u.Scheme == "rtmps" {
+		nconn = tls.Client(nconn, tlsConfig)
+	}
+
 	rw := &bufio.ReadWriter{
 		Reader: bufio.NewReaderSize(nconn, 4096),
 		Writer: bufio.NewWriterSize(nconn, 4096),
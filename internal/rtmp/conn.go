@@ -12,6 +12,9 @@ import (
 type Conn struct {
 	rconn *rtmp.Conn
 	nconn net.Conn
+
+	// packets read by ReadMetadata that have not been returned yet
+	pending []av.Packet
 }
 
 // NetConn returns the underlying net.Conn.
@@ -31,6 +34,12 @@ func (c *Conn) URL() *url.URL {
 
 // ReadPacket reads a packet.
 func (c *Conn) ReadPacket() (av.Packet, error) {
+	if len(c.pending) > 0 {
+		pkt := c.pending[0]
+		c.pending = c.pending[1:]
+		return pkt, nil
+	}
+
 	return c.rconn.ReadPacket()
 }
 
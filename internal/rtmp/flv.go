@@ -0,0 +1,53 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/aler9/gortsplib"
+	"github.com/notedit/rtmp/av"
+	"github.com/notedit/rtmp/format/flv"
+)
+
+// FLVWriter writes RTMP packets in the FLV format.
+// Each packet is written to the underlying writer with a single call to Write(),
+// therefore it can be used to write a packet per WebSocket message.
+type FLVWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+	mux *flv.Muxer
+}
+
+// NewFLVWriter allocates a FLVWriter.
+func NewFLVWriter(w io.Writer) *FLVWriter {
+	fw := &FLVWriter{
+		w: w,
+	}
+	fw.mux = flv.NewMuxer(&fw.buf)
+	return fw
+}
+
+// WriteMetadata writes the FLV header and track informations.
+func (fw *FLVWriter) WriteMetadata(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
+	fw.mux.HasVideo = (videoTrack != nil)
+	fw.mux.HasAudio = (audioTrack != nil)
+
+	err := fw.mux.WriteFileHeader()
+	if err != nil {
+		return err
+	}
+
+	return writeMetadata(fw, videoTrack, audioTrack)
+}
+
+// WritePacket writes a packet.
+func (fw *FLVWriter) WritePacket(pkt av.Packet) error {
+	err := fw.mux.WritePacket(pkt)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.w.Write(fw.buf.Bytes())
+	fw.buf.Reset()
+	return err
+}
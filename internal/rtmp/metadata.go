@@ -2,6 +2,7 @@ package rtmp
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/rtpaac"
@@ -15,164 +16,215 @@ const (
 	codecAAC  = 10
 )
 
-// ReadMetadata extracts track informations from a connection that is publishing.
-func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
-	var videoTrack *gortsplib.Track
-	var audioTrack *gortsplib.Track
+// readMetadataProbeDuration is the maximum duration of the stream that is read
+// in order to find tracks.
+const readMetadataProbeDuration = 1 * time.Second
 
-	md, err := func() (flvio.AMFMap, error) {
-		pkt, err := c.ReadPacket()
-		if err != nil {
-			return nil, err
-		}
+func parseMetadata(byts []byte) (flvio.AMFMap, error) {
+	arr, err := flvio.ParseAMFVals(byts, false)
+	if err != nil {
+		return nil, err
+	}
 
-		if pkt.Type != av.Metadata {
-			return nil, fmt.Errorf("first packet must be metadata")
-		}
+	if len(arr) != 1 {
+		return nil, fmt.Errorf("invalid metadata")
+	}
 
-		arr, err := flvio.ParseAMFVals(pkt.Data, false)
-		if err != nil {
-			return nil, err
-		}
+	ma, ok := arr[0].(flvio.AMFMap)
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata")
+	}
 
-		if len(arr) != 1 {
-			return nil, fmt.Errorf("invalid metadata")
+	return ma, nil
+}
+
+func metadataHasVideo(md flvio.AMFMap) (bool, error) {
+	v, ok := md.GetV("videocodecid")
+	if !ok {
+		return false, nil
+	}
+
+	switch vt := v.(type) {
+	case float64:
+		switch vt {
+		case 0:
+			return false, nil
+
+		case codecH264:
+			return true, nil
 		}
 
-		ma, ok := arr[0].(flvio.AMFMap)
-		if !ok {
-			return nil, fmt.Errorf("invalid metadata")
+	case string:
+		if vt == "avc1" {
+			return true, nil
 		}
+	}
 
-		return ma, nil
-	}()
-	if err != nil {
-		return nil, nil, err
+	return false, fmt.Errorf("unsupported video codec %v", v)
+}
+
+func metadataHasAudio(md flvio.AMFMap) (bool, error) {
+	v, ok := md.GetV("audiocodecid")
+	if !ok {
+		return false, nil
 	}
 
-	hasVideo, err := func() (bool, error) {
-		v, ok := md.GetV("videocodecid")
-		if !ok {
+	switch vt := v.(type) {
+	case float64:
+		switch vt {
+		case 0:
 			return false, nil
-		}
-
-		switch vt := v.(type) {
-		case float64:
-			switch vt {
-			case 0:
-				return false, nil
 
-			case codecH264:
-				return true, nil
-			}
+		case codecAAC:
+			return true, nil
+		}
 
-		case string:
-			if vt == "avc1" {
-				return true, nil
-			}
+	case string:
+		if vt == "mp4a" {
+			return true, nil
 		}
+	}
+
+	return false, fmt.Errorf("unsupported audio codec %v", v)
+}
 
-		return false, fmt.Errorf("unsupported video codec %v", v)
-	}()
+// DecodeH264DecoderConfig decodes the SPS and PPS contained in a AVC sequence header.
+func DecodeH264DecoderConfig(byts []byte) (*gortsplib.TrackConfigH264, error) {
+	codec, err := nh264.FromDecoderConfig(byts)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	hasAudio, err := func() (bool, error) {
-		v, ok := md.GetV("audiocodecid")
-		if !ok {
-			return false, nil
-		}
+	if len(codec.SPS) == 0 || len(codec.PPS) == 0 {
+		return nil, fmt.Errorf("H264 configuration doesn't contain SPS or PPS")
+	}
 
-		switch vt := v.(type) {
-		case float64:
-			switch vt {
-			case 0:
-				return false, nil
+	return &gortsplib.TrackConfigH264{SPS: codec.SPS[0], PPS: codec.PPS[0]}, nil
+}
 
-			case codecAAC:
-				return true, nil
-			}
+func trackFromH264DecoderConfig(byts []byte) (*gortsplib.Track, error) {
+	conf, err := DecodeH264DecoderConfig(byts)
+	if err != nil {
+		return nil, err
+	}
 
-		case string:
-			if vt == "mp4a" {
-				return true, nil
-			}
-		}
+	return gortsplib.NewTrackH264(96, conf)
+}
 
-		return false, fmt.Errorf("unsupported audio codec %v", v)
-	}()
+func trackFromAACDecoderConfig(byts []byte) (*gortsplib.Track, error) {
+	var mpegConf rtpaac.MPEG4AudioConfig
+	err := mpegConf.Decode(byts)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	if !hasVideo && !hasAudio {
-		return nil, nil, fmt.Errorf("stream has no tracks")
-	}
+	return gortsplib.NewTrackAAC(96, &gortsplib.TrackConfigAAC{
+		Type:              int(mpegConf.Type),
+		SampleRate:        mpegConf.SampleRate,
+		ChannelCount:      mpegConf.ChannelCount,
+		AOTSpecificConfig: mpegConf.AOTSpecificConfig,
+	})
+}
+
+// ReadMetadata extracts track informations from a connection that is publishing.
+// Tracks are found by reading the AVC and AAC sequence headers. Metadata is optional
+// and, when present, is used to stop reading as soon as all declared tracks are found;
+// otherwise, the stream is read until all tracks are found or until readMetadataProbeDuration
+// has passed. Frames read in the meanwhile are returned by the next calls to ReadPacket.
+func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
+	var videoTrack *gortsplib.Track
+	var audioTrack *gortsplib.Track
+
+	hasMetadata := false
+	hasVideo := false
+	hasAudio := false
+
+	probeStarted := false
+	var probeStart time.Duration
 
 	for {
-		var pkt av.Packet
-		pkt, err = c.ReadPacket()
+		pkt, err := c.rconn.ReadPacket()
 		if err != nil {
 			return nil, nil, err
 		}
 
 		switch pkt.Type {
-		case av.H264DecoderConfig:
-			if !hasVideo {
-				return nil, nil, fmt.Errorf("unexpected video packet")
-			}
-
-			if videoTrack != nil {
-				return nil, nil, fmt.Errorf("video track setupped twice")
+		case av.Metadata:
+			md, err := parseMetadata(pkt.Data)
+			if err != nil {
+				return nil, nil, err
 			}
 
-			codec, err := nh264.FromDecoderConfig(pkt.Data)
+			hasVideo, err = metadataHasVideo(md)
 			if err != nil {
 				return nil, nil, err
 			}
 
-			videoTrack, err = gortsplib.NewTrackH264(96, &gortsplib.TrackConfigH264{SPS: codec.SPS[0], PPS: codec.PPS[0]})
+			hasAudio, err = metadataHasAudio(md)
 			if err != nil {
 				return nil, nil, err
 			}
 
-		case av.AACDecoderConfig:
-			if !hasAudio {
-				return nil, nil, fmt.Errorf("unexpected audio packet")
+			// metadata that doesn't declare any track is ignored
+			hasMetadata = hasVideo || hasAudio
+
+		case av.H264DecoderConfig:
+			if videoTrack == nil {
+				videoTrack, err = trackFromH264DecoderConfig(pkt.Data)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 
-			if audioTrack != nil {
-				return nil, nil, fmt.Errorf("audio track setupped twice")
+		case av.AACDecoderConfig:
+			if audioTrack == nil {
+				audioTrack, err = trackFromAACDecoderConfig(pkt.Data)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 
-			var mpegConf rtpaac.MPEG4AudioConfig
-			err := mpegConf.Decode(pkt.Data)
-			if err != nil {
-				return nil, nil, err
+		case av.H264, av.AAC:
+			// frames that precede the configuration of their track can't be decoded
+			// and are discarded.
+			if (pkt.Type == av.H264 && videoTrack != nil) ||
+				(pkt.Type == av.AAC && audioTrack != nil) {
+				c.pending = append(c.pending, pkt)
 			}
 
-			audioTrack, err = gortsplib.NewTrackAAC(96, &gortsplib.TrackConfigAAC{
-				Type:              int(mpegConf.Type),
-				SampleRate:        mpegConf.SampleRate,
-				ChannelCount:      mpegConf.ChannelCount,
-				AOTSpecificConfig: mpegConf.AOTSpecificConfig,
-			})
-			if err != nil {
-				return nil, nil, err
+			if !probeStarted {
+				probeStarted = true
+				probeStart = pkt.Time
+			} else if (pkt.Time - probeStart) >= readMetadataProbeDuration {
+				if videoTrack == nil && audioTrack == nil {
+					return nil, nil, fmt.Errorf("stream has no tracks")
+				}
+				return videoTrack, audioTrack, nil
 			}
 		}
 
-		if (!hasVideo || videoTrack != nil) &&
-			(!hasAudio || audioTrack != nil) {
+		if hasMetadata {
+			if (!hasVideo || videoTrack != nil) &&
+				(!hasAudio || audioTrack != nil) {
+				return videoTrack, audioTrack, nil
+			}
+		} else if videoTrack != nil && audioTrack != nil {
 			return videoTrack, audioTrack, nil
 		}
 	}
 }
 
+type packetWriter interface {
+	WritePacket(av.Packet) error
+}
+
 // WriteMetadata writes track informations to a connection that is reading.
 func (c *Conn) WriteMetadata(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
-	err := c.WritePacket(av.Packet{
+	return writeMetadata(c, videoTrack, audioTrack)
+}
+
+func writeMetadata(w packetWriter, videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
+	err := w.WritePacket(av.Packet{
 		Type: av.Metadata,
 		Data: flvio.FillAMF0ValMalloc(flvio.AMFMap{
 			{
@@ -226,7 +278,7 @@ func (c *Conn) WriteMetadata(videoTrack *gortsplib.Track, audioTrack *gortsplib.
 		codec.ToConfig(b, &n)
 		b = b[:n]
 
-		err = c.WritePacket(av.Packet{
+		err = w.WritePacket(av.Packet{
 			Type: av.H264DecoderConfig,
 			Data: b,
 		})
@@ -251,7 +303,7 @@ func (c *Conn) WriteMetadata(videoTrack *gortsplib.Track, audioTrack *gortsplib.
 			return err
 		}
 
-		err = c.WritePacket(av.Packet{
+		err = w.WritePacket(av.Packet{
 			Type: av.AACDecoderConfig,
 			Data: enc,
 		})
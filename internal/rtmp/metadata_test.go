@@ -0,0 +1,203 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/notedit/rtmp/av"
+	nh264 "github.com/notedit/rtmp/codec/h264"
+	"github.com/notedit/rtmp/format/flv/flvio"
+	"github.com/notedit/rtmp/format/rtmp"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSPS = []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+)
+
+func testH264Config() av.Packet {
+	codec := nh264.Codec{
+		SPS: map[int][]byte{0: testSPS},
+		PPS: map[int][]byte{0: testPPS},
+	}
+	b := make([]byte, 128)
+	var n int
+	codec.ToConfig(b, &n)
+	return av.Packet{Type: av.H264DecoderConfig, Data: b[:n]}
+}
+
+func testAACConfig(t *testing.T) av.Packet {
+	enc, err := rtpaac.MPEG4AudioConfig{
+		Type:         rtpaac.MPEG4AudioTypeAACLC,
+		SampleRate:   44100,
+		ChannelCount: 2,
+	}.Encode()
+	require.NoError(t, err)
+	return av.Packet{Type: av.AACDecoderConfig, Data: enc}
+}
+
+func testMetadata(videoCodec float64, audioCodec float64) av.Packet {
+	return av.Packet{
+		Type: av.Metadata,
+		Data: flvio.FillAMF0ValMalloc(flvio.AMFMap{
+			{K: "videocodecid", V: videoCodec},
+			{K: "audiocodecid", V: audioCodec},
+		}),
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	h264Frame := func(ts time.Duration) av.Packet {
+		return av.Packet{Type: av.H264, Data: []byte{0x00, 0x00, 0x00, 0x02, 0x05, byte(ts / time.Millisecond / 100)}, Time: ts}
+	}
+	aacFrame := func(ts time.Duration) av.Packet {
+		return av.Packet{Type: av.AAC, Data: []byte{0x01, byte(ts / time.Millisecond / 100)}, Time: ts}
+	}
+
+	for _, ca := range []struct {
+		name      string
+		packets   func(t *testing.T) []av.Packet
+		video     bool
+		audio     bool
+		firstNext av.Packet
+	}{
+		{
+			"metadata",
+			func(t *testing.T) []av.Packet {
+				return []av.Packet{
+					testMetadata(codecH264, codecAAC),
+					testH264Config(),
+					testAACConfig(t),
+					h264Frame(0),
+				}
+			},
+			true,
+			true,
+			h264Frame(0),
+		},
+		{
+			"no metadata",
+			func(t *testing.T) []av.Packet {
+				return []av.Packet{
+					testH264Config(),
+					h264Frame(0),
+					testAACConfig(t),
+					aacFrame(0),
+				}
+			},
+			true,
+			true,
+			h264Frame(0),
+		},
+		{
+			"video only",
+			func(t *testing.T) []av.Packet {
+				return []av.Packet{
+					testH264Config(),
+					h264Frame(0),
+					h264Frame(500 * time.Millisecond),
+					h264Frame(1000 * time.Millisecond),
+					h264Frame(1500 * time.Millisecond),
+				}
+			},
+			true,
+			false,
+			h264Frame(0),
+		},
+		{
+			"audio only with frames before configuration",
+			func(t *testing.T) []av.Packet {
+				return []av.Packet{
+					aacFrame(0),
+					testAACConfig(t),
+					aacFrame(500 * time.Millisecond),
+					aacFrame(1000 * time.Millisecond),
+					aacFrame(1500 * time.Millisecond),
+				}
+			},
+			false,
+			true,
+			aacFrame(500 * time.Millisecond),
+		},
+		{
+			"metadata after configuration",
+			func(t *testing.T) []av.Packet {
+				return []av.Packet{
+					testAACConfig(t),
+					aacFrame(0),
+					testMetadata(0, codecAAC),
+					aacFrame(100 * time.Millisecond),
+				}
+			},
+			false,
+			true,
+			aacFrame(0),
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+
+				conn, _, err := rtmp.NewClient().Dial("rtmp://"+ln.Addr().String()+"/stream", rtmp.PrepareWriting)
+				require.NoError(t, err)
+
+				for _, pkt := range ca.packets(t) {
+					err := conn.WritePacket(pkt)
+					require.NoError(t, err)
+				}
+
+				err = conn.FlushWrite()
+				require.NoError(t, err)
+			}()
+
+			nconn, err := ln.Accept()
+			require.NoError(t, err)
+			defer nconn.Close()
+
+			conn := NewServerConn(nconn)
+			nconn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			err = conn.ServerHandshake()
+			require.NoError(t, err)
+
+			videoTrack, audioTrack, err := conn.ReadMetadata()
+			require.NoError(t, err)
+			require.Equal(t, ca.video, videoTrack != nil)
+			require.Equal(t, ca.audio, audioTrack != nil)
+
+			if videoTrack != nil {
+				conf, err := videoTrack.ExtractConfigH264()
+				require.NoError(t, err)
+				require.Equal(t, testSPS, conf.SPS)
+				require.Equal(t, testPPS, conf.PPS)
+			}
+
+			if audioTrack != nil {
+				conf, err := audioTrack.ExtractConfigAAC()
+				require.NoError(t, err)
+				require.Equal(t, 44100, conf.SampleRate)
+				require.Equal(t, 2, conf.ChannelCount)
+			}
+
+			pkt, err := conn.ReadPacket()
+			require.NoError(t, err)
+			require.Equal(t, ca.firstNext.Type, pkt.Type)
+			require.Equal(t, ca.firstNext.Time, pkt.Time)
+			require.Equal(t, ca.firstNext.Data, pkt.Data)
+
+			<-done
+		})
+	}
+}